@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// isAdmin reports whether the request has the AdminUser and AdminPass credentials with HTTP basic authentication, or
+// an identity with the admin role authenticated with the admin AuthMethods, returned if so.
+func (s *server) isAdmin(r *http.Request) (id *authIdentity, ok bool) {
+	user, pass, basic := r.BasicAuth()
+	// evaluate both comparisons so the timing doesn't tell which one failed
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.AdminUser)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(s.AdminPass)) == 1
+	if basic && s.AdminPass != "" && userOK && passOK {
+		ok = true
+		return
+	}
+	var err error
+	if id, err = s.authenticate(r, s.AuthMethods[roleAdmin]); err == nil && id != nil && s.hasRole(id, roleAdmin) {
+		ok = true
+		return
+	}
+	id = nil
+	return
+}
+
+// adminAuth requires the AdminUser and AdminPass credentials with HTTP basic authentication, or an identity with the
+// admin role authenticated with the admin AuthMethods.
+func (s *server) adminAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := s.isAdmin(r); ok {
+			if id != nil {
+				r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+			}
+			handler.ServeHTTP(w, r)
+			return
+		}
+		logf(r.Context(), "[ERROR] ADMIN %s %s unauthorized", r.Method, r.URL.Path)
+		w.Header().Set("WWW-Authenticate", `Basic realm="usebin admin", charset="UTF-8"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+// adminHandler serves the net/http/pprof endpoints under /debug/pprof/, the log level at /log/level, CDN purges at
+// /cache/purge, the memory cache statistics at /cache/memory, the NNTP servers at /servers, the metrics of their
+// connections at /metrics, the takedowns under /takedowns, the article index under /index and the control articles
+// under /control/.
+func (s *server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/log/level", s.handleLogLevel)
+	mux.HandleFunc("/cache/purge", s.handlePurge)
+	mux.HandleFunc("/cache/memory", s.handleMemoryCache)
+	mux.HandleFunc("/servers", s.handleServers)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/takedowns", s.handleTakedowns)
+	mux.HandleFunc("/takedowns/", s.handleTakedowns)
+	mux.HandleFunc("/index", s.handleIndex)
+	mux.HandleFunc("/index/", s.handleIndex)
+	mux.HandleFunc("/control/", s.handleControl)
+	return s.withRequestID(s.adminAuth(mux))
+}
+
+// serveAdmin starts the admin server on AdminHost:AdminPort. It is kept separate from the public listeners, never
+// behind the PROXY protocol, so it can be firewalled on its own.
+func (s *server) serveAdmin() (err error) {
+	if s.AdminPass == "" && len(s.AuthMethods[roleAdmin]) == 0 {
+		err = fmt.Errorf("AdminPort is set without an AdminPass or admin AuthMethods")
+		return
+	}
+	if s.AdminHost == "" {
+		s.AdminHost = "127.0.0.1"
+	}
+	runtime.SetBlockProfileRate(s.BlockProfileRate)
+	runtime.SetMutexProfileFraction(s.MutexProfileFraction)
+	adminServer := &http.Server{
+		Addr:    net.JoinHostPort(s.AdminHost, fmt.Sprint(s.AdminPort)),
+		Handler: s.adminHandler(),
+	}
+	scheme := "http"
+	for _, method := range s.AuthMethods[roleAdmin] {
+		if method == AuthMTLS {
+			// a client certificate can only be presented over TLS
+			if adminServer.TLSConfig, err = s.tlsConfig(); err != nil {
+				return
+			}
+			scheme = "https"
+		}
+	}
+	ln, err := net.Listen("tcp", adminServer.Addr)
+	if err != nil {
+		return
+	}
+	go func() {
+		log.Printf("Admin listening at %s://%s\n", scheme, adminServer.Addr)
+		var err error
+		if adminServer.TLSConfig != nil {
+			err = adminServer.ServeTLS(ln, "", "")
+		} else {
+			err = adminServer.Serve(ln)
+		}
+		if err != nil {
+			logPrintf("[ERROR] admin server: %s", err.Error())
+		}
+	}()
+	return
+}
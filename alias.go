@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/nntp.v0"
+)
+
+var (
+	aliasBucket   = []byte("aliases")    // slug to message-id
+	messageBucket = []byte("messageIds") // message-id to slug, so that an article keeps a single alias
+)
+
+const (
+	// aliasAlphabet are the characters of the slugs, lowercase letters and digits without the ones read alike.
+	aliasAlphabet = "23456789abcdefghijkmnpqrstuvwxyz"
+	// aliasLength is the length of the slugs, 32^7 of them, grown by one character every aliasTries collisions.
+	aliasLength = 7
+	aliasTries  = 4
+)
+
+// aliases persists the slugs of the articles in a bolt database.
+type aliases struct {
+	db *bolt.DB
+}
+
+func openAliases(path string) (a *aliases, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		if _, err = tx.CreateBucketIfNotExists(aliasBucket); err != nil {
+			return
+		}
+		_, err = tx.CreateBucketIfNotExists(messageBucket)
+		return
+	}); err != nil {
+		db.Close()
+		return
+	}
+	a = &aliases{db: db}
+	return
+}
+
+// newSlug returns a random slug of n characters of aliasAlphabet.
+func newSlug(n int) (slug string, err error) {
+	b := make([]byte, n)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	for i := range b {
+		// 256 is a multiple of the 32 characters, so they are all equally likely
+		b[i] = aliasAlphabet[int(b[i])%len(aliasAlphabet)]
+	}
+	return string(b), nil
+}
+
+// validSlug reports whether slug is made of the characters of aliasAlphabet, and not too long.
+func validSlug(slug string) bool {
+	if slug == "" || len(slug) > 2*aliasLength {
+		return false
+	}
+	for i := 0; i < len(slug); i++ {
+		if strings.IndexByte(aliasAlphabet, slug[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the message-id of a slug, "" if there is none.
+func (a *aliases) Get(slug string) (messageID nntp.MessageID, err error) {
+	err = a.db.View(func(tx *bolt.Tx) error {
+		messageID = nntp.MessageID(tx.Bucket(aliasBucket).Get([]byte(slug)))
+		return nil
+	})
+	return
+}
+
+// Add returns the slug of an article, created if it has none. New slugs are drawn until one is free, in the same
+// transaction so that concurrent requests never share one, created reporting whether it is new.
+func (a *aliases) Add(messageID nntp.MessageID) (slug string, created bool, err error) {
+	key := []byte(messageID.Short())
+	err = a.db.Update(func(tx *bolt.Tx) (err error) {
+		slugs, ids := tx.Bucket(aliasBucket), tx.Bucket(messageBucket)
+		if existing := ids.Get(key); existing != nil {
+			slug = string(existing)
+			return
+		}
+		for tries := 0; ; tries++ {
+			if slug, err = newSlug(aliasLength + tries/aliasTries); err != nil {
+				return
+			}
+			if slugs.Get([]byte(slug)) == nil {
+				break
+			}
+		}
+		if err = slugs.Put([]byte(slug), key); err != nil {
+			return
+		}
+		created = true
+		return ids.Put(key, []byte(slug))
+	})
+	return
+}
+
+// handleAlias serves /a/: POST /a/ creates the alias of the Message-ID of the JSON body, and GET /a/<slug> redirects to
+// the article of the slug at /m/<Message-ID>.csv, with the query string of the request, so that links fit in chat
+// clients and QR codes.
+func (s *server) handleAlias(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if s.aliases == nil {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "AliasDB is not configured")
+		return
+	}
+	_, slug := splitRoute(r.URL.Path)
+	if slug == "" {
+		s.handleAliasPOST(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !validSlug(slug) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	messageID, err := s.aliases.Get(slug)
+	if err != nil {
+		logf(r.Context(), "[ERROR] ALIAS %s error: %s", slug, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if messageID == "" {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	location := articlePath("/m/", messageID)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	// a slug never changes its article
+	w.Header().Set("Cache-Control", s.cacheControl(r))
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+}
+
+// handleAliasPOST serves POST /a/, where the body is {"messageId": "<Message-ID>"}. The alias is returned as JSON, with
+// its URL in Location, and 201 Created if it is new.
+func (s *server) handleAliasPOST(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		MessageID nntp.MessageID `json:"messageId"`
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&request); err != nil {
+		logf(r.Context(), "[ERROR] ALIAS invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	messageID := nntp.MessageID(request.MessageID.Short())
+	if messageID == "" || messageID.Validate() != nil {
+		logf(r.Context(), "[ERROR] ALIAS invalid message-id %q", request.MessageID)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	slug, created, err := s.aliases.Add(messageID)
+	if err != nil {
+		logf(r.Context(), "[ERROR] ALIAS %s error: %s", messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/a/"+slug)
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		logf(r.Context(), "[INFO] ALIAS %s %s", slug, messageID)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"slug": slug, "url": "/a/" + slug, "messageId": string(messageID)})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/nntp.v0"
+)
+
+// maxAltIDs is the number of fallback message-ids a request can list in the alt query parameter.
+const maxAltIDs = 16
+
+// altMessageIDs returns the fallback message-ids listed comma separated in the alt query parameter, none if it is not
+// set.
+func altMessageIDs(r *http.Request) (ids []nntp.MessageID, err error) {
+	param := r.URL.Query().Get("alt")
+	if param == "" {
+		return
+	}
+	for _, id := range strings.Split(param, ",") {
+		messageID := nntp.MessageID(strings.TrimSpace(id))
+		if err = messageID.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid alt message-id %q: %w", id, err)
+		}
+		ids = append(ids, messageID)
+	}
+	if len(ids) > maxAltIDs {
+		return nil, fmt.Errorf("more than %d alt message-ids", maxAltIDs)
+	}
+	return
+}
+
+// resolveAlt returns the message-id the request is served as: messageID itself, or if the request lists fallback
+// message-ids in the alt query parameter, such as the obfuscated and original message-ids of a post recorded in an
+// NZB, the first of messageID and the fallbacks which is found with STAT. If none is found, or they cannot be looked
+// up, the response is sent and ok is false. Taken down fallbacks are skipped.
+func (s *server) resolveAlt(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) (resolved nntp.MessageID, ok bool) {
+	alts, err := altMessageIDs(r)
+	if err != nil {
+		logf(r.Context(), "[ERROR] %s %s %s", r.Method, messageID, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(alts) == 0 {
+		return messageID, true
+	}
+	for _, candidate := range append([]nntp.MessageID{messageID}, alts...) {
+		if candidate.Short() != messageID.Short() && s.takenDown(r.Context(), candidate) {
+			continue
+		}
+		found, err := s.statArticle(r.Context(), candidate)
+		if err != nil {
+			logf(r.Context(), "[ERROR] %s %s alt %s %s", r.Method, messageID, candidate, err.Error())
+			if !unavailable(w, err) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		if found {
+			if candidate.Short() != messageID.Short() {
+				logf(r.Context(), "[INFO] %s %s found as %s", r.Method, messageID, candidate)
+				w.Header().Set("X-Usebin-Message-Id", string(candidate.Short()))
+			}
+			return candidate, true
+		}
+	}
+	logf(r.Context(), "[ERROR] %s %s not found, nor any of %d alt message-ids", r.Method, messageID, len(alts))
+	w.WriteHeader(http.StatusNotFound)
+	return
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/rx.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// overview fetches the overview record of a single article by its message-id. Not all servers support OVER with a
+// message-id argument, in which case an NNTP error is returned.
+func overview(ctx context.Context, conn *nntp.Conn, messageID nntp.MessageID) (ov *nntp.ArticleOverview, err error) {
+	_, span := startSpan(ctx, "nntp OVER")
+	defer func() { endSpan(span, err) }()
+	writer, reader := rx.Pipe[*nntp.ArticleOverview](nil)
+	conn.CmdOver(nntp.OverMessageID(messageID)).Subscribe(writer)
+	for {
+		item, ok := reader.Read()
+		if !ok {
+			break
+		}
+		if ov == nil {
+			ov = item
+		}
+	}
+	err = reader.Wait()
+	return
+}
+
+// headerWireSize returns the number of octets the header block takes on the wire, including the empty separator line.
+func headerWireSize(header textproto.MIMEHeader) (size int64) {
+	for key, values := range header {
+		for _, value := range values {
+			size += int64(len(key) + len(": ") + len(value) + len("\r\n"))
+		}
+	}
+	return size + int64(len("\r\n"))
+}
+
+// articleBodySize estimates the dot-decoded body size of an article from its Bytes header, or from the :bytes overview
+// field when the header is not present. The byte count covers the whole article in wire format, so the header block
+// and the CR of every CRLF line ending are subtracted; dot-stuffing is not accounted for.
+func articleBodySize(ctx context.Context, conn *nntp.Conn, messageID nntp.MessageID, header textproto.MIMEHeader) (size int64, ok bool, err error) {
+	var bytes, lines uint64
+	bytes, _ = strconv.ParseUint(header.Get("Bytes"), 10, 64)
+	lines, _ = strconv.ParseUint(header.Get("Lines"), 10, 64)
+	if bytes == 0 {
+		var ov *nntp.ArticleOverview
+		if ov, err = overview(ctx, conn, messageID); err != nil || ov == nil {
+			return
+		}
+		bytes, lines = ov.Bytes, ov.Lines
+	}
+	if bytes == 0 {
+		return
+	}
+	if size = int64(bytes) - headerWireSize(header) - int64(lines); size < 0 {
+		size = 0
+	}
+	ok = true
+	return
+}
+
+// dotEncodedBodySize estimates the size of the dot-encoded body of an article, termination line included, from its
+// Bytes header, or from the :bytes field of ov when the header is not present. ov may be nil. The byte count leaves
+// out dot-stuffing, so the estimate is only right for bodies with no line starting with a dot.
+func dotEncodedBodySize(header textproto.MIMEHeader, ov *nntp.ArticleOverview) (size int64, ok bool) {
+	bytes, _ := strconv.ParseUint(header.Get("Bytes"), 10, 64)
+	if bytes == 0 && ov != nil {
+		bytes = ov.Bytes
+	}
+	if bytes == 0 {
+		return
+	}
+	if size = int64(bytes) - headerWireSize(header) + int64(len(".\r\n")); size < int64(len(".\r\n")) {
+		return
+	}
+	ok = true
+	return
+}
+
+// errSizeMismatch is returned by copyExactly when the body is not of the expected size.
+var errSizeMismatch = errors.New("body size mismatch")
+
+// copyExactly copies size bytes from src to dst, failing with errSizeMismatch if src is shorter or longer. The last
+// byte is only written once src is known to end there, so that a longer src never looks like a complete response.
+// src is read into a whole buffer each time rather than cut at size, as a dot reader given a buffer shorter than the
+// termination line takes it for data and then blocks.
+func copyExactly(dst io.Writer, src io.Reader, size int64) (n int64, err error) {
+	var (
+		buf  = make([]byte, 32*1024)
+		read int64
+		last byte
+		m    int
+	)
+	for {
+		m, err = src.Read(buf)
+		if read += int64(m); read > size {
+			err = fmt.Errorf("%w: more than %d bytes", errSizeMismatch, size)
+			return
+		}
+		// hold back the last byte until the end of src
+		chunk := buf[:m]
+		if read == size && m > 0 {
+			last, chunk = chunk[m-1], chunk[:m-1]
+		}
+		if len(chunk) > 0 {
+			wrote, werr := dst.Write(chunk)
+			if n += int64(wrote); werr != nil {
+				err = werr
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+	}
+	if read < size {
+		err = fmt.Errorf("%w: %d bytes instead of %d", errSizeMismatch, read, size)
+		return
+	}
+	wrote, err := dst.Write([]byte{last})
+	n += int64(wrote)
+	return
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gopkg.in/nntp.v0"
+)
+
+// errRangeWritten stops the fetching of the segments of a file once the requested range is written.
+var errRangeWritten = errors.New("range written")
+
+// rangeWriter writes the bytes of a range of the data written to it to w, discarding the others. The whole data is
+// reported as written, and errRangeWritten returned once the range is.
+type rangeWriter struct {
+	w         io.Writer
+	skip      int64
+	remaining int64
+}
+
+func (rw *rangeWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	if rw.skip >= int64(len(p)) {
+		rw.skip -= int64(len(p))
+		return
+	}
+	p, rw.skip = p[rw.skip:], 0
+	if int64(len(p)) > rw.remaining {
+		p = p[:rw.remaining]
+	}
+	if _, err = rw.w.Write(p); err != nil {
+		return
+	}
+	if rw.remaining -= int64(len(p)); rw.remaining == 0 {
+		err = errRangeWritten
+	}
+	return
+}
+
+// segmentPart fetches and decodes the segment i of the file.
+func (s *server) segmentPart(ctx context.Context, file *nzbFile, i int) (part *yEncPart, err error) {
+	return file.decodeSegment(i, s.fetchBody(ctx, file.Segments[i].MessageID))
+}
+
+// locateOffset returns the segment of the file holding the byte at offset, decoded, and the offset of its data in the
+// file. The segments of a multipart binary give the offsets of their parts in their =ypart line, and all but the last
+// are usually the same size, so the segment is guessed from the size of the first one, then the next or previous
+// segment fetched until the guess is right. Without part offsets, the segments are read from the first one.
+func (s *server) locateOffset(ctx context.Context, file *nzbFile, first *yEncPart, offset int64) (i int, part *yEncPart, start int64, err error) {
+	if first.Begin == 0 {
+		for part = first; offset >= start+int64(len(part.Data)); {
+			start += int64(len(part.Data))
+			if i++; i == len(file.Segments) {
+				err = fmt.Errorf("no segment holds offset %d", offset)
+				return
+			}
+			if part, err = s.segmentPart(ctx, file, i); err != nil {
+				return
+			}
+		}
+		return
+	}
+	if partSize := first.End - first.Begin + 1; partSize > 0 {
+		i = int(offset / partSize)
+	}
+	if i >= len(file.Segments) {
+		i = len(file.Segments) - 1
+	}
+	for tries := 0; tries < len(file.Segments); tries++ {
+		if i == 0 {
+			part = first
+		} else if part, err = s.segmentPart(ctx, file, i); err != nil {
+			return
+		}
+		if part.Begin == 0 {
+			err = fmt.Errorf("%s: no yEnc part offset", file.Segments[i].MessageID)
+			return
+		}
+		switch start = part.Begin - 1; {
+		case offset < start && i > 0:
+			i--
+		case offset > part.End-1 && i < len(file.Segments)-1:
+			i++
+		case offset < start || offset > part.End-1:
+			err = fmt.Errorf("no segment holds offset %d", offset)
+			return
+		default:
+			return
+		}
+	}
+	err = fmt.Errorf("no segment holds offset %d", offset)
+	return
+}
+
+// copyRange writes length bytes of the file from offset to w, fetching only the segments holding them. first is the
+// decoded first segment.
+func (s *server) copyRange(ctx context.Context, w io.Writer, file *nzbFile, first *yEncPart, offset, length int64) (err error) {
+	if length == 0 {
+		return
+	}
+	i, part, start, err := s.locateOffset(ctx, file, first, offset)
+	if err != nil {
+		return
+	}
+	rw := &rangeWriter{w: w, skip: offset - start, remaining: length}
+	if _, err = rw.Write(part.Data); err == nil {
+		rest := file.Segments[i+1:]
+		ids := make([]nntp.MessageID, len(rest))
+		for j := range rest {
+			ids[j] = rest[j].MessageID
+		}
+		err = s.fetchInOrder(ctx, ids, func(j int, result *batchResult) (err error) {
+			if part, err = file.decodeSegment(i+1+j, result); err == nil {
+				_, err = rw.Write(part.Data)
+			}
+			return
+		})
+		if err == nil {
+			err = fmt.Errorf("decoded %d bytes short of the range", rw.remaining)
+		}
+	}
+	if errors.Is(err, errRangeWritten) {
+		err = nil
+	}
+	return
+}
+
+// readRange returns length bytes of the file from offset, see copyRange.
+func (s *server) readRange(ctx context.Context, file *nzbFile, first *yEncPart, offset, length int64) (data []byte, err error) {
+	var buf bytes.Buffer
+	if err = s.copyRange(ctx, &buf, file, first, offset, length); err == nil {
+		data = buf.Bytes()
+	}
+	return
+}
+
+// serveFile sends the file of the segments, decoded and joined, or the single range of it requested by spec with 206
+// Partial Content. Only the segments holding the range are fetched, along with the first one giving the name and size
+// of the file, see locateOffset. Several ranges are answered with the whole file. With FastStart, MP4 videos are
+// served with their moov box first, see fastStartLayout. started reports whether the response is sent, after which an
+// error can only abort it.
+func (s *server) serveFile(ctx context.Context, w http.ResponseWriter, file *nzbFile, index int, spec string) (started bool, err error) {
+	// the segments of a file are posted together, at the date the NZB gives
+	ctx = withArticleDate(ctx, file.Date)
+	w.Header().Set("Accept-Ranges", "bytes")
+	first, err := s.segmentPart(ctx, file, 0)
+	if err != nil {
+		return
+	}
+	name, size := file.nameAndSize(first, index)
+	var layout *fastStart
+	if s.FastStart && isMP4(name) {
+		if layout, err = s.cachedFastStartLayout(ctx, file, first, size); errors.Is(err, errFastStart) {
+			logf(ctx, "[DEBUG] %s served as is: %s", name, err.Error())
+			err = nil
+		} else if err != nil {
+			// its ETag stands for the rearranged video, so it is sent whole and without one
+			logf(ctx, "[WARN] %s served as is: %s", name, err.Error())
+			w.Header().Del("ETag")
+			layout, spec, err = nil, "", nil
+		}
+	}
+
+	ra, status := httpRange{length: size}, http.StatusOK
+	ranges, err := parseRange(spec, size)
+	if errors.Is(err, errNoOverlap) {
+		err = nil
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		started = true
+		return
+	} else if err == nil && len(ranges) == 1 {
+		ra, status = ranges[0], http.StatusPartialContent
+		w.Header().Set("Content-Range", ra.contentRange(size))
+	}
+	err = nil
+	setFileHeaders(w, name, ra.length)
+	w.WriteHeader(status)
+	started = true
+	if layout != nil {
+		return started, layout.copyRange(ctx, s, w, file, first, ra.start, ra.length)
+	}
+	return started, s.copyRange(ctx, w, file, first, ra.start, ra.length)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// assetHashLength is the number of hex digits of the SHA-256 of an asset in its fingerprinted name.
+const assetHashLength = 10
+
+// assetCacheControl is the Cache-Control of the fingerprinted assets, whose content never changes under their name.
+const assetCacheControl = "public, max-age=31536000, immutable"
+
+// pageCacheControl is the Cache-Control of the pages of the web UI, revalidated with their ETag so that they always
+// refer to the assets of the version running.
+const pageCacheControl = "no-cache"
+
+// assetFS serves the static files with the assets under assets/ also at a fingerprinted name, such as
+// assets/usebin.3f2a9c1b0d.js, and the HTML pages referring to them by that name.
+type assetFS struct {
+	fs.FS
+	assets map[string]string // the asset of a fingerprinted name
+	pages  map[string]*assetPage
+}
+
+// assetPage is an HTML page with its references to the assets fingerprinted.
+type assetPage struct {
+	data []byte
+	etag string
+	info fs.FileInfo
+}
+
+// assetInfo is the FileInfo of a file with its size replaced.
+type assetInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (info assetInfo) Size() int64 {
+	return info.size
+}
+
+type assetFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *assetFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *assetFile) Close() error {
+	return nil
+}
+
+// fingerprint returns the name of the asset with the first assetHashLength hex digits of the SHA-256 of its content
+// before its extension.
+func fingerprint(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:assetHashLength] + ext
+}
+
+// newAssetFS fingerprints the assets of the static files, and the references to them in the HTML pages at the root, in
+// quoted "/assets/<name>" attributes.
+func newAssetFS(files fs.FS) (a *assetFS, err error) {
+	a = &assetFS{FS: files, assets: make(map[string]string), pages: make(map[string]*assetPage)}
+	var refs []string
+	err = fs.WalkDir(files, "assets", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		fingerprinted := fingerprint(name, data)
+		a.assets[fingerprinted] = name
+		refs = append(refs, "\"/"+name+"\"", "\"/"+fingerprinted+"\"")
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	replacer := strings.NewReplacer(refs...)
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".html" {
+			continue
+		}
+		var (
+			data []byte
+			info fs.FileInfo
+		)
+		if data, err = fs.ReadFile(files, entry.Name()); err != nil {
+			return
+		} else if info, err = entry.Info(); err != nil {
+			return
+		}
+		data = []byte(replacer.Replace(string(data)))
+		sum := sha256.Sum256(data)
+		a.pages[entry.Name()] = &assetPage{
+			data: data,
+			etag: "\"" + hex.EncodeToString(sum[:])[:assetHashLength] + "\"",
+			info: assetInfo{FileInfo: info, size: int64(len(data))},
+		}
+	}
+	return
+}
+
+func (a *assetFS) Open(name string) (fs.File, error) {
+	if asset, ok := a.assets[name]; ok {
+		return a.FS.Open(asset)
+	} else if page, ok := a.pages[name]; ok {
+		return &assetFile{Reader: bytes.NewReader(page.data), info: page.info}, nil
+	}
+	return a.FS.Open(name)
+}
+
+// fingerprinted reports whether the URL path is a fingerprinted asset.
+func (a *assetFS) fingerprinted(urlPath string) bool {
+	if a == nil {
+		return false
+	}
+	_, ok := a.assets[strings.TrimPrefix(urlPath, "/")]
+	return ok
+}
+
+// page returns the HTML page served at the URL path, the index for the paths without an extension, nil if it is not a
+// page.
+func (a *assetFS) page(urlPath string) *assetPage {
+	if a == nil {
+		return nil
+	}
+	name := strings.TrimPrefix(urlPath, "/")
+	if path.Ext(name) == "" {
+		name = "index.html"
+	}
+	return a.pages[name]
+}
+
+// withETags sets the ETag of the pages before handler serves them, for http.ServeContent to answer revalidations
+// with 304 Not Modified.
+func (a *assetFS) withETags(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if page := a.page(r.URL.Path); page != nil {
+			setETag(w, page.etag)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
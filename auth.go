@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// The route groups, which are also the roles granting access to them.
+const (
+	roleRead  = "read"
+	rolePost  = "post"
+	roleAdmin = "admin"
+)
+
+// The authentication methods of AuthMethods.
+const (
+	AuthAnonymous = "anonymous"
+	AuthBasic     = "basic"
+	AuthProxy     = "proxy"
+	AuthMTLS      = "mtls"
+)
+
+// authIdentity is who a request is authenticated as.
+type authIdentity struct {
+	Name   string
+	Method string
+	// Groups are the groups of the user told by the OAuth2 proxy
+	Groups []string
+	// From is the sender of the posts which don't set one, "" for a random one
+	From string
+}
+
+// identityKey is the context key of the identity of the request.
+type identityKey struct{}
+
+// identity returns who the request ctx belongs to is authenticated as, nil if anonymous.
+func identity(ctx context.Context) *authIdentity {
+	id, _ := ctx.Value(identityKey{}).(*authIdentity)
+	return id
+}
+
+// loadHtpasswd reads the users and password hashes of an htpasswd file. Only the {SHA} and $apr1$ hashes are
+// supported, as created by htpasswd -s and -m.
+func loadHtpasswd(path string) (users map[string]string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	users = make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expecting user:hash", path, n)
+		}
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$apr1$") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash of %s, expecting {SHA} or $apr1$ (htpasswd -s or -m)", path, n, user)
+		}
+		users[user] = hash
+	}
+	err = scanner.Err()
+	return
+}
+
+// checkHtpasswd reports whether password matches the htpasswd hash.
+func checkHtpasswd(hash, password string) bool {
+	var computed string
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	} else if salt, _, ok := strings.Cut(strings.TrimPrefix(hash, "$apr1$"), "$"); ok {
+		computed = apr1(password, salt)
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// apr1 returns the Apache MD5-based crypt hash of password with salt.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	alt := md5.Sum([]byte(password + salt + password))
+	d := md5.New()
+	d.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			d.Write(alt[:])
+		} else {
+			d.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	final := d.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(final)
+		}
+		if i%3 != 0 {
+			d.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 != 0 {
+			d.Write(final)
+		} else {
+			d.Write(pw)
+		}
+		final = d.Sum(nil)
+	}
+	var out []byte
+	encode := func(a, b, c byte, n int) {
+		for v := uint(a)<<16 | uint(b)<<8 | uint(c); n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(final[0], final[6], final[12], 4)
+	encode(final[1], final[7], final[13], 4)
+	encode(final[2], final[8], final[14], 4)
+	encode(final[3], final[9], final[15], 4)
+	encode(final[4], final[10], final[5], 4)
+	encode(0, 0, final[11], 2)
+	return magic + salt + "$" + string(out)
+}
+
+// routeGroup returns the route group of a request: posts are "post", the article routes and /batch and /nzb "read",
+// and the web UI and the API description "", which is never authenticated. /newid is "post", as it is only useful to
+// uploaders, and so is POST /share, handing out downloads to anyone with the link.
+func routeGroup(r *http.Request) string {
+	switch prefix := route(r.URL.Path); {
+	case r.URL.Path == "/newid" || prefix == "/verify/" || prefix == "/tus/" || r.Method == http.MethodPost && (prefix == "/m/" || prefix == "/d/" || prefix == "/a/" || prefix == "/share"):
+		return rolePost
+	case prefix == "static" || prefix == "/view/" || prefix == "/share/" || prefix == "/r/" || r.URL.Path == "/api" || r.URL.Path == "/stats" || r.URL.Path == "/healthz":
+		// the stats page is behind StatsAuth, and the token of a share link is the capability to download it
+		return ""
+	default:
+		return roleRead
+	}
+}
+
+// authenticate returns who the request is authenticated as with the first of methods it has credentials for, nil if
+// none. An error tells that the credentials it presented are invalid.
+func (s *server) authenticate(r *http.Request, methods []string) (id *authIdentity, err error) {
+	for _, method := range methods {
+		switch method {
+		case AuthBasic:
+			user, pass, ok := r.BasicAuth()
+			if !ok || s.htpasswd == nil {
+				continue
+			}
+			if hash, found := s.htpasswd[user]; !found || !checkHtpasswd(hash, pass) {
+				err = fmt.Errorf("invalid password of %q", user)
+				return
+			}
+			id = &authIdentity{Name: user, Method: method}
+		case AuthProxy:
+			// trust the headers of the proxy only, the address of the peer being the one before realIP
+			user := r.Header.Get(s.AuthUserHeader)
+			if user == "" || !s.isTrustedProxy(peerAddr(r)) {
+				continue
+			}
+			id = &authIdentity{Name: user, Method: method}
+			for _, group := range strings.Split(r.Header.Get(s.AuthGroupsHeader), ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					id.Groups = append(id.Groups, group)
+				}
+			}
+		case AuthMTLS:
+			// the certificate was verified against ClientCAFile during the handshake
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				continue
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+			id = &authIdentity{Name: certIdentity(cert), Method: method, From: s.certFrom(cert)}
+		default:
+			continue
+		}
+		return
+	}
+	return
+}
+
+// loadCertPool reads the PEM certificates of the CAs of the client certificates.
+func loadCertPool(path string) (pool *x509.CertPool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		pool, err = nil, fmt.Errorf("%s: no PEM certificate found", path)
+	}
+	return
+}
+
+// certIdentity is the name of the identity of a client certificate, its subject common name.
+func certIdentity(cert *x509.Certificate) string {
+	return cert.Subject.CommonName
+}
+
+// certFrom is the sender of the posts of a client certificate: its subject common name with the first email address of
+// the certificate, or the common name itself if it is an address, or else the common name under MessageIDDomain.
+func (s *server) certFrom(cert *x509.Certificate) string {
+	name := certIdentity(cert)
+	for _, email := range cert.EmailAddresses {
+		if _, err := mail.ParseAddress(email); err == nil {
+			return (&mail.Address{Name: name, Address: email}).String()
+		}
+	}
+	if address, err := mail.ParseAddress(name); err == nil && address.Name == "" {
+		return address.Address
+	}
+	// the characters out of a dot-atom separate its atoms
+	local := strings.Join(strings.FieldsFunc(name, func(c rune) bool {
+		return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune(atext, c))
+	}), ".")
+	if local == "" {
+		return ""
+	}
+	return (&mail.Address{Name: name, Address: local + "@" + s.MessageIDDomain}).String()
+}
+
+// hasRole reports whether AuthRoles grants role to the identity, by name, by one of its groups as "group:<name>", or
+// to any authenticated identity as "*".
+func (s *server) hasRole(id *authIdentity, role string) bool {
+	keys := []string{id.Name, "*"}
+	for _, group := range id.Groups {
+		keys = append(keys, "group:"+group)
+	}
+	for _, key := range keys {
+		for _, granted := range s.AuthRoles[key] {
+			if granted == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorize authenticates the request with the AuthMethods of its route group and checks the identity has the role of
+// the group, answering 401 Unauthorized or 403 Forbidden otherwise. The route groups listing "anonymous", or not
+// listed, let unauthenticated requests through. The identity is passed on in the context of the request.
+func (s *server) authorize(handler http.Handler) http.Handler {
+	if len(s.AuthMethods) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := routeGroup(r)
+		methods, ok := s.AuthMethods[group]
+		if group == "" || !ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		id, err := s.authenticate(r, methods)
+		if err != nil {
+			logf(r.Context(), "[ERROR] [Auth] %s %s unauthorized: %s", r.Method, r.URL.Path, err.Error())
+			s.unauthorized(w, methods)
+			return
+		}
+		if id == nil {
+			for _, method := range methods {
+				if method == AuthAnonymous {
+					handler.ServeHTTP(w, r)
+					return
+				}
+			}
+			s.unauthorized(w, methods)
+			return
+		}
+		if !s.hasRole(id, group) {
+			logf(r.Context(), "[ERROR] [Auth] %s %s forbidden to %s", r.Method, r.URL.Path, id.Name)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// unauthorized answers 401 Unauthorized, asking for basic authentication if it is one of the methods.
+func (s *server) unauthorized(w http.ResponseWriter, methods []string) {
+	for _, method := range methods {
+		if method == AuthBasic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="usebin", charset="UTF-8"`)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// validateAuth checks the AuthMethods and fills in the defaults of the other Auth keys.
+func (s *server) validateAuth() (err error) {
+	for group, methods := range s.AuthMethods {
+		if group != roleRead && group != rolePost && group != roleAdmin {
+			return fmt.Errorf("AuthMethods has an invalid route group %q, expecting read, post or admin", group)
+		}
+		for _, method := range methods {
+			switch method {
+			case AuthAnonymous:
+			case AuthProxy:
+				if len(s.TrustedProxies) == 0 {
+					return fmt.Errorf("AuthMethods of %s has proxy without TrustedProxies", group)
+				}
+			case AuthBasic:
+				if s.Htpasswd == "" {
+					return fmt.Errorf("AuthMethods of %s has basic without an Htpasswd", group)
+				}
+			case AuthMTLS:
+				if s.ClientCAFile == "" {
+					return fmt.Errorf("AuthMethods of %s has mtls without a ClientCAFile", group)
+				}
+				if group == roleAdmin && (s.CertFile == "" || s.KeyFile == "") {
+					// the admin port serves TLS with them for the client certificates to be presented
+					return fmt.Errorf("AuthMethods of admin has mtls without a CertFile and KeyFile")
+				}
+			default:
+				return fmt.Errorf("AuthMethods of %s has an invalid method %q", group, method)
+			}
+		}
+	}
+	if s.AuthUserHeader == "" {
+		s.AuthUserHeader = "X-Forwarded-User"
+	}
+	if s.AuthGroupsHeader == "" {
+		s.AuthGroupsHeader = "X-Forwarded-Groups"
+	}
+	return
+}
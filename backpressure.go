@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrPoolSaturated is matched by the error of a Get given up because too many Gets are waiting for a conn to the
+// server, or waited for too long, see MaxPoolWaiters and MaxPoolWait.
+var ErrPoolSaturated = errors.New("pool saturated")
+
+const (
+	// waitAvgWeight is the weight of the last wait in the moving average of the waits for a conn.
+	waitAvgWeight = 0.2
+	// minRetryAfter and maxRetryAfter bound the Retry-After sent when the pool is saturated.
+	minRetryAfter = time.Second
+	maxRetryAfter = time.Minute
+)
+
+// saturatedError is the error of a Get given up on a saturated server, telling when to try again.
+type saturatedError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *saturatedError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %s", e.Host, ErrPoolSaturated.Error(), e.RetryAfter)
+}
+
+func (e *saturatedError) Is(target error) bool {
+	return target == ErrPoolSaturated
+}
+
+// SetSaturation makes the Gets fail with ErrPoolSaturated instead of waiting for a conn to a server when maxWaiters
+// Gets already wait for one, or once they have waited for maxWait. 0 disables either limit.
+func (p *Pool) SetSaturation(maxWaiters int, maxWait time.Duration) {
+	for _, sp := range p.all() {
+		sp.mu.Lock()
+		sp.maxWaiters, sp.maxWait = maxWaiters, maxWait
+		sp.mu.Unlock()
+	}
+}
+
+// saturated counts a Get given up and returns its error. The Retry-After is an estimate of how long the Gets waiting
+// will take to be served: the average recent wait, longer the more Gets are waiting per conn. sp.mu must be held.
+func (sp *serverPool) saturated() error {
+	sp.rejected++
+	retryAfter := sp.waitAvg + sp.waitAvg*time.Duration(len(sp.waiters))/time.Duration(sp.server.Connections)
+	if retryAfter < minRetryAfter {
+		retryAfter = minRetryAfter
+	} else if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+	return &saturatedError{Host: sp.server.Host, RetryAfter: retryAfter.Round(time.Second)}
+}
+
+// waited records how long a Get waited for a conn in the moving average of the waits.
+func (sp *serverPool) waited(wait time.Duration) {
+	sp.mu.Lock()
+	sp.waitAvg += time.Duration(waitAvgWeight * float64(wait-sp.waitAvg))
+	sp.mu.Unlock()
+}
+
+// unavailable sends 503 Service Unavailable with a Retry-After if err is the pool being saturated, so clients back off
+// instead of piling up behind the requests already waiting, or 502 Bad Gateway if it is ErrBackendFailure, so that the
+// article isn't cached as missing like a 404 Not Found would be, unless NNTPCodes gives another status to the NNTP code
+// it failed with or FetchTimeout passed, which is 504 Gateway Timeout.
+func unavailable(w http.ResponseWriter, err error) bool {
+	var saturated *saturatedError
+	if errors.Is(err, ErrBackendFailure) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(backendStatus(err))
+		return true
+	} else if !errors.As(err, &saturated) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(saturated.RetryAfter/time.Second)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	"gopkg.in/nntp.v0"
+)
+
+type batchResult struct {
+	header textproto.MIMEHeader
+	body   []byte
+	status int
+}
+
+// fetchBody downloads the dot-decoded article body into memory, limited to ArticleMemoryLimit.
+func (s *server) fetchBody(ctx context.Context, messageID nntp.MessageID) (result *batchResult) {
+	var (
+		err     error
+		nntpErr *nntp.Error
+		conn    *nntp.Conn
+		article *nntp.Article
+	)
+	result = &batchResult{header: make(textproto.MIMEHeader)}
+	if s.takenDown(ctx, messageID) {
+		result.status = http.StatusUnavailableForLegalReasons
+		return
+	}
+
+	defer func() {
+		if conn != nil {
+			if err == nil || errors.As(err, &nntpErr) {
+				s.pool.Put(conn)
+			} else {
+				s.pool.Close(conn)
+			}
+		} else {
+			closeArticle(article)
+		}
+	}()
+
+	if conn, article, err = s.lookup(ctx, messageID, false, "ARTICLE", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdArticle(nntp.ArticleMessageID(messageID))
+	}); errors.Is(err, ErrArticleNotFound) {
+		result.status = http.StatusNotFound
+		return
+	} else if err != nil {
+		logf(ctx, "[ERROR] BATCH %s %s", messageID, err.Error())
+		result.status = http.StatusInternalServerError
+		if errors.Is(err, ErrPoolSaturated) {
+			result.status = http.StatusServiceUnavailable
+		} else if errors.Is(err, ErrBackendFailure) {
+			result.status = backendStatus(err)
+		}
+		return
+	}
+	if result.body, err = io.ReadAll(io.LimitReader(article.Body, int64(s.ArticleMemoryLimit)+1)); err != nil {
+		logf(ctx, "[ERROR] BATCH %s read error: %s", messageID, err.Error())
+		result.status = http.StatusInternalServerError
+		return
+	}
+	if uint64(len(result.body)) > s.ArticleMemoryLimit {
+		logf(ctx, "[ERROR] BATCH %s size exceeds limit", messageID)
+		result.body = nil
+		result.status = s.oversizeStatus()
+		setErrorCode(ctx, "too_large")
+		// the rest of the body is drained by the next command on the connection
+		return
+	}
+	if conn != nil {
+		s.populate(ctx, messageID, article.Header, bytes.NewReader(result.body))
+	}
+	s.remember(ctx, messageID, article, result.body)
+	s.recordServed(messageID, conn, int64(len(result.body)))
+	copyUsenetHeaders(result.header, article.Header)
+	result.status = http.StatusOK
+	return
+}
+
+// fetchInOrder fetches the article bodies concurrently, up to BatchConcurrency of them at the same time, and calls
+// handle with each result in the order of ids. Articles are dispatched in order, and a slot is only released once its
+// result is handled, so memory is bounded to BatchConcurrency bodies and handle never waits on an article which is not
+// being fetched. Fetching stops when ctx is done or handle returns an error.
+func (s *server) fetchInOrder(ctx context.Context, ids []nntp.MessageID, handle func(i int, result *batchResult) error) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	slots := make(chan struct{}, s.BatchConcurrency)
+	results := make([]chan *batchResult, len(ids))
+	for i := range results {
+		results[i] = make(chan *batchResult, 1)
+	}
+	go func() {
+		for i, id := range ids {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int, id nntp.MessageID) {
+				results[i] <- s.fetchBody(ctx, id)
+			}(i, id)
+		}
+	}()
+	for i := range ids {
+		var result *batchResult
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err = handle(i, result)
+		<-slots
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// handleBatch serves POST /batch, where the body is a JSON array of message-ids. The articles are fetched concurrently
+// through the pool, and streamed back in the requested order as a multipart/mixed response with one part per
+// message-id. Each part carries its message-id in Content-ID and the fetch result in X-Usebin-Status.
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var ids []nntp.MessageID
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(&ids); err != nil {
+		logf(r.Context(), "[ERROR] BATCH invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(ids) > s.BatchSizeLimit {
+		logf(r.Context(), "[ERROR] BATCH %d message-ids exceed limit", len(ids))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	for _, id := range ids {
+		if id == "" || id.Validate() != nil {
+			logf(r.Context(), "[ERROR] BATCH invalid message-id %q", id)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	if err := s.fetchInOrder(r.Context(), ids, func(i int, result *batchResult) (err error) {
+		result.header.Set("Content-ID", string(ids[i].Full()))
+		result.header.Set("Content-Type", "text/plain; charset=utf-8")
+		result.header.Set("X-Usebin-Status", strconv.Itoa(result.status))
+		var part io.Writer
+		if part, err = mw.CreatePart(result.header); err == nil {
+			_, err = part.Write(result.body)
+		}
+		return
+	}); err != nil {
+		if !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] BATCH write error: %s", err.Error())
+		}
+		panic(http.ErrAbortHandler)
+	}
+	mw.Close()
+	logf(r.Context(), "[INFO] BATCH %d articles", len(ids))
+}
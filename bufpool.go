@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// minBufferClass is the size of the smallest buffers of a bufferPool, enough for most NFO and text files.
+const minBufferClass = 64 * 1024
+
+// bufferClassGrowth is the ratio between the sizes of two consecutive classes of a bufferPool.
+const bufferClassGrowth = 4
+
+// bufferPool hands out the buffers articles are read into, in size classes growing from minBufferClass by
+// bufferClassGrowth up to the limit, each with its own sync.Pool. A small article only holds a buffer of the smallest
+// class fitting it while it is served, instead of one of ArticleSizeLimit.
+type bufferPool struct {
+	sizes []int
+	pools []sync.Pool
+}
+
+func newBufferPool(limit int) *bufferPool {
+	p := &bufferPool{}
+	for size := minBufferClass; size < limit; size *= bufferClassGrowth {
+		p.sizes = append(p.sizes, size)
+	}
+	p.sizes = append(p.sizes, limit)
+	p.pools = make([]sync.Pool, len(p.sizes))
+	for i := range p.pools {
+		size := p.sizes[i]
+		p.pools[i].New = func() any {
+			return make([]byte, size)
+		}
+	}
+	return p
+}
+
+// class returns the index of the smallest class of at least size bytes, the largest one if none is.
+func (p *bufferPool) class(size int) int {
+	for i, classSize := range p.sizes {
+		if classSize >= size {
+			return i
+		}
+	}
+	return len(p.sizes) - 1
+}
+
+// Get returns a buffer of the smallest class of at least size bytes, which must be given back with Put. It is
+// shorter than size if size is more than the limit.
+func (p *bufferPool) Get(size int) []byte {
+	return p.pools[p.class(size)].Get().([]byte)
+}
+
+// Put gives back a buffer returned by Get or ReadFull. Buffers of another size are dropped.
+func (p *bufferPool) Put(buf []byte) {
+	buf = buf[:cap(buf)]
+	if i := p.class(len(buf)); p.sizes[i] == len(buf) {
+		p.pools[i].Put(buf)
+	}
+}
+
+// ReadFull reads r until io.EOF into a buffer of the pool, moving to a buffer of the next class whenever it is full,
+// until the one of the limit is. It returns the buffer, which must be given back with Put even if err is not nil, and
+// the number of bytes read: if it is the limit, r may have more.
+func (p *bufferPool) ReadFull(r io.Reader) (buf []byte, n int, err error) {
+	i := 0
+	buf = p.pools[i].Get().([]byte)
+	for {
+		var m int
+		m, err = io.ReadFull(r, buf[n:])
+		n += m
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			return
+		} else if err != nil || i == len(p.sizes)-1 {
+			return
+		}
+		i++
+		next := p.pools[i].Get().([]byte)
+		copy(next, buf[:n])
+		p.pools[i-1].Put(buf)
+		buf = next
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+const defaultCacheControl = "public, max-age=2592000"
+
+// defaultNotFoundCacheControl lets the caches answer a missing article for a minute, then serve it stale for 10 more
+// while they re-check it, since it may still be propagating to the NNTP servers.
+const defaultNotFoundCacheControl = "public, max-age=60, stale-while-revalidate=600"
+
+// ETag formats of a route.
+const (
+	ETagStrong = ""
+	ETagWeak   = "weak"
+	ETagNone   = "none"
+)
+
+// RouteCaching overrides the caching headers of the responses of a route, e.g. to add the immutable or s-maxage
+// directives a CDN honors.
+type RouteCaching struct {
+	// CacheControl replaces the default Cache-Control header if set
+	CacheControl string
+	// NotFoundCacheControl replaces the default Cache-Control header of the 404 Not Found responses if set
+	NotFoundCacheControl string
+	// ETag is how the ETag of an article is sent, see the ETag formats
+	ETag string
+}
+
+// cachedRoutes are the routes whose caching can be configured, as named by route.
+var cachedRoutes = []string{"/m/", "/d/", "/h/", "/f/", "/p/", "/sum/", "/j/", "/c/", "/a/", "/join/", "/view/", "static"}
+
+func validateRouteCaching(routes map[string]RouteCaching) (err error) {
+	for name, caching := range routes {
+		known := false
+		for _, cached := range cachedRoutes {
+			known = known || name == cached
+		}
+		if !known {
+			return fmt.Errorf("invalid route %q in RouteCaching, expecting one of %q", name, cachedRoutes)
+		}
+		switch caching.ETag {
+		case ETagStrong, ETagWeak, ETagNone:
+		default:
+			return fmt.Errorf("invalid ETag format %q for route %s", caching.ETag, name)
+		}
+	}
+	return
+}
+
+// cacheControl returns the Cache-Control header of the responses to the request. The fingerprinted assets are cached
+// for good, and the pages of the web UI revalidated unless the route says otherwise.
+func (s *server) cacheControl(r *http.Request) string {
+	if forcedServer(r.Context()) != "" {
+		return "no-store"
+	}
+	prefix := route(r.URL.Path)
+	if prefix == "static" && s.assets.fingerprinted(r.URL.Path) {
+		return assetCacheControl
+	}
+	if caching := s.RouteCaching[prefix]; caching.CacheControl != "" {
+		return caching.CacheControl
+	}
+	if prefix == "/view/" || prefix == "static" && s.assets.page(r.URL.Path) != nil {
+		return pageCacheControl
+	}
+	return defaultCacheControl
+}
+
+// notFoundCacheControl returns the Cache-Control header of the 404 Not Found responses to the request.
+func (s *server) notFoundCacheControl(r *http.Request) string {
+	if caching := s.RouteCaching[route(r.URL.Path)]; caching.NotFoundCacheControl != "" {
+		return caching.NotFoundCacheControl
+	}
+	return defaultNotFoundCacheControl
+}
+
+// notFoundResponseWriter sends a 404 Not Found with the Cache-Control of the missing articles instead of the one of
+// the route, so that the caches don't keep answering it once the article has propagated. If retryAfter is set, for an
+// article posted in the PropagationWindow, 503 Service Unavailable is sent instead, with a Retry-After.
+type notFoundResponseWriter struct {
+	http.ResponseWriter
+	ctx          context.Context
+	cacheControl string
+	retryAfter   time.Duration
+}
+
+func (nw *notFoundResponseWriter) WriteHeader(status int) {
+	if status == http.StatusNotFound && nw.retryAfter > 0 {
+		setErrorCode(nw.ctx, "propagating")
+		nw.Header().Set("Retry-After", strconv.Itoa(int(nw.retryAfter/time.Second)))
+		nw.Header().Set("Cache-Control", "no-store")
+		status = http.StatusServiceUnavailable
+	} else if status == http.StatusNotFound {
+		nw.Header().Set("Cache-Control", nw.cacheControl)
+	}
+	nw.ResponseWriter.WriteHeader(status)
+}
+
+func (nw *notFoundResponseWriter) Flush() {
+	if flusher, ok := nw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (nw *notFoundResponseWriter) Unwrap() http.ResponseWriter {
+	return nw.ResponseWriter
+}
+
+// routeETag returns the ETag of the article served by the request in the format of its route, or an empty string if
+// the route sends none.
+func (s *server) routeETag(r *http.Request, messageID nntp.MessageID) string {
+	return s.formatETag(r, messageETag(messageID))
+}
+
+// formatETag returns the strong etag in the ETag format of the route of the request.
+func (s *server) formatETag(r *http.Request, etag string) string {
+	switch s.RouteCaching[route(r.URL.Path)].ETag {
+	case ETagWeak:
+		return "W/" + etag
+	case ETagNone:
+		return ""
+	}
+	return etag
+}
+
+// checkArticlePreconditions is checkPreconditions for the routes serving an article. With RevalidateArticles, a
+// re-validation is only answered with StatusNotModified once the article is known to be still available: without asking
+// the NNTP servers if it is in the memory cache or the store, otherwise with STAT. An article expired or removed from
+// Usenet is answered with StatusNotFound, so the caches drop it. The article is not downloaded either way.
+func (s *server) checkArticlePreconditions(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID, etag string) (done bool, rangeHeader string) {
+	if s.RevalidateArticles && revalidates(r, etag) {
+		found, err := s.statArticle(r.Context(), messageID)
+		if err != nil {
+			logf(r.Context(), "[ERROR] %s %s revalidation %s", r.Method, messageID, err.Error())
+			if !unavailable(w, err) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return true, ""
+		}
+		if !found {
+			logf(r.Context(), "[ERROR] %s %s revalidation not found", r.Method, messageID)
+			w.WriteHeader(http.StatusNotFound)
+			return true, ""
+		}
+	}
+	return checkPreconditions(w, r, etag)
+}
+
+// setETag sets the ETag header, unless the route sends none.
+func setETag(w http.ResponseWriter, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
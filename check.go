@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// checkReport prints the outcome of every config check, remembering whether any of them failed.
+type checkReport struct {
+	w      io.Writer
+	failed bool
+}
+
+func (c *checkReport) ok(format string, args ...any) {
+	fmt.Fprintf(c.w, "OK    "+format+"\n", args...)
+}
+
+func (c *checkReport) warn(format string, args ...any) {
+	fmt.Fprintf(c.w, "WARN  "+format+"\n", args...)
+}
+
+func (c *checkReport) fail(format string, args ...any) {
+	c.failed = true
+	fmt.Fprintf(c.w, "ERROR "+format+"\n", args...)
+}
+
+// checkDir reports whether dir exists or can be created, and files can be written into it.
+func checkDir(dir string) (err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	file, err := os.CreateTemp(dir, "*.check")
+	if err != nil {
+		return
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// check validates the config without serving, reporting every problem found instead of stopping at the first one. If
+// dial is set, every NNTP server is connected to and authenticated with, and so is the LocatorURL. It reports whether
+// the config is usable.
+func (s *server) check(w io.Writer, dial bool) bool {
+	c := &checkReport{w: w}
+	if err := s.applyDefaults(); err != nil {
+		c.fail("config: %s", err.Error())
+		return false
+	}
+
+	posting := false
+	for i, server := range s.NNTPServers {
+		name := fmt.Sprintf("NNTPServers[%d] %s", i, server.Host)
+		host, _, err := net.SplitHostPort(server.Host)
+		if err != nil {
+			c.fail("%s: invalid host, expecting host:port: %s", name, err.Error())
+			continue
+		}
+		if _, err = net.DefaultResolver.LookupHost(context.Background(), host); err != nil {
+			c.fail("%s: cannot resolve host: %s", name, err.Error())
+			continue
+		}
+		if server.Posting {
+			posting = true
+		} else if server.Feed != FeedPost {
+			c.warn("%s: Feed is set but Posting is not, the server is never posted to", name)
+		}
+		if server.RateLimit < 0 {
+			c.fail("%s: negative RateLimit", name)
+		}
+		if server.Retention < 0 {
+			c.fail("%s: negative Retention", name)
+		}
+		if server.Connections != 0 && server.MinIdleConnections > server.Connections {
+			c.warn("%s: MinIdleConnections is more than Connections", name)
+		}
+		if server.ReservedPostingConnections > 0 && !server.Posting {
+			c.warn("%s: ReservedPostingConnections is set but Posting is not, the connections are never reserved", name)
+		}
+		if !dial {
+			c.ok("%s: host resolved", name)
+			continue
+		}
+		conn, postingAllowed, err := server.newConn(context.Background(), nil)
+		if err != nil {
+			c.fail("%s: cannot connect: %s", name, err.Error())
+			continue
+		}
+		conn.Close()
+		if server.Feed == FeedPost && server.Posting && !postingAllowed {
+			c.warn("%s: the server prohibits posting but Posting is set, posts will fail", name)
+		} else if server.Feed == FeedPost && !server.Posting && postingAllowed {
+			c.warn("%s: the server allows posting but Posting is not set", name)
+		}
+		if server.User != "" {
+			c.ok("%s: connected and authenticated", name)
+		} else {
+			c.ok("%s: connected", name)
+		}
+	}
+	if len(s.NNTPServers) == 0 {
+		c.ok("no NNTPServers, running in local-only mode with StoreDir %s", s.StoreDir)
+	} else if !posting {
+		c.warn("none of the NNTPServers has Posting enabled, posts will fail")
+	}
+
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		c.fail("both CertFile and KeyFile are required to enable TLS")
+	} else if s.CertFile != "" {
+		if cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile); err != nil {
+			c.fail("CertFile/KeyFile: %s", err.Error())
+		} else if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			c.fail("CertFile: %s", err.Error())
+		} else if remaining := time.Until(leaf.NotAfter); remaining <= 0 {
+			c.fail("CertFile: certificate expired at %s", leaf.NotAfter)
+		} else if remaining < 14*24*time.Hour {
+			c.warn("CertFile: certificate expires at %s", leaf.NotAfter)
+		} else {
+			c.ok("CertFile: certificate for %v valid until %s", leaf.DNSNames, leaf.NotAfter)
+		}
+		if _, err := s.tlsOptions(); err != nil {
+			c.fail("TLS: %s", err.Error())
+		}
+		seen := make(map[string]bool)
+		for _, addr := range append(append([]string(nil), s.listenAddrs()...), s.plainListenAddrs()...) {
+			if seen[addr] && !strings.HasSuffix(addr, ":0") {
+				c.fail("%s is listened to twice, by Listen or Port and HTTPListen or HTTPPort", addr)
+			}
+			seen[addr] = true
+		}
+	} else {
+		if s.HTTPPort != 0 || len(s.HTTPListen) > 0 || s.RedirectHTTP {
+			c.warn("HTTPPort, HTTPListen and RedirectHTTP only apply when TLS is enabled")
+		}
+		if s.HSTSMaxAge != 0 {
+			c.warn("HSTSMaxAge only applies when TLS is enabled")
+		}
+		if s.TLSMinVersion != "" || len(s.TLSCipherSuites) > 0 || len(s.TLSCurvePreferences) > 0 || s.TLSClientAuth != "" {
+			c.warn("TLSMinVersion, TLSCipherSuites, TLSCurvePreferences and TLSClientAuth only apply when TLS is enabled")
+		}
+	}
+
+	if s.MemoryCacheSize > 0 && s.MemoryArticleLimit > s.MemoryCacheSize {
+		c.warn("MemoryArticleLimit is more than MemoryCacheSize")
+	}
+	for i, policy := range s.NewsgroupPolicies {
+		if policy.MaxArticleSize > s.ArticleSizeLimit {
+			c.warn("NewsgroupPolicies[%d] MaxArticleSize is more than ArticleSizeLimit", i)
+		}
+	}
+	if s.ArticleMemoryLimit > 64*1024*1024 {
+		c.warn("up to ArticleMemoryLimit of %d bytes are held in memory for every GET request", s.ArticleMemoryLimit)
+	}
+	if s.BatchSizeLimit < 0 || s.BatchConcurrency < 0 || s.JoinScanRange < 0 {
+		c.fail("BatchSizeLimit, BatchConcurrency and JoinScanRange cannot be negative")
+	}
+	if s.EgressRateLimit < 0 || s.RequestRateLimit < 0 {
+		c.fail("EgressRateLimit and RequestRateLimit cannot be negative")
+	}
+	if s.IdleConnExpiry < 0 || s.IdleConnProbe < 0 || s.HedgeDelay < 0 || s.SpoolRetryDelay < 0 || s.SpoolMaxAttempts < 0 {
+		c.fail("IdleConnExpiry, IdleConnProbe, HedgeDelay, SpoolRetryDelay and SpoolMaxAttempts cannot be negative")
+	}
+	if s.FetchRetries != nil && *s.FetchRetries < 0 || s.FetchTimeout < 0 {
+		c.fail("FetchRetries and FetchTimeout cannot be negative")
+	}
+	if s.VerifyAttempts < 0 || s.VerifyDelay < 0 {
+		c.fail("VerifyAttempts and VerifyDelay cannot be negative")
+	}
+	if s.PropagationWindow < 0 {
+		c.fail("PropagationWindow cannot be negative")
+	} else if s.PropagationWindow > 0 && len(s.NNTPServers) == 0 {
+		c.warn("PropagationWindow is ignored in local-only mode")
+	}
+	if s.TusSegmentSize < 0 || s.TusMaxSize < 0 {
+		c.fail("TusSegmentSize and TusMaxSize cannot be negative")
+	} else if uint64(s.TusSegmentSize) > s.ArticleSizeLimit {
+		c.warn("TusSegmentSize is more than ArticleSizeLimit")
+	}
+	if s.MaxPoolWaiters < 0 || s.MaxPoolWait < 0 {
+		c.fail("MaxPoolWaiters and MaxPoolWait cannot be negative")
+	}
+	if s.RemoteConfigInterval < 0 {
+		c.fail("RemoteConfigInterval cannot be negative")
+	} else if s.RemoteConfigURL != "" {
+		c.ok("RemoteConfigURL %s refreshed every %d seconds", s.RemoteConfigURL, s.RemoteConfigInterval)
+	}
+	if s.TraceSampleRatio < 0 || s.TraceSampleRatio > 1 {
+		c.fail("TraceSampleRatio must be between 0 and 1")
+	}
+	if s.ShadowReadRatio < 0 || s.ShadowReadRatio > 1 {
+		c.fail("ShadowReadRatio must be between 0 and 1")
+	} else if s.ShadowReadRatio > 0 && len(s.NNTPServers) < 2 {
+		c.warn("ShadowReadRatio is set with less than 2 NNTPServers to compare")
+	}
+
+	dirs := []struct{ key, path string }{{"SpoolDir", s.SpoolDir}, {"StoreDir", s.StoreDir}, {"DavDir", s.DavDir}, {"TusDir", s.TusDir}}
+	if s.TakedownDB != "" {
+		dirs = append(dirs, struct{ key, path string }{"TakedownDB", filepath.Dir(s.TakedownDB)})
+	}
+	if s.IndexDB != "" {
+		dirs = append(dirs, struct{ key, path string }{"IndexDB", filepath.Dir(s.IndexDB)})
+	}
+	if s.QuotaDB != "" {
+		dirs = append(dirs, struct{ key, path string }{"QuotaDB", filepath.Dir(s.QuotaDB)})
+		if len(s.AuthMethods) == 0 {
+			c.warn("QuotaDB is set without AuthMethods, the quotas only apply to authenticated identities")
+		}
+	}
+	if s.ShareDB != "" {
+		dirs = append(dirs, struct{ key, path string }{"ShareDB", filepath.Dir(s.ShareDB)})
+	}
+	if s.ArticleMemoryLimit < s.ArticleSizeLimit {
+		dirs = append(dirs, struct{ key, path string }{"LargeArticleDir", s.largeArticleDir()})
+	}
+	if s.AliasDB != "" {
+		dirs = append(dirs, struct{ key, path string }{"AliasDB", filepath.Dir(s.AliasDB)})
+	}
+	for _, dir := range dirs {
+		if dir.path == "" {
+			continue
+		}
+		if err := checkDir(dir.path); err != nil {
+			c.fail("%s: %s", dir.key, err.Error())
+		} else {
+			c.ok("%s: %s is writable", dir.key, dir.path)
+		}
+	}
+	if s.SpoolDir != "" && len(s.NNTPServers) == 0 {
+		c.warn("SpoolDir is ignored in local-only mode")
+	}
+
+	if s.AdminPort != 0 && s.AdminPass == "" && len(s.AuthMethods[roleAdmin]) == 0 {
+		c.fail("AdminPort is set without an AdminPass or admin AuthMethods")
+	}
+	if s.Htpasswd != "" {
+		if users, err := loadHtpasswd(s.Htpasswd); err != nil {
+			c.fail("Htpasswd: %s", err.Error())
+		} else {
+			c.ok("Htpasswd: %d users", len(users))
+		}
+	}
+	if s.ClientCAFile != "" {
+		if _, err := loadCertPool(s.ClientCAFile); err != nil {
+			c.fail("ClientCAFile: %s", err.Error())
+		} else if s.CertFile == "" {
+			c.warn("ClientCAFile only applies when TLS is enabled")
+		} else {
+			c.ok("ClientCAFile: valid")
+		}
+	}
+
+	if s.LocatorURL != "" {
+		if locator, err := newRedisLocator(s.LocatorURL, time.Duration(s.LocatorTTL)*time.Second, 5*time.Second); err != nil {
+			c.fail("LocatorURL: %s", err.Error())
+		} else if !dial {
+			c.ok("LocatorURL: valid")
+		} else if err = locator.Ping(context.Background()); err != nil {
+			c.fail("LocatorURL: cannot connect: %s", err.Error())
+		} else {
+			c.ok("LocatorURL: connected")
+		}
+		if len(s.NNTPServers) == 0 {
+			c.warn("LocatorURL is ignored in local-only mode")
+		}
+	}
+
+	if !c.failed {
+		c.ok("config is valid")
+	}
+	return !c.failed
+}
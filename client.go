@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// getArticle writes the body of the article to w, preceded by its header if withHeader is set, fetching it the same way
+// as GET /m/ does. If dotEncoded is set, the body is written as sent over NNTP, like GET /d/.
+func (s *server) getArticle(ctx context.Context, w io.Writer, messageID nntp.MessageID, withHeader bool, dotEncoded bool) (err error) {
+	var (
+		nntpErr *nntp.Error
+		conn    *nntp.Conn
+		article *nntp.Article
+	)
+
+	defer func() {
+		if conn != nil {
+			if err == nil || errors.As(err, &nntpErr) {
+				s.pool.Put(conn)
+			} else {
+				s.pool.Close(conn)
+			}
+		} else {
+			closeArticle(article)
+		}
+	}()
+
+	if conn, article, err = s.lookup(ctx, messageID, dotEncoded, "ARTICLE", func(conn *nntp.Conn) (*nntp.Article, error) {
+		if dotEncoded {
+			return conn.CmdArticle(nntp.ArticleMessageID(messageID), nntp.WithDotEncodedBody())
+		}
+		return conn.CmdArticle(nntp.ArticleMessageID(messageID))
+	}); err != nil {
+		return
+	}
+
+	if withHeader {
+		keys := make([]string, 0, len(article.Header))
+		for key := range article.Header {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, value := range article.Header[key] {
+				if _, err = fmt.Fprintf(w, "%s: %s\n", key, value); err != nil {
+					return
+				}
+			}
+		}
+		if _, err = io.WriteString(w, "\n"); err != nil {
+			return
+		}
+	}
+	_, err = io.Copy(w, article.Body)
+	return
+}
+
+// postFile posts the contents of the file at path as the body of a new article, the same way as POST /m/ does with the
+// f, g and s query parameters. If messageID is empty, a random ngPost-like one is used. The message-id posted is
+// returned.
+func (s *server) postFile(ctx context.Context, path string, messageID nntp.MessageID, from, newsgroups, subject string) (posted nntp.MessageID, err error) {
+	var (
+		file *os.File
+		info os.FileInfo
+		ngID string
+	)
+
+	if messageID == "" {
+		if ngID, err = ngPostID(); err != nil {
+			return
+		}
+		messageID = nntp.MessageID(ngID + "@ngPost.com")
+	} else if err = messageID.Validate(); err != nil {
+		return
+	}
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+	if info, err = file.Stat(); err != nil {
+		return
+	} else if info.Size() > int64(s.ArticleSizeLimit) {
+		err = fmt.Errorf("%s is larger than ArticleSizeLimit", path)
+		return
+	}
+
+	header := make(textproto.MIMEHeader)
+	if err = s.fillPostHeader(header, messageID, from, newsgroups, subject); err != nil {
+		return
+	}
+	if s.StatBeforePost {
+		var found bool
+		if found, err = s.statArticle(ctx, messageID); err != nil {
+			return
+		} else if found {
+			err = fmt.Errorf("%s already exists", messageID)
+			return
+		}
+	}
+	if err = s.postArticle(ctx, &nntp.Article{MessageID: messageID, Header: header, Body: file}, false); err != nil {
+		return
+	}
+	posted = messageID
+	return
+}
@@ -0,0 +1,249 @@
+// Package client is a Go client of the usebin HTTP API, see the API section of the README and /openapi.json.
+//
+// Message-IDs are given with or without their angle brackets. The article headers are mapped to and from the X-Usenet-
+// HTTP headers, so Article.Header and PostOptions.Header hold them without the prefix.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// usenetPrefix is the prefix of the article headers among the HTTP headers.
+const usenetPrefix = "X-Usenet-"
+
+var (
+	// ErrNotFound is matched by the StatusError of an article found on none of the store and the NNTP servers.
+	ErrNotFound = errors.New("article not found")
+	// ErrTakenDown is matched by the StatusError of an article taken down on the server.
+	ErrTakenDown = errors.New("article taken down")
+	// ErrExists is matched by the StatusError of a post of an article that already exists or was rejected.
+	ErrExists = errors.New("article already exists")
+)
+
+// StatusError is the error of a request answered with an unexpected status code.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// RequestID is the X-Request-Id of the response, which tags the log lines of the request on the server.
+	RequestID string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Is matches ErrNotFound, ErrTakenDown and ErrExists by the status code.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrTakenDown:
+		return e.StatusCode == http.StatusUnavailableForLegalReasons
+	case ErrExists:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// Client sends requests to a usebin server.
+type Client struct {
+	// BaseURL is the URL of the server, like https://useb.in.
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// New returns a client of the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Article is an article as returned by Get and GetRaw. The caller must close its Body.
+type Article struct {
+	// Header holds the article headers.
+	Header http.Header
+	// ContentLength is the size of the body, -1 if unknown.
+	ContentLength int64
+	Body          io.ReadCloser
+}
+
+// File is a file decoded by the server, as returned by PostNZB. The caller must close its Body.
+type File struct {
+	Name string
+	// Size is the size of the file, -1 for an archive, which is built as it is streamed.
+	Size int64
+	Body io.ReadCloser
+}
+
+// PostOptions are the headers of a posted article. The server fills in the ones left empty.
+type PostOptions struct {
+	From       string
+	Newsgroups string
+	Subject    string
+	// Header holds other article headers.
+	Header http.Header
+}
+
+// PostResult is the outcome of a successful post.
+type PostResult struct {
+	// AlreadyExists is set if the server found the article before posting it, see StatBeforePost.
+	AlreadyExists bool
+	// StatusURL is set if the post failed and the article was spooled to be posted later, and is the URL of its
+	// delivery status.
+	StatusURL string
+}
+
+// Get returns the dot-decoded body of the article, as served by GET /m/.
+func (c *Client) Get(ctx context.Context, messageID string) (*Article, error) {
+	return c.get(ctx, "/m/", messageID)
+}
+
+// GetRaw returns the body of the article as sent over NNTP, dot-encoded with CRLF line endings, as served by GET /d/.
+func (c *Client) GetRaw(ctx context.Context, messageID string) (*Article, error) {
+	return c.get(ctx, "/d/", messageID)
+}
+
+func (c *Client) get(ctx context.Context, prefix, messageID string) (article *Article, err error) {
+	var resp *http.Response
+	if resp, err = c.do(ctx, http.MethodGet, articlePath(prefix, messageID), nil, nil, http.StatusOK); err != nil {
+		return
+	}
+	article = &Article{Header: usenetHeader(resp.Header), ContentLength: resp.ContentLength, Body: resp.Body}
+	return
+}
+
+// Head returns the headers of the article and the size of its body, estimated from its byte count unless the server
+// has it locally, -1 if the server could not tell it.
+func (c *Client) Head(ctx context.Context, messageID string) (header http.Header, size int64, err error) {
+	var resp *http.Response
+	if resp, err = c.do(ctx, http.MethodHead, articlePath("/m/", messageID), nil, nil, http.StatusOK); err != nil {
+		return
+	}
+	resp.Body.Close()
+	header, size = usenetHeader(resp.Header), resp.ContentLength
+	if estimate, parseErr := strconv.ParseInt(resp.Header.Get("X-Usebin-Body-Size"), 10, 64); size < 0 && parseErr == nil {
+		size = estimate
+	}
+	return
+}
+
+// Post posts the article with the body read from body, which is dot-encoded by the server, as POST /m/ does. opts may
+// be nil.
+func (c *Client) Post(ctx context.Context, messageID string, body io.Reader, opts *PostOptions) (result PostResult, err error) {
+	var (
+		query  = make(url.Values)
+		header = make(http.Header)
+		resp   *http.Response
+	)
+	if opts != nil {
+		for key, value := range map[string]string{"f": opts.From, "g": opts.Newsgroups, "s": opts.Subject} {
+			if value != "" {
+				query.Set(key, value)
+			}
+		}
+		for key, values := range opts.Header {
+			for _, value := range values {
+				header.Add(usenetPrefix+key, value)
+			}
+		}
+	}
+	header.Set("Content-Type", "application/octet-stream")
+	path := articlePath("/m/", messageID)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	if resp, err = c.do(ctx, http.MethodPost, path, header, body, http.StatusOK, http.StatusAccepted); err != nil {
+		return
+	}
+	resp.Body.Close()
+	result.AlreadyExists = resp.Header.Get("X-Already-Exists") == "true"
+	if resp.StatusCode == http.StatusAccepted {
+		result.StatusURL = c.BaseURL + resp.Header.Get("Location")
+	}
+	return
+}
+
+// PostNZB returns the files of the NZB read from nzb, decoded by the server as POST /nzb does. format is "" for the
+// file of an NZB with a single file, or "tar" or "zip" for an archive of all the files.
+func (c *Client) PostNZB(ctx context.Context, nzb io.Reader, format string) (file *File, err error) {
+	var resp *http.Response
+	path := "/nzb"
+	if format != "" {
+		path += "?format=" + url.QueryEscape(format)
+	}
+	header := http.Header{"Content-Type": {"application/x-nzb"}}
+	if resp, err = c.do(ctx, http.MethodPost, path, header, nzb, http.StatusOK); err != nil {
+		return
+	}
+	file = &File{Size: -1, Body: resp.Body}
+	if _, params, parseErr := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); parseErr == nil {
+		file.Name = params["filename"]
+	}
+	if length := resp.Header.Get("Content-Length"); length != "" {
+		if file.Size, err = strconv.ParseInt(length, 10, 64); err != nil {
+			resp.Body.Close()
+			file = nil
+		}
+	}
+	return
+}
+
+// do sends the request and returns the response if its status code is one of the expected ones, otherwise a
+// StatusError.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body io.Reader, expected ...int) (resp *http.Response, err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, method, c.BaseURL+path, body); err != nil {
+		return
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if resp, err = httpClient.Do(req); err != nil {
+		return
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	err = &StatusError{Method: method, URL: req.URL.String(), StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-Id")}
+	resp = nil
+	return
+}
+
+// articlePath returns the path of the article under the route prefix.
+func articlePath(prefix, messageID string) string {
+	messageID = strings.TrimSuffix(strings.TrimPrefix(messageID, "<"), ">")
+	return prefix + url.PathEscape(messageID) + ".csv"
+}
+
+// usenetHeader returns the article headers among the HTTP headers, without their prefix. X-Usenet-Age is left out,
+// being computed by the server rather than an article header.
+func usenetHeader(httpHeader http.Header) (header http.Header) {
+	header = make(http.Header)
+	for key, values := range httpHeader {
+		if strings.HasPrefix(key, usenetPrefix) && len(key) > len(usenetPrefix) && key != usenetPrefix+"Age" {
+			header[key[len(usenetPrefix):]] = values
+		}
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The client is tested against the server itself in the client_test.go of the server, this one covers what the
+// server cannot be made to answer there.
+
+func TestStatusErrorIs(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		target error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusUnavailableForLegalReasons, ErrTakenDown},
+		{http.StatusConflict, ErrExists},
+		{http.StatusBadGateway, nil},
+		{http.StatusInternalServerError, nil},
+	} {
+		err := &StatusError{Method: http.MethodGet, URL: "https://useb.in/m/a.csv", StatusCode: test.status}
+		for _, target := range []error{ErrNotFound, ErrTakenDown, ErrExists} {
+			if got := errors.Is(err, target); got != (target == test.target) {
+				t.Errorf("errors.Is(%v, %v) = %t", err, target, got)
+			}
+		}
+	}
+}
+
+func TestClientStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-1")
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	}))
+	defer ts.Close()
+
+	_, err := New(ts.URL+"/").Get(context.Background(), "<a/b@example.com>")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Get: %v", err)
+	}
+	if statusErr.URL != ts.URL+"/m/a%2Fb@example.com.csv" || statusErr.RequestID != "req-1" || !errors.Is(err, ErrTakenDown) {
+		t.Errorf("Get: %+v", statusErr)
+	}
+	if !strings.Contains(err.Error(), "(request req-1)") {
+		t.Errorf("error %q without its request", err.Error())
+	}
+}
+
+func TestClientHeadSize(t *testing.T) {
+	for _, test := range []struct {
+		length, estimate string
+		want             int64
+	}{
+		{"100", "", 100},
+		{"", "90", 90},
+		{"", "", -1},
+		{"", "bogus", -1},
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.estimate != "" {
+				w.Header().Set("X-Usebin-Body-Size", test.estimate)
+			}
+			if test.length != "" {
+				w.Header().Set("Content-Length", test.length)
+			}
+			w.Header().Set("X-Usenet-Subject", "test")
+		}))
+		header, size, err := New(ts.URL).Head(context.Background(), "a@example.com")
+		ts.Close()
+		if err != nil || size != test.want || header.Get("Subject") != "test" {
+			t.Errorf("Head with Content-Length %q and X-Usebin-Body-Size %q = %d, %v: %v", test.length, test.estimate, size,
+				header, err)
+		}
+	}
+}
+
+func TestClientPostSpooled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := io.ReadAll(r.Body); string(body) != "body\n" || r.URL.Query().Get("g") != "alt.test" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", "/s/a@example.com.csv")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	result, err := New(ts.URL).Post(context.Background(), "a@example.com", strings.NewReader("body\n"), &PostOptions{Newsgroups: "alt.test"})
+	if err != nil || result.StatusURL != ts.URL+"/s/a@example.com.csv" {
+		t.Errorf("Post spooled %+v: %v", result, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/useb-in/usebin/client"
+)
+
+// newTestClient returns a client of the server with the fake NNTP servers, served by httptest.
+func newTestClient(t *testing.T, fakes ...*fakeNNTP) *client.Client {
+	s := newTestServer(t, fakes...)
+	ts := httptest.NewServer(s.withRequestID(s.handleMessage(http.NotFoundHandler())))
+	t.Cleanup(ts.Close)
+	return client.New(ts.URL)
+}
+
+func TestClientGet(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a/b@example.com", testHeader, "line 1\r\nline 2\r\n")
+	c := newTestClient(t, f)
+
+	article, err := c.Get(context.Background(), "<a/b@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(article.Body)
+	article.Body.Close()
+	if err != nil || string(body) != "line 1\nline 2\n" {
+		t.Errorf("Get body %q: %v", body, err)
+	}
+	if got := article.Header.Get("Subject"); got != "test article" {
+		t.Errorf("Get Subject %q", got)
+	}
+
+	article, err = c.GetRaw(context.Background(), "a/b@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(article.Body)
+	article.Body.Close()
+	if err != nil || string(body) != "line 1\r\nline 2\r\n.\r\n" || article.ContentLength != int64(len(body)) {
+		t.Errorf("GetRaw body %q of %d bytes: %v", body, article.ContentLength, err)
+	}
+}
+
+func TestClientHead(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader+"\nBytes: 500\nLines: 10", "body\r\n")
+	c := newTestClient(t, f)
+
+	header, size, err := c.Head(context.Background(), "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// estimated from the Bytes header, see TestHandleMessageHead
+	if size != 346 {
+		t.Errorf("Head size %d", size)
+	}
+	if got := header.Get("From"); got != "poster <poster@example.com>" {
+		t.Errorf("Head From %q", got)
+	}
+}
+
+func TestClientPost(t *testing.T) {
+	f := newFakeNNTP(t)
+	c := newTestClient(t, f)
+
+	opts := &client.PostOptions{Newsgroups: "alt.test", Subject: "posted", Header: http.Header{"Organization": {"usebin"}}}
+	result, err := c.Post(context.Background(), "new@example.com", strings.NewReader("line 1\n.dotted line\n"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AlreadyExists || result.StatusURL != "" {
+		t.Errorf("Post result %+v", result)
+	}
+	article, ok := f.article("new@example.com")
+	if !ok {
+		t.Fatal("Post did not post the article")
+	}
+	if article.body != "line 1\r\n.dotted line\r\n" {
+		t.Errorf("posted body %q", article.body)
+	}
+	for _, line := range []string{"Subject: posted", "Newsgroups: alt.test", "Organization: usebin"} {
+		if !strings.Contains(article.header, line) {
+			t.Errorf("posted header %q without %q", article.header, line)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	f := newFakeNNTP(t)
+	c := newTestClient(t, f)
+
+	_, err := c.Get(context.Background(), "missing@example.com")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Get of a missing article: %v", err)
+	}
+	if _, _, err = c.Head(context.Background(), "missing@example.com"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Head of a missing article: %v", err)
+	}
+
+	f.answer("POST", 441)
+	if _, err = c.Post(context.Background(), "refused@example.com", strings.NewReader("body\n"), nil); !errors.Is(err, client.ErrExists) {
+		t.Errorf("Post refused: %v", err)
+	}
+
+	f.answer("ARTICLE", -1)
+	_, err = c.Get(context.Background(), "a@example.com")
+	var statusErr *client.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway || statusErr.RequestID == "" {
+		t.Fatalf("Get with the connection closed: %v", err)
+	}
+	if errors.Is(err, client.ErrNotFound) || errors.Is(err, client.ErrExists) || errors.Is(err, client.ErrTakenDown) {
+		t.Errorf("%v matches an article error", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"gopkg.in/nntp.v0"
+)
+
+// serverCounts counts the segments checked on an NNTP server.
+type serverCounts struct {
+	Host string `json:"host"`
+	segmentCounts
+}
+
+// checkedFile is a file of an NZB document as graded by POST /nzb/check: a segment is available if one of the servers
+// has it, and missing if all the servers telling about it miss it.
+type checkedFile struct {
+	Index    int    `json:"index"`
+	Subject  string `json:"subject"`
+	Segments int    `json:"segments"`
+	Checked  int    `json:"checked"`
+	segmentCounts
+	Servers []serverCounts `json:"servers"`
+}
+
+// segmentCheck is the outcome of checking a segment on every server.
+type segmentCheck struct {
+	found, told bool
+}
+
+// statOn reports whether the server of the index in NNTPServers has the article, using the STAT command.
+func (s *server) statOn(ctx context.Context, index int, messageID nntp.MessageID) (found bool, err error) {
+	var nntpErr *nntp.Error
+	conn, err := s.pool.GetFrom(ctx, index)
+	if err != nil {
+		return
+	}
+	_, err = conn.CmdStat(nntp.ArticleMessageID(messageID))
+	logCommand(ctx, s.NNTPServers[index].Host, "STAT", messageID, err)
+	if err == nil || errors.As(err, &nntpErr) {
+		s.pool.Put(conn)
+	} else {
+		s.pool.Close(conn)
+	}
+	if err == nil {
+		found = true
+	} else if errors.As(err, &nntpErr) && s.missing(nntpErr) {
+		err = nil
+	}
+	return
+}
+
+// handleNZBCheck serves POST /nzb/check, where the body is an NZB document, checking its segments with STAT on every
+// NNTP server, all of them or a sample of each file, to grade how complete its files are overall and on each server.
+func (s *server) handleNZBCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.pool == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "there are no NNTP servers to check in local-only mode")
+		return
+	}
+	sample := 0
+	if value := r.URL.Query().Get("sample"); value != "" {
+		var err error
+		if sample, err = strconv.Atoi(value); err != nil || sample < 1 {
+			logf(r.Context(), "[ERROR] NZB check invalid sample %q", value)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	doc, err := parseNZB(r.Body)
+	if err != nil {
+		logf(r.Context(), "[ERROR] NZB check invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	files := make([]checkedFile, len(doc.Files))
+	totals := make([]serverCounts, len(s.NNTPServers))
+	for k, server := range s.NNTPServers {
+		totals[k].Host = server.Host
+	}
+	slots := make(chan struct{}, s.BatchConcurrency)
+	for i := range doc.Files {
+		file, checked := &doc.Files[i], &files[i]
+		*checked = checkedFile{Index: i, Subject: file.Subject, Segments: len(file.Segments)}
+		checked.Servers = make([]serverCounts, len(s.NNTPServers))
+		for k, server := range s.NNTPServers {
+			checked.Servers[k].Host = server.Host
+		}
+		indexes := sampleSegments(len(file.Segments), len(file.Segments))
+		if sample > 0 {
+			indexes = sampleSegments(len(file.Segments), sample)
+		}
+		checked.Checked = len(indexes)
+		segments := make([]segmentCheck, len(indexes))
+		for j, index := range indexes {
+			for k := range s.NNTPServers {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(segment *segmentCheck, k int, messageID nntp.MessageID) {
+					defer func() {
+						<-slots
+						wg.Done()
+					}()
+					found, err := s.statOn(r.Context(), k, messageID)
+					mu.Lock()
+					defer mu.Unlock()
+					checked.Servers[k].count(found, err)
+					totals[k].count(found, err)
+					segment.found = segment.found || found
+					segment.told = segment.told || err == nil
+				}(&segments[j], k, file.Segments[index].MessageID)
+			}
+		}
+		wg.Wait()
+		for _, segment := range segments {
+			if segment.told {
+				checked.count(segment.found, nil)
+			} else {
+				checked.count(false, ErrBackendFailure)
+			}
+		}
+		checked.complete()
+		for k := range checked.Servers {
+			checked.Servers[k].complete()
+		}
+	}
+	for k := range totals {
+		totals[k].complete()
+	}
+	if err = r.Context().Err(); err != nil {
+		logf(r.Context(), "[ERROR] NZB check error: %s", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"files": files, "servers": totals})
+	logf(r.Context(), "[INFO] NZB check %d files on %d servers", len(files), len(totals))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/nntp.v0"
+)
+
+// concatIDs validates the message-ids of the segments of a concatenation, up to BatchSizeLimit of them.
+func (s *server) concatIDs(ids []nntp.MessageID) (status int, err error) {
+	if len(ids) == 0 {
+		return http.StatusBadRequest, errors.New("no message-ids")
+	}
+	if len(ids) > s.BatchSizeLimit {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("%d message-ids exceed limit", len(ids))
+	}
+	for _, id := range ids {
+		if id == "" || id.Validate() != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid message-id %q", id)
+		}
+	}
+	return
+}
+
+// concatETag returns the strong ETag of the concatenation of the segments, derived from their message-ids.
+func concatETag(ids []nntp.MessageID) string {
+	hash := sha256.New()
+	for _, id := range ids {
+		hash.Write([]byte(id.Short()))
+		hash.Write([]byte{','})
+	}
+	return "\"" + hex.EncodeToString(hash.Sum(nil)[:16]) + "\""
+}
+
+// handleConcatPOST serves POST /concat, where the body is a JSON array of message-ids like for POST /batch.
+func (s *server) handleConcatPOST(w http.ResponseWriter, r *http.Request) {
+	var ids []nntp.MessageID
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(&ids); err != nil {
+		logf(r.Context(), "[ERROR] CONCAT invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.handleConcat(w, r, ids)
+}
+
+// handleConcat serves GET /c/<Message-ID>,<Message-ID>,...csv and POST /concat, the yEnc-decoded bodies of the
+// segments back to back in the given order, like a single file NZB of these segments. The file name and size are
+// taken from the yEnc header of the first segment, and a range only fetches the segments holding it, see serveFile.
+func (s *server) handleConcat(w http.ResponseWriter, r *http.Request, ids []nntp.MessageID) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if status, err := s.concatIDs(ids); err != nil {
+		logf(r.Context(), "[ERROR] CONCAT %s", err.Error())
+		w.WriteHeader(status)
+		return
+	}
+	etag := s.formatETag(r, s.fileETag(concatETag(ids)))
+	done, spec := checkPreconditions(w, r, etag)
+	if done {
+		return
+	}
+
+	file := &nzbFile{}
+	for i, id := range ids {
+		file.Segments = append(file.Segments, nzbSegment{Number: i + 1, MessageID: id})
+	}
+	setETag(w, etag)
+	started, err := s.serveFile(r.Context(), w, file, 0, spec)
+	if err != nil {
+		if !started || !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] CONCAT %s error: %s", ids[0], err.Error())
+		}
+		if started {
+			// abort the response so that the client can tell the file is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, errNZBSegment) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if !unavailable(w, err) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	logf(r.Context(), "[INFO] CONCAT %s %d segments", ids[0], len(ids))
+}
+
+// parseConcatPath returns the message-ids of GET /c/, comma separated before the .csv extension in the path still
+// percent-encoded, so that a message-id with a comma has it encoded.
+func parseConcatPath(name string) (ids []nntp.MessageID, ok bool) {
+	if !strings.HasSuffix(name, ".csv") {
+		return
+	}
+	for _, escaped := range strings.Split(strings.TrimSuffix(name, ".csv"), ",") {
+		id, err := url.PathUnescape(escaped)
+		if err != nil {
+			return nil, false
+		}
+		ids = append(ids, nntp.MessageID(id))
+	}
+	ok = true
+	return
+}
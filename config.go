@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/flynn/json5"
+)
+
+// envPrefix is the prefix of the environment variables overriding config keys, e.g. USEBIN_ARTICLE_SIZE_LIMIT.
+const envPrefix = "USEBIN_"
+
+// configOverride is a config key set on the command line.
+type configOverride struct {
+	key   string
+	value string
+}
+
+// configKeys returns the names of the config keys, the exported fields of server.
+func configKeys() (keys []string) {
+	t := reflect.TypeOf(server{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			keys = append(keys, t.Field(i).Name)
+		}
+	}
+	return
+}
+
+// splitWords splits a config key into its words, keeping acronyms together: "HSTSMaxAge" is "HSTS", "Max", "Age".
+func splitWords(key string) (words []string) {
+	runes := []rune(key)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && (!unicode.IsUpper(runes[i-1]) ||
+			i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// envName returns the environment variable of a config key, e.g. USEBIN_HSTS_MAX_AGE.
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.Join(splitWords(key), "_"))
+}
+
+// flagName returns the command line flag of a config key, e.g. -hsts-max-age.
+func flagName(key string) string {
+	return strings.ToLower(strings.Join(splitWords(key), "-"))
+}
+
+// configFlags defines a flag for every config key on flags, collecting the ones set in order.
+func configFlags(flags *flag.FlagSet) *[]configOverride {
+	overrides := new([]configOverride)
+	for _, key := range configKeys() {
+		key := key
+		flags.Func(flagName(key), fmt.Sprintf("set the %s config key", key), func(value string) error {
+			*overrides = append(*overrides, configOverride{key, value})
+			return nil
+		})
+	}
+	return overrides
+}
+
+// setConfigKey parses value into the config key. Lists and objects, like NNTPServers, are given in JSON, while a list
+// of strings may also be given comma-separated.
+func setConfigKey(s *server, key string, value string) (err error) {
+	v := reflect.ValueOf(s).Elem().FieldByName(key)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(value); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(value, 10, v.Type().Bits()); err == nil {
+			v.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		if u, err = strconv.ParseUint(value, 10, v.Type().Bits()); err == nil {
+			v.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(value, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+	default:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(value, "[") {
+			var list []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			v.Set(reflect.ValueOf(list))
+			return
+		}
+		// reset first, so the value replaces the one from the config file instead of being merged into it
+		v.Set(reflect.Zero(v.Type()))
+		err = json5.Unmarshal([]byte(value), v.Addr().Interface())
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return
+}
+
+// defaultConfigPath returns usebin/config.json in the config dir of the platform: $XDG_CONFIG_HOME or ~/.config on
+// Linux, ~/Library/Application Support on macOS and %AppData% on Windows. ~/.config/usebin/config.json is returned
+// instead if only it exists, where it used to be on every platform.
+func defaultConfigPath() (path string, err error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		err = fmt.Errorf("cannot find user config dir: %w", err)
+		return
+	}
+	path = filepath.Join(dir, "usebin", "config.json")
+	if _, err = os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if home, homeErr := os.UserHomeDir(); homeErr == nil {
+			if legacy := filepath.Join(home, ".config", "usebin", "config.json"); legacy != path {
+				if _, homeErr = os.Stat(legacy); homeErr == nil {
+					path = legacy
+				}
+			}
+		}
+	}
+	err = nil
+	return
+}
+
+// loadConfig builds the config from its layers, each overriding the previous one: the defaults applied by Serve, the
+// config file, the remote config at RemoteConfigURL, the USEBIN_* environment variables and the command line
+// overrides. If path is empty, the default config file is used if it exists. Relative CertFile, KeyFile, Htpasswd,
+// ClientCAFile and secret file paths in the config file are relative to the file. The secrets given by reference are
+// then resolved, see resolveSecrets.
+func loadConfig(path string, overrides []configOverride) (s *server, err error) {
+	var data []byte
+	s = new(server)
+
+	required := path != ""
+	if !required {
+		if path, err = defaultConfigPath(); err != nil {
+			return
+		}
+	}
+	if data, err = os.ReadFile(path); errors.Is(err, fs.ErrNotExist) && !required {
+		// running with environment variables and flags only
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("cannot read config file: %w", err)
+		return
+	} else {
+		if err = json5.Unmarshal(data, s); err != nil {
+			err = fmt.Errorf("cannot parse config file %s: %w", path, err)
+			return
+		}
+		dir := filepath.Dir(path)
+		if s.CertFile != "" && !filepath.IsAbs(s.CertFile) {
+			s.CertFile = filepath.Join(dir, s.CertFile)
+		}
+		if s.KeyFile != "" && !filepath.IsAbs(s.KeyFile) {
+			s.KeyFile = filepath.Join(dir, s.KeyFile)
+		}
+		if s.Htpasswd != "" && !filepath.IsAbs(s.Htpasswd) {
+			s.Htpasswd = filepath.Join(dir, s.Htpasswd)
+		}
+		if s.ClientCAFile != "" && !filepath.IsAbs(s.ClientCAFile) {
+			s.ClientCAFile = filepath.Join(dir, s.ClientCAFile)
+		}
+		for _, path := range []*string{&s.AdminPassFile, &s.PurgeTokenFile, &s.RemoteConfigTokenFile} {
+			if *path != "" && !filepath.IsAbs(*path) {
+				*path = filepath.Join(dir, *path)
+			}
+		}
+		for i := range s.NNTPServers {
+			if path := &s.NNTPServers[i].PassFile; *path != "" && !filepath.IsAbs(*path) {
+				*path = filepath.Join(dir, *path)
+			}
+		}
+	}
+
+	if err = applyOverrides(s, overrides); err != nil {
+		return
+	} else if err = s.resolveSecrets(); err != nil || s.RemoteConfigURL == "" {
+		return
+	}
+	if err = s.loadRemoteConfig(); err != nil {
+		return
+	}
+	// the RemoteConfigURL can itself be set by an override, which are applied again to still override the remote config
+	if err = applyOverrides(s, overrides); err != nil {
+		return
+	}
+	err = s.resolveSecrets()
+	return
+}
+
+// applyOverrides sets the config keys of the USEBIN_* environment variables, then of the command line overrides.
+func applyOverrides(s *server, overrides []configOverride) (err error) {
+	for _, key := range configKeys() {
+		if value, ok := os.LookupEnv(envName(key)); ok {
+			if err = setConfigKey(s, key, value); err != nil {
+				err = fmt.Errorf("%s: %w", envName(key), err)
+				return
+			}
+		}
+	}
+
+	for _, override := range overrides {
+		if err = setConfigKey(s, override.key, override.value); err != nil {
+			err = fmt.Errorf("-%s: %w", flagName(override.key), err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/textproto.v0"
+)
+
+var (
+	// e.g. `[01/10] - "file.part01.rar" yEnc (1/15)`
+	subjectQuotedName = regexp.MustCompile(`"([^"/\\]+\.[A-Za-z0-9]{1,10})"`)
+	// e.g. `file.part01.rar yEnc (1/15)`
+	subjectBareName = regexp.MustCompile(`([^\s"/\\]+\.[A-Za-z0-9]{1,10})\s+yEnc\b`)
+)
+
+// yEncName returns the file name in the `=ybegin` line of a yEnc-encoded body, with the name being the last parameter
+// extending to the end of the line.
+func yEncName(body []byte) (name string, ok bool) {
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	var line []byte
+	for len(body) > 0 {
+		line, body, _ = bytes.Cut(body, []byte("\n"))
+		if !bytes.HasPrefix(line, []byte("=ybegin ")) {
+			continue
+		}
+		ok = true
+		if _, after, found := bytes.Cut(line, []byte(" name=")); found {
+			name = strings.TrimSpace(string(after))
+		}
+		return
+	}
+	return
+}
+
+// detectFilename finds the original file name of an article, either in the yEnc header of its body or embedded in the
+// Subject. The body can be nil if it's not fetched, in which case a "yEnc" tag in the Subject marks it yEnc-encoded.
+func detectFilename(header textproto.MIMEHeader, body []byte) (name string, yEncoded bool) {
+	subject := header.Get("Subject")
+	if body != nil {
+		name, yEncoded = yEncName(body)
+	} else {
+		yEncoded = strings.Contains(subject, "yEnc")
+	}
+	if name == "" {
+		if m := subjectQuotedName.FindStringSubmatch(subject); m != nil {
+			name = m[1]
+		} else if m = subjectBareName.FindStringSubmatch(subject); m != nil {
+			name = m[1]
+		}
+	}
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = ""
+	}
+	return
+}
+
+// setContentType sets the Content-Type and Content-Disposition headers inferred from the article's file name if
+// DetectContentType is configured, returning the content type to use for the response body. Since yEnc-encoded bodies
+// are served as is, their content type is kept and the file name is only reported in the X-Usebin-Filename header.
+func (s *server) setContentType(w http.ResponseWriter, header textproto.MIMEHeader, body []byte, ctype string) string {
+	if !s.DetectContentType {
+		return ctype
+	}
+	name, yEncoded := detectFilename(header, body)
+	if name == "" {
+		return ctype
+	}
+	if yEncoded {
+		w.Header().Set("X-Usebin-Filename", name)
+		return ctype
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		ctype = t
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	return ctype
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// controlResult is the response of the admin API of the control articles.
+type controlResult struct {
+	// MessageID is the one of the control article, or of the replacement article of a supersede.
+	MessageID  nntp.MessageID `json:"messageId"`
+	Control    string         `json:"control,omitempty"`
+	Supersedes nntp.MessageID `json:"supersedes,omitempty"`
+	Newsgroups string         `json:"newsgroups"`
+}
+
+// originalHeader returns the headers of the article a control article acts on, found in the store or on the NNTP
+// servers.
+func (s *server) originalHeader(r *http.Request, messageID nntp.MessageID) (header textproto.MIMEHeader, err error) {
+	var (
+		conn    *nntp.Conn
+		article *nntp.Article
+	)
+	if conn, article, err = s.lookup(r.Context(), messageID, false, "HEAD", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdHead(nntp.ArticleMessageID(messageID))
+	}); err != nil {
+		return
+	}
+	if conn != nil {
+		s.pool.Put(conn)
+	} else {
+		closeArticle(article)
+	}
+	if header = article.Header; header.Get("Newsgroups") == "" || header.Get("From") == "" {
+		err = fmt.Errorf("%s has no Newsgroups or From header", messageID)
+	}
+	return
+}
+
+// injectedHere reports whether the article of the message-id and header was posted through the gateway: its message-id
+// is under MessageIDDomain, or PathIdentity is in its Path or leads its Injection-Info.
+func (s *server) injectedHere(messageID nntp.MessageID, header textproto.MIMEHeader) bool {
+	if at := strings.LastIndexByte(string(messageID), '@'); at >= 0 && strings.EqualFold(string(messageID[at+1:]), s.MessageIDDomain) {
+		return true
+	}
+	for _, identity := range strings.Split(header.Get("Path"), "!") {
+		if strings.TrimSpace(identity) == s.PathIdentity {
+			return true
+		}
+	}
+	identity, _, _ := strings.Cut(header.Get("Injection-Info"), ";")
+	return strings.TrimSpace(identity) == s.PathIdentity
+}
+
+// handleControl serves the admin API of the control articles, which act on articles the operator posted through the
+// gateway: POST /control/cancel/<Message-ID> posts a cancel of the article, with the reason of the optional
+// {"reason": ...} JSON body as its body, and POST /control/supersede/<Message-ID> posts the request body as a new
+// article replacing it, under the message-id of the id query parameter or a new one under MessageIDDomain. Both are
+// posted to the newsgroups of the original article, from its sender, since servers only honor control articles from
+// the sender of the article they act on. The articles not injected here are refused with 403 Forbidden, see
+// injectedHere.
+func (s *server) handleControl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	action, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/control/"), "/")
+	original := nntp.MessageID(id).Short()
+	if (action != "cancel" && action != "supersede") || original.Validate() != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	operator, _, _ := r.BasicAuth()
+	if id := identity(r.Context()); id != nil {
+		operator = id.Name
+	}
+
+	header, err := s.originalHeader(r, original)
+	if errors.Is(err, ErrArticleNotFound) {
+		logf(r.Context(), "[ERROR] [Control] %s %s not found", action, original)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] [Control] %s %s HEAD %s", action, original, err.Error())
+		if !unavailable(w, err) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if !s.injectedHere(original, header) {
+		logf(r.Context(), "[ERROR] [Control] %s %s by %s refused, not injected here", action, original, operator)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, "not posted through this gateway")
+		return
+	}
+
+	var newID string
+	if newID = r.URL.Query().Get("id"); newID == "" || action == "cancel" {
+		if newID, err = newMessageID(s.MessageIDDomain); err != nil {
+			logf(r.Context(), "[ERROR] [Control] %s %s newid error: %s", action, original, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	article := &nntp.Article{
+		MessageID: nntp.MessageID(newID).Short(),
+		Header: textproto.MIMEHeader{
+			"From":       {header.Get("From")},
+			"Newsgroups": {header.Get("Newsgroups")},
+		},
+	}
+	if article.MessageID.Validate() != nil || article.MessageID == original {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "invalid id")
+		return
+	}
+	result := &controlResult{MessageID: article.MessageID, Newsgroups: header.Get("Newsgroups")}
+	switch action {
+	case "cancel":
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err = json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&body); err != nil && err != io.EOF {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "expecting {\"reason\": ...}")
+			return
+		}
+		if body.Reason == "" {
+			body.Reason = "cancelled by the operator"
+		}
+		result.Control = "cancel " + string(original.Full())
+		article.Header.Set("Control", result.Control)
+		article.Header.Set("Subject", "cmsg "+result.Control)
+		article.Body = strings.NewReader(sanitizeHeaderValue(body.Reason) + "\r\n")
+	case "supersede":
+		if r.ContentLength > int64(s.ArticleSizeLimit) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		result.Supersedes = original
+		article.Header.Set("Supersedes", string(original.Full()))
+		article.Header.Set("Subject", header.Get("Subject"))
+		article.Body = http.MaxBytesReader(w, r.Body, int64(s.ArticleSizeLimit))
+	}
+
+	if err = s.postArticle(r.Context(), article, false); err != nil {
+		logf(r.Context(), "[ERROR] [Control] %s %s error: %s", action, original, err.Error())
+		if isRejectedPostError(err) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	logf(r.Context(), "[WARN] [Control] %s %s by %s as %s", action, original, operator, article.MessageID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleControlInjectedHere(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@ngPost.com", testHeader, "body\r\n")
+	f.add("path@example.com", testHeader+"\nPath: news.example.com!usebin!not-for-mail", "body\r\n")
+	f.add("injection@example.com", testHeader+"\nInjection-Info: usebin; posting-host=\"127.0.0.1\"", "body\r\n")
+	f.add("elsewhere@example.com", testHeader+"\nPath: news.example.com!not-for-mail", "body\r\n")
+	s := newTestServer(t, f)
+
+	for _, test := range []struct {
+		messageID string
+		code      int
+	}{
+		{"a@ngPost.com", http.StatusOK},
+		{"path@example.com", http.StatusOK},
+		{"injection@example.com", http.StatusOK},
+		{"elsewhere@example.com", http.StatusForbidden},
+	} {
+		posts := f.received("POST")
+		w := httptest.NewRecorder()
+		s.handleControl(w, httptest.NewRequest(http.MethodPost, "/control/cancel/"+test.messageID, nil))
+		if w.Code != test.code {
+			t.Errorf("cancel of %s answered %d, want %d", test.messageID, w.Code, test.code)
+		}
+		if posted := f.received("POST") > posts; posted != (test.code == http.StatusOK) {
+			t.Errorf("cancel of %s answered %d, posted %t", test.messageID, w.Code, posted)
+		}
+	}
+}
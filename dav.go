@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+// davFile is a file of a mounted NZB, named and sized after the yEnc header of its first segment.
+type davFile struct {
+	Name string
+	Size int64
+	nzbFile
+}
+
+// davJob is a mounted NZB, named by the hash of the NZB document so mounting it again gives the same share.
+type davJob struct {
+	ID    string
+	Files []davFile
+}
+
+// file returns the file of the job by name, nil if there is none.
+func (job *davJob) file(name string) (index int, file *davFile) {
+	for i := range job.Files {
+		if job.Files[i].Name == name {
+			return i, &job.Files[i]
+		}
+	}
+	return -1, nil
+}
+
+// davJobs keeps the mounted NZBs in DavDir, one JSON file each, so they outlive restarts and are shared by the
+// instances sharing the directory.
+type davJobs struct {
+	dir  string
+	mu   sync.Mutex
+	jobs map[string]*davJob
+}
+
+func openDavJobs(dir string) (jobs *davJobs, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	jobs = &davJobs{dir: dir, jobs: make(map[string]*davJob)}
+	return
+}
+
+// validJobID reports whether id is a job id, the hex of the first 16 bytes of a SHA-256.
+func validJobID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Get returns the job, nil if it was never mounted.
+func (d *davJobs) Get(id string) (job *davJob, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if job = d.jobs[id]; job != nil {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(d.dir, id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	job = new(davJob)
+	if err = json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	d.jobs[id] = job
+	return
+}
+
+// Put saves the job, replacing any previous copy.
+func (d *davJobs) Put(job *davJob) (err error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	file, err := os.CreateTemp(d.dir, "*.tmp")
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(d.dir, job.ID+".json"))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	d.mu.Lock()
+	d.jobs[job.ID] = job
+	d.mu.Unlock()
+	return
+}
+
+// mountNZB names and sizes the files of an NZB document after the yEnc header of their first segment, fetched
+// concurrently. Files with the same name are told apart by their index.
+func (s *server) mountNZB(ctx context.Context, id string, doc *nzbDocument) (job *davJob, err error) {
+	job = &davJob{ID: id, Files: make([]davFile, len(doc.Files))}
+	firsts := make([]nntp.MessageID, len(doc.Files))
+	for i := range doc.Files {
+		firsts[i] = doc.Files[i].Segments[0].MessageID
+	}
+	seen := make(map[string]bool)
+	err = s.fetchInOrder(ctx, firsts, func(i int, result *batchResult) (err error) {
+		file := &doc.Files[i]
+		var part *yEncPart
+		if part, err = file.decodeSegment(0, result); err != nil {
+			return
+		}
+		name, size := file.nameAndSize(part, i)
+		if seen[name] {
+			name = strconv.Itoa(i+1) + "-" + name
+		}
+		seen[name] = true
+		job.Files[i] = davFile{Name: name, Size: size, nzbFile: *file}
+		return
+	})
+	return
+}
+
+// davProp is the subset of the WebDAV properties served, the same for any PROPFIND request.
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified,omitempty"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+}
+
+// davResourceType is empty for files.
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+type davResponse struct {
+	Href   string  `xml:"D:href"`
+	Prop   davProp `xml:"D:propstat>D:prop"`
+	Status string  `xml:"D:propstat>D:status"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Namespace string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+// davCollection and davFileResponse return the PROPFIND responses of a job and of one of its files.
+func davCollection(href, name string) davResponse {
+	return davResponse{Href: href, Prop: davProp{DisplayName: name, ResourceType: davResourceType{Collection: &struct{}{}}}, Status: "HTTP/1.1 200 OK"}
+}
+
+func (s *server) davFileResponse(job *davJob, index int) davResponse {
+	file := &job.Files[index]
+	ctype := mime.TypeByExtension(path.Ext(file.Name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	size := file.Size
+	prop := davProp{DisplayName: file.Name, ContentType: ctype, ContentLength: &size, ETag: s.davETag(job.ID, index)}
+	if file.Date > 0 {
+		prop.LastModified = time.Unix(file.Date, 0).UTC().Format(http.TimeFormat)
+	}
+	return davResponse{Href: "/dav/" + job.ID + "/" + url.PathEscape(file.Name), Prop: prop, Status: "HTTP/1.1 200 OK"}
+}
+
+// davETag returns the strong ETag of a file of a job, which never changes since the job is named after its NZB, see
+// fileETag.
+func (s *server) davETag(id string, index int) string {
+	return s.fileETag("\"" + id + "-" + strconv.Itoa(index) + "\"")
+}
+
+// handleDAV serves the read-only WebDAV share of the NZBs mounted with POST /dav/, under /dav/<job-id>/<file name>:
+// OPTIONS and PROPFIND to browse them, and GET and HEAD for their files, with ranges fetching only the segments
+// holding them, so media players and file managers can stream the files of an NZB without downloading all of it.
+func (s *server) handleDAV(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if s.dav == nil {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "DavDir is not configured")
+		return
+	}
+	_, rest := splitRoute(r.URL.Path)
+	id, name, _ := strings.Cut(rest, "/")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, GET, HEAD")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if id == "" {
+		switch r.Method {
+		case http.MethodPost:
+			s.handleDAVMount(w, r)
+		case "PROPFIND":
+			// the jobs are not listed, their ids are the capability to browse them
+			s.sendMultistatus(w, r, []davResponse{davCollection("/dav/", "dav")})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if !validJobID(id) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	job, err := s.dav.Get(id)
+	if err != nil {
+		logf(r.Context(), "[ERROR] DAV %s %s", id, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if job == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if name == "" {
+		if r.Method != "PROPFIND" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		responses := []davResponse{davCollection("/dav/"+id+"/", id)}
+		if r.Header.Get("Depth") != "0" {
+			for i := range job.Files {
+				responses = append(responses, s.davFileResponse(job, i))
+			}
+		}
+		s.sendMultistatus(w, r, responses)
+		return
+	}
+	index, file := job.file(name)
+	if file == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "PROPFIND":
+		s.sendMultistatus(w, r, []davResponse{s.davFileResponse(job, index)})
+	case http.MethodHead:
+		w.Header().Set("Cache-Control", s.cacheControl(r))
+		w.Header().Set("Accept-Ranges", "bytes")
+		setETag(w, s.formatETag(r, s.davETag(id, index)))
+		setFileHeaders(w, file.Name, file.Size)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		s.handleDAVGet(w, r, job, index)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDAVGet serves a file of a job, or a range of it.
+func (s *server) handleDAVGet(w http.ResponseWriter, r *http.Request, job *davJob, index int) {
+	file := &job.Files[index]
+	w.Header().Set("Cache-Control", s.cacheControl(r))
+	etag := s.formatETag(r, s.davETag(job.ID, index))
+	done, spec := checkPreconditions(w, r, etag)
+	if done {
+		return
+	}
+	setETag(w, etag)
+	started, err := s.serveFile(r.Context(), w, &file.nzbFile, index, spec)
+	if err != nil {
+		if !started || !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] DAV %s %s error: %s", job.ID, file.Name, err.Error())
+		}
+		if started {
+			// abort the response so that the client can tell the file is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, errNZBSegment) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if !unavailable(w, err) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	logf(r.Context(), "[INFO] DAV %s %s", job.ID, file.Name)
+}
+
+// handleDAVMount serves POST /dav/, where the body is an NZB document, mounting it under /dav/<job-id>/. The job is
+// returned as JSON, with its URL in Location.
+func (s *server) handleDAVMount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	data, err := io.ReadAll(io.LimitReader(r.Body, nzbSizeLimit))
+	if err != nil {
+		logf(r.Context(), "[ERROR] DAV mount read error: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	doc, err := parseNZB(bytes.NewReader(data))
+	if err != nil {
+		logf(r.Context(), "[ERROR] DAV mount invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:16])
+
+	job, err := s.dav.Get(id)
+	if err == nil && job == nil {
+		if job, err = s.mountNZB(r.Context(), id, doc); err == nil {
+			err = s.dav.Put(job)
+		}
+	}
+	if err != nil {
+		logf(r.Context(), "[ERROR] DAV mount %s error: %s", id, err.Error())
+		if errors.Is(err, errNZBSegment) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if !unavailable(w, err) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	w.Header().Set("Location", "/dav/"+id+"/")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	files := make([]map[string]any, len(job.Files))
+	for i, file := range job.Files {
+		files[i] = map[string]any{"name": file.Name, "size": file.Size}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "url": "/dav/" + id + "/", "files": files})
+	logf(r.Context(), "[INFO] DAV mount %s %d files", id, len(job.Files))
+}
+
+// sendMultistatus sends the 207 Multi-Status answer of a PROPFIND request.
+func (s *server) sendMultistatus(w http.ResponseWriter, r *http.Request, responses []davResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(davMultistatus{Namespace: "DAV:", Responses: responses}); err != nil && !clientGone(r, err) {
+		logf(r.Context(), "[ERROR] DAV %s write error: %s", r.URL.Path, err.Error())
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+var errBadDotEncoding = errors.New("malformed dot-encoded body")
+
+// dotEncodedReader validates a dot-encoded article body while streaming it, so a malformed upload can never put the
+// NNTP connection out of sync. Every line beginning with a dot must be dot-stuffed, except for the optional
+// terminating ".\r\n" line, which is consumed and not passed through since the NNTP dot writer appends its own. Any
+// data after the terminating line is an error.
+type dotEncodedReader struct {
+	r         *bufio.Reader
+	lineStart bool
+	done      bool
+	pending   []byte
+	err       error
+}
+
+func newDotEncodedReader(r io.Reader) *dotEncodedReader {
+	return &dotEncodedReader{r: bufio.NewReader(r), lineStart: true}
+}
+
+func (d *dotEncodedReader) Read(p []byte) (n int, err error) {
+	for len(d.pending) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			// nothing is allowed after the terminating line
+			if _, err = d.r.ReadByte(); err == nil {
+				d.err = errBadDotEncoding
+			} else {
+				d.err = err
+			}
+			continue
+		}
+		var line []byte
+		line, d.err = d.r.ReadSlice('\n')
+		if d.err == bufio.ErrBufferFull {
+			d.err = nil
+		}
+		if d.lineStart && len(line) > 0 && line[0] == '.' {
+			switch {
+			case bytes.Equal(line, []byte(".\r\n")), bytes.Equal(line, []byte(".\n")),
+				bytes.Equal(line, []byte(".")) && d.err == io.EOF:
+				d.done = true
+				if d.err == io.EOF {
+					d.err = nil
+				}
+				line = nil
+			case len(line) < 2 || line[1] != '.':
+				d.err = errBadDotEncoding
+				line = nil
+			}
+		}
+		if len(line) > 0 {
+			d.lineStart = line[len(line)-1] == '\n'
+		}
+		d.pending = line
+	}
+	n = copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return
+}
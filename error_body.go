@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// errorBodyLimit is the largest body a handler writes with an error status that is turned into the message of the
+// JSON error body, larger ones being sent as is.
+const errorBodyLimit = 4096
+
+// errorCodes are the codes of the JSON error bodies by status, when the handler sets none.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:                   "bad_request",
+	http.StatusUnauthorized:                 "unauthorized",
+	http.StatusForbidden:                    "forbidden",
+	http.StatusNotFound:                     "not_found",
+	http.StatusMethodNotAllowed:             "method_not_allowed",
+	http.StatusConflict:                     "conflict",
+	http.StatusGone:                         "gone",
+	http.StatusRequestEntityTooLarge:        "too_large",
+	http.StatusRequestedRangeNotSatisfiable: "range_not_satisfiable",
+	http.StatusTooManyRequests:              "quota_exceeded",
+	http.StatusUnavailableForLegalReasons:   "taken_down",
+	http.StatusInternalServerError:          "internal_error",
+	http.StatusNotImplemented:               "not_configured",
+	http.StatusBadGateway:                   "backend_error",
+	http.StatusServiceUnavailable:           "unavailable",
+	http.StatusGatewayTimeout:               "backend_timeout",
+	http.StatusInsufficientStorage:          "too_large",
+}
+
+// errorInfoKey is the context key of the errorInfo of a request.
+type errorInfoKey struct{}
+
+// errorInfo is what is known of the failure of a request for its JSON error body: the code set by the handler, and the
+// last warning or error logged.
+type errorInfo struct {
+	mu     sync.Mutex
+	code   string
+	logged string
+}
+
+// setErrorCode sets the code of the JSON error body of the request of ctx, to tell apart the failures sharing a status.
+func setErrorCode(ctx context.Context, code string) {
+	if info, _ := ctx.Value(errorInfoKey{}).(*errorInfo); info != nil {
+		info.mu.Lock()
+		info.code = code
+		info.mu.Unlock()
+	}
+}
+
+// recordError keeps the last warning or error logged for the request of ctx, without its level tag.
+func recordError(ctx context.Context, format string, args ...any) {
+	info, _ := ctx.Value(errorInfoKey{}).(*errorInfo)
+	if info == nil {
+		return
+	}
+	if i := strings.Index(format, "] "); strings.HasPrefix(format, "[") && i > 0 {
+		format = format[i+2:]
+	}
+	line := fmt.Sprintf(format, args...)
+	info.mu.Lock()
+	info.logged = line
+	info.mu.Unlock()
+}
+
+// errorBody is the JSON body of an error response.
+type errorBody struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// errorResponseWriter holds back the error status of a handler until it returns, along with the body it wrote up to
+// errorBodyLimit, to send an errorBody instead.
+type errorResponseWriter struct {
+	http.ResponseWriter
+	status  int // the error status held back, 0 if none
+	started bool
+	body    bytes.Buffer
+}
+
+func (ew *errorResponseWriter) WriteHeader(status int) {
+	if !ew.started && ew.status == 0 && status >= http.StatusBadRequest {
+		ew.status = status
+		return
+	}
+	ew.started = true
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *errorResponseWriter) Write(p []byte) (int, error) {
+	if ew.status != 0 {
+		if ew.body.Len()+len(p) <= errorBodyLimit {
+			return ew.body.Write(p)
+		}
+		// not an error message, send it as is
+		ew.release()
+	}
+	ew.started = true
+	return ew.ResponseWriter.Write(p)
+}
+
+// release sends the held back status and body.
+func (ew *errorResponseWriter) release() {
+	status := ew.status
+	ew.status, ew.started = 0, true
+	ew.ResponseWriter.WriteHeader(status)
+	ew.ResponseWriter.Write(ew.body.Bytes())
+}
+
+func (ew *errorResponseWriter) Flush() {
+	if ew.status != 0 {
+		// the error body is only known once the handler returns
+		return
+	}
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (ew *errorResponseWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// ownErrorBody has the errorResponseWriter w wraps, if any, send the error response the handler is about to write as
+// is, for one with a JSON body of its own.
+func ownErrorBody(w http.ResponseWriter) {
+	for {
+		if ew, ok := w.(*errorResponseWriter); ok {
+			ew.started = true
+			return
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// errorBodies sends the error responses of the routes with an errorBody, with JSONErrors. The message is the text the
+// handler wrote, else the warning or error it logged for a client error, else the status text. The warning or error
+// logged for a server error is only sent in the detail with JSONErrorDetails, since it can name the NNTP servers.
+func (s *server) errorBodies(handler http.Handler) http.Handler {
+	if !s.JSONErrors {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		info := new(errorInfo)
+		ew := &errorResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(ew, r.WithContext(context.WithValue(r.Context(), errorInfoKey{}, info)))
+		if ew.status == 0 {
+			return
+		}
+		info.mu.Lock()
+		body := errorBody{Status: ew.status, Code: info.code, RequestID: requestID(r.Context())}
+		logged := info.logged
+		info.mu.Unlock()
+		if body.Code == "" {
+			if body.Code = errorCodes[ew.status]; body.Code == "" {
+				body.Code = "error"
+			}
+			if ew.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "" {
+				body.Code = "pool_saturated"
+			}
+		}
+		switch body.Message = strings.TrimSpace(ew.body.String()); {
+		case body.Message != "":
+		case ew.status < http.StatusInternalServerError && logged != "":
+			body.Message = logged
+		default:
+			body.Message = http.StatusText(ew.status)
+		}
+		if ew.status >= http.StatusInternalServerError && s.JSONErrorDetails {
+			body.Detail = logged
+		}
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(ew.status)
+		json.NewEncoder(w).Encode(body)
+	})
+}
@@ -1,8 +1,8 @@
 import * as esbuild from 'esbuild';
 
 esbuild.build({
-    entryPoints: ['./frontend/usebin.ts'],
-    outfile: './static/assets/usebin.js',
+    entryPoints: ['./frontend/usebin.ts', './frontend/upload.ts', './frontend/view.ts', './frontend/nzb.ts'],
+    outdir: './static/assets',
     bundle: true,
     minify: true,
     sourcemap: false,
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/nntp.v0"
+)
+
+// canonicalExtension is the extension of the article URLs redirected to, one of the extensions Cloudflare caches by
+// default.
+const canonicalExtension = ".csv"
+
+// articleExtensions are the extensions of the article URLs always accepted.
+var articleExtensions = []string{".csv", ".nfo"}
+
+func validateAcceptExtensions(extensions []string) error {
+	for _, ext := range extensions {
+		if ext != "" && (!strings.HasPrefix(ext, ".") || len(ext) == 1 || strings.ContainsAny(ext, "/@<>")) {
+			return fmt.Errorf("invalid extension %q in AcceptExtensions, expecting \"\" or one like \".txt\"", ext)
+		}
+	}
+	return nil
+}
+
+// articleName returns the message-id named by the last part of the path of an article route, without its extension:
+// .csv or .nfo, or with AcceptExtensions, one of its extensions, "" accepting the bare message-id. canonical reports
+// whether it has one of the extensions always accepted, and ok whether it has any and something before it.
+func (s *server) articleName(name string) (messageID nntp.MessageID, canonical, ok bool) {
+	for _, ext := range articleExtensions {
+		if strings.HasSuffix(name, ext) && len(name) > len(ext) {
+			return nntp.MessageID(name[:len(name)-len(ext)]), true, true
+		}
+	}
+	bare := false
+	for _, ext := range s.AcceptExtensions {
+		if ext == "" {
+			bare = true
+		} else if strings.HasSuffix(name, ext) && len(name) > len(ext) {
+			return nntp.MessageID(name[:len(name)-len(ext)]), false, true
+		}
+	}
+	// a bare message-id can end with anything, such as the .com of its domain
+	return nntp.MessageID(name), false, bare && name != ""
+}
+
+// articlePath returns the canonical path of an article on the route of prefix: the message-id percent-encoded as a
+// path segment, so that one with a /, ? or % still names it, followed by canonicalExtension except on the /view/ page.
+func articlePath(prefix string, messageID nntp.MessageID) (path string) {
+	path = prefix + url.PathEscape(string(messageID.Short()))
+	if prefix != "/view/" {
+		path += canonicalExtension
+	}
+	return
+}
+
+// redirectCanonical redirects a GET or HEAD request of an article route to its canonical URL, the message-id followed
+// by canonicalExtension, so that clients requesting another form still end up at the one the CDN caches. It reports
+// whether the request was redirected.
+func (s *server) redirectCanonical(w http.ResponseWriter, r *http.Request, prefix string, messageID nntp.MessageID) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	location := articlePath(prefix, messageID)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	// the canonical URL of a message-id never changes
+	w.Header().Set("Cache-Control", s.cacheControl(r))
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+	return true
+}
+
+// canonicalRoutes are the routes /r/ redirects to with its to query parameter, by the name of their prefix.
+var canonicalRoutes = map[string]string{"m": "/m/", "d": "/d/", "h": "/h/", "p": "/p/", "sum": "/sum/", "j": "/j/",
+	"join": "/join/", "view": "/view/"}
+
+// handleRedirect serves GET /r/<Message-ID>, redirecting to the canonical URL of the article under PublicURL, for
+// integrations to link by Message-ID without knowing the URL rules of the CDN: the route named by the to query
+// parameter, /m/ by default, and the Message-ID followed by canonicalExtension, except for the /view/ page. The
+// Message-ID can be given with or without its angle brackets, and with any of the extensions accepted.
+func (s *server) handleRedirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	to := query.Get("to")
+	if to == "" {
+		to = "m"
+	}
+	prefix, ok := canonicalRoutes[to]
+	if !ok {
+		setErrorCode(r.Context(), "invalid_path")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_, name := splitRoute(r.URL.Path)
+	messageID, _, ok := s.articleName(name)
+	if !ok {
+		messageID = nntp.MessageID(name)
+	}
+	if messageID == "" || messageID.Validate() != nil {
+		setErrorCode(r.Context(), "invalid_message_id")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	location := strings.TrimSuffix(s.PublicURL, "/") + articlePath(prefix, messageID)
+	query.Del("to")
+	if len(query) > 0 {
+		location += "?" + query.Encode()
+	}
+	w.Header().Set("Cache-Control", s.cacheControl(r))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"gopkg.in/nntp.v0"
+)
+
+// trickyMessageIDs are message-ids with the characters of the URLs in them, and bytes no message-id can have.
+var trickyMessageIDs = []nntp.MessageID{
+	"a/b@example.com",
+	"a%2Fb@example.com",   // double-encoded once linked
+	"a%252Fb@example.com", // and twice
+	"100%25@example.com",
+	"50%@example.com",
+	"a+b@example.com",
+	"a?b=c@example.com",
+	"a#b@example.com",
+	"a,b@example.com",
+	"a.csv.nfo@example.com",
+	"caf\xc3\xa9@example.com",
+	"\xff\xfe@example.com",
+}
+
+func TestArticlePathRoundTrip(t *testing.T) {
+	s := &server{}
+	for _, id := range trickyMessageIDs {
+		for _, prefix := range []string{"/m/", "/d/", "/h/", "/view/"} {
+			link := articlePath(prefix, id)
+			r := httptest.NewRequest(http.MethodGet, link, nil)
+			if r.URL.RawQuery != "" || r.URL.Fragment != "" {
+				t.Errorf("articlePath(%q, %q) = %q, with a query or a fragment", prefix, id, link)
+				continue
+			}
+			got, rest := splitRoute(r.URL.Path)
+			if got != prefix {
+				t.Errorf("splitRoute of %q = %q, want %q", link, got, prefix)
+				continue
+			}
+			if prefix == "/view/" {
+				if nntp.MessageID(rest) != id {
+					t.Errorf("%q names %q, want %q", link, rest, id)
+				}
+				continue
+			}
+			if named, canonical, ok := s.articleName(rest); !ok || !canonical || named != id {
+				t.Errorf("%q names %q (canonical %t, ok %t), want %q", link, named, canonical, ok, id)
+			}
+		}
+
+		// the routes splitting the path still percent-encoded
+		link := "/f/" + url.PathEscape(string(id)) + "/" + url.PathEscape("file name?.bin")
+		r := httptest.NewRequest(http.MethodGet, link, nil)
+		named, filename, ok := fileRoute(escapedRest(r))
+		if !ok && id.Validate() == nil || ok && (named != id || filename != "file name?.bin") {
+			t.Errorf("%q names %q and %q (ok %t), want %q", link, named, filename, ok, id)
+		}
+		link = "/c/" + url.PathEscape(string(id)) + "," + url.PathEscape("b@example.com") + ".csv"
+		r = httptest.NewRequest(http.MethodGet, link, nil)
+		if ids, ok := parseConcatPath(escapedRest(r)); !ok || len(ids) != 2 || ids[0] != id || ids[1] != "b@example.com" {
+			t.Errorf("%q names %q (ok %t), want %q", link, ids, ok, id)
+		}
+	}
+}
+
+func TestHandleMessageTrickyIDs(t *testing.T) {
+	f := newFakeNNTP(t)
+	for _, id := range trickyMessageIDs {
+		f.add(string(id), testHeader, "body of "+string(id)+"\r\n")
+	}
+	s := newTestServer(t, f)
+	for _, id := range trickyMessageIDs {
+		w := serve(s, http.MethodGet, articlePath("/m/", id), nil, nil)
+		switch {
+		case id.Validate() != nil:
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("GET of the invalid %q answered %d", id, w.Code)
+			}
+		case w.Code != http.StatusOK:
+			t.Errorf("GET of %q answered %d", id, w.Code)
+		case w.Body.String() != "body of "+string(id)+"\n":
+			t.Errorf("GET of %q answered %q", id, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeArticle is an article of a fakeNNTP: its header lines, each ended by CRLF, and its dot-decoded body.
+type fakeArticle struct {
+	header string
+	body   string
+}
+
+// fakeNNTP is an in-process NNTP server on a loopback listener, answering the greeting, AUTHINFO, MODE READER,
+// ARTICLE, HEAD, BODY, STAT, OVER, DATE and POST from its articles, for the tests to drive the pool and the handlers
+// against a real connection.
+type fakeNNTP struct {
+	ln net.Listener
+	// User and Pass are the AUTHINFO credentials required before any other command, none if User is ""
+	User, Pass string
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	articles map[string]fakeArticle // by message-id with its angle brackets
+	answers  map[string]int         // the code answered to a command instead, -1 closing the connection, -2 never answering
+	once     map[string]int         // the same, answered only the next time
+	commands []string               // received, the AUTHINFO PASS ones without the password
+}
+
+func newFakeNNTP(t testing.TB) (f *fakeNNTP) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = &fakeNNTP{ln: ln, conns: make(map[net.Conn]bool), articles: make(map[string]fakeArticle), answers: make(map[string]int),
+		once: make(map[string]int)}
+	go f.accept()
+	t.Cleanup(f.close)
+	return
+}
+
+// Host is the address to set as the Host of an NNTPServer.
+func (f *fakeNNTP) Host() string {
+	return f.ln.Addr().String()
+}
+
+// add adds an article, header being its header lines separated by "\n" and body its dot-decoded body.
+func (f *fakeNNTP) add(messageID, header, body string) {
+	header = strings.ReplaceAll(strings.TrimSuffix(header, "\n"), "\n", "\r\n") + "\r\n"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.articles["<"+strings.Trim(messageID, "<>")+">"] = fakeArticle{header: header, body: body}
+}
+
+// article returns an article, like one posted.
+func (f *fakeNNTP) article(messageID string) (article fakeArticle, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	article, ok = f.articles["<"+strings.Trim(messageID, "<>")+">"]
+	return
+}
+
+// answer makes the server answer command with code from now on instead of running it, -1 closing the connection,
+// -2 never answering, and 0 running it again.
+func (f *fakeNNTP) answer(command string, code int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if code == 0 {
+		delete(f.answers, command)
+	} else {
+		f.answers[command] = code
+	}
+}
+
+// answerOnce makes the server answer command with code the next time only, before the answers of answer.
+func (f *fakeNNTP) answerOnce(command string, code int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.once[command] = code
+}
+
+// received returns how many times command was received.
+func (f *fakeNNTP) received(command string) (n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, received := range f.commands {
+		if received == command {
+			n++
+		}
+	}
+	return
+}
+
+// close stops the listener and closes the connections.
+func (f *fakeNNTP) close() {
+	f.ln.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeNNTP) accept() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[conn] = true
+		f.mu.Unlock()
+		go f.serve(conn)
+	}
+}
+
+func (f *fakeNNTP) serve(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("200 fake NNTP server ready, posting allowed")
+	authenticated := f.User == ""
+	user := ""
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			tc.PrintfLine("500 empty command")
+			continue
+		}
+		command, arg := strings.ToUpper(fields[0]), ""
+		if command == "AUTHINFO" && len(fields) > 1 {
+			command += " " + strings.ToUpper(fields[1])
+			fields = fields[1:]
+		}
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, command)
+		code, answered := f.once[command]
+		if answered {
+			delete(f.once, command)
+		} else {
+			code, answered = f.answers[command]
+		}
+		article, found := f.articles[arg]
+		f.mu.Unlock()
+		if answered {
+			if code == -2 {
+				// stalled until the client gives up
+				for {
+					if _, err := tc.ReadLine(); err != nil {
+						return
+					}
+				}
+			} else if code < 0 {
+				return
+			}
+			tc.PrintfLine("%d fake answer", code)
+			continue
+		}
+		if !authenticated && !strings.HasPrefix(command, "AUTHINFO") && command != "QUIT" {
+			tc.PrintfLine("480 authentication required")
+			continue
+		}
+
+		switch command {
+		case "AUTHINFO USER":
+			user = arg
+			tc.PrintfLine("381 password required")
+		case "AUTHINFO PASS":
+			if authenticated = user == f.User && arg == f.Pass; authenticated {
+				tc.PrintfLine("281 authentication accepted")
+			} else {
+				tc.PrintfLine("481 authentication failed")
+			}
+		case "MODE":
+			tc.PrintfLine("200 posting allowed")
+		case "DATE":
+			tc.PrintfLine("111 %s", time.Now().UTC().Format("20060102150405"))
+		case "ARTICLE", "HEAD", "BODY", "STAT", "OVER":
+			if !found {
+				tc.PrintfLine("430 no such article")
+				continue
+			}
+			f.sendArticle(tc, command, arg, article)
+		case "POST":
+			tc.PrintfLine("340 send article")
+			f.receiveArticle(tc)
+		case "QUIT":
+			tc.PrintfLine("205 bye")
+			return
+		default:
+			tc.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+// sendArticle answers an article command for the article found.
+func (f *fakeNNTP) sendArticle(tc *textproto.Conn, command, messageID string, article fakeArticle) {
+	switch command {
+	case "ARTICLE":
+		tc.PrintfLine("220 0 %s", messageID)
+		tc.W.WriteString(article.header + "\r\n")
+		f.sendBody(tc, article)
+	case "HEAD":
+		// gopkg.in/nntp.v0 reads the header up to an empty line rather than to the termination line
+		tc.PrintfLine("221 0 %s", messageID)
+		tc.W.WriteString(article.header + "\r\n")
+		tc.W.Flush()
+	case "BODY":
+		tc.PrintfLine("222 0 %s", messageID)
+		f.sendBody(tc, article)
+	case "STAT":
+		tc.PrintfLine("223 0 %s", messageID)
+	case "OVER":
+		header, _ := textproto.NewReader(bufio.NewReader(strings.NewReader(article.header + "\r\n"))).ReadMIMEHeader()
+		// the byte count of the article with CRLF line endings, its dot-stuffing left out
+		tc.PrintfLine("224 overview follows")
+		w := tc.DotWriter()
+		fmt.Fprintf(w, "0\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n", header.Get("Subject"), header.Get("From"), header.Get("Date"),
+			messageID, header.Get("References"), len(article.header)+len("\r\n")+len(article.body),
+			strings.Count(article.body, "\n"))
+		w.Close()
+	}
+}
+
+// sendBody sends the body of the article dot-encoded.
+func (f *fakeNNTP) sendBody(tc *textproto.Conn, article fakeArticle) {
+	w := tc.DotWriter()
+	io.WriteString(w, article.body)
+	w.Close()
+}
+
+// receiveArticle reads a posted article and adds it under its Message-ID.
+func (f *fakeNNTP) receiveArticle(tc *textproto.Conn) {
+	data, err := io.ReadAll(tc.DotReader())
+	if err != nil {
+		return
+	}
+	header, body, _ := strings.Cut(string(data), "\n\n")
+	messageID := ""
+	for _, line := range strings.Split(header, "\n") {
+		if key, value, ok := strings.Cut(line, ": "); ok && strings.EqualFold(key, "Message-Id") {
+			messageID = value
+		}
+	}
+	if messageID == "" {
+		tc.PrintfLine("441 no Message-ID")
+		return
+	}
+	f.add(messageID, header, strings.ReplaceAll(body, "\n", "\r\n"))
+	tc.PrintfLine("240 article received")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"sync"
+
+	"gopkg.in/nntp.v0"
+)
+
+const (
+	// maxMoovSize is the largest moov box moved to the front of an MP4 video, which is held in memory.
+	maxMoovSize = 64 * 1024 * 1024
+	// maxTopBoxes is the most top-level boxes read to find the moov box, each header read fetching a segment.
+	maxTopBoxes = 32
+	// fastStartCacheSize is the most bytes of moov boxes the cached layouts hold.
+	fastStartCacheSize = 256 * 1024 * 1024
+)
+
+var errFastStart = errors.New("not a video with its moov box at the end")
+
+// isMP4 reports whether the file name is of an MP4 or QuickTime video.
+func isMP4(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp4", ".m4v", ".mov":
+		return true
+	}
+	return false
+}
+
+// mp4Box is a top-level box of an MP4 file.
+type mp4Box struct {
+	kind   string
+	offset int64
+	size   int64
+}
+
+// fastStart is the layout of an MP4 video served with its moov box moved before the mdat box the encoder wrote it
+// after, like ffmpeg -movflags faststart does, so players can start playing it without first fetching its end. The
+// bytes from mdat to moov move after the moov box, and the chunk offsets of moov are shifted accordingly.
+type fastStart struct {
+	mdat int64  // the offset of the first mdat box, where moov is moved to
+	moov []byte // the moov box with its chunk offsets shifted
+	from int64  // the offset of moov in the file
+}
+
+// fastStartEntry is the layout of a video, nil if it is served as is, under the message-id of its first segment.
+type fastStartEntry struct {
+	messageID nntp.MessageID
+	layout    *fastStart
+}
+
+// fastStartCache keeps the layouts of the most recently served videos, so that the requests of their ranges don't
+// fetch their boxes again, evicting the least recently used ones to stay within fastStartCacheSize.
+type fastStartCache struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List // of *fastStartEntry, the most recently used first
+	entries map[nntp.MessageID]*list.Element
+}
+
+func newFastStartCache() *fastStartCache {
+	return &fastStartCache{lru: list.New(), entries: make(map[nntp.MessageID]*list.Element)}
+}
+
+// Get returns the layout of the video of the first segment, ok being false if it is not cached.
+func (c *fastStartCache) Get(messageID nntp.MessageID) (layout *fastStart, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[messageID.Short()]
+	if ok {
+		c.lru.MoveToFront(element)
+		layout = element.Value.(*fastStartEntry).layout
+	}
+	return
+}
+
+// Put keeps the layout of the video of the first segment, evicting the least recently used ones to make room for it.
+func (c *fastStartCache) Put(messageID nntp.MessageID, layout *fastStart) {
+	entry := &fastStartEntry{messageID: messageID.Short(), layout: layout}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.messageID]; ok {
+		c.size -= element.Value.(*fastStartEntry).size()
+		c.lru.Remove(element)
+	}
+	for c.lru.Len() > 0 && c.size+entry.size() > fastStartCacheSize {
+		oldest := c.lru.Remove(c.lru.Back()).(*fastStartEntry)
+		delete(c.entries, oldest.messageID)
+		c.size -= oldest.size()
+	}
+	c.entries[entry.messageID] = c.lru.PushFront(entry)
+	c.size += entry.size()
+}
+
+// size is the number of bytes of the moov box of the entry.
+func (entry *fastStartEntry) size() int {
+	if entry.layout == nil {
+		return 0
+	}
+	return len(entry.layout.moov)
+}
+
+// fileETag returns the strong etag of a file assembled from segments, which differs with FastStart since the videos
+// are then sent in another byte order, so that a range of one layout is never resumed from the other.
+func (s *server) fileETag(etag string) string {
+	if s.FastStart {
+		etag = strings.TrimSuffix(etag, "\"") + ".faststart\""
+	}
+	return etag
+}
+
+// cachedFastStartLayout is fastStartLayout, cached with the outcome of the videos served as is by the message-id of
+// their first segment. The other errors, such as a failed fetch, are not cached.
+func (s *server) cachedFastStartLayout(ctx context.Context, file *nzbFile, first *yEncPart, size int64) (layout *fastStart, err error) {
+	messageID := file.Segments[0].MessageID
+	if layout, ok := s.fastStarts.Get(messageID); ok {
+		if layout == nil {
+			return nil, errFastStart
+		}
+		return layout, nil
+	}
+	if layout, err = s.fastStartLayout(ctx, file, first, size); err == nil || errors.Is(err, errFastStart) {
+		s.fastStarts.Put(messageID, layout)
+	}
+	return
+}
+
+// fastStartLayout reads the top-level boxes of the MP4 video, the moov box included if it is the last one after mdat,
+// and returns its fastStart layout. errFastStart is returned for the videos which already start with their moov box,
+// or which cannot be rearranged.
+func (s *server) fastStartLayout(ctx context.Context, file *nzbFile, first *yEncPart, size int64) (layout *fastStart, err error) {
+	var boxes []mp4Box
+	for offset := int64(0); offset < size; {
+		if len(boxes) == maxTopBoxes {
+			return nil, fmt.Errorf("%w: more than %d top-level boxes", errFastStart, maxTopBoxes)
+		}
+		var header []byte
+		if header, err = s.readRange(ctx, file, first, offset, min64(16, size-offset)); err != nil {
+			return
+		}
+		box, ok := parseBoxHeader(header, offset, size)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid box at %d", errFastStart, offset)
+		}
+		boxes = append(boxes, box)
+		offset += box.size
+	}
+	moov, mdat := -1, -1
+	for i, box := range boxes {
+		switch box.kind {
+		case "moov":
+			moov = i
+		case "mdat":
+			if mdat < 0 {
+				mdat = i
+			}
+		}
+	}
+	if moov != len(boxes)-1 || mdat < 0 || mdat > moov {
+		return nil, errFastStart
+	}
+	if boxes[moov].size > maxMoovSize {
+		return nil, fmt.Errorf("%w: moov box of %d bytes", errFastStart, boxes[moov].size)
+	}
+	layout = &fastStart{mdat: boxes[mdat].offset, from: boxes[moov].offset}
+	if layout.moov, err = s.readRange(ctx, file, first, layout.from, boxes[moov].size); err != nil {
+		return nil, err
+	}
+	if err = shiftChunkOffsets(layout.moov[boxHeaderSize(layout.moov):], boxes[moov].size); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// copyRange writes length bytes of the rearranged video from offset to w.
+func (layout *fastStart) copyRange(ctx context.Context, s *server, w io.Writer, file *nzbFile, first *yEncPart, offset, length int64) (err error) {
+	moovSize := int64(len(layout.moov))
+	// the pieces of the rearranged video, at their offset in the original file, or from moov if from is negative
+	for _, piece := range []struct{ start, end, from int64 }{
+		{0, layout.mdat, 0},
+		{layout.mdat, layout.mdat + moovSize, -1},
+		{layout.mdat + moovSize, layout.from + moovSize, layout.mdat},
+	} {
+		if length == 0 {
+			return
+		}
+		if offset >= piece.end {
+			continue
+		}
+		n := min64(length, piece.end-offset)
+		if piece.from < 0 {
+			start := offset - piece.start
+			_, err = w.Write(layout.moov[start : start+n])
+		} else {
+			err = s.copyRange(ctx, w, file, first, piece.from+offset-piece.start, n)
+		}
+		if err != nil {
+			return
+		}
+		offset, length = offset+n, length-n
+	}
+	return
+}
+
+// parseBoxHeader parses the header of the box at offset of a file of size bytes.
+func parseBoxHeader(header []byte, offset, size int64) (box mp4Box, ok bool) {
+	if len(header) < 8 {
+		return
+	}
+	box = mp4Box{kind: string(header[4:8]), offset: offset, size: int64(binary.BigEndian.Uint32(header))}
+	switch box.size {
+	case 0:
+		// up to the end of the file
+		box.size = size - offset
+	case 1:
+		if len(header) < 16 {
+			return
+		}
+		if largeSize := binary.BigEndian.Uint64(header[8:]); largeSize <= math.MaxInt64 {
+			box.size = int64(largeSize)
+		}
+	}
+	ok = box.size >= int64(boxHeaderSize(header)) && box.size <= size-offset
+	return
+}
+
+// boxHeaderSize returns the size of the header of a box, 16 bytes with a large size.
+func boxHeaderSize(box []byte) int {
+	if binary.BigEndian.Uint32(box) == 1 {
+		return 16
+	}
+	return 8
+}
+
+// shiftChunkOffsets adds delta to the chunk offsets of the stco and co64 boxes of the tracks among the boxes of data,
+// the content of a moov box.
+func shiftChunkOffsets(data []byte, delta int64) (err error) {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return fmt.Errorf("%w: truncated box", errFastStart)
+		}
+		size := int64(binary.BigEndian.Uint32(data))
+		headerSize := boxHeaderSize(data)
+		if size == 1 {
+			if len(data) < 16 || binary.BigEndian.Uint64(data[8:]) > uint64(len(data)) {
+				return fmt.Errorf("%w: truncated box", errFastStart)
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:]))
+		} else if size == 0 {
+			size = int64(len(data))
+		}
+		if size < int64(headerSize) || size > int64(len(data)) {
+			return fmt.Errorf("%w: truncated box", errFastStart)
+		}
+		box := data[headerSize:size]
+		switch string(data[4:8]) {
+		case "trak", "mdia", "minf", "stbl":
+			err = shiftChunkOffsets(box, delta)
+		case "stco":
+			err = shiftOffsets(box, delta, 4)
+		case "co64":
+			err = shiftOffsets(box, delta, 8)
+		case "cmov":
+			err = fmt.Errorf("%w: compressed moov box", errFastStart)
+		}
+		if err != nil {
+			return
+		}
+		data = data[size:]
+	}
+	return
+}
+
+// shiftOffsets adds delta to the entries of width bytes of an stco or co64 box, after its version, flags and count.
+func shiftOffsets(box []byte, delta int64, width int) error {
+	if len(box) < 8 {
+		return fmt.Errorf("%w: truncated chunk offsets", errFastStart)
+	}
+	count := int(binary.BigEndian.Uint32(box[4:]))
+	entries := box[8:]
+	if count > len(entries)/width {
+		return fmt.Errorf("%w: truncated chunk offsets", errFastStart)
+	}
+	for i := 0; i < count; i++ {
+		entry := entries[i*width:]
+		if width == 4 {
+			offset := int64(binary.BigEndian.Uint32(entry)) + delta
+			if offset > math.MaxUint32 {
+				return fmt.Errorf("%w: chunk offset overflows stco", errFastStart)
+			}
+			binary.BigEndian.PutUint32(entry, uint32(offset))
+		} else {
+			binary.BigEndian.PutUint64(entry, binary.BigEndian.Uint64(entry)+uint64(delta))
+		}
+	}
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// mp4Video returns an MP4 video with its moov box last: ftyp, a mdat of 8 bytes and a moov holding the stco of its
+// single chunk.
+func mp4Video() (video []byte) {
+	box := func(kind string, content []byte) []byte {
+		header := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+		return append(append(header, kind...), content...)
+	}
+	video = append(box("ftyp", []byte("isom\x00\x00\x00\x00")), box("mdat", []byte("mdatdata"))...)
+	// version and flags, one entry, the offset of the mdat data
+	stco := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(make([]byte, 4), 1), 24)
+	return append(video, box("moov", box("stco", stco))...)
+}
+
+func TestServeFileFastStart(t *testing.T) {
+	f := newFakeNNTP(t)
+	video := mp4Video()
+	// posted as two segments, the second one holding the moov box
+	crc, size := crc32.ChecksumIEEE(video), int64(len(video))
+	segments := []string{
+		string(encodeYEnc("video.mp4", size, 1, 2, 1, video[:32], crc)),
+		string(encodeYEnc("video.mp4", size, 2, 2, 33, video[32:], crc)),
+	}
+	f.add("video1@example.com", testHeader, segments[0])
+	f.add("video2@example.com", testHeader, segments[1])
+	file := &nzbFile{Segments: []nzbSegment{{Number: 1, MessageID: "video1@example.com"}, {Number: 2, MessageID: "video2@example.com"}}}
+	s := newTestServer(t, f)
+	s.FastStart, s.fastStarts = true, newFastStartCache()
+
+	moov := append([]byte(nil), video[32:]...)
+	// the chunk offset moved after moov
+	binary.BigEndian.PutUint32(moov[24:], 24+uint32(len(moov)))
+	want := string(video[:16]) + string(moov) + string(video[16:32])
+	serveFile := func(spec string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		w.Header().Set("ETag", s.fileETag(`"video"`))
+		if _, err := s.serveFile(context.Background(), w, file, 0, spec); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := serveFile("")
+	if body := w.Body.String(); body != want {
+		t.Errorf("video served % x, want % x", body, want)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"video.faststart"` {
+		t.Errorf("ETag %q", etag)
+	}
+
+	// the ranges after the first request don't fetch the boxes again
+	fetched := f.received("ARTICLE")
+	w = serveFile("bytes=16-")
+	if w.Code != http.StatusPartialContent || w.Body.String() != want[16:] {
+		t.Errorf("range of the video answered %d % x", w.Code, w.Body.String())
+	}
+	if n := f.received("ARTICLE") - fetched; n != 1 {
+		t.Errorf("%d segments fetched for a range of a cached layout, want the first one only", n)
+	}
+
+	// the boxes failing to be fetched, the video is sent whole as is, without the ETag of the rearranged one
+	s.fastStarts = newFastStartCache()
+	// the first segment in memory, for the connection dropped to be the one of the second
+	s.memory = newMemoryCache(1 << 20)
+	s.memory.Put("video1@example.com", textproto.MIMEHeader{}, []byte(segments[0]))
+	f.answerOnce("ARTICLE", -1)
+	w = serveFile("bytes=16-")
+	if w.Code != http.StatusOK || w.Body.String() != string(video) {
+		t.Errorf("video failing to be rearranged answered %d % x", w.Code, w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Errorf("video failing to be rearranged ETag %q", etag)
+	}
+}
+
+func TestFastStartCache(t *testing.T) {
+	c := newFastStartCache()
+	layout := func(size int) *fastStart {
+		return &fastStart{moov: make([]byte, size)}
+	}
+	c.Put("a@example.com", layout(fastStartCacheSize/2))
+	c.Put("c@example.com", layout(fastStartCacheSize/2))
+	if _, ok := c.Get("a@example.com"); !ok {
+		t.Error("layout evicted within the cache size")
+	}
+	c.Put("b@example.com", nil)
+	if got, ok := c.Get("b@example.com"); !ok || got != nil {
+		t.Errorf("video served as is cached as %v, %t", got, ok)
+	}
+	// c is the least recently used
+	c.Put("d@example.com", layout(1))
+	if _, ok := c.Get("c@example.com"); ok {
+		t.Error("least recently used layout not evicted")
+	}
+	for _, id := range []nntp.MessageID{"a@example.com", "b@example.com", "d@example.com"} {
+		if _, ok := c.Get(id); !ok {
+			t.Errorf("%s evicted", id)
+		}
+	}
+}
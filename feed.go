@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// Feed methods of an NNTP server, how articles are sent to it.
+const (
+	FeedPost     = ""
+	FeedIHave    = "ihave"
+	FeedTakeThis = "takethis"
+)
+
+// RFC 4644 streaming response codes, not provided by the nntp package.
+const (
+	ResponseCodeStreamingPermitted nntp.ResponseCode = 203
+	ResponseCodeCheckSend          nntp.ResponseCode = 238
+	ResponseCodeTakeThisSuccess    nntp.ResponseCode = 239
+	ResponseCodeCheckUnwanted      nntp.ResponseCode = 438
+	ResponseCodeTakeThisRejected   nntp.ResponseCode = 439
+)
+
+// modeStream switches the connection to RFC 4644 streaming mode, required before issuing TAKETHIS.
+func modeStream(conn *nntp.Conn) (err error) {
+	if err = conn.PrintfLine("MODE STREAM"); err != nil {
+		err = fmt.Errorf("[modeStream] failed to send MODE STREAM command: %w", err)
+		return
+	}
+	code, msg, err := conn.ReadCodeLine(0)
+	if err != nil {
+		err = fmt.Errorf("[modeStream] failed to read MODE STREAM response: %w", err)
+		return
+	}
+	if nntp.ResponseCode(code) != ResponseCodeStreamingPermitted {
+		err = fmt.Errorf("[modeStream] unexpected response: %w", &nntp.Error{Code: nntp.ResponseCode(code), Message: msg})
+	}
+	return
+}
+
+// prepareFeedHeader adds the headers a relaying peer requires but a posting server would otherwise generate. Any Path
+// or Date provided by the client is preserved.
+func prepareFeedHeader(header textproto.MIMEHeader, pathIdentity string) {
+	if header.Get("Path") == "" {
+		header.Set("Path", pathIdentity+"!not-for-mail")
+	}
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	}
+}
+
+// writeArticle sends the article header and body, followed by the dot-termination sequence.
+func writeArticle(conn *nntp.Conn, article *nntp.Article, dotEncoded bool) (err error) {
+	article.Header.Set("Message-Id", string(article.MessageID.Full()))
+	for key, values := range article.Header {
+		for _, value := range values {
+			if err = conn.PrintfLine("%s: %s", textproto.CanonicalMIMEHeaderKey(key), value); err != nil {
+				err = fmt.Errorf("failed to send article header: %w", err)
+				return
+			}
+		}
+	}
+	if err = conn.PrintfLine(""); err != nil {
+		err = fmt.Errorf("failed to send article header termination line: %w", err)
+		return
+	}
+	var writer io.WriteCloser
+	if dotEncoded {
+		writer = conn.DotWriter(textproto.DisableDotEncoding)
+	} else {
+		writer = conn.DotWriter()
+	}
+	if _, err = io.Copy(writer, article.Body); err != nil {
+		err = fmt.Errorf("failed to send article body: %w", err)
+		return
+	}
+	if err = writer.Close(); err != nil {
+		err = fmt.Errorf("failed to send article body termination sequence: %w", err)
+	}
+	return
+}
+
+// cmdIHave offers the article to a peer with the IHAVE command, as specified in RFC 3977 section 6.3.2.
+func cmdIHave(conn *nntp.Conn, article *nntp.Article, dotEncoded bool) (err error) {
+	if err = conn.PrintfLine("IHAVE %s", article.MessageID.Full()); err != nil {
+		err = fmt.Errorf("[cmdIHave] failed to send IHAVE command: %w", err)
+		return
+	}
+	code, msg, err := conn.ReadCodeLine(0)
+	if err != nil {
+		err = fmt.Errorf("[cmdIHave] failed to read IHAVE response: %w", err)
+		return
+	}
+	if nntp.ResponseCode(code) != nntp.ResponseCodeTransferSend { // 335
+		err = fmt.Errorf("[cmdIHave] unexpected response: %w", &nntp.Error{Code: nntp.ResponseCode(code), Message: msg})
+		return
+	}
+	if err = writeArticle(conn, article, dotEncoded); err != nil {
+		err = fmt.Errorf("[cmdIHave] %w", err)
+		return
+	}
+	if code, msg, err = conn.ReadCodeLine(0); err != nil {
+		err = fmt.Errorf("[cmdIHave] failed to read IHAVE result: %w", err)
+		return
+	}
+	if nntp.ResponseCode(code) != nntp.ResponseCodeTransferSuccess { // 235
+		err = fmt.Errorf("[cmdIHave] unexpected response: %w", &nntp.Error{Code: nntp.ResponseCode(code), Message: msg})
+	}
+	return
+}
+
+// cmdTakeThis sends the article to a peer in streaming mode, as specified in RFC 4644 section 2.5.
+func cmdTakeThis(conn *nntp.Conn, article *nntp.Article, dotEncoded bool) (err error) {
+	if err = conn.PrintfLine("TAKETHIS %s", article.MessageID.Full()); err != nil {
+		err = fmt.Errorf("[cmdTakeThis] failed to send TAKETHIS command: %w", err)
+		return
+	}
+	if err = writeArticle(conn, article, dotEncoded); err != nil {
+		err = fmt.Errorf("[cmdTakeThis] %w", err)
+		return
+	}
+	code, msg, err := conn.ReadCodeLine(0)
+	if err != nil {
+		err = fmt.Errorf("[cmdTakeThis] failed to read TAKETHIS result: %w", err)
+		return
+	}
+	if nntp.ResponseCode(code) != ResponseCodeTakeThisSuccess { // 239
+		err = fmt.Errorf("[cmdTakeThis] unexpected response: %w", &nntp.Error{Code: nntp.ResponseCode(code), Message: msg})
+	}
+	return
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+var ErrArticleNotFound = errors.New("article not found")
+
+// ErrBackendFailure is matched by the error of a fetch for which some of the servers tried could not tell whether they
+// have the article, failing with a connection error or an NNTP error other than the article missing, so that it is
+// not answered as missing.
+var ErrBackendFailure = errors.New("backend failure")
+
+// backendError is the error of a fetch for which some of the servers tried failed, wrapping the last failure, with the
+// HTTP status of its NNTP code if it set one.
+type backendError struct {
+	err    error
+	status int
+}
+
+func (e *backendError) Error() string {
+	return ErrBackendFailure.Error() + ": " + e.err.Error()
+}
+
+func (e *backendError) Unwrap() error {
+	return e.err
+}
+
+func (e *backendError) Is(target error) bool {
+	return target == ErrBackendFailure
+}
+
+// fetch runs the article command cmd against the servers the pool chooses for the message-id, moving on to the next
+// one on an NNTP or connection error unless NNTPCodes fails fast on its code, within FetchRetries and FetchTimeout, or
+// racing them with HedgeDelay, see fetchHedged. It returns ErrArticleNotFound if the servers tried all answered so, a
+// backendError wrapping the last failure otherwise, and on success a conn the caller gives back to the pool.
+func (s *server) fetch(ctx context.Context, messageID nntp.MessageID, command string, cmd func(conn *nntp.Conn) (*nntp.Article, error)) (conn *nntp.Conn, article *nntp.Article, err error) {
+	var nntpErr *nntp.Error
+	if s.pool == nil {
+		// local-only mode, there is nothing to fetch from
+		err = ErrArticleNotFound
+		return
+	}
+	if s.FetchTimeout > 0 {
+		// the pool acquisitions and the commands are bounded by ctx, the returned conn outlives it
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.FetchTimeout)*time.Millisecond)
+		defer cancel()
+	}
+	ctx = s.dated(s.locate(ctx, messageID), messageID)
+	defer func() {
+		if err == nil {
+			s.rememberDate(messageID, article.Header)
+		}
+	}()
+	if s.HedgeDelay > 0 {
+		return s.fetchHedged(ctx, messageID, command, cmd)
+	}
+	var failed error // the last server failing to tell whether it has the article
+	for retries := 0; ; retries++ {
+		conn, article, err = s.fetchFrom(ctx, messageID, retries, command, cmd)
+		switch {
+		case errors.Is(err, ErrBackendFailure):
+			// a failure to connect or to reauthenticate, wrapping the NNTP error of the latter
+			failed = err
+		case errors.As(err, &nntpErr) && s.failFast(nntpErr):
+			err = s.nntpBackendError(fmt.Errorf("failing fast: %w", err), nntpErr)
+			return
+		case errors.As(err, &nntpErr):
+			if !s.missing(nntpErr) {
+				failed = s.nntpBackendError(err, nntpErr)
+			}
+		case errors.Is(err, ErrNoMoreServers):
+			err = notFound(failed)
+			return
+		default:
+			// the article, or a pool error
+			return
+		}
+		if !s.canRetry(retries) || ctx.Err() != nil {
+			err = notFound(failed)
+			return
+		}
+	}
+}
+
+// notFound returns the error of a fetch once no more servers are tried: ErrArticleNotFound if they all answered that
+// they have not the article, else failed.
+func notFound(failed error) error {
+	if failed != nil {
+		return failed
+	}
+	return ErrArticleNotFound
+}
+
+// canRetry reports whether another server can be tried after retries of them, see FetchRetries, unset for all of them.
+func (s *server) canRetry(retries int) bool {
+	return s.FetchRetries == nil || retries < *s.FetchRetries
+}
+
+// fetchFrom runs cmd on the server the pool picks for the message-id after skipping retry servers. On an NNTP error,
+// the conn is given back to the pool and the error returned as is, while a connection error, or a failure to connect,
+// is returned in a backendError. An NNTP error whose code reauthenticates, like 480, has the conn authenticated again
+// and cmd run once more, a failure to authenticate closing the conn, unless the server has no User to authenticate
+// with, the code then being an NNTP error like the others.
+func (s *server) fetchFrom(ctx context.Context, messageID nntp.MessageID, retry int, command string, cmd func(conn *nntp.Conn) (*nntp.Article, error)) (conn *nntp.Conn, article *nntp.Article, err error) {
+	var nntpErr *nntp.Error
+	getCtx, span := startSpan(ctx, "pool.Get", attribute.Int("usebin.retries", retry))
+	conn, err = s.pool.Get(getCtx, false, messageID, retry)
+	endSpan(span, err)
+	if errors.Is(err, ErrNoMoreServers) {
+		conn = nil
+		return
+	} else if err != nil && errors.Is(err, context.DeadlineExceeded) {
+		// FetchTimeout passed waiting for a conn
+		conn, err = nil, &backendError{err: fmt.Errorf("pool error: %w", err), status: http.StatusGatewayTimeout}
+		return
+	} else if err != nil && (errors.Is(err, ErrPoolSaturated) || errors.Is(err, ErrPoolStopped) || ctx.Err() != nil) {
+		conn, err = nil, fmt.Errorf("pool error: %w", err)
+		return
+	} else if err != nil {
+		// the server could not be connected to
+		conn, err = nil, &backendError{err: fmt.Errorf("pool error: %w", err)}
+		return
+	}
+	_, span = startSpan(ctx, "nntp "+command, s.commandAttributes(conn, messageID)...)
+	article, err = runUntil(ctx, conn, cmd)
+	endSpan(span, err)
+	server, _ := s.pool.Server(conn)
+	logCommand(ctx, server.Host, command, messageID, err)
+	if errors.As(err, &nntpErr) && s.nntpCode(nntpErr).Action == CodeReauth && s.pool.HasUser(conn) {
+		// the server expired the session, authenticate again and retry once
+		if err = s.pool.Reauthenticate(conn); err != nil {
+			logf(ctx, "[ERROR] [NNTP] %s reauthentication error: %s", server.Host, err.Error())
+			s.pool.Close(conn)
+			conn, err = nil, &backendError{err: fmt.Errorf("reauthentication error: %w", err)}
+			return
+		}
+		logf(ctx, "[INFO] [NNTP] %s reauthenticated after %d %s", server.Host, nntpErr.Code, nntpErr.Message)
+		_, span = startSpan(ctx, "nntp "+command, s.commandAttributes(conn, messageID)...)
+		article, err = runUntil(ctx, conn, cmd)
+		endSpan(span, err)
+		logCommand(ctx, server.Host, command, messageID, err)
+	}
+	if err != nil {
+		if errors.As(err, &nntpErr) {
+			s.pool.Put(conn)
+			conn = nil
+			return
+		}
+		s.pool.Close(conn)
+		conn, err = nil, &backendError{err: fmt.Errorf("connection error: %w", err)}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err.(*backendError).status = http.StatusGatewayTimeout
+		}
+	}
+	return
+}
+
+// errCommandTimeout is the error of a command cut off by the deadline of its fetch.
+var errCommandTimeout = fmt.Errorf("no answer within FetchTimeout: %w", context.DeadlineExceeded)
+
+// runUntil runs cmd on conn, closing the conn if the deadline of ctx passes first so that a server stalling on the
+// command is cut off, in which case errCommandTimeout is returned. The body of the article is read after, unbounded.
+func runUntil(ctx context.Context, conn *nntp.Conn, cmd func(conn *nntp.Conn) (*nntp.Article, error)) (article *nntp.Article, err error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return cmd(conn)
+	}
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		conn.Close()
+	})
+	article, err = cmd(conn)
+	if !timer.Stop() {
+		// the conn was closed under the command, even if its answer came in time
+		article, err = nil, errCommandTimeout
+	}
+	return
+}
+
+// fetchHedged is fetch racing the servers for tail latency: whenever no server has answered within HedgeDelay, or
+// the last one tried answered with an NNTP error, the next server is asked too, and the first article wins. The other
+// requests are cancelled while waiting for a conn, and closed if they answer after the winner, since their article
+// is left unread.
+func (s *server) fetchHedged(ctx context.Context, messageID nntp.MessageID, command string, cmd func(conn *nntp.Conn) (*nntp.Article, error)) (conn *nntp.Conn, article *nntp.Article, err error) {
+	type fetchResult struct {
+		conn    *nntp.Conn
+		article *nntp.Article
+		err     error
+	}
+	var (
+		nntpErr *nntp.Error
+		failed  error // the last server failing to tell whether it has the article
+	)
+
+	hedgeCtx, cancel := context.WithCancel(ctx)
+	results := make(chan fetchResult)
+	pending, next, exhausted := 0, 0, false
+	launch := func() {
+		retry := next
+		next++
+		pending++
+		go func() {
+			var result fetchResult
+			result.conn, result.article, result.err = s.fetchFrom(hedgeCtx, messageID, retry, command, cmd)
+			results <- result
+		}()
+		// the last server of the retry budget counts as the last one of the pool, as does the last one asked in time
+		exhausted = exhausted || !s.canRetry(retry) || ctx.Err() != nil
+	}
+	defer func() {
+		cancel()
+		if pending > 0 {
+			// release the conns of the losers once they answer
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if result := <-results; result.conn != nil {
+						s.pool.Close(result.conn)
+					}
+				}
+			}(pending)
+		}
+	}()
+
+	delay := time.Duration(s.HedgeDelay) * time.Millisecond
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	launch()
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !exhausted {
+				launch()
+				timer.Reset(delay)
+			}
+		case result := <-results:
+			pending--
+			switch {
+			case result.err == nil:
+				conn, article, err = result.conn, result.article, nil
+				return
+			case errors.Is(result.err, ErrNoMoreServers):
+				exhausted = true
+			case errors.Is(result.err, ErrBackendFailure):
+				failed = result.err
+				if !exhausted {
+					launch()
+				}
+			case errors.As(result.err, &nntpErr) && s.failFast(nntpErr):
+				err = s.nntpBackendError(fmt.Errorf("failing fast: %w", result.err), nntpErr)
+				return
+			case errors.As(result.err, &nntpErr):
+				if !s.missing(nntpErr) {
+					failed = s.nntpBackendError(result.err, nntpErr)
+				}
+				// no article there, don't wait for the delay to try elsewhere
+				if !exhausted {
+					launch()
+				}
+			default:
+				// keep the last connection or pool error to report if no server has the article
+				failed = result.err
+				if !exhausted {
+					launch()
+				}
+			}
+		}
+	}
+	err = notFound(failed)
+	return
+}
+
+// logCommand logs the outcome of an NNTP command at DEBUG level, including the full response of a failed one.
+func logCommand(ctx context.Context, host string, command string, messageID nntp.MessageID, err error) {
+	var nntpErr *nntp.Error
+	if err == nil {
+		logf(ctx, "[DEBUG] [NNTP] %s %s %s OK", host, command, messageID)
+	} else if errors.As(err, &nntpErr) {
+		logf(ctx, "[DEBUG] [NNTP] %s %s %s %d %s", host, command, messageID, nntpErr.Code, nntpErr.Message)
+	} else {
+		logf(ctx, "[DEBUG] [NNTP] %s %s %s failed: %s", host, command, messageID, err.Error())
+	}
+}
+
+// copyUsenetHeaders adds the article headers to dst with the "X-Usenet-" prefix, leaving out the ones identifying the
+// NNTP provider.
+func copyUsenetHeaders(dst interface{ Add(key, value string) }, header textproto.MIMEHeader) {
+	for key, values := range header {
+		switch strings.ToLower(key) {
+		case "organization", "x-complaints-to":
+			continue
+		}
+		for _, value := range values {
+			dst.Add("X-Usenet-"+key, value)
+		}
+	}
+}
+
+// setArticleDate sets Last-Modified to the Date header of the article, so that caches can tell how fresh it is, and
+// X-Usenet-Age to the seconds elapsed since then, left out if the date cannot be parsed.
+func setArticleDate(dst http.Header, header textproto.MIMEHeader) {
+	date, err := mail.ParseDate(header.Get("Date"))
+	if err != nil {
+		return
+	}
+	dst.Set("Last-Modified", date.UTC().Format(http.TimeFormat))
+	age := time.Since(date)
+	if age < 0 {
+		// the clock of the posting server is ahead
+		age = 0
+	}
+	dst.Set("X-Usenet-Age", strconv.FormatInt(int64(age/time.Second), 10))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+func TestFetchTimeout(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader, "body\r\n")
+	s := newTestServer(t, f)
+	s.FetchTimeout = 100
+
+	// a server stalling after the command is cut off
+	f.answer("ARTICLE", -2)
+	start := time.Now()
+	err := fetchArticle(s, "a@example.com")
+	if !errors.Is(err, ErrBackendFailure) || !errors.Is(err, errCommandTimeout) {
+		t.Errorf("fetch from a stalled server: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch from a stalled server took %s", elapsed)
+	}
+	if w := serve(s, http.MethodGet, "/m/a@example.com.csv", nil, nil); w.Code != http.StatusGatewayTimeout || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("GET /m/ from a stalled server answered %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+
+	// the conns closed were released, the server answering again
+	f.answer("ARTICLE", 0)
+	if err := fetchArticle(s, "a@example.com"); err != nil {
+		t.Errorf("fetch once the server answers: %v", err)
+	}
+
+	// the deadline passing while waiting for a conn
+	var held []*nntp.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := s.pool.Get(context.Background(), false, "a@example.com", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, conn)
+	}
+	if err := fetchArticle(s, "a@example.com"); !errors.Is(err, ErrBackendFailure) || backendStatus(err) != http.StatusGatewayTimeout {
+		t.Errorf("fetch with every conn in use: %v", err)
+	}
+	if w := serve(s, http.MethodGet, "/m/a@example.com.csv", nil, nil); w.Code != http.StatusGatewayTimeout || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("GET /m/ with every conn in use answered %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	for _, conn := range held {
+		s.pool.Put(conn)
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	one, none := 1, 0
+	for _, test := range []struct {
+		name    string
+		retries *int
+		asked   int
+	}{
+		{"unset", nil, 3},
+		{"0", &none, 1},
+		{"1", &one, 2},
+	} {
+		fakes := []*fakeNNTP{newFakeNNTP(t), newFakeNNTP(t), newFakeNNTP(t)}
+		s := newTestServer(t, fakes...)
+		s.FetchRetries = test.retries
+		if err := fetchArticle(s, "missing@example.com"); !errors.Is(err, ErrArticleNotFound) {
+			t.Errorf("FetchRetries %s: fetch of a missing article: %v", test.name, err)
+		}
+		asked := 0
+		for _, f := range fakes {
+			asked += f.received("ARTICLE")
+		}
+		if asked != test.asked {
+			t.Errorf("FetchRetries %s: %d servers asked, want %d", test.name, asked, test.asked)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+// fileRoute splits the path after /f/, still percent-encoded, into the message-id and the file name, the last path
+// segment, decoding both.
+func fileRoute(name string) (messageID nntp.MessageID, filename string, ok bool) {
+	i := strings.LastIndexByte(name, '/')
+	if i < 0 || i == len(name)-1 {
+		return
+	}
+	id, err := url.PathUnescape(name[:i])
+	if err != nil {
+		return
+	}
+	if filename, err = url.PathUnescape(name[i+1:]); err != nil {
+		return
+	}
+	messageID = nntp.MessageID(id)
+	ok = messageID != "" && messageID.Validate() == nil
+	return
+}
+
+// handleFile serves GET /f/<Message-ID>/<filename>, the article body yEnc-decoded, or as is if it is not yEnc-encoded,
+// as a download named filename. The file name is only used for the Content-Disposition and Content-Type headers, so
+// links download under a sensible name and the CDN caches each name apart. A part of a multipart binary is served
+// alone, GET /join/ serves the whole file.
+func (s *server) handleFile(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	etag := s.routeETag(r, messageID)
+	if done, _ := s.checkArticlePreconditions(w, r, messageID, etag); done {
+		return
+	}
+	result := s.fetchBody(r.Context(), messageID)
+	if result.status != http.StatusOK {
+		logf(r.Context(), "[ERROR] FILE %s %d", messageID, result.status)
+		w.WriteHeader(result.status)
+		return
+	}
+	data := result.body
+	if _, yEncoded := yEncName(data); yEncoded {
+		part, err := decodeYEnc(data)
+		if err != nil {
+			logf(r.Context(), "[ERROR] FILE %s %s", messageID, err.Error())
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		data = part.Data
+	}
+
+	filename := path.Base(r.URL.Path)
+	ctype := mime.TypeByExtension(path.Ext(filename))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	for key, values := range result.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	setETag(w, etag)
+	// handles the ranges, the preconditions were checked already
+	http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(data))
+	logf(r.Context(), "[INFO] FILE %s as %s", messageID, filename)
+}
@@ -0,0 +1,42 @@
+// A small highlighter for the usual suspects of text articles: C-like, shell and script sources, configs and diffs. It
+// only tells comments, strings, numbers and keywords apart, which is language agnostic enough to be right most of the
+// time without detecting the language.
+
+const keywords = new Set([
+    'async', 'await', 'break', 'case', 'catch', 'class', 'const', 'continue', 'def', 'default', 'defer', 'do', 'elif',
+    'else', 'enum', 'export', 'extends', 'false', 'fi', 'finally', 'fn', 'for', 'func', 'function', 'go', 'if', 'import',
+    'in', 'interface', 'let', 'match', 'new', 'nil', 'null', 'package', 'pub', 'return', 'select', 'static', 'struct',
+    'switch', 'then', 'this', 'throw', 'true', 'try', 'type', 'typeof', 'use', 'var', 'void', 'while', 'with', 'yield',
+]);
+
+// each alternative is a capture group, in the order of the classes below
+const token = new RegExp([
+    /(\/\/[^\n]*|\/\*[\s\S]*?\*\/|#[^\n]*)/.source, // comment
+    /("(?:[^"\\\n]|\\.)*"|'(?:[^'\\\n]|\\.)*'|`(?:[^`\\]|\\.)*`)/.source, // string
+    /(\b(?:0x[0-9a-fA-F]+|\d+(?:\.\d+)?(?:[eE][+-]?\d+)?)\b)/.source, // number
+    /(\b[A-Za-z_]\w*\b)/.source, // word, a keyword or not
+].join('|'), 'g');
+
+const classes = ['comment', 'string', 'number'];
+
+// highlight appends the text to parent as spans classed comment, string, number or keyword, and plain text nodes.
+export function highlight(parent: HTMLElement, text: string) {
+    let last = 0;
+    for (const match of text.matchAll(token)) {
+        const group = match.slice(1).findIndex((g) => g !== undefined);
+        let kind = classes[group];
+        if (group === 3) {
+            if (!keywords.has(match[0])) {
+                continue;
+            }
+            kind = 'keyword';
+        }
+        parent.appendChild(document.createTextNode(text.substring(last, match.index)));
+        const span = document.createElement('span');
+        span.className = kind;
+        span.textContent = match[0];
+        parent.appendChild(span);
+        last = match.index + match[0].length;
+    }
+    parent.appendChild(document.createTextNode(text.substring(last)));
+}
@@ -0,0 +1,198 @@
+// NZBNamespace is the XML namespace of the NZB documents, which most of them declare
+const NZBNamespace = 'http://www.newzbin.com/DTD/2003/nzb';
+
+interface NZBFile {
+    element: Element;
+    subject: string;
+    segments: number;
+    bytes: number;
+    row: HTMLTableRowElement;
+}
+
+// Inspected is a file as listed by POST /nzb/inspect
+interface Inspected {
+    index: number;
+    name: string;
+    size: number;
+    segments: number;
+    bytes: number;
+    sampled: number;
+    available: number;
+    missing: number;
+    failed: number;
+    completeness: number | null;
+}
+
+const drop = <HTMLElement>document.getElementById('drop');
+const picker = <HTMLInputElement>document.getElementById('file');
+const status = <HTMLElement>document.getElementById('status');
+const table = <HTMLTableElement>document.getElementById('files');
+const all = <HTMLAnchorElement>document.getElementById('all');
+
+drop.addEventListener('dragover', (e) => {
+    e.preventDefault();
+    drop.classList.add('over');
+});
+drop.addEventListener('dragleave', () => drop.classList.remove('over'));
+drop.addEventListener('drop', (e) => {
+    e.preventDefault();
+    drop.classList.remove('over');
+    if (e.dataTransfer.files.length > 0) {
+        openFile(e.dataTransfer.files[0]);
+    }
+});
+drop.addEventListener('click', () => picker.click());
+picker.addEventListener('change', () => {
+    if (picker.files.length > 0) {
+        openFile(picker.files[0]);
+    }
+});
+
+function openFile(file: File) {
+    file.text().then((text) => browse(text, file.name)).catch(failed);
+}
+
+// main browses the NZB linked by the url query parameter, if any.
+async function main() {
+    const url = new URLSearchParams(window.location.search).get('url');
+    if (url == null) {
+        return;
+    }
+    status.textContent = `fetching ${url}`;
+    const resp = await fetch(url);
+    if (resp.status !== 200) {
+        status.textContent = `failed to get ${url}: ${resp.status} ${resp.statusText}`;
+        return;
+    }
+    await browse(await resp.text(), url.substring(url.lastIndexOf('/') + 1));
+}
+
+// browse lists the files of the NZB document parsed in the browser, then completes them with their names, sizes and
+// completeness from POST /nzb/inspect.
+async function browse(text: string, name: string) {
+    const doc = new DOMParser().parseFromString(text, 'application/xml');
+    const files = parseFiles(doc);
+    const tbody = table.tBodies[0];
+    tbody.textContent = '';
+    if (files.length === 0) {
+        table.hidden = all.hidden = true;
+        status.textContent = `no files in ${name}`;
+        return;
+    }
+    document.title = `useb.in · ${name}`;
+    for (const file of files) {
+        tbody.appendChild(file.row);
+        showFile(file, null);
+    }
+    table.hidden = false;
+    all.hidden = files.length < 2;
+    all.removeAttribute('href');
+    all.textContent = 'download all as zip';
+    all.onclick = () => download(all, text, '?format=zip', 'nzb.zip');
+    status.textContent = `${name}: checking ${files.length} files`;
+
+    const resp = await fetch(`${window.location.origin}/nzb/inspect`, { method: 'POST', body: text });
+    if (resp.status !== 200) {
+        status.textContent = `${name}: failed to check the files: ${resp.status} ${resp.statusText}`;
+        return;
+    }
+    const inspected: Inspected[] = (await resp.json()).files;
+    inspected.forEach((inspected, i) => showFile(files[i], inspected));
+    status.textContent = `${name}: ${files.length} files`;
+}
+
+function parseFiles(doc: Document): NZBFile[] {
+    let elements = Array.from(doc.getElementsByTagNameNS(NZBNamespace, 'file'));
+    if (elements.length === 0) {
+        elements = Array.from(doc.getElementsByTagName('file'));
+    }
+    return elements.map((element) => {
+        const segments = Array.from(element.getElementsByTagNameNS('*', 'segment'));
+        return {
+            element,
+            subject: element.getAttribute('subject') ?? '',
+            segments: segments.length,
+            bytes: segments.reduce((sum, segment) => sum + Number(segment.getAttribute('bytes') ?? 0), 0),
+            row: document.createElement('tr'),
+        };
+    });
+}
+
+function showFile(file: NZBFile, inspected: Inspected | null) {
+    const cells = inspected == null
+        ? [file.subject, `~${formatSize(file.bytes)}`, String(file.segments), '...']
+        : [
+            inspected.name,
+            inspected.size > 0 ? formatSize(inspected.size) : `~${formatSize(file.bytes)}`,
+            String(file.segments),
+            completeness(inspected),
+        ];
+    file.row.textContent = '';
+    cells.forEach((text, i) => {
+        const cell = file.row.insertCell();
+        cell.textContent = text;
+        cell.className = i === 1 || i === 2 ? 'number' : '';
+    });
+    if (inspected != null && inspected.completeness != null && inspected.completeness < 100) {
+        file.row.cells[3].classList.add('incomplete');
+    }
+    const link = document.createElement('a');
+    link.textContent = 'download';
+    const name = inspected?.name ?? 'file';
+    link.onclick = () => download(link, singleFile(file.element), '', name);
+    file.row.insertCell().appendChild(link);
+}
+
+function completeness(inspected: Inspected): string {
+    if (inspected.completeness == null) {
+        return `unknown, ${inspected.failed} failed`;
+    }
+    const sampled = inspected.sampled < inspected.segments ? ` of ${inspected.sampled} sampled` : '';
+    return `${inspected.completeness}%${sampled}`;
+}
+
+// singleFile returns an NZB document of the file alone, for POST /nzb to return it as is.
+function singleFile(element: Element): string {
+    const doc = document.implementation.createDocument(element.namespaceURI, 'nzb', null);
+    doc.documentElement.appendChild(doc.importNode(element, true));
+    return new XMLSerializer().serializeToString(doc);
+}
+
+// download fetches the files of the NZB document with POST /nzb, then saves the response once it is complete, so it
+// is kept in memory until then.
+async function download(link: HTMLAnchorElement, nzb: string, query: string, name: string) {
+    if (link.href !== '') {
+        return;
+    }
+    link.onclick = null;
+    link.textContent = 'downloading...';
+    try {
+        const resp = await fetch(`${window.location.origin}/nzb${query}`, { method: 'POST', body: nzb });
+        if (resp.status !== 200) {
+            throw new Error(`${resp.status} ${resp.statusText}`);
+        }
+        link.href = URL.createObjectURL(await resp.blob());
+        link.download = name;
+        link.textContent = `save ${name}`;
+        link.click();
+    } catch (e) {
+        console.error(e);
+        link.textContent = `failed: ${e.message}`;
+    }
+}
+
+function formatSize(size: number): string {
+    const units = ['B', 'KiB', 'MiB', 'GiB', 'TiB'];
+    let i = 0;
+    for (; size >= 1024 && i < units.length - 1; i++) {
+        size /= 1024;
+    }
+    return `${i === 0 ? size : size.toFixed(1)} ${units[i]}`;
+}
+
+function failed(e: Error) {
+    console.error(e);
+    status.textContent = `failed to browse the NZB: ${e.message}`;
+}
+
+main().catch(failed);
@@ -0,0 +1,160 @@
+import * as yenc from './yenc';
+import * as hex from '@stablelib/hex';
+
+interface Segment {
+    number: number; // starts from 1
+    begin: number; // offset in the file, inclusive
+    end: number; // offset in the file, exclusive
+    bytes: number; // size of the posted article body
+    messageID: string;
+    row: HTMLElement;
+}
+
+// SegmentSize is the number of file bytes in each article, the size most posters use
+const SegmentSize = 716800;
+// Concurrency is the number of segments posted at the same time
+const Concurrency = 4;
+const Retries = 3;
+
+const drop = <HTMLElement>document.getElementById('drop');
+const picker = <HTMLInputElement>document.getElementById('file');
+const group = <HTMLInputElement>document.getElementById('group');
+const progress = <HTMLElement>document.getElementById('progress');
+const result = <HTMLAnchorElement>document.getElementById('nzb');
+
+drop.addEventListener('dragover', (e) => {
+    e.preventDefault();
+    drop.classList.add('over');
+});
+drop.addEventListener('dragleave', () => drop.classList.remove('over'));
+drop.addEventListener('drop', (e) => {
+    e.preventDefault();
+    drop.classList.remove('over');
+    if (e.dataTransfer.files.length > 0) {
+        upload(e.dataTransfer.files[0]);
+    }
+});
+drop.addEventListener('click', () => picker.click());
+picker.addEventListener('change', () => {
+    if (picker.files.length > 0) {
+        upload(picker.files[0]);
+    }
+});
+
+async function upload(file: File) {
+    const total = Math.max(1, Math.ceil(file.size / SegmentSize));
+    group.disabled = true;
+    const segments: Segment[] = [];
+    progress.textContent = '';
+    result.hidden = true;
+    for (let i = 0; i < total; i++) {
+        const row = document.createElement('div');
+        progress.appendChild(row);
+        const begin = i * SegmentSize;
+        const end = Math.min(file.size, begin + SegmentSize);
+        segments.push({ number: i + 1, begin, end, bytes: 0, messageID: randomMessageID(), row });
+        showProgress(segments[i], 0, 'waiting');
+    }
+
+    let next = 0;
+    let failed = false;
+    async function worker() {
+        while (next < segments.length && !failed) {
+            const segment = segments[next++];
+            try {
+                await postSegment(file, segment, total);
+            } catch (e) {
+                console.error(e);
+                failed = true;
+                showProgress(segment, 0, `failed: ${e.message}`);
+            }
+        }
+    }
+    await Promise.all(Array.from({ length: Math.min(Concurrency, total) }, worker));
+    group.disabled = false;
+    if (failed) {
+        return;
+    }
+
+    const nzb = new Blob([nzbDocument(file, segments)], { type: 'application/x-nzb' });
+    result.href = URL.createObjectURL(nzb);
+    result.download = file.name + '.nzb';
+    result.textContent = `download ${result.download}`;
+    result.hidden = false;
+}
+
+async function postSegment(file: File, segment: Segment, total: number) {
+    const data = new Uint8Array(await file.slice(segment.begin, segment.end).arrayBuffer());
+    const body = yenc.encode({
+        name: file.name,
+        line: yenc.LineMax,
+        size: file.size,
+        part: segment.number,
+        total,
+        begin: segment.begin,
+        end: segment.end,
+        data,
+    });
+    segment.bytes = body.byteLength;
+    const subject = `"${file.name}" yEnc (${segment.number}/${total})`;
+    for (let retries = 0; ; retries++) {
+        try {
+            await post(segment, body, subject);
+            showProgress(segment, 1, 'posted');
+            return;
+        } catch (e) {
+            if (retries >= Retries) {
+                throw e;
+            }
+            segment.messageID = randomMessageID();
+        }
+    }
+}
+
+// post sends the article with XMLHttpRequest rather than fetch, which has no upload progress.
+function post(segment: Segment, body: Uint8Array, subject: string): Promise<void> {
+    return new Promise((resolve, reject) => {
+        const xhr = new XMLHttpRequest();
+        const query = `s=${encodeURIComponent(subject)}&g=${encodeURIComponent(group.value)}`;
+        xhr.open('POST', `${window.location.origin}/m/${segment.messageID}.csv?${query}`);
+        xhr.upload.onprogress = (e) => showProgress(segment, e.loaded / e.total, 'posting');
+        // 202 Accepted means the article is spooled to be posted later
+        xhr.onload = () => xhr.status === 200 || xhr.status === 202
+            ? resolve()
+            : reject(new Error(`${xhr.status} ${xhr.statusText}`));
+        xhr.onerror = () => reject(new Error('network error'));
+        xhr.send(body);
+    });
+}
+
+function showProgress(segment: Segment, done: number, status: string) {
+    const width = 40;
+    const bar = '#'.repeat(Math.round(done * width)).padEnd(width, '.');
+    segment.row.textContent = `${String(segment.number).padStart(5)} [${bar}] ${status}`;
+}
+
+function nzbDocument(file: File, segments: Segment[]): string {
+    const date = Math.floor(Date.now() / 1000);
+    const subject = `"${file.name}" yEnc (1/${segments.length})`;
+    const lines = [
+        '<?xml version="1.0" encoding="UTF-8"?>',
+        '<!DOCTYPE nzb PUBLIC "-//newzBin//DTD NZB 1.1//EN" "http://www.newzbin.com/DTD/nzb/nzb-1.1.dtd">',
+        '<nzb xmlns="http://www.newzbin.com/DTD/2003/nzb">',
+        `  <file poster="usebin" date="${date}" subject="${escapeXML(subject)}">`,
+        `    <groups><group>${escapeXML(group.value)}</group></groups>`,
+        '    <segments>',
+        ...segments.map((s) => `      <segment bytes="${s.bytes}" number="${s.number}">${escapeXML(s.messageID)}</segment>`),
+        '    </segments>',
+        '  </file>',
+        '</nzb>',
+    ];
+    return lines.join('\n') + '\n';
+}
+
+function escapeXML(s: string): string {
+    return s.replace(/[<>&"']/g, (c) => `&#${c.charCodeAt(0)};`);
+}
+
+function randomMessageID(): string {
+    return hex.encode(crypto.getRandomValues(new Uint8Array(16))).toLowerCase() + '@ngPost.com';
+}
@@ -0,0 +1,86 @@
+import * as yenc from './yenc';
+import * as bytes from './bytes';
+import { decodeText } from './text';
+import { CodePage } from './charset';
+import { highlight } from './highlight';
+
+const headers = <HTMLElement>document.getElementById('headers');
+const status = <HTMLElement>document.getElementById('status');
+const content = <HTMLElement>document.getElementById('content');
+const file = <HTMLElement>document.getElementById('file');
+const download = <HTMLAnchorElement>document.getElementById('download');
+const join = <HTMLAnchorElement>document.getElementById('join');
+
+// UsenetHeader is the prefix of the article headers among the response headers, see copyUsenetHeaders
+const UsenetHeader = 'x-usenet-';
+
+async function main() {
+    const messageID = decodeURIComponent(window.location.pathname.substring('/view/'.length));
+    document.title = `useb.in · ${messageID}`;
+    const resp = await fetch(`${window.location.origin}/m/${encodeURIComponent(messageID)}.csv`);
+    if (resp.status !== 200) {
+        status.textContent = resp.status === 404
+            ? `${messageID} not found`
+            : `failed to get ${messageID}: ${resp.status} ${resp.statusText}`;
+        return;
+    }
+    showHeaders(resp.headers);
+    const body = new Uint8Array(await resp.arrayBuffer());
+    status.hidden = true;
+    if (isYEnc(body)) {
+        try {
+            showFile(messageID, yenc.decode(body));
+            return;
+        } catch (e) {
+            console.error(e);
+        }
+    }
+    highlight(content, decodeText(body, CodePage.DOS437En));
+}
+
+function showHeaders(respHeaders: Headers) {
+    const lines: string[] = [];
+    respHeaders.forEach((value, key) => {
+        if (key.startsWith(UsenetHeader)) {
+            lines.push(`${canonicalKey(key.substring(UsenetHeader.length))}: ${value}`);
+        }
+    });
+    headers.textContent = lines.sort().join('\n');
+}
+
+function canonicalKey(key: string): string {
+    return key.replace(/(^|-)([a-z])/g, (_, dash, c) => dash + c.toUpperCase());
+}
+
+// isYEnc reports whether the body starts with a yEnc header, allowing for a few lines of text before it.
+function isYEnc(body: Uint8Array): boolean {
+    const head = bytes.toString(body.subarray(0, 4096));
+    return /(^|\n)=ybegin /.test(head);
+}
+
+function showFile(messageID: string, decoded: yenc.yFile) {
+    const lines = [`name  ${decoded.name}`, `size  ${decoded.size} bytes`];
+    if (decoded.total != null) {
+        lines.push(`part  ${decoded.part} of ${decoded.total}, bytes ${decoded.begin + 1} to ${decoded.end}`);
+    }
+    file.textContent = lines.join('\n');
+    file.hidden = false;
+    const blob = new Blob([decoded.data], { type: 'application/octet-stream' });
+    download.href = URL.createObjectURL(blob);
+    download.download = decoded.name;
+    download.hidden = false;
+    if (decoded.total != null && decoded.total > 1) {
+        download.textContent = `download part ${decoded.part}`;
+        // all the parts joined by the server
+        join.href = `${window.location.origin}/join/${encodeURIComponent(messageID)}.csv`;
+        join.textContent = `download ${decoded.name}`;
+        join.hidden = false;
+    } else {
+        download.textContent = `download ${decoded.name}`;
+    }
+}
+
+main().catch((e) => {
+    console.error(e);
+    status.textContent = `failed to display the article: ${e.message}`;
+});
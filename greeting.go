@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/nntp.v0"
+)
+
+// readGreeting reads the greeting of the server, which tells with its code whether posting is allowed.
+func readGreeting(conn *nntp.Conn) (posting bool, err error) {
+	code, msg, err := conn.ReadCodeLine(0)
+	if err != nil {
+		err = fmt.Errorf("[readGreeting] failed to read greeting: %w", err)
+		return
+	}
+	return postingAllowed(code, msg, "greeting")
+}
+
+// modeReader switches the connection to reader mode, which some servers require before the article commands. The
+// response tells again whether posting is allowed, possibly not as the greeting did.
+func modeReader(conn *nntp.Conn) (posting bool, err error) {
+	if err = conn.PrintfLine("MODE READER"); err != nil {
+		err = fmt.Errorf("[modeReader] failed to send MODE READER command: %w", err)
+		return
+	}
+	code, msg, err := conn.ReadCodeLine(0)
+	if err != nil {
+		err = fmt.Errorf("[modeReader] failed to read MODE READER response: %w", err)
+		return
+	}
+	return postingAllowed(code, msg, "MODE READER")
+}
+
+func postingAllowed(code int, msg, command string) (posting bool, err error) {
+	switch nntp.ResponseCode(code) {
+	case nntp.ResponseCodeReadyPostingAllowed:
+		posting = true
+	case nntp.ResponseCodeReadyPostingProhibited:
+	default:
+		err = fmt.Errorf("[%s] unexpected response: %w", command, &nntp.Error{Code: nntp.ResponseCode(code), Message: msg})
+	}
+	return
+}
+
+// greeted records whether the server allows posting, as it told when the last connection was opened, and logs when it
+// starts or stops contradicting the Posting flag.
+func (sp *serverPool) greeted(posting bool) {
+	sp.mu.Lock()
+	changed := sp.postingAllowed == nil || *sp.postingAllowed != posting
+	sp.postingAllowed = &posting
+	mismatch := sp.postingMismatch()
+	sp.mu.Unlock()
+	switch {
+	case !changed:
+	case mismatch && posting:
+		// a server used for reading only is a legitimate setup
+		logPrintf("[INFO] [Pool] %s - greeting allows posting but Posting is not set", sp.server.Host)
+	case mismatch:
+		logPrintf("[WARN] [Pool] %s - greeting prohibits posting but Posting is set, posts will fail", sp.server.Host)
+	default:
+		logPrintf("[INFO] [Pool] %s - greeting posting allowed: %t", sp.server.Host, posting)
+	}
+}
+
+// postingMismatch reports whether the server allows posting while Posting is not set, or the opposite. It only
+// applies to the servers posted to with POST, as a prohibited posting does not forbid IHAVE and TAKETHIS. sp.mu must
+// be held.
+func (sp *serverPool) postingMismatch() bool {
+	return sp.postingAllowed != nil && sp.server.Feed == FeedPost && *sp.postingAllowed != sp.server.Posting
+}
+
+// handleServers serves the state of the connections to the NNTP servers as JSON, with whether their greeting
+// contradicts their Posting flag.
+func (s *server) handleServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if s.pool == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "no NNTPServers, running in local-only mode")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.pool.Stats())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// handleHealth serves GET /healthz, answering 200 OK as long as the server is serving, for the HEALTHCHECK of a
+// container and the health checks of load balancers.
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok\n")
+}
+
+// healthURL returns the URL of GET /healthz on the first of the main listeners, on the loopback interface when it
+// listens on all of them.
+func (s *server) healthURL() (healthURL string, err error) {
+	host, port, err := net.SplitHostPort(s.listenAddrs()[0])
+	if err != nil {
+		return
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() && ip.To4() != nil {
+		host = "127.0.0.1"
+	} else if ip != nil && ip.IsUnspecified() {
+		host = "::1"
+	}
+	scheme := "http"
+	if s.CertFile != "" && s.KeyFile != "" {
+		scheme = "https"
+	}
+	healthURL = scheme + "://" + net.JoinHostPort(host, port) + "/healthz"
+	return
+}
+
+// healthcheck requests GET /healthz of the server running with the same config, failing unless it answers 200 OK.
+func (s *server) healthcheck(timeout time.Duration) (err error) {
+	healthURL, err := s.healthURL()
+	if err != nil {
+		return
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		// the certificate is the server's own, for a public name rather than the loopback address
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	req, err := http.NewRequest(http.MethodGet, healthURL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s answered %s", healthURL, resp.Status)
+	}
+	return
+}
+
+func healthcheckCommand(server *server, args []string) {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 5*time.Second, "how long to wait for the server to answer")
+	flags.Parse(args)
+	if err := server.applyDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	if err := server.healthcheck(*timeout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// hsts adds the Strict-Transport-Security header to every response served over HTTPS, if HSTSMaxAge is configured.
+func (s *server) hsts(handler http.Handler) http.Handler {
+	if s.HSTSMaxAge <= 0 {
+		return handler
+	}
+	value := "max-age=" + strconv.FormatInt(s.HSTSMaxAge, 10)
+	if s.HSTSSubdomains {
+		value += "; includeSubDomains"
+	}
+	if s.HSTSPreload {
+		value += "; preload"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// redirectHTTPS permanently redirects any plain HTTP request to the same URL on the HTTPS listener of port. A request
+// without a Host, as HTTP/1.0 allows, is redirected to the host of the first Listen address, or answered with 400 Bad
+// Request if it listens on every address.
+func (s *server) redirectHTTPS(port int) http.Handler {
+	fallback, _, _ := net.SplitHostPort(s.listenAddrs()[0])
+	if ip := net.ParseIP(fallback); ip != nil && ip.IsUnspecified() {
+		fallback = ""
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if host == "" && fallback == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "missing Host header")
+			return
+		} else if host == "" {
+			host = fallback
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			// bare IPv6 literal
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	for _, test := range []struct {
+		listen   []string
+		host     string
+		port     int
+		location string
+	}{
+		{nil, "example.com", 443, "https://example.com/m/a@example.com?x=1"},
+		{nil, "example.com:80", 8443, "https://example.com:8443/m/a@example.com?x=1"},
+		{nil, "[::1]:80", 443, "https://[::1]/m/a@example.com?x=1"},
+		// without a Host
+		{[]string{"usebin.example.com:443"}, "", 443, "https://usebin.example.com/m/a@example.com?x=1"},
+		{[]string{"[::1]:8443"}, "", 8443, "https://[::1]:8443/m/a@example.com?x=1"},
+		{[]string{"0.0.0.0:443"}, "", 443, ""},
+		{[]string{":443"}, "", 443, ""},
+	} {
+		s := &server{Listen: test.listen}
+		r := httptest.NewRequest(http.MethodGet, "/m/a@example.com?x=1", nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		s.redirectHTTPS(test.port).ServeHTTP(w, r)
+		if test.location == "" {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Listen %v without a Host answered %d", test.listen, w.Code)
+			}
+			continue
+		}
+		if location := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || location != test.location {
+			t.Errorf("Listen %v, Host %q redirected with %d to %q, want %q", test.listen, test.host, w.Code, location, test.location)
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"net/textproto"
 	"strconv"
 	"strings"
+
+	"gopkg.in/nntp.v0"
 )
 
 // condResult is the result of an HTTP request precondition check.
@@ -22,11 +24,56 @@ const (
 	condFalse
 )
 
-// checkPreconditions evaluates request preconditions and reports whether a precondition
+// scanETag determines if a syntactically valid ETag is present at s. If so,
+// the ETag and remaining text after consuming ETag is returned. Otherwise,
+// it returns "", "".
+func scanETag(s string) (etag string, remain string) {
+	s = textproto.TrimString(s)
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s[start:]) < 2 || s[start] != '"' {
+		return "", ""
+	}
+	// ETag is either W/"text" or "text".
+	// See RFC 7232 2.3.
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		// Character values allowed in ETags.
+		case c == 0x21 || c >= 0x23 && c <= 0x7E || c >= 0x80:
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
+
+// etagStrongMatch reports whether a and b match using strong ETag comparison.
+// Assumes a and b are valid ETags.
+func etagStrongMatch(a, b string) bool {
+	return a == b && a != "" && a[0] == '"'
+}
+
+// etagWeakMatch reports whether a and b match using weak ETag comparison.
+// Assumes a and b are valid ETags.
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// messageETag returns the strong ETag of an article, derived from its message-id since articles are immutable.
+func messageETag(messageID nntp.MessageID) string {
+	return "\"" + string(messageID.Short()) + "\""
+}
+
+// checkPreconditions evaluates request preconditions against the resource's etag and reports whether a precondition
 // resulted in sending StatusNotModified or StatusPreconditionFailed.
-func checkPreconditions(w http.ResponseWriter, r *http.Request) (done bool, rangeHeader string) {
+func checkPreconditions(w http.ResponseWriter, r *http.Request, etag string) (done bool, rangeHeader string) {
 	// This function carefully follows RFC 7232 section 6.
-	ch := checkIfMatch(r)
+	ch := checkIfMatch(r, etag)
 	if ch == condNone {
 		ch = checkIfUnmodifiedSince(r)
 	}
@@ -34,9 +81,10 @@ func checkPreconditions(w http.ResponseWriter, r *http.Request) (done bool, rang
 		w.WriteHeader(http.StatusPreconditionFailed)
 		return true, ""
 	}
-	switch checkIfNoneMatch(r) {
+	switch checkIfNoneMatch(r, etag) {
 	case condFalse:
 		if r.Method == "GET" || r.Method == "HEAD" {
+			setETag(w, etag)
 			writeNotModified(w)
 			return true, ""
 		} else {
@@ -45,25 +93,73 @@ func checkPreconditions(w http.ResponseWriter, r *http.Request) (done bool, rang
 		}
 	case condNone:
 		if checkIfModifiedSince(r) == condFalse {
+			setETag(w, etag)
 			writeNotModified(w)
 			return true, ""
 		}
 	}
 
 	rangeHeader = r.Header.Get("Range")
-	if rangeHeader != "" && checkIfRange(w, r) == condFalse {
+	if rangeHeader != "" && checkIfRange(r, etag) == condFalse {
 		rangeHeader = ""
 	}
 	return false, rangeHeader
 }
 
-func checkIfMatch(r *http.Request) condResult {
+// revalidates reports whether checkPreconditions would answer the request with StatusNotModified, that is the
+// client or the CDN is re-validating the copy it has cached.
+func revalidates(r *http.Request, etag string) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	ch := checkIfMatch(r, etag)
+	if ch == condNone {
+		ch = checkIfUnmodifiedSince(r)
+	}
+	if ch == condFalse {
+		return false
+	}
+	switch checkIfNoneMatch(r, etag) {
+	case condFalse:
+		return true
+	case condNone:
+		return checkIfModifiedSince(r) == condFalse
+	}
+	return false
+}
+
+// checkIfMatch evaluates If-Match against etag. Without an ETag, the route sending none, only * can be told, which
+// matches any article served, the missing ones being answered with StatusNotFound.
+func checkIfMatch(r *http.Request, etag string) condResult {
 	im := r.Header.Get("If-Match")
 	if im == "" {
 		return condNone
 	}
-	// since we only store immutable contents, if client has cached it before, it's always valid
-	return condTrue
+	for {
+		im = textproto.TrimString(im)
+		if len(im) == 0 {
+			break
+		}
+		if im[0] == ',' {
+			im = im[1:]
+			continue
+		}
+		if im[0] == '*' {
+			return condTrue
+		}
+		candidate, remain := scanETag(im)
+		if candidate == "" {
+			break
+		}
+		if etagStrongMatch(candidate, etag) {
+			return condTrue
+		}
+		im = remain
+	}
+	if etag == "" {
+		return condNone
+	}
+	return condFalse
 }
 
 func checkIfUnmodifiedSince(r *http.Request) condResult {
@@ -86,22 +182,55 @@ func checkIfModifiedSince(r *http.Request) condResult {
 	return condTrue
 }
 
-func checkIfNoneMatch(r *http.Request) condResult {
-	if r.Header.Get("If-None-Match") == "" {
+// checkIfNoneMatch evaluates If-None-Match against etag, only * being told without an ETag.
+func checkIfNoneMatch(r *http.Request, etag string) condResult {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return condNone
+	}
+	buf := inm
+	for {
+		buf = textproto.TrimString(buf)
+		if len(buf) == 0 {
+			break
+		}
+		if buf[0] == ',' {
+			buf = buf[1:]
+			continue
+		}
+		if buf[0] == '*' {
+			return condFalse
+		}
+		candidate, remain := scanETag(buf)
+		if candidate == "" {
+			break
+		}
+		if etagWeakMatch(candidate, etag) {
+			return condFalse
+		}
+		buf = remain
+	}
+	if etag == "" {
 		return condNone
 	}
-	// since we only store immutable contents, if client has cached it before, it's always valid
 	return condTrue
 }
 
-func checkIfRange(w http.ResponseWriter, r *http.Request) condResult {
+func checkIfRange(r *http.Request, etag string) condResult {
 	if r.Method != "GET" && r.Method != "HEAD" {
 		return condNone
 	}
-	if r.Header.Get("If-Range") == "" {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
 		return condNone
 	}
-	// since we only store immutable contents, if client has cached it before, it's always valid
+	if candidate, _ := scanETag(ir); candidate != "" {
+		if etagStrongMatch(candidate, etag) {
+			return condTrue
+		}
+		return condFalse
+	}
+	// a date validator, since we only store immutable contents, if client has cached it before, it's always valid
 	return condTrue
 }
 
@@ -215,6 +344,22 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 	return ranges, nil
 }
 
+// parseStreamRange parses a Range header of a single range with a start offset, the only kind that can be served from
+// a body of unknown size by skipping its first bytes, with the end of the range capped at limit. ok is false for the
+// other kinds of ranges, which are ignored.
+func parseStreamRange(s string, limit int64) (ra httpRange, ok bool, err error) {
+	const b = "bytes="
+	if s == "" || strings.Contains(s, ",") || strings.HasPrefix(textproto.TrimString(strings.TrimPrefix(s, b)), "-") {
+		return
+	}
+	var ranges []httpRange
+	if ranges, err = parseRange(s, limit); err != nil || len(ranges) != 1 {
+		return
+	}
+	ra, ok = ranges[0], true
+	return
+}
+
 func sumRangesSize(ranges []httpRange) (size int64) {
 	for _, ra := range ranges {
 		size += ra.length
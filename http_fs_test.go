@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScanETag(t *testing.T) {
+	for _, test := range []struct {
+		s, etag, remain string
+	}{
+		{`"abc"`, `"abc"`, ""},
+		{`W/"abc"`, `W/"abc"`, ""},
+		{`""`, `""`, ""},
+		{`  "abc" , "def"`, `"abc"`, ` , "def"`},
+		{`"abc","def"`, `"abc"`, `,"def"`},
+		{"\"caf\xc3\xa9\"", "\"caf\xc3\xa9\"", ""},
+		// malformed
+		{``, "", ""},
+		{`"`, "", ""},
+		{`"abc`, "", ""},
+		{`abc`, "", ""},
+		{`W/`, "", ""},
+		{`W/abc`, "", ""},
+		{`w/"abc"`, "", ""},
+		{`"a b"`, "", ""},
+		{"\"a\x7fb\"", "", ""},
+	} {
+		if etag, remain := scanETag(test.s); etag != test.etag || remain != test.remain {
+			t.Errorf("scanETag(%q) = %q, %q, want %q, %q", test.s, etag, remain, test.etag, test.remain)
+		}
+	}
+}
+
+// conditionalRequest returns a request with the header key set to value, if any.
+func conditionalRequest(method, key, value string) *http.Request {
+	r := httptest.NewRequest(method, "/m/abc@example.com.csv", nil)
+	if value != "" {
+		r.Header.Set(key, value)
+	}
+	return r
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	for _, test := range []struct {
+		header, etag string
+		want         condResult
+	}{
+		{"", `"abc"`, condNone},
+		{`"abc"`, `"abc"`, condTrue},
+		{`*`, `"abc"`, condTrue},
+		{`"x", "abc"`, `"abc"`, condTrue},
+		{` "x" ,  , "abc" `, `"abc"`, condTrue},
+		{`"x", "y"`, `"abc"`, condFalse},
+		// the strong comparison: a weak tag never matches
+		{`W/"abc"`, `"abc"`, condFalse},
+		{`W/"abc"`, `W/"abc"`, condFalse},
+		{`"abc"`, `W/"abc"`, condFalse},
+		// the list ends at a malformed tag
+		{`bogus, "abc"`, `"abc"`, condFalse},
+		{`"x", "ab c", "abc"`, `"abc"`, condFalse},
+		// without an ETag only * is told
+		{`*`, "", condTrue},
+		{`"abc"`, "", condNone},
+	} {
+		if got := checkIfMatch(conditionalRequest(http.MethodGet, "If-Match", test.header), test.etag); got != test.want {
+			t.Errorf("checkIfMatch(%q, %q) = %d, want %d", test.header, test.etag, got, test.want)
+		}
+	}
+}
+
+func TestCheckIfNoneMatch(t *testing.T) {
+	for _, test := range []struct {
+		header, etag string
+		want         condResult
+	}{
+		{"", `"abc"`, condNone},
+		{`"abc"`, `"abc"`, condFalse},
+		{`*`, `"abc"`, condFalse},
+		{`"x", "y"`, `"abc"`, condTrue},
+		{` "x" ,  , "abc" `, `"abc"`, condFalse},
+		// the weak comparison: the W/ prefix is ignored
+		{`W/"abc"`, `"abc"`, condFalse},
+		{`"abc"`, `W/"abc"`, condFalse},
+		{`"x", W/"abc"`, `"abc"`, condFalse},
+		// the list ends at a malformed tag
+		{`bogus`, `"abc"`, condTrue},
+		{`"x" bogus "abc"`, `"abc"`, condTrue},
+		{`"abc`, `"abc"`, condTrue},
+		// without an ETag only * is told
+		{`*`, "", condFalse},
+		{`"abc"`, "", condNone},
+	} {
+		if got := checkIfNoneMatch(conditionalRequest(http.MethodGet, "If-None-Match", test.header), test.etag); got != test.want {
+			t.Errorf("checkIfNoneMatch(%q, %q) = %d, want %d", test.header, test.etag, got, test.want)
+		}
+	}
+}
+
+func TestCheckIfRange(t *testing.T) {
+	for _, test := range []struct {
+		method, header, etag string
+		want                 condResult
+	}{
+		{http.MethodGet, "", `"abc"`, condNone},
+		{http.MethodGet, `"abc"`, `"abc"`, condTrue},
+		{http.MethodHead, `"abc"`, `"abc"`, condTrue},
+		{http.MethodGet, `"x"`, `"abc"`, condFalse},
+		// an ETag is compared strongly
+		{http.MethodGet, `W/"abc"`, `"abc"`, condFalse},
+		{http.MethodGet, `"abc"`, "", condFalse},
+		// a date always validates the immutable articles
+		{http.MethodGet, "Mon, 02 Jan 2006 15:04:05 GMT", `"abc"`, condTrue},
+		{http.MethodGet, "Mon, 02 Jan 2006 15:04:05 GMT", "", condTrue},
+		{http.MethodPost, `"abc"`, `"abc"`, condNone},
+	} {
+		if got := checkIfRange(conditionalRequest(test.method, "If-Range", test.header), test.etag); got != test.want {
+			t.Errorf("checkIfRange(%s, %q, %q) = %d, want %d", test.method, test.header, test.etag, got, test.want)
+		}
+	}
+}
+
+func FuzzParseRange(f *testing.F) {
+	for _, seed := range []struct {
+		s    string
+		size int64
+	}{
+		{"", 100},
+		{"bytes=0-99", 100},
+		{"bytes=0-", 100},
+		{"bytes=-10", 100},
+		{"bytes=-0", 100},
+		{"bytes=-200", 100},
+		{"bytes=50-10", 100},
+		{"bytes=100-", 100},
+		{"bytes=0-0,-1", 100},
+		{"bytes= 1 - 2 , , 3-4", 100},
+		{"bytes=0-9223372036854775807", 100},
+		{"bytes=--1", 100},
+		{"bytes=0-1", 0},
+		{"items=0-1", 100},
+	} {
+		f.Add(seed.s, seed.size)
+	}
+	f.Fuzz(func(t *testing.T, s string, size int64) {
+		if size < 0 {
+			return
+		}
+		ranges, err := parseRange(s, size)
+		if err != nil {
+			if ranges != nil {
+				t.Fatalf("parseRange(%q, %d) returned ranges with the error %v", s, size, err)
+			}
+			return
+		}
+		var specs []string
+		for _, r := range ranges {
+			if r.start < 0 || r.length < 0 || r.start+r.length > size {
+				t.Fatalf("parseRange(%q, %d) returned %+v out of the content", s, size, r)
+			}
+			if r.length > 0 {
+				specs = append(specs, fmt.Sprintf("%d-%d", r.start, r.start+r.length-1))
+			}
+		}
+		if len(specs) == 0 {
+			return
+		}
+		// the ranges written back parse to themselves
+		again, err := parseRange("bytes="+strings.Join(specs, ","), size)
+		if err != nil {
+			t.Fatalf("parseRange of the ranges of %q: %v", s, err)
+		}
+		var nonEmpty []httpRange
+		for _, r := range ranges {
+			if r.length > 0 {
+				nonEmpty = append(nonEmpty, r)
+			}
+		}
+		if fmt.Sprint(again) != fmt.Sprint(nonEmpty) {
+			t.Fatalf("parseRange(%q, %d) = %v, written back %v", s, size, nonEmpty, again)
+		}
+	})
+}
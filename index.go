@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/nntp.v0"
+)
+
+var indexBucket = []byte("articles")
+
+var errIndexQuery = errors.New("expecting sort=hits|size|firstSeen|lastSeen, limit, minHits, since (RFC 3339) and backend")
+
+// indexFlushInterval is how often the hits recorded in memory are written to IndexDB.
+const indexFlushInterval = 10 * time.Second
+
+// storeBackend names the store as the backend of articles served from StoreDir.
+const storeBackend = "store"
+
+// articleRecord is the metadata kept in the index about a served article.
+type articleRecord struct {
+	MessageID nntp.MessageID `json:"messageId"`
+	Size      int64          `json:"size"`
+	FirstSeen time.Time      `json:"firstSeen"`
+	LastSeen  time.Time      `json:"lastSeen"`
+	Hits      uint64         `json:"hits"`
+	// Backend is the host of the NNTP server which served the article last, or "store".
+	Backend string `json:"backend"`
+}
+
+// merge adds the hits recorded since the record was last written.
+func (a *articleRecord) merge(newer *articleRecord) {
+	if a.FirstSeen.IsZero() {
+		a.FirstSeen = newer.FirstSeen
+	}
+	a.MessageID, a.Size, a.LastSeen, a.Backend = newer.MessageID, newer.Size, newer.LastSeen, newer.Backend
+	a.Hits += newer.Hits
+}
+
+// backendUsage sums up the articles served by a backend.
+type backendUsage struct {
+	Backend  string `json:"backend"`
+	Articles int    `json:"articles"`
+	Hits     uint64 `json:"hits"`
+	// Bytes is the article size times the hits, the body bytes served.
+	Bytes int64 `json:"bytes"`
+}
+
+// articleIndex records the served articles in a bolt database. Hits are collected in memory and written every
+// indexFlushInterval, so serving never waits on the database.
+type articleIndex struct {
+	db      *bolt.DB
+	mu      sync.Mutex
+	pending map[nntp.MessageID]*articleRecord
+}
+
+func openIndex(path string) (index *articleIndex, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		_, err = tx.CreateBucketIfNotExists(indexBucket)
+		return
+	}); err != nil {
+		db.Close()
+		return
+	}
+	index = &articleIndex{db: db, pending: make(map[nntp.MessageID]*articleRecord)}
+	go func() {
+		for range time.Tick(indexFlushInterval) {
+			if err := index.flush(); err != nil {
+				logPrintf("[ERROR] [Index] flush error: %s", err.Error())
+			}
+		}
+	}()
+	return
+}
+
+// Record counts a hit of the article, served by backend.
+func (x *articleIndex) Record(messageID nntp.MessageID, backend string, size int64) {
+	now := time.Now().UTC()
+	messageID = messageID.Short()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	record, ok := x.pending[messageID]
+	if !ok {
+		record = &articleRecord{MessageID: messageID, FirstSeen: now}
+		x.pending[messageID] = record
+	}
+	record.Size, record.LastSeen, record.Backend = size, now, backend
+	record.Hits++
+}
+
+// flush writes the pending hits to the database.
+func (x *articleIndex) flush() error {
+	x.mu.Lock()
+	pending := x.pending
+	x.pending = make(map[nntp.MessageID]*articleRecord)
+	x.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	return x.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(indexBucket)
+		for messageID, newer := range pending {
+			record := new(articleRecord)
+			if data := bucket.Get([]byte(messageID)); data != nil {
+				if err := json.Unmarshal(data, record); err != nil {
+					return err
+				}
+			}
+			record.merge(newer)
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(messageID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the record of an article including its pending hits, or nil if it was never served.
+func (x *articleIndex) Get(messageID nntp.MessageID) (record *articleRecord, err error) {
+	messageID = messageID.Short()
+	if err = x.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(indexBucket).Get([]byte(messageID))
+		if data == nil {
+			return nil
+		}
+		record = new(articleRecord)
+		return json.Unmarshal(data, record)
+	}); err != nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if newer, ok := x.pending[messageID]; ok {
+		if record == nil {
+			record = new(articleRecord)
+		}
+		record.merge(newer)
+	}
+	return
+}
+
+// All returns the records of all the articles ever served, including their pending hits.
+func (x *articleIndex) All() (records []*articleRecord, err error) {
+	byID := make(map[nntp.MessageID]*articleRecord)
+	if err = x.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(indexBucket).ForEach(func(_, data []byte) error {
+			record := new(articleRecord)
+			records = append(records, record)
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			byID[record.MessageID] = record
+			return nil
+		})
+	}); err != nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for messageID, newer := range x.pending {
+		record, ok := byID[messageID]
+		if !ok {
+			record = new(articleRecord)
+			records = append(records, record)
+		}
+		record.merge(newer)
+	}
+	return
+}
+
+// recordServed counts a hit of an article in the index, if IndexDB is set. The backend is the server of conn, or the
+// store if conn is nil. The server is also recorded by the locator, if LocatorURL is set.
+func (s *server) recordServed(messageID nntp.MessageID, conn *nntp.Conn, size int64) {
+	if s.index == nil && s.locator == nil {
+		return
+	}
+	backend := storeBackend
+	if conn != nil {
+		if server, ok := s.pool.Server(conn); ok {
+			backend = server.Host
+		}
+	}
+	if s.index != nil {
+		s.index.Record(messageID, backend, size)
+	}
+	if s.locator != nil && backend != storeBackend {
+		s.locator.Record(messageID, backend)
+	}
+}
+
+// indexLess orders the records by the sort query parameter of GET /index, the most popular first by default.
+var indexLess = map[string]func(a, b *articleRecord) bool{
+	"hits":      func(a, b *articleRecord) bool { return a.Hits > b.Hits },
+	"size":      func(a, b *articleRecord) bool { return a.Size > b.Size },
+	"firstSeen": func(a, b *articleRecord) bool { return a.FirstSeen.After(b.FirstSeen) },
+	"lastSeen":  func(a, b *articleRecord) bool { return a.LastSeen.After(b.LastSeen) },
+}
+
+// handleIndex serves the admin API of the index: GET /index lists the served articles, GET /index/<Message-ID> returns
+// one of them and GET /index/backends sums them up by backend.
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	var (
+		result any
+		err    error
+	)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if s.index == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "IndexDB is not configured")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch path := strings.TrimPrefix(r.URL.Path, "/index"); path {
+	case "":
+		if result, err = s.queryIndex(r.URL.Query()); errors.Is(err, errIndexQuery) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err.Error())
+			return
+		}
+	case "/backends":
+		result, err = s.indexUsage()
+	default:
+		messageID := nntp.MessageID(path[1:])
+		if messageID.Validate() != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var record *articleRecord
+		if record, err = s.index.Get(messageID); err == nil && record == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		result = record
+	}
+	if err != nil {
+		logf(r.Context(), "[ERROR] [Index] %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// queryIndex lists the records matching the query parameters of GET /index: sort (hits, size, firstSeen or lastSeen),
+// limit (100 by default), backend, minHits and since, an RFC 3339 time the articles were last served after.
+func (s *server) queryIndex(query url.Values) (result []*articleRecord, err error) {
+	var (
+		limit   = 100
+		minHits uint64
+		since   time.Time
+	)
+
+	less, ok := indexLess[query.Get("sort")]
+	if query.Get("sort") == "" {
+		less, ok = indexLess["hits"], true
+	}
+	badQuery := !ok
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		badQuery = badQuery || err != nil || limit < 0
+	}
+	if v := query.Get("minHits"); v != "" {
+		minHits, err = strconv.ParseUint(v, 10, 64)
+		badQuery = badQuery || err != nil
+	}
+	if v := query.Get("since"); v != "" {
+		since, err = time.Parse(time.RFC3339, v)
+		badQuery = badQuery || err != nil
+	}
+	if badQuery {
+		err = errIndexQuery
+		return
+	}
+
+	records, err := s.index.All()
+	if err != nil {
+		return
+	}
+	result = make([]*articleRecord, 0, len(records))
+	backend := query.Get("backend")
+	for _, record := range records {
+		if record.Hits >= minHits && !record.LastSeen.Before(since) && (backend == "" || record.Backend == backend) {
+			result = append(result, record)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return less(result[i], result[j]) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return
+}
+
+// indexUsage sums up the index by backend, the busiest first.
+func (s *server) indexUsage() (usage []*backendUsage, err error) {
+	records, err := s.index.All()
+	if err != nil {
+		return
+	}
+	byBackend := make(map[string]*backendUsage)
+	for _, record := range records {
+		u, ok := byBackend[record.Backend]
+		if !ok {
+			u = &backendUsage{Backend: record.Backend}
+			byBackend[record.Backend] = u
+			usage = append(usage, u)
+		}
+		u.Articles++
+		u.Hits += record.Hits
+		u.Bytes += record.Size * int64(record.Hits)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Bytes > usage[j].Bytes })
+	if usage == nil {
+		usage = []*backendUsage{}
+	}
+	return
+}
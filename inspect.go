@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"gopkg.in/nntp.v0"
+)
+
+// defaultInspectSample is the number of segments of each file checked by POST /nzb/inspect, unless sample says
+// otherwise.
+const defaultInspectSample = 10
+
+// inspectedFile is a file of an NZB document as listed by POST /nzb/inspect.
+type inspectedFile struct {
+	Index   int    `json:"index"`
+	Subject string `json:"subject"`
+	Name    string `json:"name"`
+	// Size is the size of the decoded file given by its first segment, 0 if it is unavailable
+	Size     int64 `json:"size"`
+	Date     int64 `json:"date"`
+	Segments int   `json:"segments"`
+	// Bytes is the size of the segments given by the NZB document
+	Bytes   int64 `json:"bytes"`
+	Sampled int   `json:"sampled"`
+	segmentCounts
+}
+
+// segmentCounts counts the segments checked by their outcome.
+type segmentCounts struct {
+	Available int `json:"available"`
+	Missing   int `json:"missing"`
+	// Failed are the segments the NNTP servers failed to tell about
+	Failed int `json:"failed"`
+	// Completeness is the percentage of the segments found, of the ones told about, null if none is
+	Completeness *float64 `json:"completeness"`
+}
+
+// count adds the outcome of checking a segment, found or missing unless err tells it could not be checked.
+func (c *segmentCounts) count(found bool, err error) {
+	switch {
+	case err != nil:
+		c.Failed++
+	case found:
+		c.Available++
+	default:
+		c.Missing++
+	}
+}
+
+// complete sets the Completeness once all the segments are counted.
+func (c *segmentCounts) complete() {
+	if told := c.Available + c.Missing; told > 0 {
+		completeness := math.Round(float64(c.Available)*1000/float64(told)) / 10
+		c.Completeness = &completeness
+	}
+}
+
+// sampleSegments returns the indexes of count segments of n spread evenly, the first and the last included, or all of
+// them if count is at least n.
+func sampleSegments(n, count int) (indexes []int) {
+	if count >= n {
+		count = n
+	}
+	for k := 0; k < count; k++ {
+		if count == 1 {
+			indexes = append(indexes, 0)
+		} else {
+			indexes = append(indexes, k*(n-1)/(count-1))
+		}
+	}
+	return
+}
+
+// statSegment reports whether a segment is available, a taken down one being missing.
+func (s *server) statSegment(ctx context.Context, messageID nntp.MessageID) (found bool, err error) {
+	if s.takenDown(ctx, messageID) {
+		return
+	}
+	return s.statArticle(ctx, messageID)
+}
+
+// handleNZBInspect serves POST /nzb/inspect, where the body is an NZB document, listing its files as JSON: the name
+// and size of each file are read from the yEnc header of its first segment, like for POST /nzb, and a sample of its
+// segments, the first and last ones included, is checked with STAT to tell how complete it is.
+func (s *server) handleNZBInspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sample := defaultInspectSample
+	if value := r.URL.Query().Get("sample"); value != "" {
+		var err error
+		if sample, err = strconv.Atoi(value); err != nil || sample < 1 {
+			logf(r.Context(), "[ERROR] NZB inspect invalid sample %q", value)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	doc, err := parseNZB(r.Body)
+	if err != nil {
+		logf(r.Context(), "[ERROR] NZB inspect invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	files := make([]inspectedFile, len(doc.Files))
+	firsts := make([]nntp.MessageID, len(doc.Files))
+	for i := range doc.Files {
+		file := &doc.Files[i]
+		files[i] = inspectedFile{Index: i, Subject: file.Subject, Date: file.Date, Segments: len(file.Segments)}
+		for _, segment := range file.Segments {
+			files[i].Bytes += segment.Bytes
+		}
+		firsts[i] = file.Segments[0].MessageID
+	}
+	// the first segments are fetched whole, for their yEnc header, and count as sampled
+	err = s.fetchInOrder(r.Context(), firsts, func(i int, result *batchResult) error {
+		file, inspected := &doc.Files[i], &files[i]
+		part, err := file.decodeSegment(0, result)
+		if err != nil {
+			inspected.Name, _ = file.nameAndSize(&yEncPart{}, i)
+		} else {
+			inspected.Name, inspected.Size = file.nameAndSize(part, i)
+		}
+		inspected.Sampled++
+		switch result.status {
+		case http.StatusOK:
+			inspected.count(true, nil)
+		case http.StatusNotFound, http.StatusUnavailableForLegalReasons:
+			inspected.count(false, nil)
+		default:
+			inspected.count(false, err)
+		}
+		return nil
+	})
+	if err != nil {
+		logf(r.Context(), "[ERROR] NZB inspect error: %s", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	slots := make(chan struct{}, s.BatchConcurrency)
+	for i := range doc.Files {
+		file := &doc.Files[i]
+		ctx := withArticleDate(r.Context(), file.Date)
+		for _, j := range sampleSegments(len(file.Segments), sample)[1:] {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(inspected *inspectedFile, messageID nntp.MessageID) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				found, err := s.statSegment(ctx, messageID)
+				mu.Lock()
+				inspected.Sampled++
+				inspected.count(found, err)
+				mu.Unlock()
+			}(&files[i], file.Segments[j].MessageID)
+		}
+	}
+	wg.Wait()
+	for i := range files {
+		files[i].complete()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"files": files})
+	logf(r.Context(), "[INFO] NZB inspect %d files", len(files))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/rx.v0"
+)
+
+// partSubject matches the last "(1/15)" part counter of a subject, like "name.rar" yEnc (1/15).
+var partSubject = regexp.MustCompile(`^(.*)[(\[](\d+)/(\d+)[)\]](.*)$`)
+
+var errJoinParts = errors.New("parts unavailable")
+
+// parsePartSubject splits a multipart subject around its part counter, returning the subject without the part number
+// to compare with the other parts. A counter too big for an int is no counter.
+func parsePartSubject(subject string) (key string, part int, total int, ok bool) {
+	m := partSubject.FindStringSubmatch(subject)
+	if m == nil {
+		return
+	}
+	var partErr, totalErr error
+	part, partErr = strconv.Atoi(m[2])
+	total, totalErr = strconv.Atoi(m[3])
+	if partErr != nil || totalErr != nil || part < 1 || total < 1 || part > total {
+		return
+	}
+	key = m[1] + "(/" + m[3] + ")" + m[4]
+	ok = true
+	return
+}
+
+// xrefNumber returns a newsgroup and the article number there from an Xref header, like "news.example.com
+// alt.binaries.misc:1234".
+func xrefNumber(xref string) (group string, number int, ok bool) {
+	fields := strings.Fields(xref)
+	if len(fields) < 2 {
+		return
+	}
+	// the first field is the name of the server
+	for _, field := range fields[1:] {
+		var n string
+		if group, n, ok = strings.Cut(field, ":"); ok {
+			if number, _ = strconv.Atoi(n); number > 0 {
+				return
+			}
+		}
+	}
+	ok = false
+	return
+}
+
+// locateParts finds the message-ids of all parts of the multipart binary the article is a part of, in order. The
+// siblings are found in the overview of the article's newsgroup, within JoinScanRange article numbers around it, on
+// the server having the article, since article numbers are local to each server.
+func (s *server) locateParts(ctx context.Context, messageID nntp.MessageID) (file *nzbFile, err error) {
+	var (
+		nntpErr *nntp.Error
+		conn    *nntp.Conn
+		article *nntp.Article
+	)
+
+	defer func() {
+		if conn != nil {
+			if err == nil || errors.As(err, &nntpErr) || errors.Is(err, errJoinParts) {
+				s.pool.Put(conn)
+			} else {
+				s.pool.Close(conn)
+			}
+		}
+	}()
+
+	if conn, article, err = s.fetch(ctx, messageID, "HEAD", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdHead(nntp.ArticleMessageID(messageID))
+	}); err != nil {
+		return
+	}
+	subject := article.Header.Get("Subject")
+	file = &nzbFile{Subject: subject, Poster: article.Header.Get("From")}
+	key, _, total, ok := parsePartSubject(subject)
+	if !ok || total == 1 {
+		// a single part
+		file.Segments = []nzbSegment{{Number: 1, MessageID: messageID}}
+		return
+	}
+	if window := 2*s.JoinScanRange + 1; total > window {
+		// the subject is anyone's to write, and the parts could not all be in the scanned overview anyway
+		err = fmt.Errorf("%w: %d parts, more than the %d articles scanned", errJoinParts, total, window)
+		return
+	}
+	group, number, ok := xrefNumber(article.Header.Get("Xref"))
+	if !ok {
+		err = fmt.Errorf("%w: no Xref header to locate the parts", errJoinParts)
+		return
+	}
+
+	_, span := startSpan(ctx, "nntp GROUP", s.commandAttributes(conn, messageID)...)
+	_, err = conn.CmdGroup(group)
+	endSpan(span, err)
+	if err != nil {
+		return
+	}
+	_, span = startSpan(ctx, "nntp OVER", s.commandAttributes(conn, messageID)...)
+	first := number - s.JoinScanRange
+	if first < 1 {
+		first = 1
+	}
+	parts := make([]nntp.MessageID, total)
+	writer, reader := rx.Pipe[*nntp.ArticleOverview](nil)
+	conn.CmdOver(nntp.WithArticleRange(first, number+s.JoinScanRange)).Subscribe(writer)
+	for {
+		ov, ok := reader.Read()
+		if !ok {
+			break
+		}
+		// reposts of a missing part often come from another poster, so only the subject is compared
+		if ovKey, part, _, ok := parsePartSubject(ov.Subject); ok && ovKey == key && parts[part-1] == "" {
+			parts[part-1] = ov.MessageID
+		}
+	}
+	err = reader.Wait()
+	endSpan(span, err)
+	if err != nil {
+		return
+	}
+	missing := 0
+	for i, id := range parts {
+		if id == "" {
+			missing++
+		} else {
+			file.Segments = append(file.Segments, nzbSegment{Number: i + 1, MessageID: id})
+		}
+	}
+	if missing > 0 {
+		err = fmt.Errorf("%w: %d of %d parts not found in %s", errJoinParts, missing, total, group)
+	}
+	return
+}
+
+// handleJoin serves GET /join/, the multipart binary any part of which is the article, decoded and joined like a
+// single file NZB, or a range of it.
+func (s *server) handleJoin(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	etag := s.formatETag(r, s.fileETag(messageETag(messageID)))
+	done, spec := s.checkArticlePreconditions(w, r, messageID, etag)
+	if done {
+		return
+	}
+	file, err := s.locateParts(r.Context(), messageID)
+	if errors.Is(err, ErrArticleNotFound) || errors.Is(err, errJoinParts) {
+		logf(r.Context(), "[ERROR] JOIN %s %s", messageID, err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] JOIN %s %s", messageID, err.Error())
+		if !unavailable(w, err) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+
+	setETag(w, etag)
+	started, err := s.serveFile(r.Context(), w, file, 0, spec)
+	if err != nil {
+		if !started || !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] JOIN %s error: %s", messageID, err.Error())
+		}
+		if started {
+			// abort the response so that the client can tell the file is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, errNZBSegment) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if !unavailable(w, err) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	logf(r.Context(), "[INFO] JOIN %s %d parts", messageID, len(file.Segments))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParsePartSubject(t *testing.T) {
+	for _, test := range []struct {
+		subject     string
+		key         string
+		part, total int
+		ok          bool
+	}{
+		{`"name.rar" yEnc (1/15)`, `"name.rar" yEnc (/15)`, 1, 15, true},
+		{`"name.rar" yEnc [15/15] 1 MB`, `"name.rar" yEnc (/15) 1 MB`, 15, 15, true},
+		{`x (1/2000000000)`, `x (/2000000000)`, 1, 2000000000, true},
+		{`no counter`, "", 0, 0, false},
+		{`x (0/15)`, "", 0, 0, false},
+		{`x (16/15)`, "", 0, 0, false},
+		// too big for an int
+		{`x (1/99999999999999999999)`, "", 0, 0, false},
+		{`x (99999999999999999999/99999999999999999999)`, "", 0, 0, false},
+	} {
+		key, part, total, ok := parsePartSubject(test.subject)
+		if ok != test.ok || ok && (key != test.key || part != test.part || total != test.total) {
+			t.Errorf("parsePartSubject(%q) = %q, %d, %d, %t", test.subject, key, part, total, ok)
+		}
+	}
+}
+
+func TestLocatePartsHugeCounter(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", "Subject: x (1/2000000000)\nXref: news.example.com alt.test:1000", "body\r\n")
+	s := newTestServer(t, f)
+	if _, err := s.locateParts(context.Background(), "a@example.com"); !errors.Is(err, errJoinParts) {
+		t.Errorf("locateParts of a part of 2000000000: %v", err)
+	}
+	// rejected before the overview is scanned
+	if n := f.received("GROUP"); n != 0 {
+		t.Errorf("GROUP sent %d times", n)
+	}
+	if w := serve(s, http.MethodGet, "/join/a@example.com.csv", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET /join/ of a part of 2000000000 answered %d", w.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"gopkg.in/nntp.v0"
+)
+
+// Answers to the articles over the size limit, set by OversizeArticles.
+const (
+	OversizeInsufficientStorage = ""
+	OversizeTooLarge            = "413"
+	OversizeBadGateway          = "502"
+	OversizeTruncate            = "truncate"
+)
+
+// errLargeArticle is returned by spoolLargeArticle for an article larger than ArticleSizeLimit.
+var errLargeArticle = errors.New("article larger than ArticleSizeLimit")
+
+// spoolLargeArticle writes an article larger than ArticleMemoryLimit to a temporary file in LargeArticleDir, the head
+// already read into a buffer followed by the rest of the body, so that it is served from the file. It fails with
+// errLargeArticle if the body is larger than ArticleSizeLimit, along with the file holding the first ArticleSizeLimit
+// bytes of it. The file must be removed once served.
+func (s *server) spoolLargeArticle(body io.Reader, head []byte) (spooled *storedFile, err error) {
+	file, err := os.CreateTemp(s.largeArticleDir(), "usebin-*.article")
+	if err != nil {
+		return
+	}
+	spooled = &storedFile{File: file}
+	defer func() {
+		if err != nil && err != errLargeArticle {
+			spooled.remove()
+			spooled = nil
+		}
+	}()
+	if _, err = file.Write(head); err != nil {
+		return
+	}
+	n, err := io.Copy(file, io.LimitReader(body, int64(s.ArticleSizeLimit)-int64(len(head))+1))
+	if err != nil {
+		return
+	}
+	if spooled.size = int64(len(head)) + n; spooled.size > int64(s.ArticleSizeLimit) {
+		spooled.size = int64(s.ArticleSizeLimit)
+		err = errLargeArticle
+	}
+	return
+}
+
+// largeArticleDir returns LargeArticleDir, or the directory for temporary files if not set.
+func (s *server) largeArticleDir() string {
+	if s.LargeArticleDir != "" {
+		return s.LargeArticleDir
+	}
+	return os.TempDir()
+}
+
+// openLargeArticleDir creates LargeArticleDir if articles can be spooled to it.
+func (s *server) openLargeArticleDir() error {
+	if s.ArticleMemoryLimit == s.ArticleSizeLimit || s.LargeArticleDir == "" {
+		return nil
+	}
+	return os.MkdirAll(s.LargeArticleDir, 0700)
+}
+
+// remove closes and removes the temporary file of an article spooled by spoolLargeArticle.
+func (f *storedFile) remove() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// oversizeBody is the JSON body of the answer to an article over the size limit, with OversizeArticles "413" or
+// "502": the limit, and the number of bytes read when it was hit.
+type oversizeBody struct {
+	errorBody
+	Limit int64 `json:"limit"`
+	Read  int64 `json:"read"`
+}
+
+// validateOversizeArticles checks the value of OversizeArticles.
+func validateOversizeArticles(oversize string) error {
+	switch oversize {
+	case OversizeInsufficientStorage, OversizeTooLarge, OversizeBadGateway, OversizeTruncate:
+		return nil
+	}
+	return fmt.Errorf("invalid OversizeArticles %q, expecting \"413\", \"502\" or \"truncate\"", oversize)
+}
+
+// oversizeStatus returns the status of the answers to the articles over the size limit. The routes needing the whole
+// body, like POST /batch, answer them with 507 Insufficient Storage when GET /m/ truncates them.
+func (s *server) oversizeStatus() int {
+	switch s.OversizeArticles {
+	case OversizeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case OversizeBadGateway:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInsufficientStorage
+	}
+}
+
+// oversize answers a request for an article larger than limit, of which read bytes were read, as OversizeArticles
+// says, the number of bytes read being sent in X-Usebin-Bytes-Read. It reports whether the article is to be served
+// truncated to limit instead, with X-Usebin-Truncated set and kept out of the caches.
+func (s *server) oversize(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID, limit, read int64) (truncate bool) {
+	w.Header().Set("X-Usebin-Bytes-Read", strconv.FormatInt(read, 10))
+	switch s.OversizeArticles {
+	case OversizeTruncate:
+		logf(r.Context(), "[WARN] %s %s size exceeds limit, truncated to %d bytes", r.Method, messageID, limit)
+		w.Header().Set("X-Usebin-Truncated", "true")
+		w.Header().Set("Cache-Control", "no-store")
+		return true
+	case OversizeInsufficientStorage:
+		logf(r.Context(), "[ERROR] %s %s size exceeds limit", r.Method, messageID)
+		w.WriteHeader(http.StatusInsufficientStorage)
+		return false
+	}
+	logf(r.Context(), "[ERROR] %s %s size exceeds limit of %d bytes", r.Method, messageID, limit)
+	status := s.oversizeStatus()
+	body := oversizeBody{
+		errorBody: errorBody{
+			Status:    status,
+			Code:      "too_large",
+			Message:   fmt.Sprintf("the article is larger than the limit of %d bytes", limit),
+			RequestID: requestID(r.Context()),
+		},
+		Limit: limit,
+		Read:  read,
+	}
+	ownErrorBody(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// listenAddrs returns the addresses of the main listeners: Listen, or else Host and Port. The default empty Host
+// listens on all the interfaces, IPv4 and IPv6.
+func (s *server) listenAddrs() []string {
+	if len(s.Listen) > 0 {
+		return s.Listen
+	}
+	return []string{net.JoinHostPort(s.Host, strconv.Itoa(int(s.Port)))}
+}
+
+// plainListenAddrs returns the addresses of the plain HTTP listeners alongside the HTTPS ones: HTTPListen, or else
+// Host and HTTPPort, none if neither is set.
+func (s *server) plainListenAddrs() []string {
+	if len(s.HTTPListen) > 0 {
+		return s.HTTPListen
+	}
+	if s.HTTPPort == 0 {
+		return nil
+	}
+	return []string{net.JoinHostPort(s.Host, strconv.Itoa(int(s.HTTPPort)))}
+}
+
+// validateListen checks the Listen and HTTPListen addresses are in host:port notation, IPv6 hosts in brackets.
+func (s *server) validateListen() (err error) {
+	for _, addrs := range []struct {
+		key   string
+		addrs []string
+	}{{"Listen", s.Listen}, {"HTTPListen", s.HTTPListen}} {
+		for _, addr := range addrs.addrs {
+			_, port, splitErr := net.SplitHostPort(addr)
+			if splitErr == nil {
+				_, splitErr = strconv.ParseUint(port, 10, 16)
+			}
+			if splitErr != nil {
+				return fmt.Errorf("%s has an invalid address %q, expecting host:port like \":8080\" or \"[::1]:8080\"", addrs.key, addr)
+			}
+		}
+	}
+	return
+}
+
+// listenAll creates the listeners of addrs, closing the ones already created if one fails.
+func (s *server) listenAll(addrs []string) (lns []net.Listener, err error) {
+	for _, addr := range addrs {
+		var ln net.Listener
+		if ln, err = s.listen(addr); err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return
+}
+
+// listenPort returns the port a listener is bound to, the one picked by the system for port 0.
+func listenPort(ln net.Listener) int {
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+const (
+	// locatorKeyPrefix namespaces the keys of the locator in a Redis database shared with other applications.
+	locatorKeyPrefix = "usebin:locator:"
+	// locatorConns is the number of idle connections kept to the Redis server.
+	locatorConns = 8
+	// locatorQueue is the number of records waiting to be written, beyond which records are dropped.
+	locatorQueue = 1024
+)
+
+// preferredServerKey is the context key of the host of the NNTP server known to have the article of a fetch.
+type preferredServerKey struct{}
+
+// preferredServer returns the host of the server the locator found for the article fetched with ctx, "" if none.
+func preferredServer(ctx context.Context) string {
+	host, _ := ctx.Value(preferredServerKey{}).(string)
+	return host
+}
+
+// locatorRecord is the NNTP server which last served an article, to be written to the locator.
+type locatorRecord struct {
+	messageID nntp.MessageID
+	host      string
+}
+
+// redisLocator shares between the instances behind a load balancer which NNTP server last served each article, in a
+// Redis database, so an instance asked for an article another one served goes straight to the server which has it
+// instead of the one the message-id hashes to. Records are written in the background, so serving never waits on
+// Redis, and lookups give up after the timeout.
+type redisLocator struct {
+	addr     string
+	tls      bool
+	user     string
+	pass     string
+	db       int
+	ttl      time.Duration
+	timeout  time.Duration
+	idles    chan *redisConn
+	pending  chan locatorRecord
+	hostname string
+}
+
+// newRedisLocator returns the locator of the redis:// or rediss:// URL, like redis://:password@host:6379/0, records
+// expiring after ttl.
+func newRedisLocator(rawURL string, ttl, timeout time.Duration) (l *redisLocator, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		err = fmt.Errorf("LocatorURL %q is not a redis:// or rediss:// URL", rawURL)
+		return
+	}
+	l = &redisLocator{
+		addr:     u.Host,
+		tls:      u.Scheme == "rediss",
+		user:     u.User.Username(),
+		ttl:      ttl,
+		timeout:  timeout,
+		idles:    make(chan *redisConn, locatorConns),
+		pending:  make(chan locatorRecord, locatorQueue),
+		hostname: u.Hostname(),
+	}
+	l.pass, _ = u.User.Password()
+	if u.Port() == "" {
+		l.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil {
+			l, err = nil, fmt.Errorf("LocatorURL %q has an invalid database number", rawURL)
+		}
+	}
+	return
+}
+
+// run writes the records queued by Record, until the queue is closed.
+func (l *redisLocator) run() {
+	for record := range l.pending {
+		ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+		_, err := l.do(ctx, "SET", locatorKeyPrefix+string(record.messageID.Short()), record.host, "EX", strconv.FormatInt(int64(l.ttl/time.Second), 10))
+		cancel()
+		if err != nil {
+			logPrintf("[ERROR] [Locator] %s record error: %s", record.messageID, err.Error())
+		}
+	}
+}
+
+// Record queues the host of the server which served the article to be written, or drops it if too many are queued.
+func (l *redisLocator) Record(messageID nntp.MessageID, host string) {
+	select {
+	case l.pending <- locatorRecord{messageID, host}:
+	default:
+		logPrintf("[DEBUG] [Locator] %s record dropped", messageID)
+	}
+}
+
+// Locate returns the host of the server which last served the article, "" if unknown.
+func (l *redisLocator) Locate(ctx context.Context, messageID nntp.MessageID) (host string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	return l.do(ctx, "GET", locatorKeyPrefix+string(messageID.Short()))
+}
+
+// Ping checks the Redis server can be reached and authenticated with.
+func (l *redisLocator) Ping(ctx context.Context) (err error) {
+	_, err = l.do(ctx, "PING")
+	return
+}
+
+// do sends a command on an idle connection, or a new one, and returns its reply, "" for a nil one.
+func (l *redisLocator) do(ctx context.Context, args ...string) (reply string, err error) {
+	var conn *redisConn
+	select {
+	case conn = <-l.idles:
+	default:
+		if conn, err = l.dial(ctx); err != nil {
+			return
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Time{})
+	}
+	var redisErr redisError
+	if reply, err = conn.do(args...); err != nil && !errors.As(err, &redisErr) {
+		// the connection is out of sync, or broken
+		conn.Close()
+		return
+	}
+	select {
+	case l.idles <- conn:
+	default:
+		conn.Close()
+	}
+	return
+}
+
+func (l *redisLocator) dial(ctx context.Context) (conn *redisConn, err error) {
+	var netConn net.Conn
+	if l.tls {
+		netConn, err = (&tls.Dialer{Config: &tls.Config{ServerName: l.hostname}}).DialContext(ctx, "tcp", l.addr)
+	} else {
+		netConn, err = (&net.Dialer{}).DialContext(ctx, "tcp", l.addr)
+	}
+	if err != nil {
+		return
+	}
+	conn = &redisConn{Conn: netConn, r: bufio.NewReader(netConn)}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if l.pass != "" {
+		if l.user != "" {
+			_, err = conn.do("AUTH", l.user, l.pass)
+		} else {
+			_, err = conn.do("AUTH", l.pass)
+		}
+	}
+	if err == nil && l.db != 0 {
+		_, err = conn.do("SELECT", strconv.Itoa(l.db))
+	}
+	if err != nil {
+		conn, _ = nil, conn.Close()
+	}
+	return
+}
+
+// redisError is an error reply of the Redis server, after which the connection is still usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn speaks the RESP protocol of Redis, only as much as the locator needs: commands of bulk strings, and
+// replies of simple, bulk or nil strings, integers and errors.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *redisConn) do(args ...string) (reply string, err error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err = io.WriteString(c.Conn, b.String()); err != nil {
+		return
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if line = strings.TrimSuffix(line, "\r\n"); line == "" {
+		err = fmt.Errorf("redis: empty reply")
+		return
+	}
+	switch line[0] {
+	case '+', ':':
+		reply = line[1:]
+	case '-':
+		err = redisError(line[1:])
+	case '$':
+		var n int
+		if n, err = strconv.Atoi(line[1:]); err != nil || n < 0 {
+			// a nil bulk string, the key doesn't exist
+			return
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, buf); err == nil {
+			reply = string(buf[:n])
+		}
+	default:
+		err = fmt.Errorf("redis: unexpected reply %q", line)
+	}
+	return
+}
+
+// locate returns ctx carrying the server the locator found for the article, if any, for the pool to try it first.
+func (s *server) locate(ctx context.Context, messageID nntp.MessageID) context.Context {
+	if s.locator == nil {
+		return ctx
+	}
+	host, err := s.locator.Locate(ctx, messageID)
+	if err != nil {
+		logf(ctx, "[ERROR] [Locator] %s lookup error: %s", messageID, err.Error())
+		return ctx
+	}
+	if host == "" {
+		return ctx
+	}
+	logf(ctx, "[DEBUG] [Locator] %s last served by %s", messageID, host)
+	return context.WithValue(ctx, preferredServerKey{}, host)
+}
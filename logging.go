@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log levels, a log line's level is given by its leading tag, e.g. "[DEBUG] ...". Lines without a level tag are INFO.
+const (
+	LogDebug int32 = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = [...]string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// logLevel is the minimum level of the lines being logged, changeable at runtime.
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(LogInfo)
+}
+
+func parseLogLevel(name string) (level int32, err error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			level = int32(i)
+			return
+		}
+	}
+	err = fmt.Errorf("unknown log level %q", name)
+	return
+}
+
+// lineLevel returns the level of a log line from its leading tag.
+func lineLevel(format string) int32 {
+	if strings.HasPrefix(format, "[") {
+		if i := strings.IndexByte(format, ']'); i > 0 {
+			if level, err := parseLogLevel(format[1:i]); err == nil {
+				return level
+			}
+		}
+	}
+	return LogInfo
+}
+
+// logf logs like log.Printf if the line's level is enabled, tagging the line with the id of the request ctx belongs
+// to, right after its level.
+func logf(ctx context.Context, format string, args ...any) {
+	level := lineLevel(format)
+	if level >= LogWarn {
+		recordError(ctx, format, args...)
+	}
+	if level < logLevel.Load() {
+		return
+	}
+	if w := jsonLog.Load(); w != nil {
+		w.record(level, requestID(ctx), fmt.Sprintf(format, args...))
+		return
+	}
+	if id := requestID(ctx); id != "" {
+		if i := strings.Index(format, "] "); strings.HasPrefix(format, "[") && i > 0 {
+			format = format[:i+2] + "[" + id + "] " + format[i+2:]
+		} else {
+			format = "[" + id + "] " + format
+		}
+	}
+	log.Printf(format, args...)
+}
+
+// jsonLog writes the log as JSON lines with LogFormat "json", nil otherwise.
+var jsonLog atomic.Pointer[jsonLogWriter]
+
+// jsonLogRecord is a line of the log with LogFormat "json".
+type jsonLogRecord struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	RequestID string `json:"requestId,omitempty"`
+	Message   string `json:"msg"`
+}
+
+// jsonLogWriter writes the lines of the log as JSON objects, one per line, for the log collectors of containers. The
+// lines written by the log package are given their level by their leading tag.
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// setLogFormat writes the log as text lines to stderr, the default, or as JSON lines to stdout with "json".
+func setLogFormat(format string) (err error) {
+	switch format {
+	case "", "text":
+	case "json":
+		writer := &jsonLogWriter{w: redactingWriter{W: os.Stdout}}
+		jsonLog.Store(writer)
+		log.SetFlags(0)
+		log.SetOutput(writer)
+	default:
+		err = fmt.Errorf("invalid LogFormat %q, expecting \"text\" or \"json\"", format)
+	}
+	return
+}
+
+func (w *jsonLogWriter) Write(p []byte) (n int, err error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	w.record(lineLevel(line), "", line)
+	return len(p), nil
+}
+
+// record writes a line of the level, without its leading tag.
+func (w *jsonLogWriter) record(level int32, id, line string) {
+	if i := strings.Index(line, "] "); strings.HasPrefix(line, "[") && i > 0 {
+		if _, err := parseLogLevel(line[1:i]); err == nil {
+			line = line[i+2:]
+		}
+	}
+	data, _ := json.Marshal(jsonLogRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     logLevelNames[level],
+		RequestID: id,
+		Message:   line,
+	})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w.Write(append(data, '\n'))
+}
+
+// logPrintf logs a line not belonging to any request, if the line's level is enabled.
+func logPrintf(format string, args ...any) {
+	logf(context.Background(), format, args...)
+}
+
+// handleLogLevel reports the log level on GET, and changes it to the level in the request body on PUT.
+func (s *server) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		level, err := parseLogLevel(strings.TrimSpace(string(body)))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err.Error())
+			return
+		}
+		logLevel.Store(level)
+		logf(r.Context(), "[WARN] ADMIN log level set to %s", logLevelNames[level])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, logLevelNames[logLevel.Load()])
+}
@@ -1,48 +1,85 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"path/filepath"
 	"runtime/pprof"
-	"syscall"
+	"time"
 
-	"github.com/flynn/json5"
+	"gopkg.in/nntp.v0"
 )
 
-var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+// poolStopTimeout is how long a command done waits for the conns in use to be given back when stopping the pool.
+const poolStopTimeout = 10 * time.Second
 
-func main() {
-	var (
-		err      error
-		confPath string
-		confData []byte
-		server   server
-	)
+var (
+	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+	configPath = flag.String("config", "", "read the config from this file instead of usebin/config.json in the user config dir")
+)
 
-	if confPath, err = os.UserHomeDir(); err != nil {
-		log.Fatal("cannot find user home dir")
-	}
-	confPath = filepath.Join(confPath, ".config", "usebin", "config.json")
+func usage() {
+	fmt.Fprint(flag.CommandLine.Output(), `Usage: usebin [flags] [command] [args]
 
-	if confData, err = os.ReadFile(confPath); err != nil {
-		log.Fatal("cannot read config file", err)
-	}
+Commands:
+  serve         serve the HTTP API, the default
+  get [-header] [-raw] <message-id>
+                write the body of an article to stdout
+  post [-id <message-id>] [-from <from>] [-newsgroups <newsgroups>] [-subject <subject>] <file>
+                post a file as the body of an article and write its message-id to stdout
+  check [-dial] validate the config
+  selftest [-article <message-id>] [-group <newsgroup>]
+                connect to every NNTP server, fetch an article and post one, and print the outcome of each
+  healthcheck [-timeout <duration>]
+                check the server running with the same config answers, for container health checks
+  service install|uninstall
+                install the serve command as a Windows service, with the -config given
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
 
-	if err = json5.Unmarshal(confData, &server); err != nil {
-		log.Fatal("cannot parse config file", err)
+func main() {
+	log.SetOutput(redactingWriter{W: os.Stderr})
+	overrides := configFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
+	server, err := loadConfig(*configPath, *overrides)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if server.CertFile != "" {
-		server.CertFile = filepath.Join(confPath, server.CertFile)
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
 	}
-	if server.KeyFile != "" {
-		server.KeyFile = filepath.Join(confPath, server.KeyFile)
+	switch args[0] {
+	case "serve":
+		serveCommand(server)
+	case "get":
+		getCommand(server, args[1:])
+	case "post":
+		postCommand(server, args[1:])
+	case "check":
+		checkCommand(server, args[1:])
+	case "selftest":
+		selftestCommand(server, args[1:])
+	case "service":
+		serviceCommand(args[1:])
+	case "healthcheck":
+		healthcheckCommand(server, args[1:])
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n", args[0])
+		flag.Usage()
+		os.Exit(2)
 	}
+}
 
-	flag.Parse()
+func serveCommand(server *server) {
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -50,18 +87,90 @@ func main() {
 		}
 		pprof.StartCPUProfile(f)
 		log.Printf("CPU profiling started: %s", *cpuprofile)
-		defer pprof.StopCPUProfile()
-		c := make(chan os.Signal)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			<-c
+		// Serve returns on SIGINT and SIGTERM
+		defer func() {
 			pprof.StopCPUProfile()
 			log.Printf("CPU profiling saved at %s", *cpuprofile)
-			os.Exit(1)
 		}()
 	}
 
+	if ran, err := runService(server.Serve); ran || err != nil {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if err := server.Serve(); err != nil {
 		log.Fatal(err)
 	}
 }
+
+func getCommand(server *server, args []string) {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	withHeader := flags.Bool("header", false, "write the article header before the body")
+	raw := flags.Bool("raw", false, "write the body dot-encoded, as sent over NNTP")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: usebin get [-header] [-raw] <message-id>")
+	}
+	messageID := nntp.MessageID(flags.Arg(0))
+	if err := messageID.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := server.applyDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	if err := server.openArticles(); err != nil {
+		log.Fatal(err)
+	}
+	err := server.getArticle(context.Background(), os.Stdout, messageID, *withHeader, *raw)
+	server.stopPool()
+	if err != nil {
+		log.Fatalf("%s: %s", messageID, err.Error())
+	}
+}
+
+func postCommand(server *server, args []string) {
+	flags := flag.NewFlagSet("post", flag.ExitOnError)
+	messageID := flags.String("id", "", "message-id of the article, a random one if not set")
+	from := flags.String("from", "", "From header, a random sender if not set")
+	newsgroups := flags.String("newsgroups", "", "Newsgroups header, DefaultNewsgroup if not set")
+	subject := flags.String("subject", "", "Subject header, the message-id if not set")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: usebin post [-id <message-id>] [-from <from>] [-newsgroups <newsgroups>] [-subject <subject>] <file>")
+	}
+
+	if err := server.applyDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	if err := server.openArticles(); err != nil {
+		log.Fatal(err)
+	}
+	posted, err := server.postFile(context.Background(), flags.Arg(0), nntp.MessageID(*messageID), *from, *newsgroups, *subject)
+	server.stopPool()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(posted.Short())
+}
+
+// stopPool stops the pool of a command once done, if any, waiting up to poolStopTimeout for the conns in use.
+func (s *server) stopPool() {
+	if s.pool == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), poolStopTimeout)
+	defer cancel()
+	s.pool.Stop(ctx)
+}
+
+func checkCommand(server *server, args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	dial := flags.Bool("dial", false, "connect and authenticate to every NNTP server")
+	flags.Parse(args)
+	if !server.check(redactingWriter{W: os.Stdout}, *dial) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// memoryEntry is an article kept in memory, with its dot-decoded body.
+type memoryEntry struct {
+	messageID nntp.MessageID
+	header    textproto.MIMEHeader
+	body      []byte
+}
+
+// memoryCache keeps the most recently served small articles in memory, evicting the least recently used ones to stay
+// within its budget of body bytes.
+type memoryCache struct {
+	budget    uint64
+	mu        sync.Mutex
+	size      uint64
+	lru       *list.List // of *memoryEntry, the most recently used first
+	entries   map[nntp.MessageID]*list.Element
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// memoryStats is the hit rate and the state of the memory cache, served at /cache/memory.
+type memoryStats struct {
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	HitRate   float64 `json:"hitRate"`
+	Evictions uint64  `json:"evictions"`
+	Articles  int     `json:"articles"`
+	Bytes     uint64  `json:"bytes"`
+	Budget    uint64  `json:"budget"`
+}
+
+// memoryBody is the body of an article served from the memory cache.
+type memoryBody struct {
+	*bytes.Reader
+}
+
+func newMemoryCache(budget uint64) *memoryCache {
+	return &memoryCache{budget: budget, lru: list.New(), entries: make(map[nntp.MessageID]*list.Element)}
+}
+
+// Get returns the article if it is in memory, counting the hit or miss.
+func (c *memoryCache) Get(messageID nntp.MessageID) (article *nntp.Article, ok bool) {
+	c.mu.Lock()
+	element, ok := c.entries[messageID.Short()]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return
+	}
+	c.hits.Add(1)
+	entry := element.Value.(*memoryEntry)
+	article = &nntp.Article{MessageID: messageID, Header: entry.header, Body: memoryBody{bytes.NewReader(entry.body)}}
+	return
+}
+
+// Put keeps a copy of the article body, evicting the least recently used articles to make room for it.
+func (c *memoryCache) Put(messageID nntp.MessageID, header textproto.MIMEHeader, body []byte) {
+	if uint64(len(body)) > c.budget {
+		return
+	}
+	entry := &memoryEntry{messageID: messageID.Short(), header: header, body: append([]byte(nil), body...)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.messageID]; ok {
+		c.size -= uint64(len(element.Value.(*memoryEntry).body))
+		c.lru.Remove(element)
+	}
+	for c.size+uint64(len(entry.body)) > c.budget {
+		oldest := c.lru.Remove(c.lru.Back()).(*memoryEntry)
+		delete(c.entries, oldest.messageID)
+		c.size -= uint64(len(oldest.body))
+		c.evictions.Add(1)
+	}
+	c.entries[entry.messageID] = c.lru.PushFront(entry)
+	c.size += uint64(len(entry.body))
+}
+
+func (c *memoryCache) Stats() (stats memoryStats) {
+	stats.Hits, stats.Misses, stats.Evictions = c.hits.Load(), c.misses.Load(), c.evictions.Load()
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	c.mu.Lock()
+	stats.Articles, stats.Bytes = len(c.entries), c.size
+	c.mu.Unlock()
+	stats.Budget = c.budget
+	return
+}
+
+// remember keeps an article just served in the memory cache, if MemoryCacheSize is set and the body is no larger than
+// MemoryArticleLimit and the fetch was not forced to a server. Articles served from the memory cache are left as they
+// are.
+func (s *server) remember(ctx context.Context, messageID nntp.MessageID, article *nntp.Article, body []byte) {
+	if s.memory == nil || uint64(len(body)) > s.MemoryArticleLimit || forcedServer(ctx) != "" {
+		return
+	}
+	if _, ok := article.Body.(memoryBody); ok {
+		return
+	}
+	s.memory.Put(messageID, article.Header, body)
+	logf(ctx, "[DEBUG] [Memory] %s cached, %d bytes", messageID, len(body))
+}
+
+// handleMemoryCache serves the statistics of the memory cache as JSON.
+func (s *server) handleMemoryCache(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if s.memory == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "MemoryCacheSize is not configured")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.memory.Stats())
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// newIDLimit is the max number of message-ids returned by a single GET /newid request.
+const newIDLimit = 1000
+
+// atext are the characters of an RFC 5322 atom besides letters and digits.
+const atext = "!#$%&'*+-/=?^_`{|}~"
+
+// isDotAtom reports whether s is an RFC 5322 dot-atom, as the right part of a message-id must be to be used as is.
+func isDotAtom(s string) bool {
+	for _, atom := range strings.Split(s, ".") {
+		if atom == "" {
+			return false
+		}
+		for _, c := range atom {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune(atext, c)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newMessageID returns a message-id made of 128 random bits under the domain, without its angle brackets.
+func newMessageID(domain string) (id string, err error) {
+	var b [16]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		return
+	}
+	id = hex.EncodeToString(b[:]) + "@" + domain
+	return
+}
+
+// handleNewID serves GET /newid, returning a JSON array of count new message-ids under MessageIDDomain, 1 by default,
+// so uploaders can name the segments before posting them.
+func (s *server) handleNewID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	count := 1
+	if value := r.URL.Query().Get("count"); value != "" {
+		var err error
+		if count, err = strconv.Atoi(value); err != nil || count < 1 || count > newIDLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "expecting a count between 1 and %d\n", newIDLimit)
+			return
+		}
+	}
+	ids := make([]string, count)
+	for i := range ids {
+		var err error
+		if ids[i], err = newMessageID(s.MessageIDDomain); err != nil {
+			logf(r.Context(), "[ERROR] newid error: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	data, _ := json.Marshal(ids)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
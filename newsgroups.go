@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/textproto.v0"
+)
+
+// NewsgroupPolicy restricts the posts to the newsgroups it matches, so that the gateway, and DefaultNewsgroup in
+// particular, cannot be used to post to any newsgroup.
+type NewsgroupPolicy struct {
+	// Group is a newsgroup name, a prefix ending with ".*" like "alt.binaries.*", or "*" for all the newsgroups
+	Group string
+	// Block rejects the posts to the matching newsgroups
+	Block bool
+	// RequireHeaders are the article headers the posts must have, like "Approved" for moderated newsgroups
+	RequireHeaders []string
+	// MaxArticleSize, if set, is the max number of bytes of the posts, below ArticleSizeLimit
+	MaxArticleSize uint64
+}
+
+func (policy *NewsgroupPolicy) match(group string) bool {
+	if prefix := strings.TrimSuffix(policy.Group, "*"); prefix != policy.Group {
+		return strings.HasPrefix(group, prefix)
+	}
+	return group == policy.Group
+}
+
+// validateNewsgroupPolicies checks the groups of the policies.
+func validateNewsgroupPolicies(policies []NewsgroupPolicy) (err error) {
+	for i, policy := range policies {
+		group := strings.TrimSuffix(policy.Group, ".*")
+		if policy.Group != "*" && (group == "" || strings.ContainsAny(group, "*, ")) {
+			return fmt.Errorf("NewsgroupPolicies[%d] invalid Group %q", i, policy.Group)
+		}
+	}
+	return
+}
+
+// postPolicy checks the Newsgroups header of an article to post against the NewsgroupPolicies, the first matching
+// policy applying to each newsgroup. A crosspost must be allowed in all its newsgroups, so it gets the smallest
+// MaxArticleSize of them, ArticleSizeLimit otherwise. With RestrictNewsgroups, a newsgroup no policy matches is
+// rejected. The error tells the poster why the article is rejected.
+func (s *server) postPolicy(header textproto.MIMEHeader) (limit uint64, err error) {
+	limit = s.ArticleSizeLimit
+	for _, group := range strings.Split(header.Get("Newsgroups"), ",") {
+		if group = strings.TrimSpace(group); group == "" {
+			continue
+		}
+		var policy *NewsgroupPolicy
+		for i := range s.NewsgroupPolicies {
+			if s.NewsgroupPolicies[i].match(group) {
+				policy = &s.NewsgroupPolicies[i]
+				break
+			}
+		}
+		switch {
+		case policy == nil && s.RestrictNewsgroups:
+			return 0, fmt.Errorf("posting to %s is not allowed", group)
+		case policy == nil:
+			continue
+		case policy.Block:
+			return 0, fmt.Errorf("posting to %s is blocked", group)
+		}
+		for _, key := range policy.RequireHeaders {
+			if header.Get(key) == "" {
+				return 0, fmt.Errorf("posting to %s requires the %s header", group, key)
+			}
+		}
+		if policy.MaxArticleSize > 0 && policy.MaxArticleSize < limit {
+			limit = policy.MaxArticleSize
+		}
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/nntp.v0"
+)
+
+// Actions of an NNTPCode, how a fetch goes on once a server answers an article request with the code.
+const (
+	// CodeMissing is the server not having the article, the next server being tried, and 404 Not Found answered if
+	// none of the servers asked has it
+	CodeMissing = "missing"
+	// CodeNext is the server failing, the next server being tried
+	CodeNext = "next"
+	// CodeFail fails the fetch right away, without trying the next servers
+	CodeFail = "fail"
+	// CodeReauth is the server having expired the session of the connection, which is authenticated again for the
+	// command to be retried once, the next server being tried if that fails
+	CodeReauth = "reauth"
+)
+
+// NNTPCode is how an NNTP response code answering an article request is handled, see NNTPCodes.
+type NNTPCode struct {
+	// Action is "missing", "next", "fail" or "reauth", by default "missing" for 430 and 423, "reauth" for 480, "fail"
+	// for the FailFastCodes and "next" for the others
+	Action string
+	// Status is the HTTP status a request is answered with when its fetch fails with the code last, 502 Bad Gateway by
+	// default. It is ignored for "missing".
+	Status int
+}
+
+// defaultNNTPCodes are the NNTP codes handled unless FailFastCodes or NNTPCodes say otherwise; the other codes move on
+// to the next server.
+var defaultNNTPCodes = map[nntp.ResponseCode]NNTPCode{
+	nntp.ResponseCodeNoSuchArticleId:        {Action: CodeMissing},
+	nntp.ResponseCodeNoSuchArticleNumber:    {Action: CodeMissing},
+	nntp.ResponseCodeAuthenticationRequired: {Action: CodeReauth},
+}
+
+// resolveNNTPCodes builds the table of the NNTP codes from defaultNNTPCodes, the FailFastCodes and the NNTPCodes, in
+// that order of precedence, checking the NNTPCodes.
+func (s *server) resolveNNTPCodes() (err error) {
+	s.nntpCodes = make(map[nntp.ResponseCode]NNTPCode, len(defaultNNTPCodes)+len(s.FailFastCodes)+len(s.NNTPCodes))
+	for code, handling := range defaultNNTPCodes {
+		s.nntpCodes[code] = handling
+	}
+	for _, code := range s.FailFastCodes {
+		s.nntpCodes[nntp.ResponseCode(code)] = NNTPCode{Action: CodeFail}
+	}
+	for key, handling := range s.NNTPCodes {
+		code, err := strconv.Atoi(key)
+		if err != nil || code < 400 || code > 599 {
+			return fmt.Errorf("invalid NNTP code %q in NNTPCodes, expecting one from 400 to 599", key)
+		}
+		switch handling.Action {
+		case "":
+			handling.Action = CodeNext
+			if inherited, ok := s.nntpCodes[nntp.ResponseCode(code)]; ok {
+				handling.Action = inherited.Action
+			}
+		case CodeMissing, CodeNext, CodeFail, CodeReauth:
+		default:
+			return fmt.Errorf("invalid Action %q of NNTPCodes %s, expecting %q, %q, %q or %q", handling.Action, key, CodeMissing, CodeNext, CodeFail, CodeReauth)
+		}
+		if handling.Status != 0 && (handling.Status < 400 || handling.Status > 599) {
+			return fmt.Errorf("invalid Status %d of NNTPCodes %s, expecting an HTTP error status", handling.Status, key)
+		}
+		s.nntpCodes[nntp.ResponseCode(code)] = handling
+	}
+	return nil
+}
+
+// nntpCode returns how the NNTP error of an article request is handled.
+func (s *server) nntpCode(nntpErr *nntp.Error) NNTPCode {
+	if handling, ok := s.nntpCodes[nntpErr.Code]; ok {
+		return handling
+	}
+	if handling, ok := defaultNNTPCodes[nntpErr.Code]; ok {
+		// before applyDefaults
+		return handling
+	}
+	return NNTPCode{Action: CodeNext}
+}
+
+// missing reports whether an NNTP error is the server answering that it has not the article.
+func (s *server) missing(nntpErr *nntp.Error) bool {
+	return s.nntpCode(nntpErr).Action == CodeMissing
+}
+
+// failFast reports whether an NNTP error ends a fetch instead of moving on to the next server, see FailFastCodes and
+// NNTPCodes.
+func (s *server) failFast(nntpErr *nntp.Error) bool {
+	return s.nntpCode(nntpErr).Action == CodeFail
+}
+
+// nntpBackendError returns the backendError of an NNTP error, with the Status of its code.
+func (s *server) nntpBackendError(err error, nntpErr *nntp.Error) *backendError {
+	return &backendError{err: err, status: s.nntpCode(nntpErr).Status}
+}
+
+// backendStatus returns the HTTP status of a request whose fetch failed with ErrBackendFailure: the Status of the NNTP
+// code it failed with last, else 502 Bad Gateway.
+func backendStatus(err error) int {
+	var backendErr *backendError
+	if errors.As(err, &backendErr) && backendErr.status != 0 {
+		return backendErr.status
+	}
+	return http.StatusBadGateway
+}
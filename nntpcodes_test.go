@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"gopkg.in/nntp.v0"
+)
+
+func TestResolveNNTPCodes(t *testing.T) {
+	s := &server{
+		FailFastCodes: []int{451, 502},
+		NNTPCodes: map[string]NNTPCode{
+			"430": {Status: http.StatusGone},               // inherits missing
+			"451": {Action: CodeNext},                      // overrides FailFastCodes
+			"480": {Action: CodeFail},                      // overrides the default
+			"503": {Status: http.StatusServiceUnavailable}, // inherits next
+		},
+	}
+	if err := s.resolveNNTPCodes(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		code nntp.ResponseCode
+		want NNTPCode
+	}{
+		{430, NNTPCode{Action: CodeMissing, Status: http.StatusGone}},
+		{423, NNTPCode{Action: CodeMissing}},
+		{451, NNTPCode{Action: CodeNext}},
+		{480, NNTPCode{Action: CodeFail}},
+		{502, NNTPCode{Action: CodeFail}},
+		{503, NNTPCode{Action: CodeNext, Status: http.StatusServiceUnavailable}},
+		{400, NNTPCode{Action: CodeNext}},
+	} {
+		if got := s.nntpCode(&nntp.Error{Code: test.code}); got != test.want {
+			t.Errorf("nntpCode(%d) = %+v, want %+v", test.code, got, test.want)
+		}
+	}
+
+	// the defaults
+	s = &server{}
+	if err := s.resolveNNTPCodes(); err != nil {
+		t.Fatal(err)
+	}
+	for code, action := range map[nntp.ResponseCode]string{430: CodeMissing, 423: CodeMissing, 480: CodeReauth, 451: CodeNext} {
+		if got := s.nntpCode(&nntp.Error{Code: code}); got.Action != action || got.Status != 0 {
+			t.Errorf("default nntpCode(%d) = %+v, want %q", code, got, action)
+		}
+	}
+}
+
+func TestResolveNNTPCodesInvalid(t *testing.T) {
+	for _, codes := range []map[string]NNTPCode{
+		{"abc": {}},
+		{"399": {}},
+		{"600": {}},
+		{"503": {Action: "retry"}},
+		{"503": {Action: "Missing"}},
+		{"503": {Status: http.StatusFound}},
+		{"503": {Status: 600}},
+	} {
+		s := &server{NNTPCodes: codes}
+		if err := s.resolveNNTPCodes(); err == nil {
+			t.Errorf("NNTPCodes %+v accepted", codes)
+		}
+	}
+}
+
+func TestBackendStatus(t *testing.T) {
+	s := &server{NNTPCodes: map[string]NNTPCode{"503": {Status: http.StatusServiceUnavailable}}}
+	if err := s.resolveNNTPCodes(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		err  error
+		want int
+	}{
+		{errors.New("no backend error"), http.StatusBadGateway},
+		{&backendError{err: io.EOF}, http.StatusBadGateway},
+		{&backendError{err: io.EOF, status: http.StatusGatewayTimeout}, http.StatusGatewayTimeout},
+		{fmt.Errorf("wrapped: %w", &backendError{err: io.EOF, status: http.StatusGatewayTimeout}), http.StatusGatewayTimeout},
+		{s.nntpBackendError(io.EOF, &nntp.Error{Code: 503}), http.StatusServiceUnavailable},
+		{s.nntpBackendError(io.EOF, &nntp.Error{Code: 502}), http.StatusBadGateway},
+	} {
+		if got := backendStatus(test.err); got != test.want {
+			t.Errorf("backendStatus(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+// fetchArticle runs fetch with ARTICLE, reading and giving back the conn of an article found.
+func fetchArticle(s *server, messageID nntp.MessageID) (err error) {
+	conn, article, err := s.fetch(context.Background(), messageID, "ARTICLE", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdArticle(nntp.ArticleMessageID(messageID))
+	})
+	if err == nil {
+		io.Copy(io.Discard, article.Body)
+		s.pool.Put(conn)
+	}
+	return
+}
+
+func TestFetchNNTPCodes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		codes    map[string]NNTPCode
+		answer   int
+		found    bool // the error answered by the first server only, the second one having the article
+		want     error
+		status   int
+		articles int // ARTICLE commands received by both servers
+	}{
+		{name: "missing", answer: 430, want: ErrArticleNotFound, articles: 2},
+		{name: "next", answer: 503, want: ErrBackendFailure, status: http.StatusBadGateway, articles: 2},
+		{name: "next with a status", codes: map[string]NNTPCode{"503": {Status: http.StatusServiceUnavailable}}, answer: 503,
+			want: ErrBackendFailure, status: http.StatusServiceUnavailable, articles: 2},
+		{name: "next found", answer: 503, found: true, articles: 2},
+		{name: "missing found", answer: 430, found: true, articles: 2},
+		{name: "fail", codes: map[string]NNTPCode{"503": {Action: CodeFail, Status: http.StatusServiceUnavailable}}, answer: 503,
+			want: ErrBackendFailure, status: http.StatusServiceUnavailable, articles: 1},
+		{name: "fail found", codes: map[string]NNTPCode{"503": {Action: CodeFail}}, answer: 503, found: true,
+			want: ErrBackendFailure, status: http.StatusBadGateway, articles: 1},
+		// reauthenticated and retried on the same server, twice for each
+		{name: "reauth", answer: 480, want: ErrBackendFailure, status: http.StatusBadGateway, articles: 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fakes := []*fakeNNTP{newFakeNNTP(t), newFakeNNTP(t)}
+			for _, f := range fakes {
+				f.User, f.Pass = "user", "secret"
+			}
+			s := newTestServer(t, fakes...)
+			s.NNTPCodes = test.codes
+			if err := s.resolveNNTPCodes(); err != nil {
+				t.Fatal(err)
+			}
+			// the server tried first for the message-id
+			first := s.pool.first("a@example.com")
+			for i, f := range fakes {
+				if i == first || !test.found {
+					f.answer("ARTICLE", test.answer)
+				} else {
+					f.add("a@example.com", testHeader, "body\r\n")
+				}
+			}
+
+			err := fetchArticle(s, "a@example.com")
+			switch {
+			case test.want == nil && err != nil:
+				t.Fatalf("fetch: %v", err)
+			case test.want != nil && !errors.Is(err, test.want):
+				t.Fatalf("fetch: %v, want %v", err, test.want)
+			case test.status != 0 && backendStatus(err) != test.status:
+				t.Errorf("backendStatus(%v) = %d, want %d", err, backendStatus(err), test.status)
+			}
+			if n := fakes[0].received("ARTICLE") + fakes[1].received("ARTICLE"); n != test.articles {
+				t.Errorf("%d ARTICLE commands, want %d", n, test.articles)
+			}
+		})
+	}
+}
+
+func TestFetchReauth(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.User, f.Pass = "user", "secret"
+	f.add("a@example.com", testHeader, "body\r\n")
+	s := newTestServer(t, f)
+
+	// the session expired once, the command is retried after authenticating again
+	f.answerOnce("ARTICLE", 480)
+	if err := fetchArticle(s, "a@example.com"); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if n := f.received("AUTHINFO PASS"); n != 2 {
+		t.Errorf("AUTHINFO PASS sent %d times, want 2", n)
+	}
+	if n := f.received("ARTICLE"); n != 2 {
+		t.Errorf("ARTICLE sent %d times, want 2", n)
+	}
+}
+
+func TestFetchReauthWithoutUser(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader, "body\r\n")
+	s := newTestServer(t, f)
+
+	// nothing to authenticate with, the code fails the fetch like any other NNTP error, the conn given back
+	f.answerOnce("ARTICLE", 480)
+	var nntpErr *nntp.Error
+	err := fetchArticle(s, "a@example.com")
+	if !errors.As(err, &nntpErr) || nntpErr.Code != 480 || backendStatus(err) != http.StatusBadGateway {
+		t.Fatalf("fetch: %v", err)
+	}
+	if stats := s.pool.Stats()[0]; stats.Open != 1 || stats.Idle != 1 {
+		t.Errorf("conn of the 480 not given back: %+v", stats)
+	}
+	if err := fetchArticle(s, "a@example.com"); err != nil {
+		t.Fatalf("fetch after the 480: %v", err)
+	}
+	if n := f.received("AUTHINFO USER"); n != 0 {
+		t.Errorf("AUTHINFO USER sent %d times", n)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+const nzbSizeLimit = 16 * 1024 * 1024
+
+// nzbDocument is the NZB file format, see https://sabnzbd.org/wiki/extra/nzb-spec.
+type nzbDocument struct {
+	XMLName xml.Name  `xml:"nzb"`
+	Files   []nzbFile `xml:"file"`
+}
+
+type nzbFile struct {
+	Poster   string       `xml:"poster,attr"`
+	Date     int64        `xml:"date,attr"`
+	Subject  string       `xml:"subject,attr"`
+	Groups   []string     `xml:"groups>group"`
+	Segments []nzbSegment `xml:"segments>segment"`
+}
+
+type nzbSegment struct {
+	Bytes     int64          `xml:"bytes,attr"`
+	Number    int            `xml:"number,attr"`
+	MessageID nntp.MessageID `xml:",chardata"`
+}
+
+var errNZBSegment = errors.New("segment unavailable")
+
+// parseNZB decodes an NZB document, with the segments of every file sorted by number.
+func parseNZB(r io.Reader) (doc *nzbDocument, err error) {
+	doc = new(nzbDocument)
+	if err = xml.NewDecoder(io.LimitReader(r, nzbSizeLimit)).Decode(doc); err != nil {
+		return
+	}
+	if len(doc.Files) == 0 {
+		err = errors.New("no files in NZB")
+		return
+	}
+	for i := range doc.Files {
+		file := &doc.Files[i]
+		if len(file.Segments) == 0 {
+			err = fmt.Errorf("no segments for file %q", file.Subject)
+			return
+		}
+		for j := range file.Segments {
+			segment := &file.Segments[j]
+			segment.MessageID = nntp.MessageID(strings.TrimSpace(string(segment.MessageID)))
+			if segment.MessageID == "" || segment.MessageID.Validate() != nil {
+				err = fmt.Errorf("invalid message-id %q", segment.MessageID)
+				return
+			}
+		}
+		sort.SliceStable(file.Segments, func(a, b int) bool { return file.Segments[a].Number < file.Segments[b].Number })
+	}
+	return
+}
+
+func (file *nzbFile) messageIDs() (ids []nntp.MessageID) {
+	for _, segment := range file.Segments {
+		ids = append(ids, segment.MessageID)
+	}
+	return
+}
+
+// decodeSegment decodes the fetched body of the segment i of the file. A segment missing or taken down is
+// errNZBSegment, while the other failures are ErrBackendFailure, so that the file isn't answered as missing.
+func (file *nzbFile) decodeSegment(i int, result *batchResult) (part *yEncPart, err error) {
+	switch result.status {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusUnavailableForLegalReasons:
+		return nil, fmt.Errorf("%s: %w (%d)", file.Segments[i].MessageID, errNZBSegment, result.status)
+	default:
+		return nil, fmt.Errorf("%s: %w (%d)", file.Segments[i].MessageID, ErrBackendFailure, result.status)
+	}
+	if part, err = decodeYEnc(result.body); err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Segments[i].MessageID, err)
+	}
+	return
+}
+
+// nameAndSize returns the name and size of the file given in the yEnc header of its first segment, the name falling
+// back to the one in the subject, or to "file" and the index of the file.
+func (file *nzbFile) nameAndSize(first *yEncPart, index int) (name string, size int64) {
+	name = path.Base(strings.ReplaceAll(first.Name, "\\", "/"))
+	if name == "" || name == "." || name == "/" {
+		if name, _ = detectFilename(textproto.MIMEHeader{"Subject": {file.Subject}}, nil); name == "" {
+			name = "file" + strconv.Itoa(index+1)
+		}
+	}
+	if size = first.Size; size == 0 {
+		size = int64(len(first.Data))
+	}
+	return
+}
+
+// streamFile fetches and decodes the segments of the file in order, calling open with the file name and size found in
+// the first segment's yEnc header, then writing the decoded data to the returned writer.
+func (s *server) streamFile(ctx context.Context, file *nzbFile, index int, open func(name string, size int64) (io.Writer, error)) (written int64, err error) {
+	var (
+		w    io.Writer
+		size int64
+	)
+	err = s.fetchInOrder(withArticleDate(ctx, file.Date), file.messageIDs(), func(i int, result *batchResult) (err error) {
+		var part *yEncPart
+		if part, err = file.decodeSegment(i, result); err != nil {
+			return
+		}
+		if w == nil {
+			var name string
+			name, size = file.nameAndSize(part, index)
+			if w, err = open(name, size); err != nil {
+				return
+			}
+		}
+		if written+int64(len(part.Data)) > size {
+			return fmt.Errorf("%s: decoded data exceeds the file size %d", file.Segments[i].MessageID, size)
+		}
+		var n int
+		n, err = w.Write(part.Data)
+		written += int64(n)
+		return
+	})
+	if err == nil && written < size {
+		err = fmt.Errorf("decoded %d bytes short of the file size %d", size-written, size)
+	}
+	return
+}
+
+// startFileResponse sends the headers of a decoded file download.
+func startFileResponse(w http.ResponseWriter, name string, size int64) {
+	setFileHeaders(w, name, size)
+	w.WriteHeader(http.StatusOK)
+}
+
+// setFileHeaders sets the headers of size bytes of a decoded file download.
+func setFileHeaders(w http.ResponseWriter, name string, size int64) {
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+}
+
+// handleNZB serves POST /nzb, where the body is an NZB document, see serveNZB.
+func (s *server) handleNZB(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	doc, err := parseNZB(r.Body)
+	if err != nil {
+		logf(r.Context(), "[ERROR] NZB invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	format, ok := nzbFormat(doc, r.URL.Query().Get("format"))
+	if !ok {
+		logf(r.Context(), "[ERROR] NZB invalid format %q", format)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	started, err := s.serveNZB(w, r, doc, format)
+	if err != nil {
+		if !started || !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] NZB error: %s", err.Error())
+		}
+		if started {
+			// abort the response so that the client can tell the file or the archive is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, errNZBSegment) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if !unavailable(w, err) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	logf(r.Context(), "[INFO] NZB %d files", len(doc.Files))
+}
+
+// nzbFormat returns the format an NZB document is served in, "tar" by default if it has several files, and reports
+// whether the requested format is valid.
+func nzbFormat(doc *nzbDocument, format string) (string, bool) {
+	switch format {
+	case "":
+		if len(doc.Files) > 1 {
+			format = "tar"
+		}
+	case "tar", "zip":
+	default:
+		return format, false
+	}
+	return format, true
+}
+
+// serveNZB sends the files of an NZB document. The decoded file, or the range of it requested, is returned as is if
+// the format is "", otherwise all files are packaged in a streaming archive, as requested by the format being "tar" or
+// "zip". The archive is built on the fly as segments are fetched. started reports whether the response is sent, after
+// which an error can only abort it.
+func (s *server) serveNZB(w http.ResponseWriter, r *http.Request, doc *nzbDocument, format string) (started bool, err error) {
+	switch format {
+	case "":
+		started, err = s.serveFile(r.Context(), w, &doc.Files[0], 0, r.Header.Get("Range"))
+	case "tar":
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"nzb.tar\"")
+		tw := tar.NewWriter(w)
+		for i := range doc.Files {
+			file := &doc.Files[i]
+			if _, err = s.streamFile(r.Context(), file, i, func(name string, size int64) (io.Writer, error) {
+				started = true
+				modTime := time.Unix(file.Date, 0)
+				return tw, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime})
+			}); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"nzb.zip\"")
+		zw := zip.NewWriter(w)
+		for i := range doc.Files {
+			file := &doc.Files[i]
+			if _, err = s.streamFile(r.Context(), file, i, func(name string, size int64) (io.Writer, error) {
+				started = true
+				// binaries posted on Usenet are mostly compressed already
+				return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Unix(file.Date, 0)})
+			}); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+	}
+	return
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolObserver is told about the lifecycle of the conns of a Pool, for metrics, tracing and tests to follow it without
+// parsing the log. Its methods are called outside of the locks of the pool, from the goroutine of the event, so they
+// must be quick and safe for concurrent use.
+type PoolObserver interface {
+	// OnDial is called once a new conn to the server of host is open, took being the time to dial and authenticate.
+	OnDial(host string, took time.Duration)
+	// OnReuse is called when a conn to the server of host is handed out again, idle or given back while waited for.
+	OnReuse(host string)
+	// OnQueueWait is called when a Get waited for a conn to the server of host to be free, for wait.
+	OnQueueWait(host string, wait time.Duration)
+	// OnPurge is called when an idle conn to the server of host is closed for being idle for too long, or for not
+	// answering the probe of SetIdleProbe.
+	OnPurge(host string)
+	// OnError is called when a Get of a conn to the server of host fails with err: dialing it, the pool being
+	// saturated or stopped, or the context of the Get being done while it waits.
+	OnError(host string, err error)
+}
+
+// poolObservers tells every observer about the events, in order.
+type poolObservers []PoolObserver
+
+func (o poolObservers) OnDial(host string, took time.Duration) {
+	for _, observer := range o {
+		observer.OnDial(host, took)
+	}
+}
+
+func (o poolObservers) OnReuse(host string) {
+	for _, observer := range o {
+		observer.OnReuse(host)
+	}
+}
+
+func (o poolObservers) OnQueueWait(host string, wait time.Duration) {
+	for _, observer := range o {
+		observer.OnQueueWait(host, wait)
+	}
+}
+
+func (o poolObservers) OnPurge(host string) {
+	for _, observer := range o {
+		observer.OnPurge(host)
+	}
+}
+
+func (o poolObservers) OnError(host string, err error) {
+	for _, observer := range o {
+		observer.OnError(host, err)
+	}
+}
+
+// SetObservers makes the pool tell the observers about the lifecycle of its conns, replacing the ones set before.
+func (p *Pool) SetObservers(observers ...PoolObserver) {
+	for _, sp := range p.all() {
+		sp.mu.Lock()
+		sp.observers = append(poolObservers(nil), observers...)
+		sp.mu.Unlock()
+	}
+}
+
+// observe returns the observers of the pool of a server.
+func (sp *serverPool) observe() poolObservers {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.observers
+}
+
+// poolErrorKind names the cause of a failed Get, for the label of its metric.
+func poolErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrPoolSaturated):
+		return "saturated"
+	case errors.Is(err, ErrPoolStopped):
+		return "stopped"
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "dial"
+	}
+}
+
+// hostMetrics are the counters of the events of the conns to a server.
+type hostMetrics struct {
+	dials, reuses, queueWaits, purges uint64
+	dialTime, queueWaitTime           time.Duration
+	errors                            map[string]uint64 // by poolErrorKind
+}
+
+// poolMetrics is the PoolObserver counting the events of the pool by server, served in the Prometheus text format at
+// /metrics on the admin server.
+type poolMetrics struct {
+	mu    sync.Mutex
+	hosts map[string]*hostMetrics
+}
+
+func newPoolMetrics() *poolMetrics {
+	return &poolMetrics{hosts: make(map[string]*hostMetrics)}
+}
+
+// host returns the counters of a server, m.mu must be held.
+func (m *poolMetrics) host(host string) *hostMetrics {
+	h, ok := m.hosts[host]
+	if !ok {
+		h = &hostMetrics{errors: make(map[string]uint64)}
+		m.hosts[host] = h
+	}
+	return h
+}
+
+func (m *poolMetrics) OnDial(host string, took time.Duration) {
+	m.mu.Lock()
+	h := m.host(host)
+	h.dials++
+	h.dialTime += took
+	m.mu.Unlock()
+}
+
+func (m *poolMetrics) OnReuse(host string) {
+	m.mu.Lock()
+	m.host(host).reuses++
+	m.mu.Unlock()
+}
+
+func (m *poolMetrics) OnQueueWait(host string, wait time.Duration) {
+	m.mu.Lock()
+	h := m.host(host)
+	h.queueWaits++
+	h.queueWaitTime += wait
+	m.mu.Unlock()
+}
+
+func (m *poolMetrics) OnPurge(host string) {
+	m.mu.Lock()
+	m.host(host).purges++
+	m.mu.Unlock()
+}
+
+func (m *poolMetrics) OnError(host string, err error) {
+	m.mu.Lock()
+	m.host(host).errors[poolErrorKind(err)]++
+	m.mu.Unlock()
+}
+
+// promLabel quotes a label value of the Prometheus text format.
+func promLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// writeTo writes the counters of every server in the Prometheus text format, followed by the gauges of the conns of
+// the pool if not nil.
+func (m *poolMetrics) writeTo(w http.ResponseWriter, pool *Pool) {
+	m.mu.Lock()
+	hosts := make([]string, 0, len(m.hosts))
+	for host := range m.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	counters := []struct {
+		name, help string
+		value      func(h *hostMetrics) string
+	}{
+		{"usebin_pool_dials_total", "Connections opened to the NNTP server.",
+			func(h *hostMetrics) string { return fmt.Sprint(h.dials) }},
+		{"usebin_pool_dial_seconds_total", "Time spent opening connections to the NNTP server.",
+			func(h *hostMetrics) string { return fmt.Sprint(h.dialTime.Seconds()) }},
+		{"usebin_pool_reuses_total", "Connections to the NNTP server handed out again.",
+			func(h *hostMetrics) string { return fmt.Sprint(h.reuses) }},
+		{"usebin_pool_queue_waits_total", "Requests which waited for a connection to the NNTP server.",
+			func(h *hostMetrics) string { return fmt.Sprint(h.queueWaits) }},
+		{"usebin_pool_queue_wait_seconds_total", "Time spent waiting for a connection to the NNTP server.",
+			func(h *hostMetrics) string { return fmt.Sprint(h.queueWaitTime.Seconds()) }},
+		{"usebin_pool_purges_total", "Idle connections to the NNTP server closed for being idle for too long.",
+			func(h *hostMetrics) string { return fmt.Sprint(h.purges) }},
+	}
+	for _, counter := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+		for _, host := range hosts {
+			fmt.Fprintf(w, "%s{host=%s} %s\n", counter.name, promLabel(host), counter.value(m.hosts[host]))
+		}
+	}
+	fmt.Fprint(w, "# HELP usebin_pool_errors_total Requests failing to get a connection to the NNTP server.\n"+
+		"# TYPE usebin_pool_errors_total counter\n")
+	for _, host := range hosts {
+		kinds := make([]string, 0, len(m.hosts[host].errors))
+		for kind := range m.hosts[host].errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "usebin_pool_errors_total{host=%s,kind=%s} %d\n", promLabel(host), promLabel(kind),
+				m.hosts[host].errors[kind])
+		}
+	}
+	m.mu.Unlock()
+
+	if pool == nil {
+		return
+	}
+	stats := pool.Stats()
+	gauges := []struct {
+		name, help string
+		value      func(stats poolStats) uint64
+	}{
+		{"usebin_pool_open_connections", "Connections to the NNTP server open or being opened.",
+			func(stats poolStats) uint64 { return stats.Open }},
+		{"usebin_pool_idle_connections", "Idle connections to the NNTP server.",
+			func(stats poolStats) uint64 { return uint64(stats.Idle) }},
+		{"usebin_pool_waiting_requests", "Requests waiting for a connection to the NNTP server.",
+			func(stats poolStats) uint64 { return uint64(stats.Waiting) }},
+	}
+	for _, gauge := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, stats := range stats {
+			fmt.Fprintf(w, "%s{host=%s,reserved=\"%t\"} %d\n", gauge.name, promLabel(stats.Host), stats.Reserved,
+				gauge.value(stats))
+		}
+	}
+}
+
+// handleMetrics serves the metrics of the pool in the Prometheus text format, for GET /metrics on the admin server.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	// there are no metrics in local-only mode
+	if r.Method == http.MethodHead || s.metrics == nil {
+		return
+	}
+	s.metrics.writeTo(w, s.pool)
+}
@@ -0,0 +1,8 @@
+//go:build !go1.21
+
+package main
+
+// newSlogObserver returns the slogObserver, nil when built without log/slog before Go 1.21.
+func newSlogObserver() PoolObserver {
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build go1.21
+
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// slogObserver is the PoolObserver of PoolEventLog, logging the events of the pool as structured records with the
+// default log/slog logger, for log pipelines to follow them without parsing the lines of the log.
+type slogObserver struct {
+	logger *slog.Logger
+}
+
+// newSlogObserver returns the slogObserver, nil when built without log/slog before Go 1.21.
+func newSlogObserver() PoolObserver {
+	return slogObserver{logger: slog.Default().With("component", "pool")}
+}
+
+func (o slogObserver) OnDial(host string, took time.Duration) {
+	o.logger.Info("dial", "host", host, "took", took)
+}
+
+func (o slogObserver) OnReuse(host string) {
+	o.logger.Info("reuse", "host", host)
+}
+
+func (o slogObserver) OnQueueWait(host string, wait time.Duration) {
+	o.logger.Info("queue wait", "host", host, "wait", wait)
+}
+
+func (o slogObserver) OnPurge(host string) {
+	o.logger.Info("purge", "host", host)
+}
+
+func (o slogObserver) OnError(host string, err error) {
+	o.logger.Warn("error", "host", host, "kind", poolErrorKind(err), "err", err)
+}
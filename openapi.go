@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// apiSpec is the part of the OpenAPI spec at static/openapi.json rendered by the API page.
+type apiSpec struct {
+	Info struct {
+		Title       string
+		Description string
+		Version     string
+	}
+	// Paths maps a path to its operations by method, and "parameters" to the parameters common to its operations.
+	Paths      map[string]map[string]json.RawMessage
+	Components struct {
+		Parameters map[string]apiParameter
+		Responses  map[string]apiResponse
+	}
+}
+
+type apiParameter struct {
+	Ref         string `json:"$ref"`
+	Name        string
+	In          string
+	Description string
+	Required    bool
+}
+
+type apiResponse struct {
+	Ref         string `json:"$ref"`
+	Description string
+}
+
+type apiOperation struct {
+	Summary     string
+	Description string
+	Parameters  []apiParameter
+	Responses   map[string]apiResponse
+}
+
+// apiEndpoint is an operation of the spec as shown on the API page, with its references resolved.
+type apiEndpoint struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Parameters  []apiParameter
+	Responses   []apiStatus
+}
+
+type apiStatus struct {
+	Code        string
+	Description string
+}
+
+// apiMethods is the order of the operations of a path on the API page.
+var apiMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+var apiTemplate = template.Must(template.New("api").Parse(`<!DOCTYPE html>
+<html>
+
+<head>
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+  <link rel="icon" type="image/png" sizes="32x32" href="/assets/favicon-32x32.png" />
+  <title>useb.in &middot; api</title>
+  <style>
+    * {
+      color: rgb(170, 170, 170);
+      background-color: black;
+      font-family: monospace;
+      font-size: initial;
+    }
+
+    a {
+      color: rgb(85, 255, 255);
+    }
+
+    summary {
+      cursor: pointer;
+      color: rgb(255, 255, 85);
+    }
+
+    th,
+    td {
+      padding: 0 1em 0 0;
+      text-align: left;
+      vertical-align: top;
+    }
+  </style>
+</head>
+
+<body>
+  <p>{{.Info.Title}} API, version {{.Info.Version}}, <a href="/openapi.json">openapi.json</a></p>
+  <p>{{.Info.Description}}</p>
+  {{- range .Endpoints}}
+  <details>
+    <summary>{{.Method}} {{.Path}} &middot; {{.Summary}}</summary>
+    {{- if .Description}}
+    <p>{{.Description}}</p>
+    {{- end}}
+    {{- if .Parameters}}
+    <table>
+      <tr><th>parameter</th><th>in</th><th>description</th></tr>
+      {{- range .Parameters}}
+      <tr><td>{{.Name}}{{if .Required}}*{{end}}</td><td>{{.In}}</td><td>{{.Description}}</td></tr>
+      {{- end}}
+    </table>
+    {{- end}}
+    <table>
+      <tr><th>status</th><th>description</th></tr>
+      {{- range .Responses}}
+      <tr><td>{{.Code}}</td><td>{{.Description}}</td></tr>
+      {{- end}}
+    </table>
+  </details>
+  {{- end}}
+</body>
+
+</html>
+`))
+
+var (
+	apiPageOnce sync.Once
+	apiPage     []byte
+	apiPageErr  error
+)
+
+// renderAPIPage renders the API page from the embedded OpenAPI spec, once since the spec is part of the binary.
+func renderAPIPage() ([]byte, error) {
+	apiPageOnce.Do(func() {
+		var (
+			raw       []byte
+			spec      apiSpec
+			endpoints []apiEndpoint
+			buf       bytes.Buffer
+		)
+		if raw, apiPageErr = staticFS.ReadFile("static/openapi.json"); apiPageErr != nil {
+			return
+		}
+		if apiPageErr = json.Unmarshal(raw, &spec); apiPageErr != nil {
+			return
+		}
+		if endpoints, apiPageErr = spec.endpoints(); apiPageErr != nil {
+			return
+		}
+		if apiPageErr = apiTemplate.Execute(&buf, struct {
+			Info      interface{}
+			Endpoints []apiEndpoint
+		}{spec.Info, endpoints}); apiPageErr != nil {
+			return
+		}
+		apiPage = buf.Bytes()
+	})
+	return apiPage, apiPageErr
+}
+
+// endpoints returns the operations of the spec sorted by path and method.
+func (spec *apiSpec) endpoints() (endpoints []apiEndpoint, err error) {
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var common []apiParameter
+		if raw, ok := spec.Paths[path]["parameters"]; ok {
+			if err = json.Unmarshal(raw, &common); err != nil {
+				return
+			}
+		}
+		for _, method := range apiMethods {
+			raw, ok := spec.Paths[path][strings.ToLower(method)]
+			if !ok {
+				continue
+			}
+			var op apiOperation
+			if err = json.Unmarshal(raw, &op); err != nil {
+				return
+			}
+			endpoint := apiEndpoint{Method: method, Path: path, Summary: op.Summary, Description: op.Description}
+			for _, param := range append(append([]apiParameter(nil), common...), op.Parameters...) {
+				endpoint.Parameters = append(endpoint.Parameters, spec.parameter(param))
+			}
+			for code, resp := range op.Responses {
+				endpoint.Responses = append(endpoint.Responses, apiStatus{code, spec.response(resp).Description})
+			}
+			sort.Slice(endpoint.Responses, func(i, j int) bool {
+				return endpoint.Responses[i].Code < endpoint.Responses[j].Code
+			})
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return
+}
+
+const (
+	apiParameterRef = "#/components/parameters/"
+	apiResponseRef  = "#/components/responses/"
+)
+
+func (spec *apiSpec) parameter(param apiParameter) apiParameter {
+	if strings.HasPrefix(param.Ref, apiParameterRef) {
+		return spec.Components.Parameters[param.Ref[len(apiParameterRef):]]
+	}
+	return param
+}
+
+func (spec *apiSpec) response(resp apiResponse) apiResponse {
+	if strings.HasPrefix(resp.Ref, apiResponseRef) {
+		return spec.Components.Responses[resp.Ref[len(apiResponseRef):]]
+	}
+	return resp
+}
+
+// handleAPI renders the API page, a description of the routes of the OpenAPI spec served at /openapi.json.
+func (s *server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	page, err := renderAPIPage()
+	if err != nil {
+		logf(r.Context(), "[ERROR] api page: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
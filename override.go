@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// forcedServerKey is the context key of the host of the NNTP server a request forces its fetches to, with the
+// X-Usebin-Server request header.
+type forcedServerKey struct{}
+
+// forcedServer returns the host of the server the fetches with ctx are forced to, "" if none.
+func forcedServer(ctx context.Context) string {
+	host, _ := ctx.Value(forcedServerKey{}).(string)
+	return host
+}
+
+// forceServer applies the X-Usebin-Server header of a GET or HEAD request, naming the Host of the NNTP server to
+// fetch the article from, to tell which provider serves a corrupted copy. Only the admins can set it, and the fetch
+// is then answered by that server alone, skipping the memory cache and the store, its article being kept out of them
+// and of the HTTP caches. If the request cannot be forced, the response is sent and ok is false.
+func (s *server) forceServer(w http.ResponseWriter, r *http.Request) (forced *http.Request, ok bool) {
+	host := r.Header.Get("X-Usebin-Server")
+	if host == "" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return r, true
+	}
+	id, admin := s.isAdmin(r)
+	if !admin {
+		logf(r.Context(), "[ERROR] %s %s X-Usebin-Server forbidden", r.Method, r.URL.Path)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	known := false
+	for _, server := range s.NNTPServers {
+		known = known || server.Host == host
+	}
+	if !known {
+		logf(r.Context(), "[ERROR] %s %s X-Usebin-Server %s is not one of the NNTPServers", r.Method, r.URL.Path, host)
+		setErrorCode(r.Context(), "unknown_server")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name := s.AdminUser
+	if id != nil {
+		name = id.Name
+	}
+	logf(r.Context(), "[INFO] %s %s forced to %s by %s", r.Method, r.URL.Path, host, name)
+	w.Header().Set("X-Usebin-Server", host)
+	return r.WithContext(context.WithValue(r.Context(), forcedServerKey{}, host)), true
+}
+
+// only returns the pool of the server with host, for a fetch forced to it, none if there is no such server.
+func (p *Pool) only(host string) (servers []*serverPool) {
+	for _, sp := range p.servers {
+		if sp.server.Host == host {
+			servers = append(servers, sp)
+		}
+	}
+	return
+}
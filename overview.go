@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// articleFields are the overview fields of an article as GET /j/ returns them, parsed from its header.
+type articleFields struct {
+	MessageID  nntp.MessageID `json:"messageId"`
+	Subject    string         `json:"subject"`
+	From       string         `json:"from"`
+	Date       *time.Time     `json:"date"` // nil if the Date header cannot be parsed
+	Newsgroups []string       `json:"newsgroups"`
+	Bytes      uint64         `json:"bytes,omitempty"`
+	Lines      uint64         `json:"lines,omitempty"`
+	References []string       `json:"references"`
+}
+
+// headerDecoder decodes the RFC 2047 encoded words of the Subject and From headers.
+var headerDecoder = new(mime.WordDecoder)
+
+// decodeHeader returns the value of a header with its encoded words decoded, or as is if one cannot be.
+func decodeHeader(value string) string {
+	if decoded, err := headerDecoder.DecodeHeader(value); err == nil {
+		return decoded
+	}
+	return value
+}
+
+// newArticleFields parses the overview fields of an article from its header.
+func newArticleFields(messageID nntp.MessageID, header textproto.MIMEHeader) (fields *articleFields) {
+	fields = &articleFields{
+		MessageID:  messageID.Short(),
+		Subject:    decodeHeader(header.Get("Subject")),
+		From:       decodeHeader(header.Get("From")),
+		Newsgroups: []string{},
+		References: strings.Fields(header.Get("References")),
+	}
+	if date, err := mail.ParseDate(header.Get("Date")); err == nil {
+		date = date.UTC()
+		fields.Date = &date
+	}
+	for _, group := range strings.Split(header.Get("Newsgroups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			fields.Newsgroups = append(fields.Newsgroups, group)
+		}
+	}
+	fields.Bytes, _ = strconv.ParseUint(header.Get("Bytes"), 10, 64)
+	fields.Lines, _ = strconv.ParseUint(header.Get("Lines"), 10, 64)
+	return
+}
+
+// handleOverview serves GET /j/<Message-ID>.csv, the overview fields of the article parsed from its header as JSON,
+// for browser apps which cannot read the X-Usenet- headers of GET /h/ across origins. The bytes and lines come from OVER
+// when the header has no Bytes, and are left out if the server doesn't know them either.
+func (s *server) handleOverview(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
+	var (
+		err     error
+		nntpErr *nntp.Error
+		conn    *nntp.Conn
+		article *nntp.Article
+	)
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	etag := s.routeETag(r, messageID)
+	if done, _ := s.checkArticlePreconditions(w, r, messageID, etag); done {
+		return
+	}
+
+	defer func() {
+		if conn != nil {
+			if err == nil || errors.As(err, &nntpErr) {
+				s.pool.Put(conn)
+			} else {
+				s.pool.Close(conn)
+			}
+		} else {
+			closeArticle(article)
+		}
+	}()
+
+	if conn, article, err = s.lookup(r.Context(), messageID, false, "HEAD", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdHead(nntp.ArticleMessageID(messageID))
+	}); errors.Is(err, ErrArticleNotFound) {
+		logf(r.Context(), "[ERROR] OVERVIEW %s not found", messageID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] OVERVIEW %s %s", messageID, err.Error())
+		if !unavailable(w, err) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	fields := newArticleFields(messageID, article.Header)
+	if fields.Bytes == 0 && conn != nil {
+		var ov *nntp.ArticleOverview
+		if ov, err = overview(r.Context(), conn, messageID); err != nil && !errors.As(err, &nntpErr) {
+			logf(r.Context(), "[ERROR] OVERVIEW %s overview error: %s", messageID, err.Error())
+		} else if ov != nil {
+			fields.Bytes, fields.Lines = ov.Bytes, ov.Lines
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	setETag(w, etag)
+	json.NewEncoder(w).Encode(fields)
+	logf(r.Context(), "[INFO] OVERVIEW %s", messageID)
+}
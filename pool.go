@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
-	"log"
+	"net"
+	"sync"
 	"time"
 
 	"gopkg.in/nntp.v0"
@@ -17,74 +19,225 @@ type NNTPServer struct {
 	Pass        string
 	TLS         bool
 	Posting     bool
+	Feed        string
 	Connections uint64
+	RateLimit   int64
+	// MinIdleConnections is the number of idle connections kept open no matter how long they have been idle
+	MinIdleConnections uint64
+	// ModeReader sends MODE READER once connected, which some servers require before the article commands
+	ModeReader bool
+	// ReservedPostingConnections are the Connections only used for posting, the others only for reading, so neither
+	// can starve the other. 0 shares all the Connections.
+	ReservedPostingConnections uint64
+	// Retention is the number of days the server keeps articles, skipped for the articles known to be older. 0 means
+	// unlimited or unknown.
+	Retention int64
+	// Backfill servers are tried after the others, so they are only asked for the articles the others do not have,
+	// or are past the Retention of, like a block account of a provider with a longer retention.
+	Backfill bool
+	// PassFile and PassEnv set the Pass from a file or from an environment variable instead, so that it doesn't have to
+	// be in the config
+	PassFile string
+	PassEnv  string
 }
 
-func (n NNTPServer) newConn() (conn *nntp.Conn, err error) {
-	var d nntp.Dialer
+// defaultConnections is the number of Connections of a server not setting it.
+const defaultConnections = 50
+
+// newConn dials the server and authenticates, returning whether the server allows posting. If bucket is not nil, all
+// reads from the connection are paced through it. Dialing is abandoned once ctx is done.
+func (n NNTPServer) newConn(ctx context.Context, bucket *tokenBucket) (conn *nntp.Conn, posting bool, err error) {
+	var netConn net.Conn
 	if n.TLS {
-		conn, err = d.DialTLS(context.Background(), "tcp", n.Host)
+		netConn, err = (&tls.Dialer{}).DialContext(ctx, "tcp", n.Host)
 	} else {
-		conn, err = d.Dial(context.Background(), "tcp", n.Host)
+		netConn, err = (&net.Dialer{}).DialContext(ctx, "tcp", n.Host)
 	}
 	if err != nil {
 		return
 	}
+	if bucket != nil {
+		netConn = &throttledConn{Conn: netConn, bucket: bucket}
+	}
+	conn = nntp.NewConn(netConn)
+	if posting, err = readGreeting(conn); err != nil {
+		conn, _ = nil, conn.Close()
+		return
+	}
+	if n.ModeReader {
+		if posting, err = modeReader(conn); err != nil {
+			conn, _ = nil, conn.Close()
+			return
+		}
+	}
 	if n.User != "" {
 		if err = conn.CmdAuthinfo(n.User, n.Pass); err != nil {
 			conn, _ = nil, conn.Close()
 			return
 		}
 	}
+	if n.Feed == FeedTakeThis {
+		if err = modeStream(conn); err != nil {
+			conn, _ = nil, conn.Close()
+			return
+		}
+	}
 	return
 }
 
+// Pool hands out connections to the NNTP servers. Each server has its own serverPool with its own lock, so servers
+// never wait on each other, and Get gives up waiting for a connection once its context is done.
 type Pool struct {
-	servers    []NNTPServer
-	getChan    chan *poolGet
-	putChan    chan *nntp.Conn
-	closeChan  chan *nntp.Conn
-	idleExpiry time.Duration
+	servers  []*serverPool
+	rules    []poolRule
+	owners   sync.Map // map Conn to its serverPool
+	stop     chan struct{}
+	stopOnce sync.Once
+	purged   chan struct{} // closed once purge returns
+}
+
+// serverPool holds the connections to one server, counting both the idle ones and the ones in use.
+type serverPool struct {
+	server NNTPServer
+	// dial opens a new connection, newConn unless replaced
+	dial func(ctx context.Context) (*nntp.Conn, error)
+
+	mu      sync.Mutex
+	idles   []*poolIdle
+	count   uint64            // connections open or being dialed
+	waiters []chan poolResult // Gets waiting for a connection, in order
+	stopped bool
+	// drained is closed once the pool is stopped and all of its conns are closed
+	drained chan struct{}
+	// postingAllowed is whether the server allowed posting when the last connection was opened, nil until then
+	postingAllowed *bool
+	// posting holds the ReservedPostingConnections of a posting server, nil if it has none
+	posting *serverPool
+	// reserved is set on the posting pool of a server
+	reserved bool
+	// maxWaiters and maxWait are the limits of SetSaturation
+	maxWaiters int
+	maxWait    time.Duration
+	probeAfter time.Duration // of SetIdleProbe
+	waitAvg    time.Duration // moving average of the waits for a conn
+	rejected   uint64        // Gets given up on saturation
+	observers  poolObservers // of SetObservers
+	// user and pass authenticate the conns dialed, the ones of server unless replaced by SetCredentials
+	user, pass string
+	// owners are the ones of the Pool, for the idle conns found dead to be forgotten
+	owners *sync.Map
+}
+
+type poolIdle struct {
+	conn      *nntp.Conn
+	idleStart time.Time
 }
 
-var ErrNoMoreServers = errors.New("no more servers")
+// poolResult answers a waiting Get: a conn to reuse, an error, or neither, meaning a slot was freed for the waiter to
+// dial a new conn.
+type poolResult struct {
+	conn *nntp.Conn
+	err  error
+}
+
+var (
+	ErrNoMoreServers = errors.New("no more servers")
+	ErrPoolStopped   = errors.New("pool stopped")
+)
 
-func NewPool(servers []NNTPServer, idleExpiry time.Duration) *Pool {
+func NewPool(servers []NNTPServer, rules []BackendRule, idleExpiry time.Duration) *Pool {
 	p := &Pool{
-		servers:    make([]NNTPServer, len(servers)),
-		getChan:    make(chan *poolGet),
-		putChan:    make(chan *nntp.Conn),
-		closeChan:  make(chan *nntp.Conn),
-		idleExpiry: idleExpiry,
+		servers: make([]*serverPool, len(servers)),
+		stop:    make(chan struct{}),
+		purged:  make(chan struct{}),
 	}
 	for i := 0; i < len(servers); i++ {
-		p.servers[i] = servers[i]
-		if p.servers[i].Connections == 0 {
-			p.servers[i].Connections = 50
+		sp := &serverPool{server: servers[i], user: servers[i].User, pass: servers[i].Pass, owners: &p.owners}
+		if sp.server.Connections == 0 {
+			sp.server.Connections = defaultConnections
+		}
+		var bucket *tokenBucket
+		if sp.server.RateLimit > 0 {
+			bucket = newTokenBucket(sp.server.RateLimit)
+		}
+		sp.dial = sp.dialer(bucket)
+		if reserved := sp.server.ReservedPostingConnections; sp.server.Posting && reserved > 0 && reserved < sp.server.Connections {
+			// the bucket is shared, the reserved connections are paced with the others
+			sp.posting = &serverPool{server: sp.server, reserved: true, user: sp.user, pass: sp.pass, owners: &p.owners}
+			// MinIdleConnections only keeps reading connections open
+			sp.posting.server.Connections, sp.posting.server.MinIdleConnections = reserved, 0
+			sp.posting.dial = sp.posting.dialer(bucket)
+			sp.server.Connections -= reserved
+		}
+		p.servers[i] = sp
+	}
+	for i := range rules {
+		pr := poolRule{rule: &rules[i]}
+		for _, host := range rules[i].Servers {
+			for _, sp := range p.servers {
+				if sp.server.Host == host {
+					pr.servers = append(pr.servers, sp)
+				}
+			}
 		}
+		p.rules = append(p.rules, pr)
 	}
-	go p.loop()
+	go p.purge(idleExpiry)
 	return p
 }
 
-func (p *Pool) Get(posting bool, messageID nntp.MessageID, retry int) (conn *nntp.Conn, err error) {
-	// pseudo-randomly convert the message ID into a server index so we choose a server uniformly
-	// this also makes sure such selection is persistent for subsequent call for the same message ID
-	sum := sha256.Sum256([]byte(messageID))
-	r := int(binary.LittleEndian.Uint64(sum[:8]) % uint64(len(p.servers)))
+// dialer returns the function opening the conns of the pool, reading through bucket if not nil.
+func (sp *serverPool) dialer(bucket *tokenBucket) func(ctx context.Context) (*nntp.Conn, error) {
+	return func(ctx context.Context) (*nntp.Conn, error) {
+		server := sp.server
+		sp.mu.Lock()
+		server.User, server.Pass = sp.user, sp.pass
+		sp.mu.Unlock()
+		conn, posting, err := server.newConn(ctx, bucket)
+		if err == nil {
+			sp.greeted(posting)
+		}
+		return conn, err
+	}
+}
+
+// all returns the pools of the servers, each followed by the one of its ReservedPostingConnections if any.
+func (p *Pool) all() (pools []*serverPool) {
+	for _, sp := range p.servers {
+		pools = append(pools, sp)
+		if sp.posting != nil {
+			pools = append(pools, sp.posting)
+		}
+	}
+	return
+}
+
+// Get returns a conn to a server chosen for the message-id, skipping the first retry servers tried, and only choosing
+// among the posting servers, on their ReservedPostingConnections if any, if posting is set. The servers are tried in
+// the order of order, or only the one a fetch is forced to by ctx, waiting for a conn until ctx is done.
+func (p *Pool) Get(ctx context.Context, posting bool, messageID nntp.MessageID, retry int) (conn *nntp.Conn, err error) {
+	r := p.first(messageID)
 	// however if the caller desires a different server, possibly due to content availability issues,
 	// iterate through the server list to find another one.
 	tries := 0
-	ret := make(chan *poolResult)
-	for i := 0; i < len(p.servers); i++ {
-		n := (i + r) % len(p.servers)
-		server := &p.servers[n]
-		if server.Posting || !posting {
+	preferred, age := "", time.Duration(0)
+	if !posting {
+		preferred, age = preferredServer(ctx), articleAge(ctx)
+	}
+	servers := p.order(messageID, r, preferred, age, !posting)
+	if forced := forcedServer(ctx); forced != "" && !posting {
+		servers = p.only(forced)
+	}
+	for _, sp := range servers {
+		if sp.server.Posting || !posting {
 			tries++
+			if posting && sp.posting != nil {
+				sp = sp.posting
+			}
 			if tries > retry {
-				p.getChan <- &poolGet{n, ret}
-				result := <-ret
-				conn, err = result.conn, result.err
+				if conn, err = sp.get(ctx); err == nil {
+					p.owners.Store(conn, sp)
+				}
 				return
 			}
 		}
@@ -94,138 +247,413 @@ func (p *Pool) Get(posting bool, messageID nntp.MessageID, retry int) (conn *nnt
 	return
 }
 
+// first returns the index of the server tried first for the message-id, before the preferred ones and the Backfill
+// ones are accounted for.
+func (p *Pool) first(messageID nntp.MessageID) int {
+	// pseudo-randomly convert the message ID into a server index so we choose a server uniformly
+	// this also makes sure such selection is persistent for subsequent call for the same message ID
+	sum := sha256.Sum256([]byte(messageID))
+	return int(binary.LittleEndian.Uint64(sum[:8]) % uint64(len(p.servers)))
+}
+
+// GetFrom returns a conn to the server of the index in the servers of the pool, for the requests about a given server
+// rather than an article, waiting for a conn to be free like Get. Its ReservedPostingConnections are never used.
+func (p *Pool) GetFrom(ctx context.Context, index int) (conn *nntp.Conn, err error) {
+	if index < 0 || index >= len(p.servers) {
+		err = ErrNoMoreServers
+		return
+	}
+	sp := p.servers[index]
+	if conn, err = sp.get(ctx); err == nil {
+		p.owners.Store(conn, sp)
+	}
+	return
+}
+
+// Server returns the definition of the server the conn is connected to.
+func (p *Pool) Server(conn *nntp.Conn) (server NNTPServer, ok bool) {
+	var sp any
+	if sp, ok = p.owners.Load(conn); ok {
+		server = sp.(*serverPool).server
+	}
+	return
+}
+
+// HasUser reports whether the server the conn is connected to has a User to authenticate with.
+func (p *Pool) HasUser(conn *nntp.Conn) bool {
+	owner, ok := p.owners.Load(conn)
+	if !ok {
+		return false
+	}
+	sp := owner.(*serverPool)
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.user != ""
+}
+
+// Reauthenticate runs AUTHINFO again on the conn with the current credentials of its server, for a session the server
+// expired. It fails if the server has no User.
+func (p *Pool) Reauthenticate(conn *nntp.Conn) error {
+	owner, ok := p.owners.Load(conn)
+	if !ok {
+		return errors.New("conn not from the pool")
+	}
+	sp := owner.(*serverPool)
+	sp.mu.Lock()
+	user, pass := sp.user, sp.pass
+	sp.mu.Unlock()
+	if user == "" {
+		return errors.New("no User to authenticate with")
+	}
+	return conn.CmdAuthinfo(user, pass)
+}
+
+// poolStats is the state of the connections to a server.
+type poolStats struct {
+	Host        string `json:"host"`
+	TLS         bool   `json:"tls"`
+	Posting     bool   `json:"posting"`
+	Feed        string `json:"feed"`
+	Connections uint64 `json:"connections"` // the limit
+	Open        uint64 `json:"open"`        // open or being dialed, including the idle ones
+	Idle        int    `json:"idle"`
+	Waiting     int    `json:"waiting"`  // Gets waiting for a conn
+	Reserved    bool   `json:"reserved"` // the ReservedPostingConnections of the server
+	// AvgWaitMs is the moving average of the waits for a conn in milliseconds, Rejected the Gets given up on saturation
+	AvgWaitMs int64  `json:"avgWaitMs"`
+	Rejected  uint64 `json:"rejected"`
+	// PostingAllowed is whether the server allowed posting when the last connection was opened, nil until then
+	PostingAllowed *bool `json:"postingAllowed"`
+	// PostingMismatch is set if PostingAllowed contradicts Posting
+	PostingMismatch bool `json:"postingMismatch"`
+}
+
+// Stats returns the state of every server, in the order of the NNTPServers, each followed by the state of its
+// ReservedPostingConnections if any.
+func (p *Pool) Stats() (stats []poolStats) {
+	for _, sp := range p.all() {
+		sp.mu.Lock()
+		stats = append(stats, poolStats{
+			Host:        sp.server.Host,
+			TLS:         sp.server.TLS,
+			Posting:     sp.server.Posting,
+			Feed:        sp.server.Feed,
+			Connections: sp.server.Connections,
+			Open:        sp.count,
+			Idle:        len(sp.idles),
+			Waiting:     len(sp.waiters),
+			Reserved:    sp.reserved,
+			AvgWaitMs:   sp.waitAvg.Milliseconds(),
+			Rejected:    sp.rejected,
+
+			PostingAllowed:  sp.postingAllowed,
+			PostingMismatch: sp.postingMismatch(),
+		})
+		sp.mu.Unlock()
+	}
+	return
+}
+
+// Put gives a conn back to the pool once the caller is done with it.
 func (p *Pool) Put(conn *nntp.Conn) {
-	p.putChan <- conn
+	if sp, ok := p.owners.Load(conn); ok && sp.(*serverPool).put(conn) {
+		p.owners.Delete(conn)
+	}
 }
 
+// Close closes a broken conn, freeing its slot.
 func (p *Pool) Close(conn *nntp.Conn) (err error) {
 	err = conn.Close()
-	p.closeChan <- conn
+	if sp, ok := p.owners.LoadAndDelete(conn); ok {
+		sp.(*serverPool).release()
+	}
 	return
 }
 
-type poolGet struct {
-	i      int
-	result chan<- *poolResult
+// Stop closes the idle conns and fails the Gets waiting and to come with ErrPoolStopped, then waits for the conns in use
+// to be given back, closing them, and for the purge of the idle conns to end. Once ctx is done, the conns still in use
+// are closed under their holders, failing their commands, and ctx.Err() is returned. Stopping the pool again only waits
+// again.
+func (p *Pool) Stop(ctx context.Context) (err error) {
+	p.stopOnce.Do(p.stopServers)
+	for _, sp := range p.all() {
+		select {
+		case <-sp.drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		select {
+		case <-p.purged:
+			return
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	inUse := 0
+	p.owners.Range(func(conn, _ any) bool {
+		conn.(*nntp.Conn).Close()
+		inUse++
+		return true
+	})
+	if inUse > 0 {
+		logPrintf("[WARN] [Pool] stopped with %d connections still in use, closed", inUse)
+	}
+	return
 }
 
-type poolResult struct {
-	conn *nntp.Conn
-	err  error
+func (p *Pool) stopServers() {
+	close(p.stop)
+	for _, sp := range p.all() {
+		sp.mu.Lock()
+		sp.stopped = true
+		idles, waiters := sp.idles, sp.waiters
+		sp.idles, sp.waiters = nil, nil
+		sp.count -= uint64(len(idles))
+		sp.drained = make(chan struct{})
+		sp.drain()
+		sp.mu.Unlock()
+		for _, idle := range idles {
+			p.owners.Delete(idle.conn)
+			idle.conn.Close()
+		}
+		for _, waiter := range waiters {
+			waiter <- poolResult{err: ErrPoolStopped}
+		}
+	}
 }
 
-type poolDeferred struct {
-	req  *poolGet
-	resp *poolResult
+// purgeInterval returns how often idle conns are checked for expiry: a quarter of idleExpiry, so conns are closed at most
+// a quarter late, but between once a second and once a minute.
+func purgeInterval(idleExpiry time.Duration) (interval time.Duration) {
+	interval = idleExpiry / 4
+	if interval < time.Second {
+		interval = time.Second
+	} else if interval > time.Minute {
+		interval = time.Minute
+	}
+	return
 }
 
-type poolIdle struct {
-	conn      *nntp.Conn
-	idleStart time.Time
+// purge closes the conns idle for longer than idleExpiry until the pool is stopped.
+func (p *Pool) purge(idleExpiry time.Duration) {
+	defer close(p.purged)
+	ticker := time.NewTicker(purgeInterval(idleExpiry))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		expired := time.Now().Add(-idleExpiry)
+		for _, sp := range p.all() {
+			observers := sp.observe()
+			for _, conn := range sp.expire(expired) {
+				p.owners.Delete(conn)
+				conn.Close()
+				observers.OnPurge(sp.server.Host)
+			}
+		}
+	}
 }
 
-func (p *Pool) loop() {
-	connMap := make(map[*nntp.Conn]int) // map Conn to its server index
-	// holds the conns being idle
-	idles := make([][]*poolIdle, len(p.servers))
-	// counters of created conns both active and idle, for each server
-	counters := make([]uint64, len(p.servers))
-	queue := make([][]*poolGet, len(p.servers))
-	deferredChan := make(chan *poolDeferred)
-	processGet := func(req *poolGet) (consumed bool) {
-		server := &p.servers[req.i]
-		// search for idle conn first
-		if len(idles[req.i]) > 0 {
-			idle := idles[req.i][0]
-			idles[req.i] = idles[req.i][1:]
-			req.result <- &poolResult{conn: idle.conn}
-			log.Printf("[Pool] %s - REASSIGNED connection, total %d", server.Host, counters[req.i])
-			consumed = true
-		} else if counters[req.i] < server.Connections {
-			// no idle conn, but still has slot left, go secure it
-			counters[req.i]++
-			// create new conn on another thread
-			go func() {
-				conn, err := p.servers[req.i].newConn()
-				deferredChan <- &poolDeferred{req: req, resp: &poolResult{conn, err}}
-			}()
-			consumed = true
+func (sp *serverPool) get(ctx context.Context) (conn *nntp.Conn, err error) {
+	sp.mu.Lock()
+	observers := sp.observers
+	defer func() {
+		if err != nil {
+			observers.OnError(sp.server.Host, err)
 		}
+	}()
+	if sp.stopped {
+		sp.mu.Unlock()
+		err = ErrPoolStopped
 		return
 	}
-	processQueue := func(i int) {
-		for j := 0; j < len(queue[i]); j++ {
-			if !processGet(queue[i][j]) {
-				queue[i] = queue[i][j:]
-				break
-			}
+	if len(sp.idles) > 0 {
+		// search for idle conn first, the most recently used one, so the least recently used ones expire
+		idle := sp.idles[len(sp.idles)-1]
+		sp.idles = sp.idles[:len(sp.idles)-1]
+		total, probeAfter := sp.count, sp.probeAfter
+		sp.mu.Unlock()
+		if sp.probe(idle, probeAfter, observers) != nil {
+			// dead, dial its replacement in its slot
+			return sp.open(ctx, observers)
 		}
+		logPrintf("[DEBUG] [Pool] %s - REASSIGNED connection, total %d", sp.server.Host, total)
+		observers.OnReuse(sp.server.Host)
+		conn = idle.conn
+		return
 	}
-	timer := time.NewTimer(time.Minute)
-	for {
-		select {
-		case get := <-p.getChan:
-			// handle Get commands
-			if !processGet(get) {
-				// slots are full, append to queue
-				queue[get.i] = append(queue[get.i], get)
-			}
+	if sp.count < sp.server.Connections {
+		// no idle conn, but still has slot left, go secure it
+		sp.count++
+		sp.mu.Unlock()
+		return sp.open(ctx, observers)
+	}
+	if sp.maxWaiters > 0 && len(sp.waiters) >= sp.maxWaiters {
+		err = sp.saturated()
+		sp.mu.Unlock()
+		return
+	}
+	// slots are full, wait in line
+	waiter := make(chan poolResult, 1)
+	sp.waiters = append(sp.waiters, waiter)
+	var timeout <-chan time.Time
+	if sp.maxWait > 0 {
+		timer := time.NewTimer(sp.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	sp.mu.Unlock()
 
-		case conn := <-p.putChan:
-			// handle Put commands
-			if i, ok := connMap[conn]; ok {
-				// check for queued Get requests
-				if len(queue[i]) > 0 {
-					get := queue[i][0]
-					queue[i] = queue[i][1:]
-					get.result <- &poolResult{conn: conn}
-					log.Printf("[Pool] %s - RECYCLED connection, total %d", p.servers[i].Host, counters[i])
-				} else {
-					idles[i] = append(idles[i], &poolIdle{conn, time.Now()})
-					log.Printf("[Pool] %s - IDLED connection, total %d", p.servers[i].Host, counters[i])
-				}
-			}
+	var result poolResult
+	start := time.Now()
+	select {
+	case result = <-waiter:
+		wait := time.Since(start)
+		sp.waited(wait)
+		observers.OnQueueWait(sp.server.Host, wait)
+	case <-ctx.Done():
+		sp.abandon(waiter)
+		err = ctx.Err()
+		return
+	case <-timeout:
+		sp.abandon(waiter)
+		sp.mu.Lock()
+		err = sp.saturated()
+		sp.mu.Unlock()
+		return
+	}
+	if result.conn == nil && result.err == nil {
+		// a slot was freed for us
+		return sp.open(ctx, observers)
+	}
+	if conn, err = result.conn, result.err; conn != nil {
+		observers.OnReuse(sp.server.Host)
+	}
+	return
+}
 
-		case conn := <-p.closeChan:
-			// handle Close commands
-			if i, ok := connMap[conn]; ok {
-				counters[i]--
-				delete(connMap, conn)
-				log.Printf("[Pool] %s - CLOSED connection, total %d", p.servers[i].Host, counters[i])
-				processQueue(i)
-			}
+// abandon removes a Get giving up from the waiters, or passes on what was already handed over to it.
+func (sp *serverPool) abandon(waiter chan poolResult) {
+	sp.mu.Lock()
+	for i := range sp.waiters {
+		if sp.waiters[i] == waiter {
+			sp.waiters = append(sp.waiters[:i], sp.waiters[i+1:]...)
+			sp.mu.Unlock()
+			return
+		}
+	}
+	sp.mu.Unlock()
+	// too late, something was already handed over, pass it on
+	if result := <-waiter; result.conn != nil {
+		sp.put(result.conn)
+	} else if result.err == nil {
+		sp.free()
+	}
+}
 
-		case result := <-deferredChan:
-			// handle allocation result
-			if result.resp.err == nil && result.resp.conn != nil {
-				connMap[result.resp.conn] = result.req.i
-				log.Printf("[Pool] %s - NEW connection, total %d", p.servers[result.req.i].Host, counters[result.req.i])
-			}
-			result.req.result <- result.resp
-			if result.resp.err != nil {
-				// allocation failed, release slot
-				log.Printf("[Pool] %s - FAILED connection, total %d", p.servers[result.req.i].Host, counters[result.req.i])
-				counters[result.req.i]--
-				processQueue(result.req.i)
-			}
+// open dials a new conn in a slot already counted, releasing the slot if it fails. The observers are told about the
+// conn dialed, the caller telling about the failures.
+func (sp *serverPool) open(ctx context.Context, observers poolObservers) (conn *nntp.Conn, err error) {
+	start := time.Now()
+	if conn, err = sp.dial(ctx); err != nil {
+		sp.mu.Lock()
+		total := sp.count - 1
+		sp.mu.Unlock()
+		logPrintf("[WARN] [Pool] %s - FAILED connection, total %d: %s", sp.server.Host, total, err.Error())
+		sp.free()
+		return
+	}
+	sp.mu.Lock()
+	total := sp.count
+	sp.mu.Unlock()
+	logPrintf("[DEBUG] [Pool] %s - NEW connection, total %d", sp.server.Host, total)
+	observers.OnDial(sp.server.Host, time.Since(start))
+	return
+}
 
-		case <-timer.C:
-			// handle idle purge timer
-			expired := time.Now().Add(-p.idleExpiry)
-			for i, arr := range idles {
-				var newArr []*poolIdle
-				for _, idle := range arr {
-					if idle.idleStart.After(expired) {
-						newArr = append(newArr, idle)
-					} else {
-						idle.conn.Close()
-						counters[i]--
-						log.Printf("[Pool] %s - PURGED connection, total %d", p.servers[i].Host, counters[i])
-					}
-				}
-				idles[i] = newArr
-			}
-			timer = time.NewTimer(time.Minute)
+// put hands the conn to the first waiting Get, or keeps it idle. The conn is closed instead if the pool is stopped.
+func (sp *serverPool) put(conn *nntp.Conn) (closed bool) {
+	sp.mu.Lock()
+	if sp.stopped {
+		sp.count--
+		sp.drain()
+		sp.mu.Unlock()
+		conn.Close()
+		closed = true
+		return
+	}
+	total := sp.count
+	if len(sp.waiters) > 0 {
+		waiter := sp.waiters[0]
+		sp.waiters = sp.waiters[1:]
+		sp.mu.Unlock()
+		waiter <- poolResult{conn: conn}
+		logPrintf("[DEBUG] [Pool] %s - RECYCLED connection, total %d", sp.server.Host, total)
+		return
+	}
+	sp.idles = append(sp.idles, &poolIdle{conn, time.Now()})
+	sp.mu.Unlock()
+	logPrintf("[DEBUG] [Pool] %s - IDLED connection, total %d", sp.server.Host, total)
+	return
+}
+
+// release frees the slot of a closed conn.
+func (sp *serverPool) release() {
+	total := sp.free()
+	logPrintf("[DEBUG] [Pool] %s - CLOSED connection, total %d", sp.server.Host, total)
+}
+
+// free gives up a slot, handing it to the first waiting Get. It returns the number of conns left.
+func (sp *serverPool) free() (total uint64) {
+	sp.mu.Lock()
+	sp.count--
+	total = sp.count
+	var waiter chan poolResult
+	if len(sp.waiters) > 0 && !sp.stopped {
+		waiter = sp.waiters[0]
+		sp.waiters = sp.waiters[1:]
+		sp.count++
+	}
+	sp.drain()
+	sp.mu.Unlock()
+	if waiter != nil {
+		waiter <- poolResult{}
+	}
+	return
+}
+
+// drain closes sp.drained once the pool is stopped and has no conn left. sp.mu must be held.
+func (sp *serverPool) drain() {
+	if sp.stopped && sp.count == 0 {
+		select {
+		case <-sp.drained:
+		default:
+			close(sp.drained)
 		}
 	}
 }
+
+// expire removes the conns idle since before expired, least recently used first, but keeps MinIdleConnections of them.
+// The conns removed are returned for the caller to close.
+func (sp *serverPool) expire(expired time.Time) (conns []*nntp.Conn) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	// idles are appended as they are put back, so they are sorted from the least recently used
+	n := 0
+	for n < len(sp.idles) && uint64(len(sp.idles)-n) > sp.server.MinIdleConnections && !sp.idles[n].idleStart.After(expired) {
+		conns = append(conns, sp.idles[n].conn)
+		sp.count--
+		logPrintf("[DEBUG] [Pool] %s - PURGED connection, total %d", sp.server.Host, sp.count)
+		n++
+	}
+	sp.idles = append([]*poolIdle(nil), sp.idles[n:]...)
+	return
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+// pipePool is a Pool of one server whose conns are net.Pipe ones, their server side only read until closed.
+type pipePool struct {
+	*Pool
+	dials  atomic.Int32
+	mu     sync.Mutex
+	closed map[*nntp.Conn]chan struct{} // closed once the conn is
+}
+
+func newPipePool(t testing.TB, connections uint64) (p *pipePool) {
+	p = &pipePool{
+		Pool:   NewPool([]NNTPServer{{Host: "pipe", Connections: connections}}, nil, time.Minute),
+		closed: make(map[*nntp.Conn]chan struct{}),
+	}
+	p.servers[0].dial = func(ctx context.Context) (*nntp.Conn, error) {
+		client, server := net.Pipe()
+		conn := nntp.NewConn(client)
+		closed := make(chan struct{})
+		p.mu.Lock()
+		p.closed[conn] = closed
+		p.mu.Unlock()
+		p.dials.Add(1)
+		go func() {
+			io.Copy(io.Discard, server)
+			server.Close()
+			close(closed)
+		}()
+		return conn, nil
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		p.Stop(ctx)
+	})
+	return
+}
+
+// isClosed reports whether the conn was closed, waiting a little for its server side to notice.
+func (p *pipePool) isClosed(conn *nntp.Conn) bool {
+	p.mu.Lock()
+	closed := p.closed[conn]
+	p.mu.Unlock()
+	select {
+	case <-closed:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+// waiting waits for n Gets to be waiting for a conn.
+func (p *pipePool) waiting(t testing.TB, n int) {
+	for deadline := time.Now().Add(time.Second); p.Stats()[0].Waiting != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d Gets waiting, want %d", p.Stats()[0].Waiting, n)
+		}
+	}
+}
+
+func TestPoolConcurrentGetPut(t *testing.T) {
+	const connections = 3
+	p := newPipePool(t, connections)
+	var inUse atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				ctx, cancel := context.Background(), context.CancelFunc(func() {})
+				if j%3 == 0 {
+					// given up on, sometimes as a conn is handed over
+					ctx, cancel = context.WithTimeout(ctx, time.Duration(j%5)*time.Microsecond)
+				}
+				conn, err := p.Get(ctx, false, "a@example.com", 0)
+				cancel()
+				if err != nil {
+					if !errors.Is(err, context.DeadlineExceeded) {
+						t.Errorf("Get: %v", err)
+					}
+					continue
+				}
+				if n := inUse.Add(1); n > connections {
+					t.Errorf("%d conns in use, limit %d", n, connections)
+				}
+				runtime.Gosched()
+				inUse.Add(-1)
+				if (i+j)%7 == 0 {
+					p.Close(conn)
+				} else {
+					p.Put(conn)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	stats := p.Stats()[0]
+	if stats.Open > connections || stats.Waiting != 0 || uint64(stats.Idle) != stats.Open {
+		t.Errorf("after the Gets %+v", stats)
+	}
+}
+
+func TestPoolWaiterWakeUp(t *testing.T) {
+	p := newPipePool(t, 1)
+	conn, err := p.Get(context.Background(), false, "a@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan *nntp.Conn)
+	get := func() {
+		conn, err := p.Get(context.Background(), false, "a@example.com", 0)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- conn
+	}
+
+	// a conn put back goes to the waiter as it is
+	go get()
+	p.waiting(t, 1)
+	p.Put(conn)
+	if woken := <-got; woken != conn {
+		t.Error("the waiter did not get the conn put back")
+	}
+
+	// a conn closed frees its slot for the waiter to dial in
+	go get()
+	p.waiting(t, 1)
+	p.Close(conn)
+	woken := <-got
+	if woken == conn || woken == nil {
+		t.Error("the waiter did not dial a new conn")
+	}
+	if n := p.dials.Load(); n != 2 {
+		t.Errorf("%d dials, want 2", n)
+	}
+
+	// a waiter giving up leaves the line
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := p.Get(ctx, false, "a@example.com", 0)
+		done <- err
+	}()
+	p.waiting(t, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Get given up on: %v", err)
+	}
+	p.waiting(t, 0)
+	p.Put(woken)
+	if stats := p.Stats()[0]; stats.Open != 1 || stats.Idle != 1 {
+		t.Errorf("after the waiter gave up %+v", stats)
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	p := newPipePool(t, 2)
+	var conns []*nntp.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := p.Get(context.Background(), false, "a@example.com", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	waiter := make(chan error)
+	go func() {
+		_, err := p.Get(context.Background(), false, "a@example.com", 0)
+		waiter <- err
+	}()
+	p.waiting(t, 1)
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- p.Stop(context.Background())
+	}()
+	if err := <-waiter; !errors.Is(err, ErrPoolStopped) {
+		t.Errorf("Get waiting as the pool stopped: %v", err)
+	}
+	if _, err := p.Get(context.Background(), false, "a@example.com", 0); !errors.Is(err, ErrPoolStopped) {
+		t.Errorf("Get after Stop: %v", err)
+	}
+
+	// Stop waits for the conns in use
+	p.Put(conns[0])
+	if !p.isClosed(conns[0]) {
+		t.Error("conn put back after Stop not closed")
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop returned %v with a conn in use", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.Close(conns[1])
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Stop: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return once the conns were given back")
+	}
+}
+
+func TestPoolStopDeadline(t *testing.T) {
+	p := newPipePool(t, 2)
+	conn, err := p.Get(context.Background(), false, "a@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop with a conn in use: %v", err)
+	}
+	// closed under its holder
+	if !p.isClosed(conn) {
+		t.Error("conn in use not closed at the deadline")
+	}
+	p.Close(conn)
+}
+
+func BenchmarkPoolGetPut(b *testing.B) {
+	p := newPipePool(b, 8)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := p.Get(context.Background(), false, "a@example.com", 0)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			p.Put(conn)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// errBadPostHeader is wrapped by the errors of postHeader, the poster's fault.
+var errBadPostHeader = errors.New("bad post header")
+
+// restrictedPostHeaders are the article headers a post cannot set unless listed in AllowedPostHeaders: they route the
+// article, or act on other articles.
+var restrictedPostHeaders = []string{"Path", "Control", "Supersedes"}
+
+// singlePostHeaders are the article headers a post can only set once.
+var singlePostHeaders = []string{"From", "Newsgroups", "Subject", "Message-Id"}
+
+// sanitizeHeaderValue removes the CR, LF and NUL characters from a header value, which would otherwise end the header
+// and inject others on the wire.
+func sanitizeHeaderValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "", "\x00", "").Replace(value)
+}
+
+// postHeader returns the article headers of a post: the X-Usenet- HTTP headers without their prefix, then the From,
+// Newsgroups and Subject ones from the f, g and s query parameters, filled in by fillPostHeader, the From one with the
+// sender of the client certificate of the poster if any. The values are
+// sanitized, and the post is rejected with an error wrapping errBadPostHeader if it sets a restricted header, sets a
+// header twice, in particular from both an HTTP header and a query parameter with different values, sets a Message-ID
+// other than the one of the URL, or a From header that is not an RFC 5322 address.
+func (s *server) postHeader(r *http.Request, messageID nntp.MessageID) (header textproto.MIMEHeader, err error) {
+	header = make(textproto.MIMEHeader)
+	for key, values := range r.Header {
+		if !strings.HasPrefix(key, "X-Usenet-") || len(key) <= 9 {
+			continue
+		}
+		key = key[9:]
+		if s.restrictedPostHeader(key) {
+			err = fmt.Errorf("%w: the %s header is not allowed", errBadPostHeader, key)
+			return
+		}
+		for _, value := range values {
+			header.Add(key, sanitizeHeaderValue(value))
+		}
+	}
+	for _, key := range singlePostHeaders {
+		if len(header[key]) > 1 {
+			err = fmt.Errorf("%w: more than one %s header", errBadPostHeader, key)
+			return
+		}
+	}
+	if id := header.Get("Message-Id"); id != "" && id != string(messageID.Full()) {
+		err = fmt.Errorf("%w: the Message-ID header %s is not the one of the URL", errBadPostHeader, id)
+		return
+	}
+	query := r.URL.Query()
+	if err = conflictingPostHeaders(header, query); err != nil {
+		return
+	}
+	from := sanitizeHeaderValue(query.Get("f"))
+	if id := identity(r.Context()); from == "" && id != nil {
+		from = id.From
+	}
+	if err = s.fillPostHeader(header, messageID, from, sanitizeHeaderValue(query.Get("g")), sanitizeHeaderValue(query.Get("s"))); err != nil {
+		return
+	}
+	if _, fromErr := mail.ParseAddress(header.Get("From")); fromErr != nil {
+		err = fmt.Errorf("%w: invalid From header: %s", errBadPostHeader, fromErr.Error())
+	}
+	return
+}
+
+// conflictingPostHeaders fails if a header is set both as an HTTP header and by its query parameter, with different
+// values.
+func conflictingPostHeaders(header textproto.MIMEHeader, query url.Values) error {
+	for param, key := range map[string]string{"f": "From", "g": "Newsgroups", "s": "Subject"} {
+		if value := sanitizeHeaderValue(query.Get(param)); value != "" && header.Get(key) != "" && header.Get(key) != value {
+			return fmt.Errorf("%w: the %s header and the %s query parameter differ", errBadPostHeader, key, param)
+		}
+	}
+	return nil
+}
+
+// restrictedPostHeader reports whether a post cannot set the header, see AllowedPostHeaders.
+func (s *server) restrictedPostHeader(key string) bool {
+	for _, allowed := range s.AllowedPostHeaders {
+		if strings.EqualFold(allowed, key) {
+			return false
+		}
+	}
+	for _, restricted := range restrictedPostHeaders {
+		if strings.EqualFold(restricted, key) {
+			return true
+		}
+	}
+	return false
+}
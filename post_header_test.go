@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzSanitizeHeaderValue(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"plain value",
+		"value\r\nInjected: header",
+		"value\nInjected: header",
+		"value\rwith a CR",
+		"nul\x00byte",
+		"\r\n\r\n",
+		"caf\xc3\xa9 \xff",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		sanitized := sanitizeHeaderValue(value)
+		if strings.ContainsAny(sanitized, "\r\n\x00") {
+			t.Fatalf("sanitizeHeaderValue(%q) = %q", value, sanitized)
+		}
+		// only the CR, LF and NUL bytes are removed, the others kept in order
+		var want strings.Builder
+		for i := 0; i < len(value); i++ {
+			if c := value[i]; c != '\r' && c != '\n' && c != 0 {
+				want.WriteByte(c)
+			}
+		}
+		if sanitized != want.String() {
+			t.Fatalf("sanitizeHeaderValue(%q) = %q, want %q", value, sanitized, want.String())
+		}
+		if again := sanitizeHeaderValue(sanitized); again != sanitized {
+			t.Fatalf("sanitizeHeaderValue(%q) = %q, not idempotent", sanitized, again)
+		}
+	})
+}
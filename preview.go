@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/nntp.v0"
+)
+
+// defaultPreviewBytes is the size of a preview when the bytes query parameter is not given.
+const defaultPreviewBytes = 4096
+
+// handlePreview serves GET /p/<Message-ID>.csv, the first bytes of the article body, 4096 or the bytes query parameter
+// up to ArticleSizeLimit, so a UI can show the start of a segment without transferring it whole. Only as much of the
+// body is read with BODY, then the connection is closed rather than drained of the rest. X-Usebin-Truncated tells
+// whether the body is longer than the preview.
+func (s *server) handlePreview(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
+	var (
+		err     error
+		nntpErr *nntp.Error
+		conn    *nntp.Conn
+		article *nntp.Article
+		n       int
+	)
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultPreviewBytes
+	if param := r.URL.Query().Get("bytes"); param != "" {
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if uint64(limit) > s.ArticleSizeLimit {
+		limit = int(s.ArticleSizeLimit)
+	}
+	etag := s.routeETag(r, messageID)
+	if done, _ := s.checkArticlePreconditions(w, r, messageID, etag); done {
+		return
+	}
+
+	truncated := false
+	defer func() {
+		if conn != nil {
+			if (err == nil || errors.As(err, &nntpErr)) && !truncated {
+				s.pool.Put(conn)
+			} else {
+				// the rest of the body would have to be read by the next command
+				s.pool.Close(conn)
+			}
+		} else {
+			closeArticle(article)
+		}
+	}()
+
+	if conn, article, err = s.lookup(r.Context(), messageID, false, "BODY", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdBody(nntp.ArticleMessageID(messageID))
+	}); errors.Is(err, ErrArticleNotFound) {
+		logf(r.Context(), "[ERROR] PREVIEW %s not found", messageID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] PREVIEW %s %s", messageID, err.Error())
+		if !unavailable(w, err) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// more bytes tell whether the body goes on. Three leave room for the terminating ".\r\n" line of a body of the
+	// preview size, which the dot reader would not recognize if the buffer ended within it
+	buf := make([]byte, limit+3)
+	if n, err = io.ReadFull(article.Body, buf); err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] PREVIEW %s read error: %s", messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if truncated = n > limit; truncated {
+		n = limit
+	}
+	w.Header().Set("Content-Type", s.setContentType(w, article.Header, buf[:n], "text/plain; charset=utf-8"))
+	w.Header().Set("X-Usebin-Truncated", strconv.FormatBool(truncated))
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	setETag(w, etag)
+	w.WriteHeader(http.StatusOK)
+	if _, writeErr := w.Write(buf[:n]); writeErr != nil && !clientGone(r, writeErr) {
+		logf(r.Context(), "[ERROR] PREVIEW %s write error: %s", messageID, writeErr.Error())
+	}
+	logf(r.Context(), "[INFO] PREVIEW %s %d bytes", messageID, n)
+}
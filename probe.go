@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+// idleProbeTimeout is the time an idle conn probed has to answer DATE before it is deemed dead.
+const idleProbeTimeout = 5 * time.Second
+
+var errProbeTimeout = errors.New("no answer to DATE")
+
+// SetIdleProbe makes the Gets probe an idle conn with DATE before handing it out if it has been idle for at least
+// after, closing it if it doesn't answer, like after a NAT timeout or the server closing it, and dialing a new conn in
+// its place. 0 hands out the idle conns without probing them.
+func (p *Pool) SetIdleProbe(after time.Duration) {
+	for _, sp := range p.all() {
+		sp.mu.Lock()
+		sp.probeAfter = after
+		sp.mu.Unlock()
+	}
+}
+
+// probeIdle sends DATE on an idle conn, closing it if it gets no answer within idleProbeTimeout. A server not knowing
+// DATE answers with an error, which is enough to tell the conn is alive, unless it is a 4xx the server closes the conn
+// after, like 400 for its idle timeout.
+func probeIdle(conn *nntp.Conn) (err error) {
+	timer := time.AfterFunc(idleProbeTimeout, func() {
+		conn.Close()
+	})
+	_, err = conn.CmdDate()
+	if !timer.Stop() && err == nil {
+		// the answer came as the conn was closed
+		err = errProbeTimeout
+	}
+	var nntpErr *nntp.Error
+	if errors.As(err, &nntpErr) && nntpErr.Code >= 500 {
+		err = nil
+	}
+	return
+}
+
+// probe checks an idle conn popped by get, if it has been idle for sp.probeAfter. A dead conn is closed and forgotten,
+// its slot left for the caller to dial a new conn in.
+func (sp *serverPool) probe(idle *poolIdle, probeAfter time.Duration, observers poolObservers) (err error) {
+	idleTime := time.Since(idle.idleStart)
+	if probeAfter <= 0 || idleTime < probeAfter {
+		return
+	}
+	if err = probeIdle(idle.conn); err == nil {
+		return
+	}
+	sp.owners.Delete(idle.conn)
+	idle.conn.Close()
+	logPrintf("[WARN] [Pool] %s - DEAD connection after %s idle, replacing it: %s", sp.server.Host,
+		idleTime.Round(time.Second), err.Error())
+	observers.OnPurge(sp.server.Host)
+	return
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+type recentPost struct {
+	messageID nntp.MessageID
+	posted    time.Time
+}
+
+// recentPosts remembers the articles posted in the last PropagationWindow, so that a request missing one of them is
+// told to try again later rather than that it is missing, the NNTP servers read from possibly not having it yet.
+type recentPosts struct {
+	mu     sync.Mutex
+	window time.Duration
+	queue  []recentPost // in the order they were posted
+	posted map[nntp.MessageID]time.Time
+}
+
+func newRecentPosts(window time.Duration) *recentPosts {
+	return &recentPosts{window: window, posted: make(map[nntp.MessageID]time.Time)}
+}
+
+// expire forgets the articles posted before the window, the caller holding mu.
+func (rp *recentPosts) expire(now time.Time) {
+	i := 0
+	for ; i < len(rp.queue) && now.Sub(rp.queue[i].posted) >= rp.window; i++ {
+		if post := rp.queue[i]; rp.posted[post.messageID].Equal(post.posted) {
+			delete(rp.posted, post.messageID)
+		}
+	}
+	rp.queue = append(rp.queue[:0], rp.queue[i:]...)
+}
+
+// Add remembers an article just posted.
+func (rp *recentPosts) Add(messageID nntp.MessageID) {
+	now := time.Now()
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.expire(now)
+	messageID = messageID.Short()
+	rp.queue = append(rp.queue, recentPost{messageID: messageID, posted: now})
+	rp.posted[messageID] = now
+}
+
+// Remaining returns how long the article is still within the window since it was posted, 0 if it was not posted in
+// the window.
+func (rp *recentPosts) Remaining(messageID nntp.MessageID) time.Duration {
+	now := time.Now()
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.expire(now)
+	posted, ok := rp.posted[messageID.Short()]
+	if !ok {
+		return 0
+	}
+	return rp.window - now.Sub(posted)
+}
+
+// propagationRetryAfter returns the Retry-After of a request missing the article if it was posted in the last
+// PropagationWindow, 0 otherwise. It is the rest of the window, within the bounds of the Retry-After of a saturated
+// pool.
+func (s *server) propagationRetryAfter(messageID nntp.MessageID) time.Duration {
+	if s.recent == nil || messageID == "" {
+		return 0
+	}
+	remaining := s.recent.Remaining(messageID)
+	if remaining <= 0 {
+		return 0
+	} else if remaining < minRetryAfter {
+		return minRetryAfter
+	} else if remaining > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return remaining.Round(time.Second)
+}
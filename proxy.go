@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseTrustedProxies converts the TrustedProxies config entries, either plain IPs or CIDR ranges, into networks.
+func parseTrustedProxies(entries []string) (nets []*net.IPNet, err error) {
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				err = fmt.Errorf("invalid trusted proxy %q", entry)
+				return
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				nets = append(nets, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+		var ipNet *net.IPNet
+		if _, ipNet, err = net.ParseCIDR(entry); err != nil {
+			err = fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			return
+		}
+		nets = append(nets, ipNet)
+	}
+	return
+}
+
+func (s *server) isTrustedProxy(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerAddrKey is the context key of the address of the peer of a request whose RemoteAddr was rewritten by realIP or
+// by a PROXY protocol header.
+type peerAddrKey struct{}
+
+// peerConnContext is the ConnContext of the HTTP servers, keeping the address of the peer of the connections with a
+// PROXY protocol header, the proxy, as their RemoteAddr is the client's.
+func peerConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return context.WithValue(ctx, peerAddrKey{}, pc.Conn.RemoteAddr().String())
+	}
+	return ctx
+}
+
+// peerAddr returns the address of the peer the request came from, its RemoteAddr unless rewritten by realIP or by a
+// PROXY protocol header.
+func peerAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(peerAddrKey{}).(string); ok {
+		return addr
+	}
+	return r.RemoteAddr
+}
+
+// realIP rewrites the request's RemoteAddr to the client address found in X-Forwarded-For, but only if the request
+// comes from one of the TrustedProxies. The X-Forwarded-For list is walked from right to left, skipping any trusted
+// proxies, so a client can't spoof its address by sending its own X-Forwarded-For header.
+func (s *server) realIP(handler http.Handler) http.Handler {
+	if len(s.trustedProxies) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isTrustedProxy(r.RemoteAddr) {
+			if addrs := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ","); len(addrs) > 0 {
+				client := ""
+				for i := len(addrs) - 1; i >= 0; i-- {
+					addr := strings.TrimSpace(addrs[i])
+					if net.ParseIP(addr) == nil {
+						break
+					}
+					client = addr
+					if !s.isTrustedProxy(addr) {
+						break
+					}
+				}
+				if client != "" {
+					if _, ok := r.Context().Value(peerAddrKey{}).(string); !ok {
+						r = r.WithContext(context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr))
+					}
+					_, port, _ := net.SplitHostPort(r.RemoteAddr)
+					r.RemoteAddr = net.JoinHostPort(client, port)
+				}
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// listen creates the TCP listener for addr, accepting HAProxy PROXY protocol headers on it if ProxyProtocol is set.
+func (s *server) listen(addr string) (ln net.Listener, err error) {
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		return
+	}
+	if s.ProxyProtocol {
+		ln = &proxyListener{Listener: ln, server: s}
+	}
+	return
+}
+
+type proxyListener struct {
+	net.Listener
+	server *server
+}
+
+func (l *proxyListener) Accept() (conn net.Conn, err error) {
+	if conn, err = l.Listener.Accept(); err != nil {
+		return
+	}
+	// without an explicit trusted list, the listener is assumed to be only reachable through the proxies
+	if len(l.server.trustedProxies) == 0 || l.server.isTrustedProxy(conn.RemoteAddr().String()) {
+		conn = &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}
+	}
+	return
+}
+
+// proxyConn lazily parses the PROXY protocol header on the first Read or RemoteAddr call, so a slow proxy can't block
+// the listener's Accept loop.
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	errProxyHeader   = errors.New("invalid PROXY protocol header")
+	errNoProxyHeader = errors.New("no PROXY protocol header")
+)
+
+// init reads the PROXY protocol header, closing the connection if it is invalid or missing: a client reaching the
+// listener without going through the proxy would otherwise pass for the proxy itself.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+		var peek []byte
+		if peek, c.err = c.reader.Peek(len(proxyV2Signature)); c.err != nil && len(peek) < 6 {
+			c.Conn.Close()
+			return
+		}
+		c.err = nil
+		switch {
+		case bytes.Equal(peek, proxyV2Signature):
+			c.remoteAddr, c.err = c.readV2()
+		case bytes.HasPrefix(peek, []byte("PROXY ")):
+			c.remoteAddr, c.err = c.readV1()
+		default:
+			c.err = errNoProxyHeader
+			logPrintf("[WARN] [Proxy] %s - connection without a PROXY protocol header, closed", c.Conn.RemoteAddr())
+		}
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// readV1 parses the human-readable header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func (c *proxyConn) readV1() (addr net.Addr, err error) {
+	var line []byte
+	for len(line) < 107 {
+		var b byte
+		if b, err = c.reader.ReadByte(); err != nil {
+			return
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		err = errProxyHeader
+		return
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		err = errProxyHeader
+		return
+	}
+	ip := net.ParseIP(fields[2])
+	port, perr := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || perr != nil {
+		err = errProxyHeader
+		return
+	}
+	addr = &net.TCPAddr{IP: ip, Port: int(port)}
+	return
+}
+
+// readV2 parses the binary header defined in section 2.2 of the PROXY protocol specification.
+func (c *proxyConn) readV2() (addr net.Addr, err error) {
+	header := make([]byte, 16)
+	if _, err = io.ReadFull(c.reader, header); err != nil {
+		return
+	}
+	if header[12]>>4 != 2 {
+		err = errProxyHeader
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	if header[12]&0x0f == 0 {
+		// LOCAL command, e.g. health checks from the proxy itself, keep the real peer address
+		return
+	}
+	switch header[13] >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			err = errProxyHeader
+			return
+		}
+		addr = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			err = errProxyHeader
+			return
+		}
+		addr = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+	}
+	return
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if c.init(); c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.init(); c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
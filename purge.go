@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+const cloudflarePurgeURL = "https://api.cloudflare.com/client/v4/zones/%s/purge_cache"
+
+var purgeClient = &http.Client{Timeout: 30 * time.Second}
+
+// articleURLs returns the public URLs serving an article, as cached by the CDN.
+func (s *server) articleURLs(messageID nntp.MessageID) (urls []string) {
+	base := strings.TrimSuffix(s.PublicURL, "/")
+	name := url.PathEscape(string(messageID.Short()))
+	for _, prefix := range []string{"/m/", "/d/", "/h/", "/p/", "/sum/", "/j/", "/join/"} {
+		for _, ext := range []string{".csv", ".nfo"} {
+			urls = append(urls, base+prefix+name+ext)
+		}
+	}
+	return
+}
+
+// purgeCDN asks the CDN to drop the cached responses of an article, with a Cloudflare style purge request: a POST of
+// {"files": [...]} to PurgeURL, authenticated with PurgeToken. It does nothing if PurgeURL is not set.
+func (s *server) purgeCDN(ctx context.Context, messageID nntp.MessageID) (err error) {
+	if s.PurgeURL == "" {
+		return
+	}
+	_, span := startSpan(ctx, "cdn.Purge")
+	defer func() { endSpan(span, err) }()
+	body, err := json.Marshal(map[string][]string{"files": s.articleURLs(messageID)})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.PurgeURL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.PurgeToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.PurgeToken)
+	}
+	resp, err := purgeClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("purge failed with %s: %s", resp.Status, bytes.TrimSpace(message))
+		return
+	}
+	logf(ctx, "[INFO] [CDN] %s purged", messageID)
+	return
+}
+
+// handlePurge purges the cached responses of the article whose message-id is the request body from the CDN.
+func (s *server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.PurgeURL == "" {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "PurgeURL is not configured")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1024))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	messageID := nntp.MessageID(strings.TrimSpace(string(body)))
+	if messageID.Validate() != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err = s.purgeCDN(r.Context(), messageID); err != nil {
+		logf(r.Context(), "[ERROR] [CDN] %s %s", messageID, err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintln(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
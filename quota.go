@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var quotaBucket = []byte("usage")
+
+// errQuotaExceeded is the error of a post body, or of a response, cut off once the quota of its identity is used up.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// quotaFlushInterval is how often the usage counted in memory is written to QuotaDB.
+const quotaFlushInterval = 10 * time.Second
+
+// QuotaLimits are the bytes an identity can post and fetch per UTC day and month, 0 meaning unlimited.
+type QuotaLimits struct {
+	DailyPosted    uint64
+	DailyFetched   uint64
+	MonthlyPosted  uint64
+	MonthlyFetched uint64
+}
+
+// quotaUsage is the bytes an identity posted and fetched in the current UTC day and month.
+type quotaUsage struct {
+	Identity       string `json:"identity"`
+	Day            string `json:"day"`
+	Month          string `json:"month"`
+	DailyPosted    uint64 `json:"dailyPosted"`
+	DailyFetched   uint64 `json:"dailyFetched"`
+	MonthlyPosted  uint64 `json:"monthlyPosted"`
+	MonthlyFetched uint64 `json:"monthlyFetched"`
+}
+
+// roll starts the counters over once their day or month is over.
+func (u *quotaUsage) roll(now time.Time) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DailyPosted, u.DailyFetched = day, 0, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthlyPosted, u.MonthlyFetched = month, 0, 0
+	}
+}
+
+// counters returns the daily and monthly usage of the posts, or of the fetches, and their limits.
+func (u *quotaUsage) counters(limits *QuotaLimits, post bool) (daily, monthly, dailyLimit, monthlyLimit uint64) {
+	if post {
+		return u.DailyPosted, u.MonthlyPosted, limits.DailyPosted, limits.MonthlyPosted
+	}
+	return u.DailyFetched, u.MonthlyFetched, limits.DailyFetched, limits.MonthlyFetched
+}
+
+// exceeded returns when the quota of the posts, or of the fetches, used up by u is renewed, the zero time if it is not
+// used up.
+func (u *quotaUsage) exceeded(limits *QuotaLimits, post bool, now time.Time) (renewed time.Time) {
+	daily, monthly, dailyLimit, monthlyLimit := u.counters(limits, post)
+	// the monthly quota is renewed last
+	if monthlyLimit != 0 && monthly >= monthlyLimit {
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if dailyLimit != 0 && daily >= dailyLimit {
+		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	}
+	return
+}
+
+// remaining returns the bytes which can still be posted, or fetched, before the quota is used up, math.MaxUint64 if
+// it is unlimited.
+func (u *quotaUsage) remaining(limits *QuotaLimits, post bool) (left uint64) {
+	daily, monthly, dailyLimit, monthlyLimit := u.counters(limits, post)
+	left = math.MaxUint64
+	for _, quota := range []struct{ used, limit uint64 }{{daily, dailyLimit}, {monthly, monthlyLimit}} {
+		switch {
+		case quota.limit == 0:
+		case quota.used >= quota.limit:
+			return 0
+		case quota.limit-quota.used < left:
+			left = quota.limit - quota.used
+		}
+	}
+	return
+}
+
+// quotas keeps the usage of the identities in a bolt database. The usage is counted in memory, loaded from the
+// database the first time an identity is seen, and written every quotaFlushInterval and on Stop, so serving never
+// waits on the database.
+type quotas struct {
+	db      *bolt.DB
+	mu      sync.Mutex
+	usage   map[string]*quotaUsage
+	dirty   map[string]bool
+	stop    chan struct{} // closed by Stop
+	stopped chan struct{} // closed once the flusher returned
+}
+
+func openQuotas(path string) (q *quotas, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		_, err = tx.CreateBucketIfNotExists(quotaBucket)
+		return
+	}); err != nil {
+		db.Close()
+		return
+	}
+	q = &quotas{db: db, usage: make(map[string]*quotaUsage), dirty: make(map[string]bool), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go q.run()
+	return
+}
+
+// run flushes the usage every quotaFlushInterval until Stop.
+func (q *quotas) run() {
+	defer close(q.stopped)
+	ticker := time.NewTicker(quotaFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+			if err := q.flush(); err != nil {
+				logPrintf("[ERROR] [Quota] flush error: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Stop stops the periodic flushes, writes the usage counted since the last one and closes the database.
+func (q *quotas) Stop() (err error) {
+	close(q.stop)
+	<-q.stopped
+	if err = q.flush(); err != nil {
+		q.db.Close()
+		return
+	}
+	return q.db.Close()
+}
+
+// load returns the usage of an identity, up to date with now. q.mu must be held.
+func (q *quotas) load(name string, now time.Time) (usage *quotaUsage, err error) {
+	if usage = q.usage[name]; usage == nil {
+		usage = &quotaUsage{Identity: name}
+		if err = q.db.View(func(tx *bolt.Tx) error {
+			if data := tx.Bucket(quotaBucket).Get([]byte(name)); data != nil {
+				return json.Unmarshal(data, usage)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		q.usage[name] = usage
+	}
+	usage.roll(now)
+	return
+}
+
+// Usage returns a copy of the usage of an identity.
+func (q *quotas) Usage(name string) (usage quotaUsage, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	current, err := q.load(name, time.Now().UTC())
+	if err == nil {
+		usage = *current
+	}
+	return
+}
+
+// Add counts bytes posted and fetched by an identity.
+func (q *quotas) Add(name string, posted, fetched uint64) (err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage, err := q.load(name, time.Now().UTC())
+	if err != nil {
+		return
+	}
+	usage.DailyPosted += posted
+	usage.MonthlyPosted += posted
+	usage.DailyFetched += fetched
+	usage.MonthlyFetched += fetched
+	q.dirty[name] = true
+	return
+}
+
+// flush writes the usage changed since the last flush to the database.
+func (q *quotas) flush() error {
+	q.mu.Lock()
+	changed := make([][]byte, 0, len(q.dirty))
+	keys := make([]string, 0, len(q.dirty))
+	for name := range q.dirty {
+		data, err := json.Marshal(q.usage[name])
+		if err != nil {
+			q.mu.Unlock()
+			return err
+		}
+		changed, keys = append(changed, data), append(keys, name)
+	}
+	q.dirty = make(map[string]bool)
+	q.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	return q.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(quotaBucket)
+		for i, name := range keys {
+			if err := bucket.Put([]byte(name), changed[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// quotaLimits returns the Quotas of an identity: its own by name, else the first of its groups as "group:<name>", else
+// the ones of every authenticated identity as "*", nil if none.
+func (s *server) quotaLimits(id *authIdentity) *QuotaLimits {
+	keys := []string{id.Name}
+	for _, group := range id.Groups {
+		keys = append(keys, "group:"+group)
+	}
+	for _, key := range append(keys, "*") {
+		if limits, ok := s.Quotas[key]; ok {
+			return &limits
+		}
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from a request body, failing with errQuotaExceeded past the remaining ones.
+type countingReader struct {
+	io.ReadCloser
+	read      uint64
+	remaining uint64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	if uint64(len(p)) > cr.remaining {
+		// a byte more tells a body going past the quota from one using it up
+		p = p[:cr.remaining+1]
+	}
+	n, err = cr.ReadCloser.Read(p)
+	cr.read += uint64(n)
+	if uint64(n) > cr.remaining {
+		n, err = int(cr.remaining), errQuotaExceeded
+	}
+	cr.remaining -= uint64(n)
+	return
+}
+
+// quotaResponseWriter cuts off a response once the remaining bytes of the quota are written, failing with
+// errQuotaExceeded.
+type quotaResponseWriter struct {
+	*statusResponseWriter
+	remaining uint64
+}
+
+func (qw *quotaResponseWriter) Write(p []byte) (n int, err error) {
+	if uint64(len(p)) <= qw.remaining {
+		n, err = qw.statusResponseWriter.Write(p)
+	} else if n, err = qw.statusResponseWriter.Write(p[:qw.remaining]); err == nil {
+		err = errQuotaExceeded
+	}
+	qw.remaining -= uint64(n)
+	return
+}
+
+// quota counts the bytes of the posts sent and of the responses of the other article routes received by the
+// authenticated identities, rejecting their requests with 429 Too Many Requests once their daily or monthly quota is
+// used up, with a Retry-After until it is renewed. A post body or a response going past the quota is cut off there,
+// with errQuotaExceeded.
+func (s *server) quota(handler http.Handler) http.Handler {
+	if s.quotas == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, group := identity(r.Context()), routeGroup(r)
+		if id == nil || group == "" || r.URL.Path == "/quota" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		post := group == rolePost
+		remaining := uint64(math.MaxUint64)
+		if limits := s.quotaLimits(id); limits != nil {
+			now := time.Now().UTC()
+			if usage, err := s.quotas.Usage(id.Name); err != nil {
+				logf(r.Context(), "[ERROR] [Quota] %s usage error: %s", id.Name, err.Error())
+			} else if renewed := usage.exceeded(limits, post, now); !renewed.IsZero() {
+				logf(r.Context(), "[WARN] [Quota] %s %s quota of %s exceeded", r.Method, r.URL.Path, id.Name)
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(renewed.Sub(now).Seconds())+1, 10))
+				w.Header().Set("Cache-Control", "no-store")
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprintf(w, "quota exceeded until %s\n", renewed.Format(time.RFC3339))
+				return
+			} else {
+				remaining = usage.remaining(limits, post)
+			}
+		}
+		sw := &statusResponseWriter{ResponseWriter: w}
+		var body *countingReader
+		if post && r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body, remaining: remaining}
+			r.Body = body
+		}
+		defer func() {
+			var posted, fetched uint64
+			if body != nil {
+				posted = body.read
+			} else if !post {
+				fetched = uint64(sw.written)
+			}
+			if err := s.quotas.Add(id.Name, posted, fetched); err != nil {
+				logf(r.Context(), "[ERROR] [Quota] %s usage error: %s", id.Name, err.Error())
+			}
+		}()
+		if post {
+			handler.ServeHTTP(sw, r)
+		} else {
+			// the request is cut off as it goes past the quota, rather than only the next one being rejected
+			handler.ServeHTTP(&quotaResponseWriter{statusResponseWriter: sw, remaining: remaining}, r)
+		}
+	})
+}
+
+// quotaResponse is the usage of an identity and its limits, 0 meaning unlimited.
+type quotaResponse struct {
+	quotaUsage
+	Limits struct {
+		DailyPosted    uint64 `json:"dailyPosted"`
+		DailyFetched   uint64 `json:"dailyFetched"`
+		MonthlyPosted  uint64 `json:"monthlyPosted"`
+		MonthlyFetched uint64 `json:"monthlyFetched"`
+	} `json:"limits"`
+}
+
+// handleQuota serves GET /quota, the usage and quotas of the identity of the request, authenticated with the post
+// AuthMethods or else the read ones.
+func (s *server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if s.quotas == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "QuotaDB is not configured")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := identity(r.Context())
+	if id == nil {
+		methods := append(append([]string(nil), s.AuthMethods[rolePost]...), s.AuthMethods[roleRead]...)
+		var err error
+		if id, err = s.authenticate(r, methods); err != nil || id == nil {
+			s.unauthorized(w, methods)
+			return
+		}
+	}
+	usage, err := s.quotas.Usage(id.Name)
+	if err != nil {
+		logf(r.Context(), "[ERROR] [Quota] %s usage error: %s", id.Name, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := quotaResponse{quotaUsage: usage}
+	if limits := s.quotaLimits(id); limits != nil {
+		response.Limits.DailyPosted, response.Limits.DailyFetched = limits.DailyPosted, limits.DailyFetched
+		response.Limits.MonthlyPosted, response.Limits.MonthlyFetched = limits.MonthlyPosted, limits.MonthlyFetched
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
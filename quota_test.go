@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuotaCutOff(t *testing.T) {
+	s := &server{Quotas: map[string]QuotaLimits{"alice": {DailyFetched: 10, DailyPosted: 10}}}
+	var err error
+	if s.quotas, err = openQuotas(filepath.Join(t.TempDir(), "quotas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.quotas.Stop() })
+	var readErr error
+	handler := s.quota(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, readErr = io.ReadAll(r.Body)
+			return
+		}
+		io.WriteString(w, "0123456")
+	}))
+	request := func(method, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/m/a@example.com", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), identityKey{}, &authIdentity{Name: "alice"}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// the second response goes past the quota, and is cut off there
+	for _, want := range []string{"0123456", "012"} {
+		if w := request(http.MethodGet, ""); w.Body.String() != want {
+			t.Errorf("response within the quota %q, want %q", w.Body.String(), want)
+		}
+	}
+	if w := request(http.MethodGet, ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("request with the quota used up answered %d", w.Code)
+	}
+
+	// a body using the quota up is read whole, a body going past it is not
+	request(http.MethodPost, "0123456")
+	if readErr != nil {
+		t.Errorf("body within the quota: %v", readErr)
+	}
+	request(http.MethodPost, "0123")
+	if !errors.Is(readErr, errQuotaExceeded) {
+		t.Errorf("body past the quota: %v", readErr)
+	}
+	if usage, err := s.quotas.Usage("alice"); err != nil || usage.DailyFetched != 10 || usage.DailyPosted != 11 {
+		t.Errorf("usage %+v, %v", usage, err)
+	}
+}
+
+func TestQuotaStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.db")
+	q, err := openQuotas(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Add("alice", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	// written on Stop, before the next periodic flush
+	if err = q.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if q, err = openQuotas(path); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop()
+	if usage, err := q.Usage("alice"); err != nil || usage.DailyPosted != 1 || usage.DailyFetched != 2 {
+		t.Errorf("usage after Stop %+v, %v", usage, err)
+	}
+}
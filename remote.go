@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/flynn/json5"
+)
+
+// defaultRemoteConfigInterval is the number of seconds between the refreshes of the remote config, unless
+// RemoteConfigInterval says otherwise.
+const defaultRemoteConfigInterval = 300
+
+// remoteConfigLimit is the maximum size of the remote config document.
+const remoteConfigLimit = 1 << 20
+
+var remoteConfigClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchRemoteConfig gets the document at RemoteConfigURL, authenticated with RemoteConfigToken as a bearer token.
+func (s *server) fetchRemoteConfig(ctx context.Context) (data []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.RemoteConfigURL, nil)
+	if err != nil {
+		return
+	}
+	if s.RemoteConfigToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.RemoteConfigToken)
+	}
+	resp, err := remoteConfigClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("remote config request failed with %s: %s", resp.Status, bytes.TrimSpace(message))
+		return
+	}
+	if data, err = io.ReadAll(io.LimitReader(resp.Body, remoteConfigLimit+1)); err == nil && len(data) > remoteConfigLimit {
+		err = fmt.Errorf("remote config is larger than %d bytes", remoteConfigLimit)
+	}
+	return
+}
+
+// parseRemoteConfig parses the remote config document into the config: a JSON array is the NNTPServers, while an
+// object sets the config keys it has, like the config file.
+func parseRemoteConfig(data []byte, s *server) (err error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var servers []NNTPServer
+		if err = json5.Unmarshal(data, &servers); err == nil {
+			s.NNTPServers = servers
+		}
+	} else {
+		err = json5.Unmarshal(data, s)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot parse remote config %s: %w", s.RemoteConfigURL, err)
+	}
+	return
+}
+
+// loadRemoteConfig applies the remote config on top of the config file. Failing to get it is only an error without
+// NNTPServers to fall back on.
+func (s *server) loadRemoteConfig() (err error) {
+	data, err := s.fetchRemoteConfig(context.Background())
+	if err != nil && len(s.NNTPServers) > 0 {
+		logPrintf("[WARN] [Remote] %s, keeping the NNTPServers of the config file", err.Error())
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	if err = parseRemoteConfig(data, s); err != nil {
+		return
+	}
+	logPrintf("[INFO] [Remote] config loaded from %s with %d NNTPServers", s.RemoteConfigURL, len(s.NNTPServers))
+	return
+}
+
+// refreshRemoteConfig gets the remote config every RemoteConfigInterval seconds, applying the credentials of the
+// NNTPServers it has to the pool, so they can be rotated without restarting. The other changes are only logged, they
+// need a restart.
+func (s *server) refreshRemoteConfig() {
+	ticker := time.NewTicker(time.Duration(s.RemoteConfigInterval) * time.Second)
+	defer ticker.Stop()
+	var last []byte
+	for range ticker.C {
+		data, err := s.fetchRemoteConfig(context.Background())
+		if err != nil {
+			logPrintf("[ERROR] [Remote] %s", err.Error())
+			continue
+		} else if bytes.Equal(data, last) {
+			continue
+		}
+		remote := &server{RemoteConfigURL: s.RemoteConfigURL}
+		if err = parseRemoteConfig(data, remote); err == nil {
+			err = remote.resolveSecrets()
+		}
+		if err != nil {
+			logPrintf("[ERROR] [Remote] %s", err.Error())
+			continue
+		}
+		last = data
+		rotated, unknown := s.pool.SetCredentials(remote.NNTPServers)
+		if rotated > 0 {
+			logPrintf("[INFO] [Remote] credentials of %d NNTPServers rotated", rotated)
+		}
+		if unknown > 0 || len(remote.NNTPServers) != len(s.NNTPServers) {
+			logPrintf("[WARN] [Remote] the NNTPServers changed, restart to apply it")
+		}
+	}
+}
+
+// SetCredentials replaces the User and Pass of the servers of the pool with the ones of the servers with the same
+// Host, for the conns dialed from then on. The idle conns of a server whose credentials changed are closed, the ones in
+// use are kept until they are closed. It returns the number of servers whose credentials changed, and the number of
+// servers not in the pool.
+func (p *Pool) SetCredentials(servers []NNTPServer) (rotated, unknown int) {
+	for _, server := range servers {
+		found := false
+		for _, sp := range p.all() {
+			if sp.server.Host != server.Host {
+				continue
+			}
+			found = true
+			sp.mu.Lock()
+			if sp.user == server.User && sp.pass == server.Pass {
+				sp.mu.Unlock()
+				continue
+			}
+			sp.user, sp.pass = server.User, server.Pass
+			idles := sp.idles
+			sp.idles = nil
+			sp.count -= uint64(len(idles))
+			sp.mu.Unlock()
+			for _, idle := range idles {
+				p.owners.Delete(idle.conn)
+				idle.conn.Close()
+			}
+			if !sp.reserved {
+				rotated++
+			}
+		}
+		if !found {
+			unknown++
+		}
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/mail"
+	"sync"
+	"time"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// dateCacheSize is the number of articles whose Date header is kept in memory for routing their fetches.
+const dateCacheSize = 65536
+
+// articleDateKey is the context key of the date an article was posted, as the unix time, when it is known before
+// fetching it.
+type articleDateKey struct{}
+
+// withArticleDate returns ctx with the date of the article it fetches, unless date is 0.
+func withArticleDate(ctx context.Context, date int64) context.Context {
+	if date <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, articleDateKey{}, date)
+}
+
+// articleAge returns how old the article fetched with ctx is, 0 if its date is unknown.
+func articleAge(ctx context.Context) time.Duration {
+	date, _ := ctx.Value(articleDateKey{}).(int64)
+	if date <= 0 {
+		return 0
+	}
+	return time.Since(time.Unix(date, 0))
+}
+
+// retains reports whether the server still has the articles as old as age, according to its Retention.
+func (n NNTPServer) retains(age time.Duration) bool {
+	return n.Retention == 0 || age <= time.Duration(n.Retention)*24*time.Hour
+}
+
+type datedArticle struct {
+	messageID nntp.MessageID
+	date      int64
+}
+
+// dateCache keeps the dates of the most recently fetched articles, evicting the least recently used ones, so that
+// fetching them again skips the servers whose Retention they are past.
+type dateCache struct {
+	mu      sync.Mutex
+	lru     *list.List // of *datedArticle, the most recently used first
+	entries map[nntp.MessageID]*list.Element
+}
+
+func newDateCache() *dateCache {
+	return &dateCache{lru: list.New(), entries: make(map[nntp.MessageID]*list.Element)}
+}
+
+// Get returns the date of the article, 0 if it is not cached.
+func (c *dateCache) Get(messageID nntp.MessageID) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[messageID.Short()]
+	if !ok {
+		return 0
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*datedArticle).date
+}
+
+// Put keeps the date of an article, evicting the least recently used ones beyond dateCacheSize.
+func (c *dateCache) Put(messageID nntp.MessageID, date int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	messageID = messageID.Short()
+	if element, ok := c.entries[messageID]; ok {
+		element.Value.(*datedArticle).date = date
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[messageID] = c.lru.PushFront(&datedArticle{messageID: messageID, date: date})
+	for c.lru.Len() > dateCacheSize {
+		oldest := c.lru.Back()
+		delete(c.entries, oldest.Value.(*datedArticle).messageID)
+		c.lru.Remove(oldest)
+	}
+}
+
+// dated returns ctx with the cached date of the article if none is known yet, when some NNTPServers set a Retention.
+func (s *server) dated(ctx context.Context, messageID nntp.MessageID) context.Context {
+	if s.dates == nil {
+		return ctx
+	}
+	if _, ok := ctx.Value(articleDateKey{}).(int64); ok {
+		return ctx
+	}
+	return withArticleDate(ctx, s.dates.Get(messageID))
+}
+
+// rememberDate caches the Date header of a fetched article, see dated.
+func (s *server) rememberDate(messageID nntp.MessageID, header textproto.MIMEHeader) {
+	if s.dates == nil {
+		return
+	}
+	if date, err := mail.ParseDate(header.Get("Date")); err == nil && date.Unix() > 0 {
+		s.dates.Put(messageID, date.Unix())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// exactRoutes are the paths answered as a whole, named after themselves.
+var exactRoutes = map[string]bool{
+	"/batch": true, "/nzb": true, "/nzb/inspect": true, "/nzb/check": true, "/concat": true, "/stats": true,
+	"/api": true, "/newid": true, "/quota": true, "/share": true, "/healthz": true,
+}
+
+// prefixRoutes are the prefixes of the paths naming a resource after them, like the message-id of an article.
+var prefixRoutes = []string{
+	"/m/", "/d/", "/h/", "/s/", "/f/", "/p/", "/c/", "/j/", "/a/", "/r/",
+	"/join/", "/view/", "/sum/", "/share/", "/verify/", "/tus/", "/dav/",
+}
+
+// splitRoute splits the path of a request into its route and the rest of the path after it, so that the handlers never
+// slice the path themselves. A prefix route without its final slash, like /m, is the route with nothing after it, left
+// to its handler rather than to the static files. The paths of no other route are the static files.
+func splitRoute(path string) (prefix, rest string) {
+	if exactRoutes[path] {
+		return path, ""
+	}
+	for _, prefix = range prefixRoutes {
+		if strings.HasPrefix(path, prefix) {
+			rest = path[len(prefix):]
+			return
+		} else if path == prefix[:len(prefix)-1] {
+			return
+		}
+	}
+	return "static", path
+}
+
+// route names the kind of request, for the span name, the caching and the stats, keeping message-ids out of it.
+func route(path string) (prefix string) {
+	prefix, _ = splitRoute(path)
+	return
+}
+
+// escapedRest returns the rest of the path of a request after its route as it was sent, still percent-encoded, for the
+// routes splitting it into several parts: a message-id decoded can have any of their separators.
+func escapedRest(r *http.Request) (rest string) {
+	prefix, rest := splitRoute(r.URL.EscapedPath())
+	if prefix != route(r.URL.Path) {
+		// the route itself was percent-encoded
+		_, rest = splitRoute(r.URL.Path)
+	}
+	return
+}
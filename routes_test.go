@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func FuzzSplitRoute(f *testing.F) {
+	for _, seed := range []string{
+		"/",
+		"/index.html",
+		"/m/a@example.com.csv",
+		"/m",
+		"/m/",
+		"/share",
+		"/share/abc",
+		"/nzb/inspect",
+		"/nzb/inspectx",
+		"/c/a%2Fb@example.com+c@example.com.csv",
+		"/f/a%40example.com/file%20name.bin",
+		"/%6D/a@example.com.csv",
+		"/m%2Fa@example.com.csv",
+		"/m/%25%2F%3F%23@example.com.csv",
+		"/m/caf%C3%A9@example.com.csv",
+		"",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		prefix, rest := splitRoute(path)
+		if got := route(path); got != prefix {
+			t.Fatalf("route(%q) = %q, splitRoute %q", path, got, prefix)
+		}
+		switch {
+		case prefix == "static":
+			if rest != path {
+				t.Fatalf("splitRoute(%q) = static %q", path, rest)
+			}
+		case exactRoutes[prefix]:
+			if path != prefix || rest != "" {
+				t.Fatalf("splitRoute(%q) = %q %q", path, prefix, rest)
+			}
+		case strings.HasSuffix(prefix, "/"):
+			// the route and the rest put back together are the path, the final slash of the route aside
+			if prefix+rest != path && (rest != "" || path+"/" != prefix) {
+				t.Fatalf("splitRoute(%q) = %q %q", path, prefix, rest)
+			}
+		default:
+			t.Fatalf("splitRoute(%q) = unknown route %q", path, prefix)
+		}
+
+		u, err := url.Parse(path)
+		if err != nil || u.Opaque != "" || u.Host != "" {
+			return
+		}
+		escaped := escapedRest(&http.Request{URL: u})
+		_, decoded := splitRoute(u.Path)
+		if route(u.EscapedPath()) != route(u.Path) {
+			// the route itself was percent-encoded, the rest is the decoded one
+			if escaped != decoded {
+				t.Fatalf("escapedRest(%q) = %q, want %q", path, escaped, decoded)
+			}
+			return
+		}
+		if unescaped, err := url.PathUnescape(escaped); err != nil || unescaped != decoded {
+			t.Fatalf("escapedRest(%q) = %q, unescaped %q, want %q (%v)", path, escaped, unescaped, decoded, err)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+// BackendRule sends the articles whose message-id matches to some NNTP servers first, for the providers known to have
+// originated or to best retain them. The other servers are still tried afterwards.
+type BackendRule struct {
+	// Domain matches the message-ids ending with it, ignoring case, like "@ngPost.com" or "nyuu"
+	Domain string
+	// Pattern is a regular expression matched against the message-id without its angle brackets, for the posting
+	// agents recognizable by the way they generate message-ids
+	Pattern string
+	// Servers are the Host of the preferred NNTPServers, in the order they are tried
+	Servers []string
+
+	pattern *regexp.Regexp
+}
+
+func (rule *BackendRule) match(messageID nntp.MessageID) bool {
+	id := string(messageID.Short())
+	if rule.Domain != "" && !strings.HasSuffix(strings.ToLower(id), strings.ToLower(rule.Domain)) {
+		return false
+	}
+	return rule.pattern == nil || rule.pattern.MatchString(id)
+}
+
+// validateBackendRules compiles the patterns of the rules and checks their servers are among the NNTP servers.
+func validateBackendRules(rules []BackendRule, servers []NNTPServer) (err error) {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Domain == "" && rule.Pattern == "" {
+			return fmt.Errorf("BackendRules[%d] has neither a Domain nor a Pattern", i)
+		}
+		if rule.Pattern != "" {
+			if rule.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("BackendRules[%d] invalid Pattern: %w", i, err)
+			}
+		}
+		if len(rule.Servers) == 0 {
+			return fmt.Errorf("BackendRules[%d] has no Servers", i)
+		}
+	next:
+		for _, host := range rule.Servers {
+			for _, server := range servers {
+				if server.Host == host {
+					continue next
+				}
+			}
+			return fmt.Errorf("BackendRules[%d] server %s is not one of the NNTPServers", i, host)
+		}
+	}
+	return
+}
+
+// poolRule is a BackendRule with its servers resolved to their serverPool.
+type poolRule struct {
+	rule    *BackendRule
+	servers []*serverPool
+}
+
+// order returns the servers to try for the message-id: the located server if any, the preferred servers of the first
+// matching rule if reading, then the others starting from the one the message-id hashes to, the Backfill ones last.
+// The servers past their Retention for an article of age are left out, unless the age is 0 for unknown. The rules are
+// about where the articles are best read from, so posting never leaves a server out for them.
+func (p *Pool) order(messageID nntp.MessageID, first int, located string, age time.Duration, reading bool) (servers []*serverPool) {
+	servers = make([]*serverPool, 0, len(p.servers))
+	for _, sp := range p.servers {
+		if located != "" && sp.server.Host == located {
+			servers = append(servers, sp)
+			break
+		}
+	}
+	for _, pr := range p.rules {
+		if reading && pr.rule.match(messageID) {
+			for _, sp := range pr.servers {
+				if sp.server.Host != located {
+					servers = append(servers, sp)
+				}
+			}
+			break
+		}
+	}
+	preferred := servers
+	for _, backfill := range []bool{false, true} {
+	next:
+		for i := 0; i < len(p.servers); i++ {
+			sp := p.servers[(i+first)%len(p.servers)]
+			if sp.server.Backfill != backfill {
+				continue
+			}
+			for _, other := range preferred {
+				if other == sp {
+					continue next
+				}
+			}
+			servers = append(servers, sp)
+		}
+	}
+	retained := servers[:0]
+	for _, sp := range servers {
+		if sp.server.retains(age) {
+			retained = append(retained, sp)
+		}
+	}
+	return retained
+}
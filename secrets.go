@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// minSecretLength is the length of the shortest secret redacted, so that a short one doesn't garble every line.
+const minSecretLength = 4
+
+// redactedSecret replaces the secrets in the log.
+const redactedSecret = "[REDACTED]"
+
+// secrets are the values redacted from the log and the output of the commands.
+var secrets struct {
+	mu       sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// addSecrets registers values for redact to replace them.
+func addSecrets(values ...string) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if secrets.values == nil {
+		secrets.values = make(map[string]bool)
+	}
+	added := false
+	for _, value := range values {
+		if len(value) >= minSecretLength && !secrets.values[value] {
+			secrets.values[value], added = true, true
+		}
+	}
+	if !added {
+		return
+	}
+	var pairs []string
+	for value := range secrets.values {
+		pairs = append(pairs, value, redactedSecret)
+	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+}
+
+// redact replaces the secrets registered in text.
+func redact(text string) string {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	if secrets.replacer == nil {
+		return text
+	}
+	return secrets.replacer.Replace(text)
+}
+
+// redactingWriter redacts the secrets from what is written to W, like the lines of the log.
+type redactingWriter struct {
+	W io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (n int, err error) {
+	if _, err = io.WriteString(w.W, redact(string(p))); err == nil {
+		n = len(p)
+	}
+	return
+}
+
+// readSecret reads a secret from a file, like the ones mounted by Docker and Kubernetes, without its final newline.
+func readSecret(path string) (secret string, err error) {
+	data, err := os.ReadFile(path)
+	secret = strings.TrimRight(string(data), "\r\n")
+	return
+}
+
+// urlPassword returns the password of the user info of a URL, if any.
+func urlPassword(rawURL string) (password string) {
+	if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+		password, _ = u.User.Password()
+	}
+	return
+}
+
+// resolvePass sets the Pass of the server from its PassFile or PassEnv, if either is set.
+func (n *NNTPServer) resolvePass() (err error) {
+	switch {
+	case n.PassFile != "" && n.PassEnv != "":
+		err = fmt.Errorf("NNTP server %s sets both PassFile and PassEnv", n.Host)
+	case n.PassFile != "":
+		if n.Pass, err = readSecret(n.PassFile); err != nil {
+			err = fmt.Errorf("cannot read PassFile of NNTP server %s: %w", n.Host, err)
+		}
+	case n.PassEnv != "":
+		var ok bool
+		if n.Pass, ok = os.LookupEnv(n.PassEnv); !ok {
+			err = fmt.Errorf("PassEnv %s of NNTP server %s is not set", n.PassEnv, n.Host)
+		}
+	}
+	return
+}
+
+// resolveSecrets sets the secrets given by reference, the Pass of the NNTPServers from their PassFile or PassEnv, and
+// AdminPass, PurgeToken and RemoteConfigToken from their file, then registers all the secrets of the config to be
+// redacted.
+func (s *server) resolveSecrets() (err error) {
+	for i := range s.NNTPServers {
+		if err = s.NNTPServers[i].resolvePass(); err != nil {
+			return
+		}
+		addSecrets(s.NNTPServers[i].Pass)
+	}
+	for _, secret := range []struct {
+		key         string
+		path, value *string
+	}{
+		{"AdminPass", &s.AdminPassFile, &s.AdminPass},
+		{"PurgeToken", &s.PurgeTokenFile, &s.PurgeToken},
+		{"RemoteConfigToken", &s.RemoteConfigTokenFile, &s.RemoteConfigToken},
+	} {
+		if *secret.path != "" {
+			if *secret.value, err = readSecret(*secret.path); err != nil {
+				err = fmt.Errorf("cannot read %sFile: %w", secret.key, err)
+				return
+			}
+		}
+		addSecrets(*secret.value)
+	}
+	addSecrets(urlPassword(s.LocatorURL), urlPassword(s.PurgeURL), urlPassword(s.RemoteConfigURL))
+	return
+}
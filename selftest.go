@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// defaultSelftestGroup is the newsgroup posted to by the selftest command, unless -group says otherwise.
+const defaultSelftestGroup = "alt.test"
+
+// selftestTimeout is the time given to each NNTP server to connect and authenticate during the selftest command.
+const selftestTimeout = 30 * time.Second
+
+// selftestResult is the outcome of the self-test of an NNTP server, a row of the matrix printed by the selftest
+// command.
+type selftestResult struct {
+	name                 string
+	connect, fetch, post string
+	capabilities         string
+	failed               bool
+}
+
+// fail sets the outcome of a step to the error it failed with.
+func (r *selftestResult) fail(step *string, err error) {
+	r.failed = true
+	*step = "FAILED " + err.Error()
+}
+
+// selftestTook formats the time taken by a step.
+func selftestTook(start time.Time) string {
+	return time.Since(start).Round(time.Millisecond).String()
+}
+
+// selftest dials the NNTP server outside of the pool and authenticates, lists its capabilities, fetches the body of
+// the article with messageID, or asks for the DATE if empty, and if it has Posting enabled, posts a short article to
+// group with its Feed.
+func (s *server) selftest(server NNTPServer, messageID nntp.MessageID, group string) (result selftestResult) {
+	result.fetch, result.post, result.capabilities = "-", "-", "-"
+	ctx, cancel := context.WithTimeout(context.Background(), selftestTimeout)
+	defer cancel()
+	start := time.Now()
+	conn, _, err := server.newConn(ctx, nil)
+	if err != nil {
+		result.fail(&result.connect, err)
+		return
+	}
+	defer conn.Close()
+	if server.User != "" {
+		result.connect = "authenticated " + selftestTook(start)
+	} else {
+		result.connect = "connected " + selftestTook(start)
+	}
+
+	if capabilities, err := conn.CmdCapabilities(); err == nil {
+		var keywords []string
+		for _, capability := range capabilities {
+			if fields := strings.Fields(capability); len(fields) > 0 && fields[0] != "VERSION" {
+				keywords = append(keywords, fields[0])
+			}
+		}
+		result.capabilities = strings.Join(keywords, ",")
+	}
+
+	start = time.Now()
+	if messageID != "" {
+		var (
+			article *nntp.Article
+			n       int64
+		)
+		if article, err = conn.CmdBody(nntp.ArticleMessageID(messageID)); err == nil {
+			n, err = io.Copy(io.Discard, article.Body)
+		}
+		if err != nil {
+			result.fail(&result.fetch, err)
+		} else {
+			result.fetch = fmt.Sprintf("BODY %s %d bytes", selftestTook(start), n)
+		}
+	} else if date, err := conn.CmdDate(); err != nil {
+		result.fail(&result.fetch, err)
+	} else {
+		result.fetch = fmt.Sprintf("DATE %s %s", selftestTook(start), date.UTC().Format(time.RFC3339))
+	}
+
+	if !server.Posting {
+		return
+	}
+	ngID, err := ngPostID()
+	if err != nil {
+		result.fail(&result.post, err)
+		return
+	}
+	posted := nntp.MessageID(ngID + "@ngPost.com")
+	header := make(textproto.MIMEHeader)
+	if err = s.fillPostHeader(header, posted, "", group, "usebin selftest "+ngID); err != nil {
+		result.fail(&result.post, err)
+		return
+	}
+	start = time.Now()
+	article := &nntp.Article{MessageID: posted, Header: header, Body: strings.NewReader("usebin selftest\r\n")}
+	if err = s.sendArticle(conn, server.Feed, article, false); err != nil {
+		result.fail(&result.post, err)
+	} else {
+		result.post = fmt.Sprintf("%s %s %s", feedCommand(server.Feed), selftestTook(start), posted)
+	}
+	return
+}
+
+// feedCommand returns the command an article is sent with for a feed.
+func feedCommand(feed string) string {
+	switch feed {
+	case FeedIHave:
+		return "IHAVE"
+	case FeedTakeThis:
+		return "TAKETHIS"
+	default:
+		return "POST"
+	}
+}
+
+func selftestCommand(server *server, args []string) {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	article := flags.String("article", "", "message-id of an article to fetch from every NNTP server, DATE is asked for if not set")
+	group := flags.String("group", defaultSelftestGroup, "newsgroup posted to on the NNTP servers with Posting enabled")
+	flags.Parse(args)
+	messageID := nntp.MessageID(*article)
+	if messageID != "" {
+		if err := messageID.Validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := server.applyDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	if len(server.NNTPServers) == 0 {
+		log.Fatal("no NNTPServers to test, running in local-only mode")
+	}
+	results := make([]selftestResult, len(server.NNTPServers))
+	var wg sync.WaitGroup
+	for i := range server.NNTPServers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = server.selftest(server.NNTPServers[i], messageID, *group)
+			results[i].name = fmt.Sprintf("NNTPServers[%d] %s", i, server.NNTPServers[i].Host)
+		}(i)
+	}
+	wg.Wait()
+
+	w := tabwriter.NewWriter(redactingWriter{W: os.Stdout}, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tCONNECT\tFETCH\tPOST\tCAPABILITIES")
+	failed := false
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.name, result.connect, result.fetch, result.post, result.capabilities)
+		failed = failed || result.failed
+	}
+	w.Flush()
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/nntp.v0"
+)
+
+// storedSniffSize is the number of bytes of a stored body read to detect its content type.
+const storedSniffSize = 1024
+
+// readFrom copies src to w with the ReadFrom of w if it has one, so that the file of a stored article is sent with
+// sendfile by the connection of the response, and otherwise through a buffer like io.Copy.
+func readFrom(w io.Writer, src io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(w, src)
+}
+
+func (nw *notFoundResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	return readFrom(nw.ResponseWriter, src)
+}
+
+func (sw *statusResponseWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err = readFrom(sw.ResponseWriter, src)
+	sw.written += n
+	if err != nil && sw.writeErr == nil {
+		sw.writeErr = err
+	}
+	return
+}
+
+func (ew *errorResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if ew.status != 0 {
+		// the body may be the error message held back
+		return io.Copy(struct{ io.Writer }{ew}, src)
+	}
+	ew.started = true
+	return readFrom(ew.ResponseWriter, src)
+}
+
+// serveStoredFile serves GET /m/ from the file of the article in the store, if the store keeps it dot-decoded, without
+// reading it into a buffer: the whole body or a single range is sent with sendfile where the connection supports it.
+// It reports whether the request was answered, leaving the articles not stored or stored dot-encoded, and the requests
+// of several ranges to handleMessageGET.
+func (s *server) serveStoredFile(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID, rangeReq string) bool {
+	filer, ok := s.store.(bodyFiler)
+	if !ok || forcedServer(r.Context()) != "" {
+		return false
+	}
+	_, span := startSpan(r.Context(), "store.BodyFile")
+	header, body, err := filer.BodyFile(messageID)
+	span.End()
+	if err != nil {
+		if !errors.Is(err, ErrArticleNotFound) && !errors.Is(err, errStoredDotEncoded) {
+			logf(r.Context(), "[ERROR] [Store] %s get error: %s", messageID, err.Error())
+		}
+		return false
+	}
+	defer body.Close()
+
+	truncated := body.size > int64(s.ArticleSizeLimit)
+	if truncated {
+		if !s.oversize(w, r, messageID, int64(s.ArticleSizeLimit), body.size) {
+			return true
+		}
+		body.size = int64(s.ArticleSizeLimit)
+	}
+
+	var ranges []httpRange
+	if body.size > 0 {
+		if ranges, err = parseRange(rangeReq, body.size); err != nil {
+			if err == errNoOverlap {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", body.size))
+			}
+			logf(r.Context(), "[ERROR] %s %s invalid range", r.Method, messageID)
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return true
+		}
+		if len(ranges) > 1 {
+			return false
+		}
+	}
+	sniff := make([]byte, storedSniffSize)
+	if body.size < storedSniffSize {
+		sniff = sniff[:body.size]
+	}
+	if _, err = body.ReadAt(sniff, body.offset); err != nil && err != io.EOF {
+		logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	ctype := s.setContentType(w, header, sniff, "text/plain; charset=utf-8")
+	if s.ContentSHA256 && !truncated {
+		sums, err := s.fileSums(messageID, body)
+		if err != nil {
+			logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return true
+		}
+		w.Header().Set("X-Content-Sha256", sums.SHA256)
+	}
+
+	code, start, length := http.StatusOK, int64(0), body.size
+	if len(ranges) == 1 {
+		code, start, length = http.StatusPartialContent, ranges[0].start, ranges[0].length
+		w.Header().Set("Content-Range", ranges[0].contentRange(body.size))
+	}
+	content, err := body.section(start, length)
+	if err != nil {
+		logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+
+	copyUsenetHeaders(w.Header(), header)
+	setArticleDate(w.Header(), header)
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Accept-Ranges", "bytes")
+	setETag(w, s.routeETag(r, messageID))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(code)
+
+	if n, err := readFrom(w, content); err != nil || n != length {
+		// the status code is sent already, abort the response so that the client can tell the body is incomplete
+		if err == nil {
+			err = errSizeMismatch
+		}
+		if !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] %s %s write error: %s", r.Method, messageID, err.Error())
+		}
+		panic(http.ErrAbortHandler)
+	}
+	s.recordServed(messageID, nil, body.size)
+
+	logf(r.Context(), "[INFO] %s %s", r.Method, messageID)
+	return true
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"embed"
 	"errors"
@@ -10,30 +11,162 @@ import (
 	"io/fs"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/nntp.v0"
 	"gopkg.in/pwgen.v0"
 	"gopkg.in/textproto.v0"
 )
 
 type server struct {
-	Host             string
-	Port             uint16
-	NNTPServers      []NNTPServer
-	IdleConnExpiry   int64
-	DefaultNewsgroup string
-	ArticleSizeLimit uint64
-	CertFile         string
-	KeyFile          string
-	pool             *Pool
-	bufPool          sync.Pool
+	Host                  string
+	Port                  uint16
+	Listen                []string
+	NNTPServers           []NNTPServer
+	RemoteConfigURL       string
+	RemoteConfigToken     string
+	RemoteConfigTokenFile string
+	RemoteConfigInterval  int64
+	IdleConnExpiry        int64
+	IdleConnProbe         int64
+	BackendRules          []BackendRule
+	HedgeDelay            int64
+	ShadowReadRatio       float64
+	FetchRetries          *int
+	FailFastCodes         []int
+	NNTPCodes             map[string]NNTPCode
+	FetchTimeout          int64
+	MaxPoolWaiters        int
+	MaxPoolWait           int64
+	PoolEventLog          bool
+	JoinScanRange         int
+	FastStart             bool
+	RouteCaching          map[string]RouteCaching
+	AcceptExtensions      []string
+	RevalidateArticles    bool
+	PublicURL             string
+	PurgeURL              string
+	PurgeToken            string
+	PurgeTokenFile        string
+	CloudflareZoneID      string
+	DefaultNewsgroup      string
+	MessageIDDomain       string
+	NewsgroupPolicies     []NewsgroupPolicy
+	AllowedPostHeaders    []string
+	RestrictNewsgroups    bool
+	PathIdentity          string
+	ArticleSizeLimit      uint64
+	ArticleMemoryLimit    uint64
+	LargeArticleDir       string
+	OversizeArticles      string
+	DetectContentType     bool
+	ContentSHA256         bool
+	JSONErrors            bool
+	JSONErrorDetails      bool
+	StatBeforePost        bool
+	DuplicatePostOK       bool
+	PropagationWindow     int64
+	VerifyAttempts        int
+	VerifyDelay           int64
+	EgressRateLimit       int64
+	RequestRateLimit      int64
+	BatchSizeLimit        int
+	BatchConcurrency      int
+	SpoolDir              string
+	SpoolRetryDelay       int64
+	SpoolMaxAttempts      int
+	StoreDir              string
+	StorePopulate         bool
+	CertFile              string
+	KeyFile               string
+	HTTPPort              uint16
+	HTTPListen            []string
+	TLSMinVersion         string
+	TLSCipherSuites       []string
+	TLSCurvePreferences   []string
+	TLSClientAuth         string
+	RedirectHTTP          bool
+	HSTSMaxAge            int64
+	HSTSSubdomains        bool
+	HSTSPreload           bool
+	TrustedProxies        []string
+	ProxyProtocol         bool
+	TraceEndpoint         string
+	TraceInsecure         bool
+	TraceSampleRatio      float64
+	AdminHost             string
+	AdminPort             uint16
+	AdminUser             string
+	AdminPass             string
+	AdminPassFile         string
+	AuthMethods           map[string][]string
+	AuthRoles             map[string][]string
+	Htpasswd              string
+	AuthUserHeader        string
+	AuthGroupsHeader      string
+	ClientCAFile          string
+	BlockProfileRate      int
+	MutexProfileFraction  int
+	LogLevel              string
+	LogFormat             string
+	StatsPage             bool
+	StatsAuth             bool
+	TakedownDB            string
+	IndexDB               string
+	QuotaDB               string
+	DavDir                string
+	TusDir                string
+	TusSegmentSize        int64
+	TusMaxSize            int64
+	ShareDB               string
+	AliasDB               string
+	Quotas                map[string]QuotaLimits
+	LocatorURL            string
+	LocatorTTL            int64
+	LocatorTimeout        int64
+	MemoryCacheSize       uint64
+	MemoryArticleLimit    uint64
+	trustedProxies        []*net.IPNet
+	htpasswd              map[string]string
+	pool                  *Pool
+	metrics               *poolMetrics
+	spool                 *spool
+	store                 ArticleStore
+	takedowns             *takedowns
+	index                 *articleIndex
+	quotas                *quotas
+	shares                *shares
+	aliases               *aliases
+	dav                   *davJobs
+	tus                   *tusUploads
+	assets                *assetFS
+	locator               *redisLocator
+	memory                *memoryCache
+	sums                  *sumCache
+	fastStarts            *fastStartCache // nil without FastStart
+	dates                 *dateCache
+	recent                *recentPosts
+	verifications         *verifications
+	shadowReads           chan struct{} // the shadow reads running, nil without ShadowReadRatio
+	nntpCodes             map[nntp.ResponseCode]NNTPCode
+	started               time.Time
+	activity              *activityLog
+	transfer              transferStats
+	bufPool               *bufferPool
 }
 
+// the scripts of the web UI are bundled into static/assets before being embedded
+//
+//go:generate node esbuild.config.js
 //go:embed static
 var staticFS embed.FS
 
@@ -43,6 +176,14 @@ const (
 	Static Entity = iota
 	FullArticle
 	ArticleHead
+	SpoolStatus
+	JoinedFile
+	ArticleView
+	DecodedFile
+	ArticlePreview
+	ArticleSums
+	ConcatenatedFile
+	ArticleOverview
 )
 
 func (s *server) handleMessage(staticHandler http.Handler) http.Handler {
@@ -51,18 +192,107 @@ func (s *server) handleMessage(staticHandler http.Handler) http.Handler {
 			entity     Entity
 			dotEncoded bool
 			messageID  nntp.MessageID
+			concatIDs  []nntp.MessageID
 		)
 
+		switch r.URL.Path {
+		case "/batch":
+			s.handleBatch(w, r)
+			return
+		case "/nzb":
+			s.handleNZB(w, r)
+			return
+		case "/nzb/inspect":
+			s.handleNZBInspect(w, r)
+			return
+		case "/nzb/check":
+			s.handleNZBCheck(w, r)
+			return
+		case "/concat":
+			s.handleConcatPOST(w, r)
+			return
+		case "/stats":
+			if s.StatsPage {
+				s.statsHandler().ServeHTTP(w, r)
+				return
+			}
+		case "/api":
+			s.handleAPI(w, r)
+			return
+		case "/newid":
+			s.handleNewID(w, r)
+			return
+		case "/quota":
+			s.handleQuota(w, r)
+			return
+		case "/share":
+			s.handleSharePOST(w, r)
+			return
+		case "/healthz":
+			s.handleHealth(w, r)
+			return
+		}
+
+		prefix, rest := splitRoute(r.URL.Path)
+		if prefix == "/dav/" {
+			s.handleDAV(w, r)
+			return
+		} else if prefix == "/share/" {
+			s.handleShare(w, r)
+			return
+		} else if prefix == "/a/" {
+			s.handleAlias(w, r)
+			return
+		} else if prefix == "/verify/" {
+			s.handleVerify(w, r)
+			return
+		} else if prefix == "/tus/" {
+			s.handleTus(w, r)
+			return
+		} else if prefix == "/r/" {
+			s.handleRedirect(w, r)
+			return
+		}
+
 		// https://developers.cloudflare.com/cache/about/default-cache-behavior/#default-cached-file-extensions
-		if name := r.URL.Path[3:]; !strings.HasSuffix(name, ".csv") && !strings.HasSuffix(name, ".nfo") {
+		if prefix == "static" {
+			// the pages and assets of the web UI
+		} else if prefix == "/view/" {
+			// a page of the web UI, named after the message-id without an extension
+			if messageID = nntp.MessageID(rest); messageID.Validate() != nil {
+				setErrorCode(r.Context(), "invalid_message_id")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		} else if prefix == "/c/" {
+			// several message-ids, checked by the handler
+			var ok bool
+			if concatIDs, ok = parseConcatPath(escapedRest(r)); !ok {
+				setErrorCode(r.Context(), "invalid_path")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		} else if prefix == "/f/" {
+			// the file name after the message-id only names the download
+			var ok bool
+			if messageID, _, ok = fileRoute(escapedRest(r)); !ok {
+				setErrorCode(r.Context(), "invalid_path")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		} else if id, canonical, ok := s.articleName(rest); !ok {
+			setErrorCode(r.Context(), "invalid_path")
 			w.WriteHeader(http.StatusBadRequest)
 			return
-		} else if messageID = nntp.MessageID(name[:len(name)-4]); messageID.Validate() != nil {
+		} else if messageID = id; messageID.Validate() != nil {
+			setErrorCode(r.Context(), "invalid_message_id")
 			w.WriteHeader(http.StatusBadRequest)
 			return
+		} else if !canonical && s.redirectCanonical(w, r, prefix, messageID) {
+			return
 		}
 
-		switch r.URL.Path[:3] {
+		switch prefix {
 		case "/m/":
 			entity = FullArticle
 			dotEncoded = false
@@ -71,15 +301,63 @@ func (s *server) handleMessage(staticHandler http.Handler) http.Handler {
 			dotEncoded = true
 		case "/h/":
 			entity = ArticleHead
+		case "/s/":
+			entity = SpoolStatus
+		case "/join/":
+			entity = JoinedFile
+		case "/view/":
+			entity = ArticleView
+		case "/f/":
+			entity = DecodedFile
+		case "/p/":
+			entity = ArticlePreview
+		case "/sum/":
+			entity = ArticleSums
+		case "/c/":
+			entity = ConcatenatedFile
+		case "/j/":
+			entity = ArticleOverview
 		default:
 			entity = Static
 		}
 
+		if date := r.URL.Query().Get("date"); date != "" && entity != Static {
+			// the date the article was posted, such as the one an NZB gives, skipping the servers past their Retention
+			unix, err := strconv.ParseInt(date, 10, 64)
+			if err != nil || unix <= 0 {
+				setErrorCode(r.Context(), "invalid_date")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r = r.WithContext(withArticleDate(r.Context(), unix))
+		}
+		if entity != Static {
+			var ok bool
+			if r, ok = s.forceServer(w, r); !ok {
+				return
+			}
+		}
+
 		// general headers
-		w.Header().Set("Cache-Control", "public, max-age=2592000")
+		w.Header().Set("Cache-Control", s.cacheControl(r))
+		nw := &notFoundResponseWriter{ResponseWriter: w, ctx: r.Context(), cacheControl: s.notFoundCacheControl(r)}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			nw.retryAfter = s.propagationRetryAfter(messageID)
+		}
+		w = nw
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 
+		if entity != Static && entity != SpoolStatus && entity != ConcatenatedFile && s.blocked(w, r, messageID) {
+			return
+		}
+		if entity != Static && entity != SpoolStatus && entity != ConcatenatedFile && entity != ArticleView && r.Method != http.MethodPost {
+			var ok bool
+			if messageID, ok = s.resolveAlt(w, r, messageID); !ok {
+				return
+			}
+		}
+
 		switch entity {
 		case FullArticle:
 			if r.Method == http.MethodGet && dotEncoded {
@@ -87,13 +365,34 @@ func (s *server) handleMessage(staticHandler http.Handler) http.Handler {
 				return
 			}
 			switch r.Method {
-			case http.MethodGet, http.MethodHead:
+			case http.MethodGet:
 				s.handleMessageGET(w, r, messageID)
+			case http.MethodHead:
+				s.handleMessageHead(w, r, messageID, true, dotEncoded)
 			case http.MethodPost:
 				s.handleMessagePOST(w, r, messageID, dotEncoded)
 			}
 		case ArticleHead:
-			s.handleMessageHead(w, r, messageID)
+			s.handleMessageHead(w, r, messageID, false, false)
+		case SpoolStatus:
+			s.handleSpoolStatus(w, r, messageID)
+		case JoinedFile:
+			s.handleJoin(w, r, messageID)
+		case DecodedFile:
+			s.handleFile(w, r, messageID)
+		case ArticlePreview:
+			s.handlePreview(w, r, messageID)
+		case ArticleSums:
+			s.handleSums(w, r, messageID)
+		case ArticleOverview:
+			s.handleOverview(w, r, messageID)
+		case ConcatenatedFile:
+			s.handleConcat(w, r, concatIDs)
+		case ArticleView:
+			// the page fetches the article itself
+			page := r.Clone(r.Context())
+			page.URL.Path = "/view.html"
+			staticHandler.ServeHTTP(w, page)
 		default:
 			staticHandler.ServeHTTP(w, r)
 		}
@@ -107,15 +406,27 @@ func (s *server) handleDotEncodedMessageGET(w http.ResponseWriter, r *http.Reque
 		conn    *nntp.Conn
 		article *nntp.Article
 		done    bool
-		found   bool
-		retries int
+		n       int64
+		spec    string
+		ra      httpRange
+		ranged  bool
+		size    int64
+		sized   bool
 	)
 
 	ctype := "text/plain; charset=utf-8"
 
-	if done, _ = checkPreconditions(w, r); done {
+	if done, spec = s.checkArticlePreconditions(w, r, messageID, s.routeETag(r, messageID)); done {
 		return
 	}
+	if ra, ranged, err = parseStreamRange(spec, int64(s.ArticleSizeLimit)); err != nil {
+		logf(r.Context(), "[ERROR] %s (RAW) %s invalid range", r.Method, messageID)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	} else if ra.length > int64(s.ArticleMemoryLimit) {
+		// the range is read into memory, a shorter one is sent
+		ra.length = int64(s.ArticleMemoryLimit)
+	}
 
 	defer func() {
 		if conn != nil {
@@ -125,57 +436,136 @@ func (s *server) handleDotEncodedMessageGET(w http.ResponseWriter, r *http.Reque
 			} else {
 				s.pool.Close(conn)
 			}
+		} else {
+			closeArticle(article)
 		}
 	}()
 
-	for found, retries = false, 0; !found; retries++ {
-		if conn, err = s.pool.Get(false, messageID, retries); errors.Is(err, ErrNoMoreServers) {
-			break
-		} else if err != nil {
-			log.Printf("[ERROR] %s (RAW) %s pool error: %s", r.Method, messageID, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		if article, err = conn.CmdArticle(nntp.ArticleMessageID(messageID), nntp.WithDotEncodedBody()); err != nil {
-			if errors.As(err, &nntpErr) {
-				s.pool.Put(conn)
-				continue
+	// the overview of the article on each conn asked for it, several with HedgeDelay, for its size. It is fetched
+	// first since the conn is busy sending the body afterwards, and only for a whole body
+	var overviews sync.Map
+	if conn, article, err = s.lookup(r.Context(), messageID, true, "ARTICLE", func(conn *nntp.Conn) (*nntp.Article, error) {
+		if !ranged {
+			var ovErr *nntp.Error
+			if ov, err := overview(r.Context(), conn, messageID); err == nil && ov != nil {
+				overviews.Store(conn, ov)
+			} else if err != nil && !errors.As(err, &ovErr) {
+				return nil, err
 			}
-			log.Printf("[ERROR] %s (RAW) %s connection error: %s", r.Method, messageID, err.Error())
+		}
+		return conn.CmdArticle(nntp.ArticleMessageID(messageID), nntp.WithDotEncodedBody())
+	}); errors.Is(err, ErrArticleNotFound) {
+		logf(r.Context(), "[ERROR] %s (RAW) %s not found", r.Method, messageID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] %s (RAW) %s %s", r.Method, messageID, err.Error())
+		if !unavailable(w, err) {
 			w.WriteHeader(http.StatusInternalServerError)
-			return
 		}
-		found = true
+		return
 	}
 
-	if !found {
-		log.Printf("[ERROR] %s (RAW) %s not found", r.Method, messageID)
-		w.WriteHeader(http.StatusNotFound)
+	copyUsenetHeaders(w.Header(), article.Header)
+	setArticleDate(w.Header(), article.Header)
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Accept-Ranges", "bytes")
+	setETag(w, s.routeETag(r, messageID))
+
+	if ranged {
+		err = s.sendDotEncodedRange(w, r, messageID, conn, article, ra)
 		return
 	}
 
-	for key, values := range article.Header {
-		switch strings.ToLower(key) {
-		case "organization", "x-complaints-to":
-			continue
+	if conn != nil {
+		var ov *nntp.ArticleOverview
+		if value, ok := overviews.Load(conn); ok {
+			ov = value.(*nntp.ArticleOverview)
 		}
-		for _, value := range values {
-			w.Header().Add("X-Usenet-"+key, value)
+		size, sized = dotEncodedBodySize(article.Header, ov)
+	}
+	if sized && size <= int64(s.ArticleSizeLimit) {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if n, err = copyExactly(w, article.Body, size); errors.Is(err, errSizeMismatch) {
+			// abort the response so that the client can tell it is incomplete
+			logf(r.Context(), "[ERROR] %s (RAW) %s %s", r.Method, messageID, err.Error())
+			panic(http.ErrAbortHandler)
 		}
+	} else {
+		w.WriteHeader(http.StatusOK)
+		n, err = io.Copy(w, io.LimitReader(article.Body, int64(s.ArticleSizeLimit)))
 	}
-	w.Header().Set("Content-Type", ctype)
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("ETag", "\""+string(messageID.Short())+"\"")
+	if err != nil {
+		// the status code is sent already, abort the response so that the client can tell the body is incomplete
+		if !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] %s (RAW) %s error after %d bytes: %s", r.Method, messageID, n, err.Error())
+		}
+		panic(http.ErrAbortHandler)
+	}
+	s.recordServed(messageID, conn, n)
 
-	w.WriteHeader(http.StatusOK)
+	logf(r.Context(), "[INFO] %s (RAW) %s", r.Method, messageID)
+}
+
+// sendDotEncodedRange sends the range of the dot-encoded body with 206 Partial Content. The size of the body is not
+// known before reading it, so the bytes before the range are read and skipped, then the range is read into a buffer
+// to tell its actual length, and the size of the body if the range reaches its end.
+func (s *server) sendDotEncodedRange(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID, conn *nntp.Conn, article *nntp.Article, ra httpRange) (err error) {
+	var (
+		skipped int64
+		n       int
+		buf     []byte
+		total   = "*"
+	)
+
+	if skipped, err = io.CopyN(io.Discard, article.Body, ra.start); err == io.EOF {
+		err = nil
+		logf(r.Context(), "[ERROR] %s (RAW) %s range starts after the body", r.Method, messageID)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", skipped))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] %s (RAW) %s read error: %s", r.Method, messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	if _, err = io.Copy(w, io.LimitReader(article.Body, int64(s.ArticleSizeLimit))); err != nil {
-		log.Printf("[ERROR] %s (RAW) %s write error: %s", r.Method, messageID, err.Error())
+	buf = s.bufPool.Get(int(ra.length))
+	defer s.bufPool.Put(buf)
+
+	if n, err = io.ReadFull(article.Body, buf[:ra.length]); err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+		total = strconv.FormatInt(ra.start+int64(n), 10)
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] %s (RAW) %s read error: %s", r.Method, messageID, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if _, err = article.Body.Read(nil); errors.Is(err, io.EOF) {
+		total = strconv.FormatInt(ra.start+int64(n), 10)
+	}
+	err = nil
+	if n == 0 {
+		logf(r.Context(), "[ERROR] %s (RAW) %s range starts after the body", r.Method, messageID)
+		w.Header().Set("Content-Range", "bytes */"+total)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
 	}
 
-	log.Printf("[INFO] %s (RAW) %s", r.Method, messageID)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", ra.start, ra.start+int64(n)-1, total))
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if _, err = w.Write(buf[:n]); err != nil {
+		if !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] %s (RAW) %s write error: %s", r.Method, messageID, err.Error())
+		}
+		panic(http.ErrAbortHandler)
+	}
+	s.recordServed(messageID, conn, ra.start+int64(n))
+
+	logf(r.Context(), "[INFO] %s (RAW) %s range %d-%d", r.Method, messageID, ra.start, ra.start+int64(n)-1)
+	return
 }
 
 func (s *server) handleMessageGET(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
@@ -184,29 +574,29 @@ func (s *server) handleMessageGET(w http.ResponseWriter, r *http.Request, messag
 		nntpErr     *nntp.Error
 		conn        *nntp.Conn
 		article     *nntp.Article
-		v           any
 		buf         []byte
 		n           int
 		size        int64
+		content     io.ReaderAt
+		spooled     *storedFile
+		truncated   bool
 		ranges      []httpRange
 		sendContent io.Reader
 		sendSize    int64
 		rangeReq    string
 		done        bool
 		code        int
-		found       bool
-		retries     int
 	)
 
 	ctype := "text/plain; charset=utf-8"
 
-	if done, rangeReq = checkPreconditions(w, r); done {
+	if done, rangeReq = s.checkArticlePreconditions(w, r, messageID, s.routeETag(r, messageID)); done {
+		return
+	}
+	// a stored article is sent from its file, without a buffer
+	if s.serveStoredFile(w, r, messageID, rangeReq) {
 		return
 	}
-
-	v = s.bufPool.Get()
-	defer s.bufPool.Put(v)
-	buf = v.([]byte)
 
 	defer func() {
 		if conn != nil {
@@ -216,57 +606,92 @@ func (s *server) handleMessageGET(w http.ResponseWriter, r *http.Request, messag
 			} else {
 				s.pool.Close(conn)
 			}
+		} else {
+			closeArticle(article)
 		}
 	}()
 
-	for found, retries = false, 0; !found; retries++ {
-		if conn, err = s.pool.Get(false, messageID, retries); errors.Is(err, ErrNoMoreServers) {
-			break
-		} else if err != nil {
-			log.Printf("[ERROR] %s %s pool error: %s", r.Method, messageID, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		if article, err = conn.CmdArticle(nntp.ArticleMessageID(messageID)); err != nil {
-			if errors.As(err, &nntpErr) {
-				s.pool.Put(conn)
-				continue
-			}
-			log.Printf("[ERROR] %s %s connection error: %s", r.Method, messageID, err.Error())
+	if conn, article, err = s.lookup(r.Context(), messageID, false, "ARTICLE", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdArticle(nntp.ArticleMessageID(messageID))
+	}); errors.Is(err, ErrArticleNotFound) {
+		logf(r.Context(), "[ERROR] %s %s not found", r.Method, messageID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] %s %s %s", r.Method, messageID, err.Error())
+		if !unavailable(w, err) {
 			w.WriteHeader(http.StatusInternalServerError)
-			return
 		}
-		found = true
-	}
-
-	if !found {
-		log.Printf("[ERROR] %s %s not found", r.Method, messageID)
-		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	if n, err = io.ReadFull(article.Body, buf); err == io.ErrUnexpectedEOF {
-		err = nil
-	} else if err != nil {
-		log.Printf("[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+	buf, n, err = s.bufPool.ReadFull(article.Body)
+	defer s.bufPool.Put(buf)
+	if err != nil {
+		logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if _, err = article.Body.Read(nil); !errors.Is(err, io.EOF) {
-		log.Printf("[ERROR] %s %s size exceeds limit", r.Method, messageID)
-		w.WriteHeader(http.StatusInsufficientStorage)
+	content, size = bytes.NewReader(buf[:n]), int64(n)
+	if _, err = article.Body.Read(nil); !errors.Is(err, io.EOF) && s.ArticleMemoryLimit < s.ArticleSizeLimit {
+		// the rest of the body goes to a file, buf keeping its beginning
+		if spooled, err = s.spoolLargeArticle(article.Body, buf[:n]); err != nil && !errors.Is(err, errLargeArticle) {
+			logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer spooled.remove()
+		content, size = spooled, spooled.size
+		logf(r.Context(), "[DEBUG] %s %s spooled, %d bytes", r.Method, messageID, size)
+		if truncated = errors.Is(err, errLargeArticle); truncated && !s.oversize(w, r, messageID, size, size+1) {
+			return
+		}
+	} else if truncated = !errors.Is(err, io.EOF); truncated && !s.oversize(w, r, messageID, size, size) {
 		return
 	}
+	err = nil
+	if conn != nil && !truncated {
+		s.populate(r.Context(), messageID, article.Header, io.NewSectionReader(content, 0, size))
+	}
+	if spooled == nil && !truncated {
+		s.remember(r.Context(), messageID, article, buf[:n])
+	}
+	ctype = s.setContentType(w, article.Header, buf[:n], ctype)
+	shadow := conn != nil && !truncated && s.shadowSampled(r.Context())
+	// the sums of a truncated body are not the ones of the article
+	if (s.ContentSHA256 || shadow) && !truncated {
+		var sums *articleSums
+		if spooled == nil {
+			sums = s.bodySums(messageID, buf[:n])
+		} else if sums, err = s.fileSums(messageID, spooled); err != nil {
+			logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if s.ContentSHA256 {
+			w.Header().Set("X-Content-Sha256", sums.SHA256)
+		}
+		if shadow {
+			s.shadowRead(r.Context(), messageID, conn, sums)
+		}
+	}
 	code = http.StatusOK
-	size = int64(n)
 	sendSize = size
-	sendContent = bytes.NewReader(buf[:n])
+	sendContent = io.NewSectionReader(content, 0, size)
+	if spooled != nil {
+		// limiting the file itself for sendfile
+		if sendContent, err = spooled.section(0, size); err != nil {
+			logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
 	if size > 0 {
 		if ranges, err = parseRange(rangeReq, size); err != nil {
 			if err == errNoOverlap {
 				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
 			}
-			log.Printf("[ERROR] %s %s invalid range", r.Method, messageID)
+			logf(r.Context(), "[ERROR] %s %s invalid range", r.Method, messageID)
 			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 			return
 		}
@@ -292,7 +717,14 @@ func (s *server) handleMessageGET(w http.ResponseWriter, r *http.Request, messag
 		// does not request multiple parts might not support
 		// multipart responses."
 		ra := ranges[0]
-		sendContent = bytes.NewReader(buf[ra.start : ra.start+ra.length])
+		sendContent = io.NewSectionReader(content, ra.start, ra.length)
+		if spooled != nil {
+			if sendContent, err = spooled.section(ra.start, ra.length); err != nil {
+				logf(r.Context(), "[ERROR] %s %s read error: %s", r.Method, messageID, err.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
 		sendSize = ra.length
 		code = http.StatusPartialContent
 		w.Header().Set("Content-Range", ra.contentRange(size))
@@ -312,7 +744,7 @@ func (s *server) handleMessageGET(w http.ResponseWriter, r *http.Request, messag
 					pw.CloseWithError(err)
 					return
 				}
-				if _, err := part.Write(buf[ra.start : ra.start+ra.length]); err != nil {
+				if _, err := io.Copy(part, io.NewSectionReader(content, ra.start, ra.length)); err != nil {
 					pw.CloseWithError(err)
 					return
 				}
@@ -322,74 +754,178 @@ func (s *server) handleMessageGET(w http.ResponseWriter, r *http.Request, messag
 		}()
 	}
 
-	for key, values := range article.Header {
-		switch strings.ToLower(key) {
-		case "organization", "x-complaints-to":
-			continue
-		}
-		for _, value := range values {
-			w.Header().Add("X-Usenet-"+key, value)
-		}
-	}
+	copyUsenetHeaders(w.Header(), article.Header)
+	setArticleDate(w.Header(), article.Header)
 	w.Header().Set("Content-Type", ctype)
 	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("ETag", "\""+string(messageID.Short())+"\"")
+	setETag(w, s.routeETag(r, messageID))
 	w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
 
 	w.WriteHeader(code)
 
 	if r.Method != http.MethodHead {
-		if _, err = io.Copy(w, sendContent); err != nil {
-			log.Printf("[ERROR] %s %s write error: %s", r.Method, messageID, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if _, err = readFrom(w, sendContent); err != nil {
+			// the status code is sent already, abort the response so that the client can tell the body is incomplete
+			if !clientGone(r, err) {
+				logf(r.Context(), "[ERROR] %s %s write error: %s", r.Method, messageID, err.Error())
+			}
+			panic(http.ErrAbortHandler)
 		}
+		s.recordServed(messageID, conn, size)
 	}
 
-	log.Printf("[INFO] %s %s", r.Method, messageID)
+	logf(r.Context(), "[INFO] %s %s", r.Method, messageID)
 }
 
 func (s *server) handleMessagePOST(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID, dotEncoded bool) {
 	var (
-		err     error
-		nntpErr *nntp.Error
-		conn    *nntp.Conn
-		ngID    string
+		err         error
+		staged      *stagedBody
+		maxBytesErr *http.MaxBytesError
+		limit       uint64
 	)
 
-	query := r.URL.Query()
-	header := make(textproto.MIMEHeader)
-	for key, values := range r.Header {
-		if strings.HasPrefix(key, "X-Usenet-") && len(key) > 9 {
-			for _, value := range values {
-				header.Add(key[9:], value)
+	if r.ContentLength > int64(s.ArticleSizeLimit) {
+		logf(r.Context(), "[ERROR] %s %s size exceeds limit", r.Method, messageID)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	verify, ok := verifyMode(r)
+	if !ok {
+		logf(r.Context(), "[ERROR] %s %s invalid verify %q", r.Method, messageID, r.URL.Query().Get("verify"))
+		setErrorCode(r.Context(), "invalid_verify")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	header, err := s.postHeader(r, messageID)
+	if errors.Is(err, errBadPostHeader) {
+		logf(r.Context(), "[ERROR] %s %s rejected: %s", r.Method, messageID, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err.Error())
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] POST %s pwgen error: %s", messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if limit, err = s.postPolicy(header); err != nil {
+		logf(r.Context(), "[ERROR] %s %s rejected: %s", r.Method, messageID, err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, err.Error())
+		return
+	} else if r.ContentLength > int64(limit) {
+		logf(r.Context(), "[ERROR] %s %s size exceeds the limit of %s", r.Method, messageID, header.Get("Newsgroups"))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	article := &nntp.Article{
+		MessageID: messageID,
+		Header:    header,
+		// unlike a plain limit, a body of unknown length exceeding the limit fails the POST instead of being truncated
+		Body: http.MaxBytesReader(w, r.Body, int64(limit)),
+	}
+	if dotEncoded {
+		article.Body = newDotEncodedReader(article.Body)
+	}
+
+	if s.StatBeforePost {
+		if found, statErr := s.statArticle(r.Context(), messageID); statErr != nil {
+			// not conclusive, go on posting
+			logf(r.Context(), "[ERROR] %s %s STAT error: %s", r.Method, messageID, statErr.Error())
+		} else if found {
+			logf(r.Context(), "[INFO] %s %s already exists", r.Method, messageID)
+			if s.DuplicatePostOK {
+				w.Header().Set("X-Already-Exists", "true")
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusConflict)
+			}
+			return
+		}
+	}
+
+	if s.spool != nil {
+		// the body is staged on disk first, so it can still be spooled if the post fails halfway
+		if staged, err = s.spool.stage(article); err != nil {
+			if errors.Is(err, errBadDotEncoding) {
+				w.WriteHeader(http.StatusBadRequest)
+			} else if errors.As(err, &maxBytesErr) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+			} else if errors.Is(err, errQuotaExceeded) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
 			}
+			logf(r.Context(), "[ERROR] %s %s spool error: %s", r.Method, messageID, err.Error())
+			return
 		}
+		defer staged.remove()
+	}
+
+	if err = s.postArticle(r.Context(), article, dotEncoded); err != nil {
+		if staged != nil && isTransientPostError(err) {
+			if spoolErr := s.spool.enqueue(r.Context(), staged, article, dotEncoded, err); spoolErr == nil {
+				logf(r.Context(), "[INFO] %s %s spooled after error: %s", r.Method, messageID, err.Error())
+				w.Header().Set("Location", articlePath("/s/", messageID))
+				w.WriteHeader(http.StatusAccepted)
+				return
+			} else {
+				logf(r.Context(), "[ERROR] %s %s spool error: %s", r.Method, messageID, spoolErr.Error())
+			}
+		}
+		if errors.Is(err, errBadDotEncoding) {
+			// the connection is closed in the middle of the POST command, the server will discard the partial article
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.As(err, &maxBytesErr) {
+			// same as above
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else if errors.Is(err, errQuotaExceeded) {
+			// same as above, the body going past the quota of the poster
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if isRejectedPostError(err) {
+			w.WriteHeader(http.StatusConflict)
+		} else if !unavailable(w, err) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		logf(r.Context(), "[ERROR] %s %s error: %s", r.Method, messageID, err.Error())
+		return
 	}
+
+	logf(r.Context(), "[INFO] POST %s", messageID)
+	if verify != "" {
+		s.answerVerified(w, r, messageID, verify)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// fillPostHeader sets the From, Newsgroups and Subject headers of an article to post if they are missing, to from,
+// newsgroups and subject if given, and otherwise to a random ngPost-like sender, DefaultNewsgroup and the message-id.
+func (s *server) fillPostHeader(header textproto.MIMEHeader, messageID nntp.MessageID, from, newsgroups, subject string) (err error) {
+	var ngID string
 	if header.Get("From") == "" {
-		if query.Get("f") != "" {
-			header.Set("From", query.Get("f"))
+		if from != "" {
+			header.Set("From", from)
 		} else {
 			// https://github.com/mbruel/ngPost/blob/7f4762b66ceefb5016a9fa6cefd310e0d3da6936/postFiles.sh#L121
-			if ngID, err = pwgen.New(pwgen.RequireCapitalize,
-				pwgen.NoAmbiguous, pwgen.RequireNumerals, pwgen.AllRandom); err != nil {
-				log.Printf("[ERROR] POST %s pwgen error: %s", messageID, err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
+			if ngID, err = ngPostID(); err != nil {
 				return
 			}
 			header.Set("From", ngID+"@ngPost.com")
 		}
 	}
 	if header.Get("Newsgroups") == "" {
-		if query.Get("g") != "" {
-			header.Set("Newsgroups", query.Get("g"))
+		if newsgroups != "" {
+			header.Set("Newsgroups", newsgroups)
 		} else {
 			header.Set("Newsgroups", s.DefaultNewsgroup)
 		}
 	}
 	if header.Get("Subject") == "" {
-		if query.Get("s") != "" {
-			header.Set("Subject", query.Get("s"))
+		if subject != "" {
+			header.Set("Subject", subject)
 		} else {
 			shortID := string(messageID.Short())
 			parts := strings.SplitN(shortID, "@", 2)
@@ -399,10 +935,25 @@ func (s *server) handleMessagePOST(w http.ResponseWriter, r *http.Request, messa
 			header.Set("Subject", shortID)
 		}
 	}
-	article := &nntp.Article{
-		MessageID: messageID,
-		Header:    header,
-		Body:      io.LimitReader(r.Body, int64(s.ArticleSizeLimit)),
+	return
+}
+
+// ngPostID returns a random name like the ones ngPost uses for senders and message-ids.
+func ngPostID() (string, error) {
+	return pwgen.New(pwgen.RequireCapitalize, pwgen.NoAmbiguous, pwgen.RequireNumerals, pwgen.AllRandom)
+}
+
+// postArticle sends the article to a posting server, using the server's configured feed method, or saves it into the
+// store in local-only mode.
+func (s *server) postArticle(ctx context.Context, article *nntp.Article, dotEncoded bool) (err error) {
+	var (
+		nntpErr *nntp.Error
+		conn    *nntp.Conn
+	)
+
+	if s.pool == nil {
+		// local-only mode, the store takes the place of Usenet
+		return s.storeArticle(article, dotEncoded)
 	}
 
 	defer func() {
@@ -414,53 +965,125 @@ func (s *server) handleMessagePOST(w http.ResponseWriter, r *http.Request, messa
 				s.pool.Close(conn)
 			}
 		}
+		if err == nil && s.recent != nil {
+			s.recent.Add(article.MessageID)
+		}
 	}()
 
-	if conn, err = s.pool.Get(true, messageID, 0); err != nil {
+	getCtx, span := startSpan(ctx, "pool.Get", attribute.Bool("usebin.posting", true))
+	conn, err = s.pool.Get(getCtx, true, article.MessageID, 0)
+	endSpan(span, err)
+	if err != nil {
 		if errors.Is(err, ErrNoMoreServers) {
-			log.Printf("[ERROR] %s %s no posting servers?", r.Method, messageID)
-			return
+			err = fmt.Errorf("no posting servers: %w", err)
 		} else {
-			log.Printf("[ERROR] %s %s pool error: %s", r.Method, messageID, err.Error())
+			err = fmt.Errorf("pool error: %w", err)
 		}
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if dotEncoded {
-		err = conn.CmdPost(article, nntp.WithDotEncodedBody())
-	} else {
-		err = conn.CmdPost(article)
-	}
+	server, _ := s.pool.Server(conn)
+	command := feedCommand(server.Feed)
+	_, span = startSpan(ctx, "nntp "+command, s.commandAttributes(conn, article.MessageID)...)
+	defer func() {
+		endSpan(span, err)
+		logCommand(ctx, server.Host, command, article.MessageID, err)
+	}()
+	err = s.sendArticle(conn, server.Feed, article, dotEncoded)
+	return
+}
 
-	if err != nil {
-		if errors.Is(err, nntp.ResponseCodePostingFailure) {
-			w.WriteHeader(http.StatusConflict)
+// sendArticle sends the article over conn with the command of feed: POST, IHAVE or TAKETHIS.
+func (s *server) sendArticle(conn *nntp.Conn, feed string, article *nntp.Article, dotEncoded bool) (err error) {
+	switch feed {
+	case FeedIHave:
+		prepareFeedHeader(article.Header, s.PathIdentity)
+		err = cmdIHave(conn, article, dotEncoded)
+	case FeedTakeThis:
+		prepareFeedHeader(article.Header, s.PathIdentity)
+		err = cmdTakeThis(conn, article, dotEncoded)
+	default:
+		if dotEncoded {
+			err = conn.CmdPost(article, nntp.WithDotEncodedBody())
 		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			err = conn.CmdPost(article)
 		}
-		log.Printf("[ERROR] %s %s error: %s", r.Method, messageID, err.Error())
-		return
 	}
+	return
+}
 
-	w.WriteHeader(http.StatusOK)
-	log.Printf("[INFO] POST %s", messageID)
+// isRejectedPostError reports whether the server refused the article itself, so posting it again is pointless.
+func isRejectedPostError(err error) bool {
+	return errors.Is(err, nntp.ResponseCodePostingFailure) || errors.Is(err, nntp.ResponseCodeTransferUnwanted) ||
+		errors.Is(err, nntp.ResponseCodeTransferRejected) || errors.Is(err, ResponseCodeTakeThisRejected) ||
+		errors.Is(err, errArticleStored)
+}
+
+// isTransientPostError reports whether a failed post is worth retrying later, that is the servers couldn't be reached
+// in time or answered with a 4xx asking to try again later. Local errors, like a malformed or oversize body or the
+// request canceled, and the servers refusing the article or its poster are not.
+func isTransientPostError(err error) bool {
+	var (
+		nntpErr *nntp.Error
+		netErr  net.Error
+	)
+	if isRejectedPostError(err) {
+		return false
+	}
+	if errors.As(err, &nntpErr) {
+		switch nntpErr.Code {
+		case nntp.ResponseCodePostingProhibited, nntp.ResponseCodePostingFailure:
+			return false
+		}
+		return nntpErr.Code >= 400 && nntpErr.Code < 500
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, ErrPoolSaturated)
+}
+
+// statArticle reports whether the store or any of the NNTP servers already has the article, using the STAT command.
+func (s *server) statArticle(ctx context.Context, messageID nntp.MessageID) (found bool, err error) {
+	var (
+		conn    *nntp.Conn
+		article *nntp.Article
+	)
+	if conn, article, err = s.lookup(ctx, messageID, false, "STAT", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdStat(nntp.ArticleMessageID(messageID))
+	}); errors.Is(err, ErrArticleNotFound) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	if conn != nil {
+		s.pool.Put(conn)
+	} else {
+		closeArticle(article)
+	}
+	found = true
+	return
 }
 
-func (s *server) handleMessageHead(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
+// handleMessageHead serves the article headers using the NNTP HEAD command. If withLength is set, Content-Length is
+// the size of the stored body, or of the dot-encoded one from the article's byte count, without downloading the full
+// article. The dot-decoded size can only be estimated from the byte count, sent in X-Usebin-Body-Size instead.
+func (s *server) handleMessageHead(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID, withLength, dotEncoded bool) {
 	var (
 		err     error
 		nntpErr *nntp.Error
 		conn    *nntp.Conn
 		article *nntp.Article
+		size    int64
+		sized   bool
 		done    bool
-		found   bool
-		retries int
 	)
 
 	ctype := "text/plain; charset=utf-8"
 
-	if done, _ = checkPreconditions(w, r); done {
+	if done, _ = s.checkArticlePreconditions(w, r, messageID, s.routeETag(r, messageID)); done {
 		return
 	}
 
@@ -472,60 +1095,87 @@ func (s *server) handleMessageHead(w http.ResponseWriter, r *http.Request, messa
 			} else {
 				s.pool.Close(conn)
 			}
+		} else {
+			closeArticle(article)
 		}
 	}()
 
-	for found, retries = false, 0; !found; retries++ {
-		if conn, err = s.pool.Get(false, messageID, retries); errors.Is(err, ErrNoMoreServers) {
-			break
-		} else if err != nil {
-			log.Printf("[ERROR] %s %s HEAD pool error: %s", r.Method, messageID, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		if article, err = conn.CmdHead(nntp.ArticleMessageID(messageID)); err != nil {
-			if errors.As(err, &nntpErr) {
-				s.pool.Put(conn)
-				continue
-			}
-			log.Printf("[ERROR] %s %s HEAD connection error: %s", r.Method, messageID, err.Error())
+	if conn, article, err = s.lookup(r.Context(), messageID, false, "HEAD", func(conn *nntp.Conn) (*nntp.Article, error) {
+		return conn.CmdHead(nntp.ArticleMessageID(messageID))
+	}); errors.Is(err, ErrArticleNotFound) {
+		logf(r.Context(), "[ERROR] %s %s HEAD not found", r.Method, messageID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] %s %s HEAD %s", r.Method, messageID, err.Error())
+		if !unavailable(w, err) {
 			w.WriteHeader(http.StatusInternalServerError)
-			return
 		}
-		found = true
-	}
-
-	if !found {
-		log.Printf("[ERROR] %s %s HEAD not found", r.Method, messageID)
-		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	for key, values := range article.Header {
-		switch strings.ToLower(key) {
-		case "organization", "x-complaints-to":
-			continue
+	ctype = s.setContentType(w, article.Header, nil, ctype)
+	// Content-Length is only sent when it is the one GET sends, caches rejecting the responses of another length
+	switch body, inMemory := article.Body.(memoryBody); {
+	case !withLength:
+	case dotEncoded:
+		if conn == nil {
+			// GET /d/ streams the stored articles dot-encoded without a length
+			break
 		}
-		for _, value := range values {
-			w.Header().Add("X-Usenet-"+key, value)
+		var ov *nntp.ArticleOverview
+		if article.Header.Get("Bytes") == "" {
+			if ov, err = overview(r.Context(), conn, messageID); err != nil && !errors.As(err, &nntpErr) {
+				logf(r.Context(), "[ERROR] %s %s HEAD overview error: %s", r.Method, messageID, err.Error())
+			}
+		}
+		if size, sized = dotEncodedBodySize(article.Header, ov); sized && size <= int64(s.ArticleSizeLimit) {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+	case inMemory:
+		w.Header().Set("Content-Length", strconv.FormatInt(body.Size(), 10))
+	case conn == nil:
+		if size, err = s.store.Stat(messageID); err != nil {
+			logf(r.Context(), "[ERROR] %s %s HEAD store error: %s", r.Method, messageID, err.Error())
+			err = nil
+		} else {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+	default:
+		// an estimate only
+		if size, sized, err = articleBodySize(r.Context(), conn, messageID, article.Header); err != nil && !errors.As(err, &nntpErr) {
+			logf(r.Context(), "[ERROR] %s %s HEAD overview error: %s", r.Method, messageID, err.Error())
+		} else if sized {
+			w.Header().Set("X-Usebin-Body-Size", strconv.FormatInt(size, 10))
 		}
 	}
+
+	copyUsenetHeaders(w.Header(), article.Header)
+	setArticleDate(w.Header(), article.Header)
 	w.Header().Set("Content-Type", ctype)
 	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("ETag", "\""+string(messageID.Short())+"\"")
+	setETag(w, s.routeETag(r, messageID))
 
 	w.WriteHeader(http.StatusOK)
 
-	log.Printf("[INFO] %s %s HEAD", r.Method, messageID)
+	logf(r.Context(), "[INFO] %s %s HEAD", r.Method, messageID)
 }
 
-func (s *server) Serve() (err error) {
-	if len(s.NNTPServers) == 0 {
-		err = fmt.Errorf("no NNTP server definitions")
+// applyDefaults validates the config and fills in the defaults of the keys not set.
+func (s *server) applyDefaults() (err error) {
+	if len(s.NNTPServers) == 0 && s.StoreDir == "" {
+		err = fmt.Errorf("no NNTP server definitions and no StoreDir")
 		return
 	}
-	if s.Host == "" {
-		s.Host = "0.0.0.0"
+	if s.LogLevel != "" {
+		var level int32
+		if level, err = parseLogLevel(s.LogLevel); err != nil {
+			return
+		}
+		logLevel.Store(level)
+	}
+	if err = setLogFormat(s.LogFormat); err != nil {
+		return
 	}
 	if s.Port == 0 {
 		s.Port = 80
@@ -533,48 +1183,346 @@ func (s *server) Serve() (err error) {
 	if s.IdleConnExpiry == 0 {
 		s.IdleConnExpiry = 60
 	}
+	if s.RemoteConfigInterval == 0 {
+		s.RemoteConfigInterval = defaultRemoteConfigInterval
+	}
 	if s.DefaultNewsgroup == "" {
 		s.DefaultNewsgroup = "alt.binaries.misc"
 	}
+	if s.MessageIDDomain == "" {
+		s.MessageIDDomain = "ngPost.com"
+	} else if !isDotAtom(s.MessageIDDomain) {
+		err = fmt.Errorf("invalid MessageIDDomain %q", s.MessageIDDomain)
+		return
+	}
+	if s.BatchSizeLimit == 0 {
+		s.BatchSizeLimit = 100
+	}
+	if s.BatchConcurrency == 0 {
+		s.BatchConcurrency = 8
+	}
+	if s.VerifyAttempts == 0 {
+		s.VerifyAttempts = 5
+	}
+	if s.VerifyDelay == 0 {
+		s.VerifyDelay = 2000
+	}
+	if s.TusSegmentSize == 0 {
+		s.TusSegmentSize = defaultTusSegmentSize
+	}
+	if s.JoinScanRange == 0 {
+		s.JoinScanRange = 5000
+	}
+	if s.PathIdentity == "" {
+		s.PathIdentity = "usebin"
+	}
+	if s.LocatorTTL == 0 {
+		s.LocatorTTL = 7 * 24 * 3600
+	}
+	if s.LocatorTimeout == 0 {
+		s.LocatorTimeout = 50
+	}
+	for _, server := range s.NNTPServers {
+		switch server.Feed {
+		case FeedPost, FeedIHave, FeedTakeThis:
+		default:
+			err = fmt.Errorf("invalid feed method %q for NNTP server %s", server.Feed, server.Host)
+			return
+		}
+		if server.Retention < 0 {
+			err = fmt.Errorf("negative Retention for NNTP server %s", server.Host)
+			return
+		}
+		if connections := server.Connections; server.ReservedPostingConnections > 0 {
+			if connections == 0 {
+				connections = defaultConnections
+			}
+			if server.ReservedPostingConnections >= connections {
+				err = fmt.Errorf("ReservedPostingConnections of NNTP server %s is not less than its Connections", server.Host)
+				return
+			}
+		}
+	}
+	if s.AdminUser == "" {
+		s.AdminUser = "admin"
+	}
+	if s.StatsAuth && s.AdminPass == "" && len(s.AuthMethods[roleAdmin]) == 0 {
+		err = fmt.Errorf("StatsAuth is set without an AdminPass or admin AuthMethods")
+		return
+	}
+	if err = validateNewsgroupPolicies(s.NewsgroupPolicies); err != nil {
+		return
+	}
+	if err = validateBackendRules(s.BackendRules, s.NNTPServers); err != nil {
+		return
+	}
+	if err = validateRouteCaching(s.RouteCaching); err != nil {
+		return
+	}
+	if err = validateAcceptExtensions(s.AcceptExtensions); err != nil {
+		return
+	}
+	if err = validateOversizeArticles(s.OversizeArticles); err != nil {
+		return
+	}
+	if err = s.resolveNNTPCodes(); err != nil {
+		return
+	}
+	if s.PurgeURL == "" && s.CloudflareZoneID != "" {
+		s.PurgeURL = fmt.Sprintf(cloudflarePurgeURL, s.CloudflareZoneID)
+	}
+	if s.PurgeURL != "" && s.PublicURL == "" {
+		err = fmt.Errorf("PublicURL is required to purge the CDN")
+		return
+	}
 	if s.ArticleSizeLimit == 0 {
 		s.ArticleSizeLimit = 4 * 1024 * 1024 // 4MB
 	}
+	if s.ArticleMemoryLimit == 0 || s.ArticleMemoryLimit > s.ArticleSizeLimit {
+		s.ArticleMemoryLimit = s.ArticleSizeLimit
+	}
+	if s.MemoryArticleLimit == 0 {
+		s.MemoryArticleLimit = 256 * 1024 // 256KB
+	}
+
+	if s.SpoolRetryDelay == 0 {
+		s.SpoolRetryDelay = 30
+	}
+	if s.TraceSampleRatio == 0 {
+		s.TraceSampleRatio = 1
+	}
+
+	if err = s.validateListen(); err != nil {
+		return
+	}
+	if err = s.validateAuth(); err != nil {
+		return
+	}
+	if len(s.Quotas) > 0 && s.QuotaDB == "" {
+		err = fmt.Errorf("Quotas is set without a QuotaDB")
+		return
+	}
+
+	s.trustedProxies, err = parseTrustedProxies(s.TrustedProxies)
+	return
+}
+
+// openArticles sets up where articles are fetched from and posted to: the pool of NNTP servers and the store.
+func (s *server) openArticles() (err error) {
+	if len(s.NNTPServers) > 0 {
+		s.pool = NewPool(s.NNTPServers, s.BackendRules, time.Second*time.Duration(s.IdleConnExpiry))
+		s.pool.SetSaturation(s.MaxPoolWaiters, time.Duration(s.MaxPoolWait)*time.Millisecond)
+		s.pool.SetIdleProbe(time.Duration(s.IdleConnProbe) * time.Second)
+		s.metrics = newPoolMetrics()
+		observers := []PoolObserver{s.metrics}
+		if s.PoolEventLog {
+			if observer := newSlogObserver(); observer != nil {
+				observers = append(observers, observer)
+			} else {
+				logPrintf("[WARN] PoolEventLog requires usebin to be built with Go 1.21 or later")
+			}
+		}
+		s.pool.SetObservers(observers...)
+		for _, server := range s.NNTPServers {
+			if server.Retention > 0 {
+				s.dates = newDateCache()
+				break
+			}
+		}
+		if s.PropagationWindow > 0 {
+			s.recent = newRecentPosts(time.Duration(s.PropagationWindow) * time.Second)
+		}
+	}
+	s.sums = newSumCache()
+	if s.FastStart {
+		s.fastStarts = newFastStartCache()
+	}
+	s.verifications = newVerifications()
+	if s.ShadowReadRatio > 0 && len(s.NNTPServers) > 1 {
+		s.shadowReads = make(chan struct{}, maxShadowReads)
+	}
+	if s.MemoryCacheSize > 0 {
+		s.memory = newMemoryCache(s.MemoryCacheSize)
+	}
+	if s.StoreDir != "" {
+		s.store, err = newFileStore(s.StoreDir)
+	}
+	return
+}
+
+func (s *server) Serve() (err error) {
+	if err = s.applyDefaults(); err != nil {
+		return
+	}
+
+	s.started = time.Now()
+	s.activity = new(activityLog)
+	s.bufPool = newBufferPool(int(s.ArticleMemoryLimit))
+	if err = s.openLargeArticleDir(); err != nil {
+		return
+	}
+
+	if err = s.openArticles(); err != nil {
+		return
+	}
+	if s.pool == nil {
+		logPrintf("[INFO] no NNTP servers, serving articles from %s only", s.StoreDir)
+	}
 
-	s.bufPool = sync.Pool{New: func() any {
-		return make([]byte, s.ArticleSizeLimit)
-	}}
+	if s.TraceEndpoint != "" {
+		if err = s.setupTracing(); err != nil {
+			return
+		}
+	}
 
-	s.pool = NewPool(s.NNTPServers, time.Second*time.Duration(s.IdleConnExpiry))
+	if s.SpoolDir != "" && s.pool != nil {
+		if s.spool, err = newSpool(s, s.SpoolDir); err != nil {
+			return
+		}
+		go s.spool.run()
+	}
+
+	if s.TakedownDB != "" {
+		if s.takedowns, err = openTakedowns(s.TakedownDB); err != nil {
+			return
+		}
+	}
+
+	if s.IndexDB != "" {
+		if s.index, err = openIndex(s.IndexDB); err != nil {
+			return
+		}
+	}
+
+	if s.QuotaDB != "" {
+		if s.quotas, err = openQuotas(s.QuotaDB); err != nil {
+			return
+		}
+		defer func() {
+			if err := s.quotas.Stop(); err != nil {
+				logPrintf("[ERROR] [Quota] flush error: %s", err.Error())
+			}
+		}()
+	}
+
+	if s.DavDir != "" {
+		if s.dav, err = openDavJobs(s.DavDir); err != nil {
+			return
+		}
+	}
+
+	if s.TusDir != "" {
+		if s.tus, err = openTusUploads(s.TusDir); err != nil {
+			return
+		}
+	}
+
+	if s.ShareDB != "" {
+		if s.shares, err = openShares(s.ShareDB); err != nil {
+			return
+		}
+	}
+
+	if s.AliasDB != "" {
+		if s.aliases, err = openAliases(s.AliasDB); err != nil {
+			return
+		}
+	}
+
+	if s.LocatorURL != "" && s.pool != nil {
+		if s.locator, err = newRedisLocator(s.LocatorURL, time.Duration(s.LocatorTTL)*time.Second, time.Duration(s.LocatorTimeout)*time.Millisecond); err != nil {
+			return
+		}
+		go s.locator.run()
+	}
+
+	if s.RemoteConfigURL != "" && s.pool != nil {
+		go s.refreshRemoteConfig()
+	}
+
+	if s.Htpasswd != "" {
+		if s.htpasswd, err = loadHtpasswd(s.Htpasswd); err != nil {
+			return
+		}
+	}
+
+	if s.AdminPort != 0 {
+		if err = s.serveAdmin(); err != nil {
+			return
+		}
+	}
 
 	subFS, err := fs.Sub(staticFS, "static")
 	if err != nil {
 		return
 	}
-	httpFS := http.FS(subFS)
+	if s.assets, err = newAssetFS(subFS); err != nil {
+		return
+	}
+	httpFS := http.FS(s.assets)
 	fileServer := http.FileServer(httpFS)
 	serveIndex := serveFileContents("index.html", httpFS)
-	staticHandler := intercept404(fileServer, serveIndex)
-	mainHandler := s.handleMessage(staticHandler)
+	staticHandler := s.assets.withETags(intercept404(fileServer, serveIndex))
+	mainHandler := s.realIP(s.withRequestID(s.trace(s.errorBodies(s.recordActivity(s.authorize(s.quota(s.account(s.throttle(s.handleMessage(staticHandler))))))))))
 
 	httpServer := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", s.Host, s.Port),
-		Handler: mainHandler,
+		Handler:     mainHandler,
+		ConnContext: peerConnContext,
 	}
+	var lns []net.Listener
+	if lns, err = s.listenAll(s.listenAddrs()); err != nil {
+		return
+	}
+	errChan := make(chan error, len(lns)+len(s.plainListenAddrs()))
 
 	if s.CertFile != "" && s.KeyFile != "" {
-		var serverCert tls.Certificate
-		if serverCert, err = tls.LoadX509KeyPair(s.CertFile, s.KeyFile); err != nil {
+		if httpServer.TLSConfig, err = s.tlsConfig(); err != nil {
 			return
 		}
-		httpServer.TLSConfig = &tls.Config{
-			Certificates: []tls.Certificate{serverCert},
-		}
+		httpServer.Handler = s.hsts(mainHandler)
 		httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
-		log.Printf("Listening at https://%s\n", httpServer.Addr)
-		err = httpServer.ListenAndServeTLS("", "")
+		if plainAddrs := s.plainListenAddrs(); len(plainAddrs) > 0 {
+			// plain HTTP listeners alongside the HTTPS ones, either serving the same content or redirecting to HTTPS
+			plainServer := &http.Server{
+				Handler:     mainHandler,
+				ConnContext: peerConnContext,
+			}
+			if s.RedirectHTTP {
+				plainServer.Handler = s.redirectHTTPS(listenPort(lns[0]))
+			}
+			var plainLns []net.Listener
+			if plainLns, err = s.listenAll(plainAddrs); err != nil {
+				return
+			}
+			for _, ln := range plainLns {
+				go func(ln net.Listener) {
+					log.Printf("Listening at http://%s\n", ln.Addr())
+					errChan <- plainServer.Serve(ln)
+				}(ln)
+			}
+		}
+		for _, ln := range lns {
+			go func(ln net.Listener) {
+				log.Printf("Listening at https://%s\n", ln.Addr())
+				errChan <- httpServer.ServeTLS(ln, "", "")
+			}(ln)
+		}
 	} else {
-		log.Printf("Listening at http://%s\n", httpServer.Addr)
-		err = httpServer.ListenAndServe()
+		for _, ln := range lns {
+			go func(ln net.Listener) {
+				log.Printf("Listening at http://%s\n", ln.Addr())
+				errChan <- httpServer.Serve(ln)
+			}(ln)
+		}
+	}
+	// returning on SIGINT and SIGTERM, for the usage counted by the quotas since their last flush to be written
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	select {
+	case err = <-errChan:
+	case sig := <-stop:
+		logPrintf("[INFO] %s received, stopping", sig)
 	}
 	return
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestServer returns a server with the fake NNTP servers as its NNTPServers, ready to serve the requests to
+// handleMessage. Its pool is stopped once the test is done.
+func newTestServer(t testing.TB, fakes ...*fakeNNTP) (s *server) {
+	s = &server{LargeArticleDir: t.TempDir()}
+	for _, f := range fakes {
+		s.NNTPServers = append(s.NNTPServers, NNTPServer{Host: f.Host(), User: f.User, Pass: f.Pass, Posting: true, Connections: 2})
+	}
+	if err := s.applyDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	s.bufPool = newBufferPool(int(s.ArticleMemoryLimit))
+	if err := s.openLargeArticleDir(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.openArticles(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.pool.Stop(ctx)
+	})
+	return
+}
+
+// serve runs the request through handleMessage, with the static files answered with 404 Not Found.
+func serve(s *server, method, target string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	s.handleMessage(http.NotFoundHandler()).ServeHTTP(w, r)
+	return w
+}
+
+const testHeader = "Subject: test article\nFrom: poster <poster@example.com>\nDate: Mon, 02 Jan 2006 15:04:05 -0700\nNewsgroups: alt.test"
+
+func TestHandleMessageGET(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader, "line 1\r\n.dotted line\r\n")
+	s := newTestServer(t, f)
+
+	w := serve(s, http.MethodGet, "/m/a@example.com.csv", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /m/ answered %d", w.Code)
+	}
+	if body := w.Body.String(); body != "line 1\n.dotted line\n" {
+		t.Errorf("GET /m/ body %q", body)
+	}
+	if got := w.Header().Get("X-Usenet-Subject"); got != "test article" {
+		t.Errorf("X-Usenet-Subject %q", got)
+	}
+	if got := w.Header().Get("ETag"); got != `"a@example.com"` {
+		t.Errorf("ETag %q", got)
+	}
+
+	w = serve(s, http.MethodGet, "/m/a@example.com.csv", nil, http.Header{"If-None-Match": {`"a@example.com"`}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("GET /m/ with a matching If-None-Match answered %d", w.Code)
+	}
+}
+
+func TestHandleMessageHead(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader+"\nBytes: 500\nLines: 10", "body\r\n")
+	s := newTestServer(t, f)
+
+	for _, test := range []struct {
+		method, target string
+		length, size   string
+	}{
+		// 500 bytes less the 144 of the header and the CR of the 10 lines
+		{http.MethodHead, "/m/a@example.com.csv", "", "346"},
+		// with the termination line
+		{http.MethodHead, "/d/a@example.com.csv", "359", ""},
+		{http.MethodGet, "/h/a@example.com.csv", "", ""},
+	} {
+		w := serve(s, test.method, test.target, nil, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s answered %d", test.method, test.target, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Length"); got != test.length {
+			t.Errorf("%s %s Content-Length %q, want %q", test.method, test.target, got, test.length)
+		}
+		if got := w.Header().Get("X-Usebin-Body-Size"); got != test.size {
+			t.Errorf("%s %s X-Usebin-Body-Size %q, want %q", test.method, test.target, got, test.size)
+		}
+		if got := w.Header().Get("X-Usenet-From"); got != "poster <poster@example.com>" {
+			t.Errorf("%s %s X-Usenet-From %q", test.method, test.target, got)
+		}
+	}
+}
+
+func TestHandleDotEncodedMessageGET(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader, "line 1\r\nline 2\r\n")
+	f.add("dotted@example.com", testHeader, "line 1\r\n.dotted line\r\n")
+	s := newTestServer(t, f)
+
+	w := serve(s, http.MethodGet, "/d/a@example.com.csv", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /d/ answered %d", w.Code)
+	}
+	if body := w.Body.String(); body != "line 1\r\nline 2\r\n.\r\n" {
+		t.Errorf("GET /d/ body %q", body)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("GET /d/ Content-Length %q for %d bytes", got, w.Body.Len())
+	}
+
+	// the overview byte count leaves out the dot-stuffing, so the body is sent without a size
+	f.answer("OVER", 503)
+	w = serve(s, http.MethodGet, "/d/dotted@example.com.csv", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /d/ answered %d", w.Code)
+	}
+	if body := w.Body.String(); body != "line 1\r\n..dotted line\r\n.\r\n" {
+		t.Errorf("GET /d/ body %q", body)
+	}
+	if got := w.Header().Get("Content-Length"); got != "" {
+		t.Errorf("GET /d/ without a size Content-Length %q", got)
+	}
+}
+
+func TestHandleMessageNotFound(t *testing.T) {
+	s := newTestServer(t, newFakeNNTP(t), newFakeNNTP(t))
+	for _, target := range []string{"/m/missing@example.com.csv", "/d/missing@example.com.csv", "/h/missing@example.com.csv"} {
+		if w := serve(s, http.MethodGet, target, nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s answered %d", target, w.Code)
+		}
+	}
+}
+
+func TestHandleMessageBackendFailure(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader, "body\r\n")
+	f.answer("ARTICLE", -1)
+	f.answer("HEAD", -1)
+	s := newTestServer(t, f)
+	for _, target := range []string{"/m/a@example.com.csv", "/d/a@example.com.csv", "/h/a@example.com.csv"} {
+		w := serve(s, http.MethodGet, target, nil, nil)
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("GET %s with the connection closed answered %d", target, w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("GET %s failing Cache-Control %q", target, got)
+		}
+	}
+
+	// a server which cannot be reached
+	down := newFakeNNTP(t)
+	down.close()
+	s = newTestServer(t, down)
+	if w := serve(s, http.MethodGet, "/m/a@example.com.csv", nil, nil); w.Code != http.StatusBadGateway {
+		t.Errorf("GET /m/ from a server down answered %d", w.Code)
+	}
+}
+
+func TestHandleMessageRange(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.add("a@example.com", testHeader, "line 1\r\nline 2\r\n")
+	s := newTestServer(t, f)
+
+	w := serve(s, http.MethodGet, "/m/a@example.com.csv", nil, http.Header{"Range": {"bytes=7-"}})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("GET /m/ with a Range answered %d", w.Code)
+	}
+	if body := w.Body.String(); body != "line 2\n" {
+		t.Errorf("GET /m/ with a Range body %q", body)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 7-13/14" {
+		t.Errorf("GET /m/ with a Range Content-Range %q", got)
+	}
+
+	// past the end of the body
+	w = serve(s, http.MethodGet, "/m/a@example.com.csv", nil, http.Header{"Range": {"bytes=14-"}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("GET /m/ with a Range past the end answered %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes */14" {
+		t.Errorf("GET /m/ with a Range past the end Content-Range %q", got)
+	}
+}
+
+func TestHandleMessageFailover(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		code int
+	}{
+		{"missing", 430},
+		{"dropping the connection", -1},
+	} {
+		fakes := []*fakeNNTP{newFakeNNTP(t), newFakeNNTP(t)}
+		s := newTestServer(t, fakes...)
+		first := s.pool.first("a@example.com")
+		a, b := fakes[first], fakes[1-first]
+		a.add("a@example.com", testHeader, "from a\r\n")
+		b.add("a@example.com", testHeader, "from b\r\n")
+		a.answer("ARTICLE", test.code)
+
+		w := serve(s, http.MethodGet, "/m/a@example.com.csv", nil, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET /m/ with the first server %s answered %d", test.name, w.Code)
+			continue
+		}
+		if body := w.Body.String(); body != "from b\n" {
+			t.Errorf("GET /m/ with the first server %s body %q", test.name, body)
+		}
+		if na, nb := a.received("ARTICLE"), b.received("ARTICLE"); na != 1 || nb != 1 {
+			t.Errorf("with the first server %s ARTICLE sent %d times to it, %d to the second one", test.name, na, nb)
+		}
+	}
+}
+
+func TestHandleMessageAuthinfo(t *testing.T) {
+	f := newFakeNNTP(t)
+	f.User, f.Pass = "user", "secret"
+	f.add("a@example.com", testHeader, "body\r\n")
+	s := newTestServer(t, f)
+	if w := serve(s, http.MethodGet, "/m/a@example.com.csv", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /m/ with AUTHINFO answered %d", w.Code)
+	}
+	if n := f.received("AUTHINFO PASS"); n != 1 {
+		t.Errorf("AUTHINFO PASS sent %d times", n)
+	}
+}
+
+func TestHandleMessagePOST(t *testing.T) {
+	f := newFakeNNTP(t)
+	s := newTestServer(t, f)
+
+	header := http.Header{"X-Usenet-Subject": {"posted"}, "Content-Type": {"application/octet-stream"}}
+	w := serve(s, http.MethodPost, "/m/new@example.com.csv?g=alt.test", strings.NewReader("line 1\n.dotted line\n"), header)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /m/ answered %d: %s", w.Code, w.Body.String())
+	}
+	article, ok := f.article("new@example.com")
+	if !ok {
+		t.Fatal("POST /m/ did not post the article")
+	}
+	if article.body != "line 1\r\n.dotted line\r\n" {
+		t.Errorf("posted body %q", article.body)
+	}
+	for _, line := range []string{"Subject: posted", "Newsgroups: alt.test"} {
+		if !strings.Contains(article.header, line) {
+			t.Errorf("posted header %q without %q", article.header, line)
+		}
+	}
+
+	// the post is read back
+	if w = serve(s, http.MethodGet, "/m/new@example.com.csv", nil, nil); w.Code != http.StatusOK || w.Body.String() != "line 1\n.dotted line\n" {
+		t.Errorf("GET /m/ of the post answered %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve(s, http.MethodPost, "/d/raw@example.com.csv", strings.NewReader(".bad dot\r\n"), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST /d/ with a malformed body answered %d", w.Code)
+	}
+
+	f.answer("POST", 441)
+	if w = serve(s, http.MethodPost, "/m/refused@example.com.csv", strings.NewReader("body\n"), nil); w.Code != http.StatusConflict {
+		t.Errorf("POST /m/ refused answered %d", w.Code)
+	}
+}
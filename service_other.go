@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "log"
+
+// runService runs serve as a Windows service, never elsewhere, where the service managers like systemd and launchd
+// run the serve command as is.
+func runService(serve func() error) (ran bool, err error) {
+	return
+}
+
+func serviceCommand(args []string) {
+	log.Fatal("the service command is only available on Windows, see the README for systemd and launchd")
+}
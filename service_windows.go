@@ -0,0 +1,158 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name of the Windows service and of the source of its events in the event log.
+const serviceName = "usebin"
+
+// windowsService runs serve until the service control manager stops it.
+type windowsService struct {
+	serve func() error
+}
+
+func (s windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (specific bool, exitCode uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			log.Printf("[ERROR] %s", err.Error())
+			return true, 1
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				return
+			}
+		}
+	}
+}
+
+// eventLogWriter writes the lines of the log to the event log, as errors, warnings or information by their level.
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (n int, err error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	switch {
+	case strings.Contains(line, "[ERROR]"):
+		err = w.elog.Error(1, line)
+	case strings.Contains(line, "[WARN]"):
+		err = w.elog.Warning(1, line)
+	default:
+		err = w.elog.Info(1, line)
+	}
+	if err == nil {
+		n = len(p)
+	}
+	return
+}
+
+// runService runs serve as a Windows service if the process was started by the service control manager, logging to
+// the event log. It reports whether it did.
+func runService(serve func() error) (ran bool, err error) {
+	if ran, err = svc.IsWindowsService(); err != nil || !ran {
+		return
+	}
+	if elog, err := eventlog.Open(serviceName); err == nil {
+		defer elog.Close()
+		log.SetOutput(redactingWriter{W: eventLogWriter{elog}})
+	}
+	err = svc.Run(serviceName, windowsService{serve})
+	return
+}
+
+// serviceCommand installs or uninstalls the Windows service, running the serve command with the absolute path of the
+// config file given with -config, or of the default one. The other flags are not kept, the service only has the
+// config file and the environment of the system.
+func serviceCommand(args []string) {
+	if len(args) != 1 || args[0] != "install" && args[0] != "uninstall" {
+		log.Fatal("usage: usebin [-config <file>] service install|uninstall")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer m.Disconnect()
+	if args[0] == "uninstall" {
+		if err = uninstallService(m); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("service %s uninstalled\n", serviceName)
+		return
+	}
+	if err = installService(m); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("service %s installed, start it with: sc start %s\n", serviceName, serviceName)
+}
+
+func installService(m *mgr.Mgr) (err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	path := *configPath
+	if path == "" {
+		if path, err = defaultConfigPath(); err != nil {
+			return
+		}
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return
+	} else if _, err = os.Stat(path); err != nil {
+		return fmt.Errorf("the service needs a config file: %w", err)
+	}
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "usebin",
+		Description: "Encrypted pastebin stored on Usenet",
+		StartType:   mgr.StartAutomatic,
+	}, "-config", path)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	if err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		err = fmt.Errorf("cannot install the event log source: %w", err)
+	}
+	return
+}
+
+func uninstallService(m *mgr.Mgr) (err error) {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	if err = s.Delete(); err != nil {
+		return
+	}
+	if err = eventlog.Remove(serviceName); err != nil {
+		err = fmt.Errorf("cannot remove the event log source: %w", err)
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+
+	"gopkg.in/nntp.v0"
+)
+
+// maxShadowReads is the number of shadow reads running at the same time, beyond which the sampled GETs are not
+// shadowed.
+const maxShadowReads = 4
+
+// shadowSampled reports whether a GET served from an NNTP server is shadowed, for ShadowReadRatio of them, unless it
+// is forced to a server.
+func (s *server) shadowSampled(ctx context.Context) bool {
+	return s.shadowReads != nil && forcedServer(ctx) == "" && rand.Float64() < s.ShadowReadRatio
+}
+
+// shadowRead fetches in the background the body of the article just served from the NNTP server of conn, with sums,
+// from another NNTP server, and logs a WARN if the SHA-256 of its body differs, so that a provider silently serving
+// damaged articles is noticed. The other server is the first one after the server of conn that a GET would try. The
+// shadow read skips the caches like the X-Usebin-Server header, and is dropped if maxShadowReads are running already.
+func (s *server) shadowRead(ctx context.Context, messageID nntp.MessageID, conn *nntp.Conn, sums *articleSums) {
+	served, _ := s.pool.Server(conn)
+	host := s.pool.shadowServer(messageID, served.Host)
+	if host == "" {
+		return
+	}
+	select {
+	case s.shadowReads <- struct{}{}:
+	default:
+		logf(ctx, "[DEBUG] [Shadow] %s dropped, %d shadow reads running", messageID, maxShadowReads)
+		return
+	}
+	// the request is answered without waiting, only its id is kept for the log
+	ctx = context.WithValue(context.WithValue(context.Background(), requestIDKey{}, requestID(ctx)), forcedServerKey{}, host)
+	go func() {
+		defer func() { <-s.shadowReads }()
+		conn, article, err := s.fetch(ctx, messageID, "BODY", func(conn *nntp.Conn) (*nntp.Article, error) {
+			return conn.CmdBody(nntp.ArticleMessageID(messageID))
+		})
+		if errors.Is(err, ErrArticleNotFound) {
+			logf(ctx, "[INFO] [Shadow] %s served by %s is missing on %s", messageID, served.Host, host)
+			return
+		} else if err != nil {
+			logf(ctx, "[ERROR] [Shadow] %s %s: %s", messageID, host, err.Error())
+			return
+		}
+		sha := sha256.New()
+		size, err := io.Copy(sha, io.LimitReader(article.Body, int64(s.ArticleSizeLimit)+1))
+		if err != nil || size > int64(s.ArticleSizeLimit) {
+			// the rest of the body is left unread
+			s.pool.Close(conn)
+		} else {
+			s.pool.Put(conn)
+		}
+		if err != nil {
+			logf(ctx, "[ERROR] [Shadow] %s %s read error: %s", messageID, host, err.Error())
+			return
+		}
+		if sum := hex.EncodeToString(sha.Sum(nil)); sum != sums.SHA256 || size != sums.Size {
+			logf(ctx, "[WARN] [Shadow] %s differs: %s served %d bytes with SHA-256 %s, %s has %d bytes with SHA-256 %s",
+				messageID, served.Host, sums.Size, sums.SHA256, host, size, sum)
+			return
+		}
+		logf(ctx, "[DEBUG] [Shadow] %s matches on %s", messageID, host)
+	}()
+}
+
+// shadowServer returns the host of the server a shadow read of the article served by primary is sent to: the first
+// other server Get would try, ignoring the Retention of the servers, "" if there is none.
+func (p *Pool) shadowServer(messageID nntp.MessageID, primary string) string {
+	for _, sp := range p.order(messageID, p.first(messageID), "", 0, true) {
+		if sp.server.Host != primary {
+			return sp.server.Host
+		}
+	}
+	return ""
+}
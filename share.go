@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/nntp.v0"
+)
+
+var shareBucket = []byte("shares")
+
+var (
+	errShareNotFound = errors.New("share link not found")
+	errShareGone     = errors.New("share link expired or used up")
+)
+
+const (
+	// shareSweepInterval is how often the links expired for more than shareRetention are deleted from ShareDB, being
+	// answered with 410 Gone until then.
+	shareSweepInterval = time.Hour
+	shareRetention     = 7 * 24 * time.Hour
+	// shareSeekWindow is how long after its last download a used up link still serves the ranges a player seeks to.
+	shareSeekWindow = 12 * time.Hour
+)
+
+// shareLink is the NZB, or the list of segments, shared under an opaque token.
+type shareLink struct {
+	Token        string    `json:"token"`
+	Files        []nzbFile `json:"files"`
+	Creator      string    `json:"creator,omitempty"`
+	Created      int64     `json:"created"`
+	Expires      int64     `json:"expires,omitempty"` // unix time, 0 for never
+	MaxDownloads int       `json:"maxDownloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	LastDownload int64     `json:"lastDownload,omitempty"`
+}
+
+// gone reports whether the link expired, or its downloads are used up for a download, else for a range past
+// shareSeekWindow after the last download.
+func (link *shareLink) gone(download bool, now time.Time) bool {
+	if link.Expires != 0 && now.Unix() >= link.Expires {
+		return true
+	}
+	if link.MaxDownloads == 0 || link.Downloads < link.MaxDownloads {
+		return false
+	}
+	return download || now.Unix() >= link.LastDownload+int64(shareSeekWindow/time.Second)
+}
+
+// shares persists the share links in a bolt database, so they outlive restarts.
+type shares struct {
+	db *bolt.DB
+}
+
+func openShares(path string) (sh *shares, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		_, err = tx.CreateBucketIfNotExists(shareBucket)
+		return
+	}); err != nil {
+		db.Close()
+		return
+	}
+	sh = &shares{db: db}
+	go func() {
+		for range time.Tick(shareSweepInterval) {
+			if err := sh.sweep(time.Now().Add(-shareRetention)); err != nil {
+				logPrintf("[ERROR] [Share] sweep error: %s", err.Error())
+			}
+		}
+	}()
+	return
+}
+
+// newShareToken returns a token of 128 random bits, URL-safe.
+func newShareToken() (token string, err error) {
+	var b [16]byte
+	if _, err = rand.Read(b[:]); err == nil {
+		token = base64.RawURLEncoding.EncodeToString(b[:])
+	}
+	return
+}
+
+// validShareToken reports whether token is a token of newShareToken.
+func validShareToken(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == 16
+}
+
+// Add saves a new link.
+func (sh *shares) Add(link *shareLink) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	return sh.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(shareBucket).Put([]byte(link.Token), data)
+	})
+}
+
+// Use returns the link of a token, counting a download if download is set, in a single transaction so that concurrent
+// downloads never exceed MaxDownloads. errShareGone is returned once the link expired or its downloads are used up.
+func (sh *shares) Use(token string, download bool, now time.Time) (link *shareLink, err error) {
+	err = sh.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(shareBucket)
+		data := bucket.Get([]byte(token))
+		if data == nil {
+			return errShareNotFound
+		}
+		link = new(shareLink)
+		if err = json.Unmarshal(data, link); err != nil {
+			return
+		}
+		if link.gone(download, now) {
+			return errShareGone
+		}
+		if !download {
+			return
+		}
+		link.Downloads, link.LastDownload = link.Downloads+1, now.Unix()
+		if data, err = json.Marshal(link); err != nil {
+			return
+		}
+		return bucket.Put([]byte(token), data)
+	})
+	if err != nil {
+		link = nil
+	}
+	return
+}
+
+// sweep deletes the links which expired before a time.
+func (sh *shares) sweep(before time.Time) error {
+	return sh.db.Update(func(tx *bolt.Tx) error {
+		var expired [][]byte
+		bucket := tx.Bucket(shareBucket)
+		if err := bucket.ForEach(func(token, data []byte) error {
+			var link shareLink
+			if err := json.Unmarshal(data, &link); err != nil {
+				return err
+			}
+			if link.Expires != 0 && link.Expires < before.Unix() {
+				expired = append(expired, token)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, token := range expired {
+			if err := bucket.Delete(token); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseShareBody returns the files of the body of POST /share, an NZB document, or a JSON array of message-ids of the
+// segments of a single file like for POST /concat.
+func (s *server) parseShareBody(r io.Reader) (files []nzbFile, err error) {
+	br := bufio.NewReader(io.LimitReader(r, nzbSizeLimit))
+	for {
+		var c byte
+		if c, err = br.ReadByte(); err != nil {
+			return
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			br.UnreadByte()
+			if c == '[' {
+				break
+			}
+			var doc *nzbDocument
+			if doc, err = parseNZB(br); err == nil {
+				files = doc.Files
+			}
+			return
+		}
+	}
+	var ids []nntp.MessageID
+	if err = json.NewDecoder(br).Decode(&ids); err != nil {
+		return
+	}
+	if _, err = s.concatIDs(ids); err != nil {
+		return
+	}
+	file := nzbFile{}
+	for i, id := range ids {
+		file.Segments = append(file.Segments, nzbSegment{Number: i + 1, MessageID: id})
+	}
+	files = []nzbFile{file}
+	return
+}
+
+// shareQuery parses the ttl and downloads query parameters of POST /share, positive integers if set.
+func shareQuery(r *http.Request) (ttl int64, downloads int, err error) {
+	query := r.URL.Query()
+	if value := query.Get("ttl"); value != "" {
+		if ttl, err = strconv.ParseInt(value, 10, 64); err != nil || ttl <= 0 {
+			return 0, 0, fmt.Errorf("invalid ttl %q", value)
+		}
+	}
+	if value := query.Get("downloads"); value != "" {
+		if downloads, err = strconv.Atoi(value); err != nil || downloads <= 0 {
+			return 0, 0, fmt.Errorf("invalid downloads %q", value)
+		}
+	}
+	return
+}
+
+// handleSharePOST serves POST /share, creating a link to the NZB document or the segments of the body, valid for ttl
+// seconds and downloads downloads if set. The link is returned as JSON, with its URL in Location.
+func (s *server) handleSharePOST(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if s.shares == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "ShareDB is not configured")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ttl, downloads, err := shareQuery(r)
+	if err != nil {
+		logf(r.Context(), "[ERROR] SHARE %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	files, err := s.parseShareBody(r.Body)
+	if err != nil {
+		logf(r.Context(), "[ERROR] SHARE invalid request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	link := &shareLink{Files: files, Created: now.Unix(), MaxDownloads: downloads}
+	if ttl > 0 {
+		link.Expires = now.Unix() + ttl
+	}
+	if id := identity(r.Context()); id != nil {
+		link.Creator = id.Name
+	}
+	if link.Token, err = newShareToken(); err == nil {
+		err = s.shares.Add(link)
+	}
+	if err != nil {
+		logf(r.Context(), "[ERROR] SHARE error: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]any{"token": link.Token, "url": "/share/" + link.Token}
+	if link.Expires != 0 {
+		response["expires"] = time.Unix(link.Expires, 0).UTC().Format(time.RFC3339)
+	}
+	if link.MaxDownloads != 0 {
+		response["maxDownloads"] = link.MaxDownloads
+	}
+	w.Header().Set("Location", "/share/"+link.Token)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+	logf(r.Context(), "[INFO] SHARE %s %d files", link.Token, len(files))
+}
+
+// countsAsDownload reports whether a GET of a share link counts as a download: the ranges from the middle of the file a
+// player seeks to come with the one from its start, which is counted.
+func countsAsDownload(r *http.Request) bool {
+	spec := strings.TrimSpace(r.Header.Get("Range"))
+	return spec == "" || !strings.HasPrefix(spec, "bytes=") || strings.HasPrefix(strings.TrimSpace(spec[len("bytes="):]), "0-")
+}
+
+// handleShare serves GET /share/<token>, the files of a share link like POST /nzb with its format query parameter,
+// without authentication since the token is the capability to download them. Unknown tokens are answered with 404 Not
+// Found, and links which expired or whose downloads are used up with 410 Gone.
+func (s *server) handleShare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if s.shares == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "ShareDB is not configured")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_, token := splitRoute(r.URL.Path)
+	if !validShareToken(token) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	link, err := s.shares.Use(token, countsAsDownload(r), time.Now())
+	if errors.Is(err, errShareNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if errors.Is(err, errShareGone) {
+		w.WriteHeader(http.StatusGone)
+		return
+	} else if err != nil {
+		logf(r.Context(), "[ERROR] SHARE %s error: %s", token, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	doc := &nzbDocument{Files: link.Files}
+	format, ok := nzbFormat(doc, r.URL.Query().Get("format"))
+	if !ok {
+		logf(r.Context(), "[ERROR] SHARE invalid format %q", format)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	started, err := s.serveNZB(w, r, doc, format)
+	if err != nil {
+		if !started || !clientGone(r, err) {
+			logf(r.Context(), "[ERROR] SHARE %s error: %s", token, err.Error())
+		}
+		if started {
+			// abort the response so that the client can tell the file or the archive is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, errNZBSegment) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if !unavailable(w, err) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	logf(r.Context(), "[INFO] SHARE %s %d files, download %d", token, len(link.Files), link.Downloads)
+}
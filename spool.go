@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// Delivery status of a spooled post.
+const (
+	SpoolPending   = "pending"
+	SpoolDelivered = "delivered"
+	SpoolFailed    = "failed"
+)
+
+const (
+	spoolScanInterval  = 5 * time.Second
+	spoolMaxRetryDelay = time.Hour
+	// how long delivered or failed entries are kept around for status queries
+	spoolRetention = 24 * time.Hour
+)
+
+type spoolEntry struct {
+	MessageID   nntp.MessageID       `json:"messageId"`
+	Header      textproto.MIMEHeader `json:"header"`
+	DotEncoded  bool                 `json:"dotEncoded"`
+	Status      string               `json:"status"`
+	Attempts    int                  `json:"attempts"`
+	Created     time.Time            `json:"created"`
+	Updated     time.Time            `json:"updated"`
+	NextAttempt *time.Time           `json:"nextAttempt,omitempty"`
+	LastError   string               `json:"lastError,omitempty"`
+	RequestID   string               `json:"requestId,omitempty"`
+}
+
+// spool persists posts which failed due to transient errors into SpoolDir, and retries them in the background with
+// exponential backoff. Each post is stored as a JSON metadata file and a body file, named after the message-id hash.
+type spool struct {
+	server *server
+	dir    string
+	mu     sync.Mutex // serializes access to the metadata files
+}
+
+// stagedBody is a request body copied to the spool directory before posting.
+type stagedBody struct {
+	file *os.File
+}
+
+func newSpool(s *server, dir string) (sp *spool, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	// bodies staged by requests interrupted by a restart
+	staging, _ := filepath.Glob(filepath.Join(dir, "*.staging"))
+	for _, path := range staging {
+		os.Remove(path)
+	}
+	sp = &spool{server: s, dir: dir}
+	return
+}
+
+func spoolName(messageID nntp.MessageID) string {
+	sum := sha256.Sum256([]byte(messageID.Short()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// stage copies the article body to a file and replaces the body with it.
+func (sp *spool) stage(article *nntp.Article) (staged *stagedBody, err error) {
+	var file *os.File
+	if file, err = os.CreateTemp(sp.dir, "*.staging"); err != nil {
+		return
+	}
+	staged = &stagedBody{file}
+	if _, err = io.Copy(file, article.Body); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		staged.remove()
+		staged = nil
+		return
+	}
+	article.Body = file
+	return
+}
+
+// remove deletes the staged body, unless it has been moved into the spool.
+func (staged *stagedBody) remove() {
+	staged.file.Close()
+	os.Remove(staged.file.Name())
+}
+
+// enqueue moves the staged body into the spool with the article metadata, to be retried later.
+func (sp *spool) enqueue(ctx context.Context, staged *stagedBody, article *nntp.Article, dotEncoded bool, cause error) (err error) {
+	name := spoolName(article.MessageID)
+	if err = os.Rename(staged.file.Name(), filepath.Join(sp.dir, name+".body")); err != nil {
+		return
+	}
+	now := time.Now()
+	next := now.Add(sp.retryDelay(1))
+	entry := &spoolEntry{
+		MessageID:   article.MessageID,
+		Header:      article.Header,
+		DotEncoded:  dotEncoded,
+		Status:      SpoolPending,
+		Attempts:    1,
+		Created:     now,
+		Updated:     now,
+		NextAttempt: &next,
+		LastError:   cause.Error(),
+		RequestID:   requestID(ctx),
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.save(name, entry)
+}
+
+func (sp *spool) retryDelay(attempts int) (delay time.Duration) {
+	delay = time.Duration(sp.server.SpoolRetryDelay) * time.Second
+	for i := 1; i < attempts && delay < spoolMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > spoolMaxRetryDelay {
+		delay = spoolMaxRetryDelay
+	}
+	return
+}
+
+func (sp *spool) save(name string, entry *spoolEntry) (err error) {
+	var data []byte
+	if data, err = json.Marshal(entry); err != nil {
+		return
+	}
+	// write then rename, so a crash never leaves a truncated metadata file behind
+	tmp := filepath.Join(sp.dir, name+".json.tmp")
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	return os.Rename(tmp, filepath.Join(sp.dir, name+".json"))
+}
+
+func (sp *spool) load(name string) (entry *spoolEntry, err error) {
+	var data []byte
+	if data, err = os.ReadFile(filepath.Join(sp.dir, name+".json")); err != nil {
+		return
+	}
+	entry = new(spoolEntry)
+	err = json.Unmarshal(data, entry)
+	return
+}
+
+// Status returns the spool entry of the message-id, or nil if it has never been spooled.
+func (sp *spool) Status(messageID nntp.MessageID) (entry *spoolEntry, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if entry, err = sp.load(spoolName(messageID)); errors.Is(err, fs.ErrNotExist) {
+		entry, err = nil, nil
+	}
+	return
+}
+
+func (sp *spool) run() {
+	ticker := time.NewTicker(spoolScanInterval)
+	defer ticker.Stop()
+	for {
+		sp.scan()
+		<-ticker.C
+	}
+}
+
+// scan retries every pending post due, and removes expired delivered or failed entries.
+func (sp *spool) scan() {
+	paths, err := filepath.Glob(filepath.Join(sp.dir, "*.json"))
+	if err != nil {
+		logPrintf("[ERROR] [Spool] scan error: %s", err.Error())
+		return
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		sp.mu.Lock()
+		entry, err := sp.load(name)
+		sp.mu.Unlock()
+		if err != nil {
+			logPrintf("[ERROR] [Spool] %s load error: %s", name, err.Error())
+			continue
+		}
+		now := time.Now()
+		if entry.Status != SpoolPending {
+			if now.Sub(entry.Updated) > spoolRetention {
+				sp.mu.Lock()
+				os.Remove(filepath.Join(sp.dir, name+".body"))
+				os.Remove(path)
+				sp.mu.Unlock()
+			}
+			continue
+		}
+		if entry.NextAttempt != nil && entry.NextAttempt.After(now) {
+			continue
+		}
+		sp.retry(name, entry)
+	}
+}
+
+func (sp *spool) retry(name string, entry *spoolEntry) {
+	// keep correlating the retries with the request which spooled the post
+	ctx := context.WithValue(context.Background(), requestIDKey{}, entry.RequestID)
+	bodyPath := filepath.Join(sp.dir, name+".body")
+	file, err := os.Open(bodyPath)
+	if err == nil {
+		err = sp.server.postArticle(ctx, &nntp.Article{
+			MessageID: entry.MessageID,
+			Header:    entry.Header,
+			Body:      file,
+		}, entry.DotEncoded)
+		file.Close()
+	} else {
+		err = fmt.Errorf("body error: %w", err)
+	}
+
+	entry.Attempts++
+	entry.Updated = time.Now()
+	entry.NextAttempt = nil
+	switch {
+	case err == nil:
+		entry.Status = SpoolDelivered
+		entry.LastError = ""
+		os.Remove(bodyPath)
+		logf(ctx, "[INFO] [Spool] %s delivered after %d attempts", entry.MessageID, entry.Attempts)
+	case isTransientPostError(err) && (sp.server.SpoolMaxAttempts <= 0 || entry.Attempts < sp.server.SpoolMaxAttempts):
+		next := entry.Updated.Add(sp.retryDelay(entry.Attempts))
+		entry.NextAttempt = &next
+		entry.LastError = err.Error()
+		logf(ctx, "[ERROR] [Spool] %s attempt %d error: %s", entry.MessageID, entry.Attempts, err.Error())
+	default:
+		entry.Status = SpoolFailed
+		entry.LastError = err.Error()
+		os.Remove(bodyPath)
+		logf(ctx, "[ERROR] [Spool] %s failed after %d attempts: %s", entry.MessageID, entry.Attempts, err.Error())
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if err = sp.save(name, entry); err != nil {
+		logf(ctx, "[ERROR] [Spool] %s save error: %s", entry.MessageID, err.Error())
+	}
+}
+
+func (s *server) handleSpoolStatus(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
+	// the status changes over time, never cache it
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.spool == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	entry, err := s.spool.Status(messageID)
+	if err != nil {
+		logf(r.Context(), "[ERROR] %s %s spool status error: %s", r.Method, messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if entry == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// only report the delivery state, not the article headers
+	data, _ := json.Marshal(struct {
+		MessageID   nntp.MessageID `json:"messageId"`
+		Status      string         `json:"status"`
+		Attempts    int            `json:"attempts"`
+		Created     time.Time      `json:"created"`
+		Updated     time.Time      `json:"updated"`
+		NextAttempt *time.Time     `json:"nextAttempt,omitempty"`
+		LastError   string         `json:"lastError,omitempty"`
+	}{entry.MessageID, entry.Status, entry.Attempts, entry.Created, entry.Updated, entry.NextAttempt, entry.LastError})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// activitySize is the number of recent requests shown on the stats page.
+const activitySize = 50
+
+// activityEntry is a request shown on the stats page. Only its route is kept, never the message-id.
+type activityEntry struct {
+	Time     time.Time
+	Method   string
+	Route    string
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// activityLog keeps the last activitySize requests in a ring.
+type activityLog struct {
+	mu      sync.Mutex
+	entries [activitySize]activityEntry
+	next    int
+	full    bool
+}
+
+func (a *activityLog) add(entry activityEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[a.next] = entry
+	a.next = (a.next + 1) % activitySize
+	a.full = a.full || a.next == 0
+}
+
+// recent returns the requests, the most recent first.
+func (a *activityLog) recent() (entries []activityEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := a.next
+	if a.full {
+		n = activitySize
+	}
+	for i := 1; i <= n; i++ {
+		entries = append(entries, a.entries[(a.next-i+activitySize)%activitySize])
+	}
+	return
+}
+
+// recordActivity adds every request to the activity log of the stats page, if StatsPage is enabled, aborted ones
+// included.
+func (s *server) recordActivity(handler http.Handler) http.Handler {
+	if !s.StatsPage {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+		defer func() {
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			s.activity.add(activityEntry{
+				Time:     start,
+				Method:   r.Method,
+				Route:    route(r.URL.Path),
+				Status:   sw.status,
+				Bytes:    sw.written,
+				Duration: time.Since(start),
+			})
+		}()
+		handler.ServeHTTP(sw, r)
+	})
+}
+
+var statsTemplate = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html>
+
+<head>
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+  <link rel="icon" type="image/png" sizes="32x32" href="/assets/favicon-32x32.png" />
+  <title>useb.in &middot; stats</title>
+  <style>
+    * {
+      color: rgb(170, 170, 170);
+      background-color: black;
+      font-family: monospace;
+      font-size: initial;
+    }
+
+    th,
+    td {
+      padding: 0 1em 0 0;
+      text-align: left;
+    }
+  </style>
+</head>
+
+<body>
+  <p>up {{.Uptime}}, since {{.Started.Format "2006-01-02 15:04:05 MST"}}</p>
+  <p>{{.Responses}} responses, {{.BytesSent}} bytes sent, {{.Aborted}} aborted</p>
+  {{- if .Servers}}
+  <table>
+    <tr><th>server</th><th>tls</th><th>posting</th><th>feed</th><th>open</th><th>idle</th><th>limit</th><th>waiting</th><th>avg wait</th><th>rejected</th></tr>
+    {{- range .Servers}}
+    <tr><td>{{.Host}}{{if .Reserved}} (reserved posting){{end}}</td><td>{{.TLS}}</td><td>{{.Posting}}{{if .PostingMismatch}} (greeting: {{not .Posting}}){{end}}</td><td>{{.Feed}}</td><td>{{.Open}}</td><td>{{.Idle}}</td><td>{{.Connections}}</td><td>{{.Waiting}}</td><td>{{.AvgWaitMs}}ms</td><td>{{.Rejected}}</td></tr>
+    {{- end}}
+  </table>
+  {{- else}}
+  <p>local-only mode</p>
+  {{- end}}
+  <p>recent requests</p>
+  <table>
+    <tr><th>time</th><th>method</th><th>route</th><th>status</th><th>bytes</th><th>duration</th></tr>
+    {{- range .Activity}}
+    <tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.Method}}</td><td>{{.Route}}</td><td>{{.Status}}</td><td>{{.Bytes}}</td><td>{{.Duration}}</td></tr>
+    {{- end}}
+  </table>
+</body>
+
+</html>
+`))
+
+// handleStats renders the stats page: the uptime, the NNTP servers without their credentials and the state of their
+// connections, and the recent requests. The admin credentials are required if StatsAuth is set.
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	data := struct {
+		Started   time.Time
+		Uptime    time.Duration
+		Responses uint64
+		BytesSent uint64
+		Aborted   uint64
+		Servers   []poolStats
+		Activity  []activityEntry
+	}{
+		Started:   s.started,
+		Uptime:    time.Since(s.started).Round(time.Second),
+		Responses: s.transfer.responses.Load(),
+		BytesSent: s.transfer.bytesSent.Load(),
+		Aborted:   s.transfer.aborted.Load(),
+		Activity:  s.activity.recent(),
+	}
+	if s.pool != nil {
+		data.Servers = s.pool.Stats()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statsTemplate.Execute(w, data); err != nil {
+		logf(r.Context(), "[ERROR] stats page: %s", err.Error())
+	}
+}
+
+// statsHandler serves the stats page, behind the admin credentials if StatsAuth is set.
+func (s *server) statsHandler() http.Handler {
+	if s.StatsAuth {
+		return s.adminAuth(http.HandlerFunc(s.handleStats))
+	}
+	return http.HandlerFunc(s.handleStats)
+}
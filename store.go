@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+var errArticleStored = errors.New("article already stored")
+
+// errStoredDotEncoded is returned by BodyFile for an article stored dot-encoded, before the bodies were kept decoded.
+var errStoredDotEncoded = errors.New("article stored dot-encoded")
+
+// storeBodyHeader marks the article files whose body is kept dot-decoded, as GET /m/ serves it. It is removed from the
+// header of the stored articles.
+const storeBodyHeader = "X-Usebin-Body"
+
+// ArticleStore keeps articles outside of Usenet, keyed by message-id. The serving handlers consult it before the NNTP
+// servers. All methods return ErrArticleNotFound if the article is not stored.
+type ArticleStore interface {
+	// Get opens the stored article. The body is dot-encoded including the termination line if dotEncoded is set, and
+	// must be closed by the caller.
+	Get(messageID nntp.MessageID, dotEncoded bool) (header textproto.MIMEHeader, body io.ReadCloser, err error)
+	// Put stores the article read from article.Body, replacing any previous copy. If dotEncoded is set, the body is
+	// already dot-stuffed, without the termination line.
+	Put(article *nntp.Article, dotEncoded bool) error
+	// Stat returns the size of the dot-decoded body of the stored article.
+	Stat(messageID nntp.MessageID) (size int64, err error)
+	Delete(messageID nntp.MessageID) error
+}
+
+// bodyFiler is implemented by the ArticleStore keeping the dot-decoded bodies in files, so that they can be served
+// with sendfile instead of being copied through a buffer.
+type bodyFiler interface {
+	// BodyFile opens the file of the stored article, which must be closed by the caller. It returns
+	// errStoredDotEncoded if the body has to be decoded first.
+	BodyFile(messageID nntp.MessageID) (header textproto.MIMEHeader, body *storedFile, err error)
+}
+
+// fileStore is an ArticleStore keeping each article in its own file: the header, an empty line and the dot-decoded
+// body, marked by the storeBodyHeader header. Files stored before are in the same format as the article is sent over
+// NNTP instead, the dot-encoded body with its termination line, and still read. Files are named after the hash of the
+// message-id and spread over 256 subdirectories.
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (st *fileStore, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	st = &fileStore{dir: dir}
+	return
+}
+
+func (st *fileStore) path(messageID nntp.MessageID) string {
+	sum := sha256.Sum256([]byte(messageID.Short()))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(st.dir, name[:2], name)
+}
+
+// open opens the article file and reads past its header, reporting whether the body is dot-decoded.
+func (st *fileStore) open(messageID nntp.MessageID) (file *os.File, header textproto.MIMEHeader, br *bufio.Reader, decoded bool, err error) {
+	if file, err = os.Open(st.path(messageID)); errors.Is(err, fs.ErrNotExist) {
+		err = ErrArticleNotFound
+		return
+	} else if err != nil {
+		return
+	}
+	br = bufio.NewReader(file)
+	if header, err = textproto.NewReader(br).ReadMIMEHeader(); err != nil {
+		file.Close()
+		file, err = nil, fmt.Errorf("failed to read stored header: %w", err)
+		return
+	}
+	decoded = header.Get(storeBodyHeader) != ""
+	header.Del(storeBodyHeader)
+	return
+}
+
+func (st *fileStore) Get(messageID nntp.MessageID, dotEncoded bool) (header textproto.MIMEHeader, body io.ReadCloser, err error) {
+	var (
+		file    *os.File
+		br      *bufio.Reader
+		decoded bool
+	)
+	if file, header, br, decoded, err = st.open(messageID); err != nil {
+		return
+	}
+	switch {
+	case decoded && dotEncoded:
+		// encoded while read, the pipe is closed with the body
+		pr, pw := io.Pipe()
+		go func() {
+			pbw := bufio.NewWriter(pw)
+			writer := textproto.DotWriter(pbw)
+			_, err := io.Copy(writer, br)
+			if err == nil {
+				err = writer.Close()
+			}
+			if err == nil {
+				err = pbw.Flush()
+			}
+			pw.CloseWithError(err)
+		}()
+		body = &storedBody{Reader: pr, file: file, pipe: pr}
+	case decoded:
+		// limited to the body so that it keeps telling io.EOF once read, like the DotReader
+		var size int64
+		if _, size, err = bodyOffset(file, br); err != nil {
+			file.Close()
+			return
+		}
+		body = &storedBody{Reader: io.LimitReader(br, size), file: file}
+	case dotEncoded:
+		body = &storedBody{Reader: br, file: file}
+	default:
+		body = &storedBody{Reader: textproto.DotReader(br), file: file}
+	}
+	return
+}
+
+// BodyFile opens the file of the stored article, its body following the header.
+func (st *fileStore) BodyFile(messageID nntp.MessageID) (header textproto.MIMEHeader, body *storedFile, err error) {
+	file, header, br, decoded, err := st.open(messageID)
+	if err != nil {
+		return
+	} else if !decoded {
+		file.Close()
+		err = errStoredDotEncoded
+		return
+	}
+	body = &storedFile{File: file}
+	if body.offset, body.size, err = bodyOffset(file, br); err != nil {
+		file.Close()
+		body = nil
+	}
+	return
+}
+
+// bodyOffset returns the offset and the size of the dot-decoded body of an article file, br having read past its
+// header.
+func bodyOffset(file *os.File, br *bufio.Reader) (offset, size int64, err error) {
+	if offset, err = file.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	offset -= int64(br.Buffered())
+	size = info.Size() - offset
+	return
+}
+
+func (st *fileStore) Put(article *nntp.Article, dotEncoded bool) (err error) {
+	path := st.path(article.MessageID)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	// write to a temporary file first so a concurrent Get never sees a partial article
+	file, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	bw := bufio.NewWriter(file)
+	header := article.Header
+	if header.Get("Message-Id") == "" {
+		header = make(textproto.MIMEHeader, len(article.Header)+1)
+		for key, values := range article.Header {
+			header[key] = values
+		}
+		header.Set("Message-Id", string(article.MessageID.Full()))
+	}
+	for key, values := range header {
+		if textproto.CanonicalMIMEHeaderKey(key) == storeBodyHeader {
+			continue
+		}
+		for _, value := range values {
+			fmt.Fprintf(bw, "%s: %s\r\n", textproto.CanonicalMIMEHeaderKey(key), value)
+		}
+	}
+	fmt.Fprintf(bw, "%s: decoded\r\n\r\n", storeBodyHeader)
+	// the body is kept as the DotReader reads back what the DotWriter sends, like from an NNTP server, the
+	// termination line added by the DotWriter ending it
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pbw := bufio.NewWriter(pw)
+		var writer io.WriteCloser
+		if dotEncoded {
+			writer = textproto.DotWriter(pbw, textproto.DisableDotEncoding)
+		} else {
+			writer = textproto.DotWriter(pbw)
+		}
+		_, err := io.Copy(writer, article.Body)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err == nil {
+			err = pbw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	body := textproto.DotReader(bufio.NewReader(pr))
+	if _, err = io.Copy(bw, body); err != nil {
+		return
+	}
+	if err = bw.Flush(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	err = os.Rename(file.Name(), path)
+	return
+}
+
+func (st *fileStore) Stat(messageID nntp.MessageID) (size int64, err error) {
+	file, _, br, decoded, err := st.open(messageID)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if decoded {
+		_, size, err = bodyOffset(file, br)
+		return
+	}
+	return io.Copy(io.Discard, textproto.DotReader(br))
+}
+
+func (st *fileStore) Delete(messageID nntp.MessageID) (err error) {
+	if err = os.Remove(st.path(messageID)); errors.Is(err, fs.ErrNotExist) {
+		err = ErrArticleNotFound
+	}
+	return
+}
+
+// storedBody closes the article file once the body has been consumed, and the pipe encoding it if any.
+type storedBody struct {
+	io.Reader
+	file *os.File
+	pipe *io.PipeReader
+}
+
+func (b *storedBody) Close() error {
+	if b.pipe != nil {
+		b.pipe.Close()
+	}
+	return b.file.Close()
+}
+
+// storedFile is the dot-decoded body of a stored article, the size bytes of its file from offset.
+type storedFile struct {
+	*os.File
+	offset, size int64
+}
+
+// section returns the reader of length bytes of the body from start, limiting the file itself so that it is sent with
+// sendfile.
+func (f *storedFile) section(start, length int64) (r io.Reader, err error) {
+	if _, err = f.Seek(f.offset+start, io.SeekStart); err == nil {
+		r = io.LimitReader(f.File, length)
+	}
+	return
+}
+
+// lookup serves the article from the memory cache or the store if it is there, and otherwise runs cmd against the NNTP
+// servers just like fetch. A cached or stored article is returned with a nil conn and a body the caller must close
+// with closeArticle. Store failures are logged and fall back to the NNTP servers. The memory cache only holds
+// dot-decoded bodies, so it is skipped if dotEncoded is set. Both are skipped for a fetch forced to a server.
+func (s *server) lookup(ctx context.Context, messageID nntp.MessageID, dotEncoded bool, command string, cmd func(conn *nntp.Conn) (*nntp.Article, error)) (conn *nntp.Conn, article *nntp.Article, err error) {
+	if forcedServer(ctx) != "" {
+		// the copy of the server is wanted, not the one kept
+		return s.fetch(ctx, messageID, command, cmd)
+	}
+	if s.memory != nil && !dotEncoded {
+		var ok bool
+		if article, ok = s.memory.Get(messageID); ok {
+			return
+		}
+	}
+	if s.store != nil {
+		var (
+			header textproto.MIMEHeader
+			body   io.ReadCloser
+		)
+		_, span := startSpan(ctx, "store.Get")
+		header, body, err = s.store.Get(messageID, dotEncoded)
+		span.End()
+		if err == nil {
+			article = &nntp.Article{MessageID: messageID, Header: header, Body: body}
+			return
+		} else if !errors.Is(err, ErrArticleNotFound) {
+			logf(ctx, "[ERROR] [Store] %s get error: %s", messageID, err.Error())
+		}
+	}
+	return s.fetch(ctx, messageID, command, cmd)
+}
+
+// closeArticle releases the file behind an article served from the store.
+func closeArticle(article *nntp.Article) {
+	if article != nil {
+		if closer, ok := article.Body.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// populate copies an article just fetched from the NNTP servers into the store, if StorePopulate is enabled, unless
+// the fetch was forced to a server.
+func (s *server) populate(ctx context.Context, messageID nntp.MessageID, header textproto.MIMEHeader, body io.Reader) {
+	if s.store == nil || !s.StorePopulate || forcedServer(ctx) != "" {
+		return
+	}
+	if err := s.store.Put(&nntp.Article{MessageID: messageID, Header: header, Body: body}, false); err != nil {
+		logf(ctx, "[ERROR] [Store] %s put error: %s", messageID, err.Error())
+	}
+}
+
+// storeArticle saves a posted article into the store in local-only mode. Like a Usenet server, the Path and Date
+// headers are added and an existing article is never replaced.
+func (s *server) storeArticle(article *nntp.Article, dotEncoded bool) (err error) {
+	if _, err = s.store.Stat(article.MessageID); err == nil {
+		err = errArticleStored
+		return
+	} else if !errors.Is(err, ErrArticleNotFound) {
+		return
+	}
+	prepareFeedHeader(article.Header, s.PathIdentity)
+	return s.store.Put(article, dotEncoded)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/list"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"gopkg.in/nntp.v0"
+)
+
+// sumCacheSize is the number of articles whose checksums are kept in memory.
+const sumCacheSize = 65536
+
+// articleSums are the checksums of an article body, dot-decoded as GET /m/ serves it.
+type articleSums struct {
+	MessageID nntp.MessageID `json:"messageId"`
+	Size      int64          `json:"size"`
+	SHA256    string         `json:"sha256"`
+	MD5       string         `json:"md5"`
+}
+
+// sumCache keeps the checksums of the most recently summed articles, evicting the least recently used ones.
+type sumCache struct {
+	mu      sync.Mutex
+	lru     *list.List // of *articleSums, the most recently used first
+	entries map[nntp.MessageID]*list.Element
+}
+
+func newSumCache() *sumCache {
+	return &sumCache{lru: list.New(), entries: make(map[nntp.MessageID]*list.Element)}
+}
+
+// Get returns the checksums of the article, nil if they are not cached.
+func (c *sumCache) Get(messageID nntp.MessageID) *articleSums {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[messageID.Short()]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*articleSums)
+}
+
+// Put keeps the checksums of an article, evicting the least recently used ones beyond sumCacheSize.
+func (c *sumCache) Put(sums *articleSums) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[sums.MessageID]; ok {
+		element.Value = sums
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[sums.MessageID] = c.lru.PushFront(sums)
+	for c.lru.Len() > sumCacheSize {
+		oldest := c.lru.Back()
+		delete(c.entries, oldest.Value.(*articleSums).MessageID)
+		c.lru.Remove(oldest)
+	}
+}
+
+// bodySums returns the checksums of the body of an article, cached or computed and cached.
+func (s *server) bodySums(messageID nntp.MessageID, body []byte) *articleSums {
+	if sums := s.sums.Get(messageID); sums != nil && sums.Size == int64(len(body)) {
+		return sums
+	}
+	sha := sha256.Sum256(body)
+	md := md5.Sum(body)
+	sums := &articleSums{
+		MessageID: messageID.Short(),
+		Size:      int64(len(body)),
+		SHA256:    hex.EncodeToString(sha[:]),
+		MD5:       hex.EncodeToString(md[:]),
+	}
+	s.sums.Put(sums)
+	return sums
+}
+
+// fileSums is bodySums for the body of a stored article, read from its file.
+func (s *server) fileSums(messageID nntp.MessageID, body *storedFile) (sums *articleSums, err error) {
+	if sums = s.sums.Get(messageID); sums != nil && sums.Size == body.size {
+		return
+	}
+	r, err := body.section(0, body.size)
+	if err != nil {
+		return
+	}
+	sha, md := sha256.New(), md5.New()
+	if _, err = io.Copy(io.MultiWriter(sha, md), r); err != nil {
+		return
+	}
+	sums = &articleSums{
+		MessageID: messageID.Short(),
+		Size:      body.size,
+		SHA256:    hex.EncodeToString(sha.Sum(nil)),
+		MD5:       hex.EncodeToString(md.Sum(nil)),
+	}
+	s.sums.Put(sums)
+	return
+}
+
+// handleSums serves GET /sum/<Message-ID>.csv, the size and the SHA-256 and MD5 checksums of the article body as JSON,
+// so mirrors can verify their copies without transferring the article. The checksums of the articles served recently
+// with ContentSHA256 set, or asked for before, are cached.
+func (s *server) handleSums(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	etag := s.routeETag(r, messageID)
+	if done, _ := s.checkArticlePreconditions(w, r, messageID, etag); done {
+		return
+	}
+	sums := s.sums.Get(messageID)
+	if sums == nil {
+		result := s.fetchBody(r.Context(), messageID)
+		if result.status != http.StatusOK {
+			logf(r.Context(), "[ERROR] SUM %s %d", messageID, result.status)
+			w.WriteHeader(result.status)
+			return
+		}
+		sums = s.bodySums(messageID, result.body)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	setETag(w, etag)
+	json.NewEncoder(w).Encode(sums)
+	logf(r.Context(), "[INFO] SUM %s", messageID)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/nntp.v0"
+)
+
+var (
+	takedownBucket = []byte("takedowns")
+	auditBucket    = []byte("audit")
+)
+
+var errTakedownNotFound = errors.New("takedown not found")
+
+// takedown blocks an article from being served or posted again.
+type takedown struct {
+	MessageID nntp.MessageID `json:"messageId"`
+	Reason    string         `json:"reason"`
+	Operator  string         `json:"operator"`
+	Time      time.Time      `json:"time"`
+}
+
+// auditEntry records a change to the takedowns, and who made it.
+type auditEntry struct {
+	Time      time.Time      `json:"time"`
+	Action    string         `json:"action"` // "add" or "remove"
+	MessageID nntp.MessageID `json:"messageId"`
+	Reason    string         `json:"reason,omitempty"`
+	Operator  string         `json:"operator"`
+	Address   string         `json:"address"`
+	RequestID string         `json:"requestId,omitempty"`
+}
+
+// takedowns persists the takedowns and their audit log in a bolt database. The audit log is append only: removing a
+// takedown is recorded as another entry.
+type takedowns struct {
+	db *bolt.DB
+}
+
+func openTakedowns(path string) (t *takedowns, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		if _, err = tx.CreateBucketIfNotExists(takedownBucket); err != nil {
+			return
+		}
+		_, err = tx.CreateBucketIfNotExists(auditBucket)
+		return
+	}); err != nil {
+		db.Close()
+		return
+	}
+	t = &takedowns{db: db}
+	return
+}
+
+func takedownKey(messageID nntp.MessageID) []byte {
+	return []byte(messageID.Short())
+}
+
+// Get returns the takedown of an article, or nil if it is not taken down.
+func (t *takedowns) Get(messageID nntp.MessageID) (entry *takedown, err error) {
+	err = t.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(takedownBucket).Get(takedownKey(messageID))
+		if data == nil {
+			return nil
+		}
+		entry = new(takedown)
+		return json.Unmarshal(data, entry)
+	})
+	return
+}
+
+func (t *takedowns) List() (entries []*takedown, err error) {
+	err = t.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(takedownBucket).ForEach(func(_, data []byte) error {
+			entry := new(takedown)
+			entries = append(entries, entry)
+			return json.Unmarshal(data, entry)
+		})
+	})
+	return
+}
+
+// Audit returns the audit log, oldest first.
+func (t *takedowns) Audit() (entries []*auditEntry, err error) {
+	err = t.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).ForEach(func(_, data []byte) error {
+			entry := new(auditEntry)
+			entries = append(entries, entry)
+			return json.Unmarshal(data, entry)
+		})
+	})
+	return
+}
+
+// update applies a change to the takedowns together with its audit entry, in a single transaction.
+func (t *takedowns) update(audit *auditEntry, change func(bucket *bolt.Bucket) error) error {
+	return t.db.Update(func(tx *bolt.Tx) (err error) {
+		if err = change(tx.Bucket(takedownBucket)); err != nil {
+			return
+		}
+		auditLog := tx.Bucket(auditBucket)
+		seq, err := auditLog.NextSequence()
+		if err != nil {
+			return
+		}
+		data, err := json.Marshal(audit)
+		if err != nil {
+			return
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return auditLog.Put(key, data)
+	})
+}
+
+func (t *takedowns) Add(entry *takedown, audit *auditEntry) error {
+	return t.update(audit, func(bucket *bolt.Bucket) error {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(takedownKey(entry.MessageID), data)
+	})
+}
+
+func (t *takedowns) Remove(messageID nntp.MessageID, audit *auditEntry) error {
+	return t.update(audit, func(bucket *bolt.Bucket) error {
+		if bucket.Get(takedownKey(messageID)) == nil {
+			return errTakedownNotFound
+		}
+		return bucket.Delete(takedownKey(messageID))
+	})
+}
+
+// takenDown reports whether the article is taken down. Lookup failures are logged and do not block the article.
+func (s *server) takenDown(ctx context.Context, messageID nntp.MessageID) bool {
+	if s.takedowns == nil {
+		return false
+	}
+	entry, err := s.takedowns.Get(messageID)
+	if err != nil {
+		logf(ctx, "[ERROR] [Takedown] %s lookup error: %s", messageID, err.Error())
+		return false
+	}
+	return entry != nil
+}
+
+// blocked reports whether the article is taken down, in which case 451 Unavailable For Legal Reasons is sent.
+func (s *server) blocked(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID) bool {
+	if !s.takenDown(r.Context(), messageID) {
+		return false
+	}
+	logf(r.Context(), "[INFO] %s %s taken down", r.Method, messageID)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	return true
+}
+
+// handleTakedowns serves the admin API of the takedowns: GET /takedowns lists them, POST /takedowns adds the one in the
+// JSON body, DELETE /takedowns/<Message-ID> removes one and GET /takedowns/audit returns the audit log. The operator
+// recorded is the admin user.
+func (s *server) handleTakedowns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if s.takedowns == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "TakedownDB is not configured")
+		return
+	}
+	operator, _, _ := r.BasicAuth()
+	address, _, _ := net.SplitHostPort(r.RemoteAddr)
+	audit := &auditEntry{Time: time.Now().UTC(), Operator: operator, Address: address, RequestID: requestID(r.Context())}
+
+	var (
+		result any
+		err    error
+	)
+	switch path := strings.TrimPrefix(r.URL.Path, "/takedowns"); {
+	case path == "" && r.Method == http.MethodGet:
+		result, err = s.takedowns.List()
+	case path == "/audit" && r.Method == http.MethodGet:
+		result, err = s.takedowns.Audit()
+	case path == "" && r.Method == http.MethodPost:
+		entry := new(takedown)
+		if err = json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(entry); err != nil || entry.MessageID.Validate() != nil || entry.Reason == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "expecting {\"messageId\": ..., \"reason\": ...}")
+			return
+		}
+		entry.MessageID = entry.MessageID.Short()
+		entry.Operator, entry.Time = audit.Operator, audit.Time
+		audit.Action, audit.MessageID, audit.Reason = "add", entry.MessageID, entry.Reason
+		if err = s.takedowns.Add(entry, audit); err == nil {
+			logf(r.Context(), "[WARN] [Takedown] %s taken down by %s: %s", entry.MessageID, operator, entry.Reason)
+			if purgeErr := s.purgeCDN(r.Context(), entry.MessageID); purgeErr != nil {
+				logf(r.Context(), "[ERROR] [CDN] %s %s", entry.MessageID, purgeErr.Error())
+			}
+			result = entry
+		}
+	case strings.HasPrefix(path, "/") && r.Method == http.MethodDelete:
+		messageID := nntp.MessageID(path[1:]).Short()
+		if messageID.Validate() != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		audit.Action, audit.MessageID = "remove", messageID
+		if err = s.takedowns.Remove(messageID, audit); errors.Is(err, errTakedownNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err == nil {
+			logf(r.Context(), "[WARN] [Takedown] %s reinstated by %s", messageID, operator)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		logf(r.Context(), "[ERROR] [Takedown] %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
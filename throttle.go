@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the largest write passed through at once, so the pacing stays smooth for large writes.
+const throttleChunk = 16 * 1024
+
+// tokenBucket is a rate limiter of bytes per second with a one second burst. Takers are allowed to put the bucket in
+// debt, and then wait for the debt to be refilled, so concurrent takers are served in order.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take takes n tokens, waiting until they are available or ctx is done.
+func (b *tokenBucket) take(ctx context.Context, n int) (err error) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// throttledResponseWriter paces writes through the per-request and the global token buckets, whichever are set.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (tw *throttledResponseWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		for _, bucket := range tw.buckets {
+			if err = bucket.take(tw.ctx, len(chunk)); err != nil {
+				return
+			}
+		}
+		var m int
+		m, err = tw.ResponseWriter.Write(chunk)
+		n += m
+		if err != nil {
+			return
+		}
+		p = p[m:]
+	}
+	return
+}
+
+func (tw *throttledResponseWriter) Flush() {
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (tw *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// throttle limits the response bandwidth to EgressRateLimit bytes per second for the whole server, and to
+// RequestRateLimit bytes per second for each request.
+func (s *server) throttle(handler http.Handler) http.Handler {
+	if s.EgressRateLimit <= 0 && s.RequestRateLimit <= 0 {
+		return handler
+	}
+	var global *tokenBucket
+	if s.EgressRateLimit > 0 {
+		global = newTokenBucket(s.EgressRateLimit)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &throttledResponseWriter{ResponseWriter: w, ctx: r.Context()}
+		if s.RequestRateLimit > 0 {
+			tw.buckets = append(tw.buckets, newTokenBucket(s.RequestRateLimit))
+		}
+		if global != nil {
+			tw.buckets = append(tw.buckets, global)
+		}
+		handler.ServeHTTP(tw, r)
+	})
+}
+
+// throttledConn paces reads from an NNTP server through the server's token bucket, shared by all of its connections.
+type throttledConn struct {
+	net.Conn
+	bucket *tokenBucket
+}
+
+func (c *throttledConn) Read(p []byte) (n int, err error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	if n, err = c.Conn.Read(p); n > 0 {
+		c.bucket.take(context.Background(), n)
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the handshakes check whether CertFile and KeyFile were renewed.
+const certCheckInterval = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require-any":        tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// tlsConfig returns the TLS config of the HTTPS listeners, serving the certificate of CertFile and KeyFile as reloaded
+// by a certReloader.
+func (s *server) tlsConfig() (config *tls.Config, err error) {
+	if config, err = s.tlsOptions(); err != nil {
+		return
+	}
+	reloader, err := newCertReloader(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config.GetCertificate = reloader.GetCertificate
+	return
+}
+
+// tlsOptions returns the TLS config of the TLS keys, TLS 1.2 at least by default, and of ClientCAFile.
+func (s *server) tlsOptions() (config *tls.Config, err error) {
+	config = &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.TLSMinVersion != "" {
+		var ok bool
+		if config.MinVersion, ok = tlsVersions[s.TLSMinVersion]; !ok {
+			return nil, fmt.Errorf("invalid TLSMinVersion %q, expecting 1.0, 1.1, 1.2 or 1.3", s.TLSMinVersion)
+		}
+	}
+	for _, name := range s.TLSCipherSuites {
+		var id uint16
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				id = suite.ID
+			}
+		}
+		if id == 0 {
+			return nil, fmt.Errorf("unknown or insecure TLSCipherSuites %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	for _, name := range s.TLSCurvePreferences {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLSCurvePreferences %q, expecting X25519, P256, P384 or P521", name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+	clientAuth := s.TLSClientAuth
+	if clientAuth == "" {
+		// the certificates are optional, the AuthMethods of the route groups decide whether they are required
+		if clientAuth = "none"; s.ClientCAFile != "" {
+			clientAuth = "verify-if-given"
+		}
+	}
+	var ok bool
+	if config.ClientAuth, ok = tlsClientAuths[clientAuth]; !ok {
+		return nil, fmt.Errorf("invalid TLSClientAuth %q, expecting none, request, require-any, verify-if-given or require-and-verify", clientAuth)
+	}
+	if s.ClientCAFile != "" {
+		if config.ClientCAs, err = loadCertPool(s.ClientCAFile); err != nil {
+			return nil, err
+		}
+	} else if config.ClientAuth >= tls.VerifyClientCertIfGiven {
+		return nil, fmt.Errorf("TLSClientAuth %s requires a ClientCAFile", clientAuth)
+	}
+	return
+}
+
+// certReloader serves the certificate of a certificate and key file pair, loading it again once the files were
+// modified, so a renewed certificate is served without a restart. A renewal failing to load is logged, and the
+// previous certificate kept.
+type certReloader struct {
+	certFile, keyFile string
+	mu                sync.Mutex
+	cert              *tls.Certificate
+	modTime           time.Time
+	checked           time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (c *certReloader, err error) {
+	c = &certReloader{certFile: certFile, keyFile: keyFile}
+	if c.modTime, err = c.lastModified(); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	c.cert, c.checked = &cert, time.Now()
+	return
+}
+
+// lastModified returns the latest modification time of the files.
+func (c *certReloader) lastModified() (modTime time.Time, err error) {
+	for _, path := range []string{c.certFile, c.keyFile} {
+		var info os.FileInfo
+		if info, err = os.Stat(path); err != nil {
+			return
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return
+}
+
+// GetCertificate is the tls.Config callback, checking the files at most every certCheckInterval.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	modTime, err := c.lastModified()
+	if err != nil {
+		logPrintf("[ERROR] [TLS] %s: %s, keeping the previous certificate", c.certFile, err.Error())
+		return c.cert, nil
+	}
+	if !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		// the certificate and the key may not have both been written yet, try again at the next check
+		logPrintf("[ERROR] [TLS] %s: %s, keeping the previous certificate", c.certFile, err.Error())
+		return c.cert, nil
+	}
+	c.cert, c.modTime = &cert, modTime
+	logPrintf("[INFO] [TLS] %s reloaded", c.certFile)
+	return c.cert, nil
+}
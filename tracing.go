@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/nntp.v0"
+)
+
+// tracer creates all spans. It is a no-op until setupTracing installs a tracer provider.
+var tracer = otel.Tracer("github.com/useb-in/usebin")
+
+// setupTracing exports the spans to the OTLP/HTTP collector at TraceEndpoint.
+func (s *server) setupTracing() (err error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(s.TraceEndpoint)}
+	if s.TraceInsecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("usebin"))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(s.TraceSampleRatio))),
+	))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return
+}
+
+// statusResponseWriter remembers the status code sent by the handler, the number of bytes of the body written and the
+// first write error.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	written  int64
+	writeErr error
+}
+
+func (sw *statusResponseWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusResponseWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.written += int64(n)
+	if err != nil && sw.writeErr == nil {
+		sw.writeErr = err
+	}
+	return n, err
+}
+
+func (sw *statusResponseWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sw *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// trace starts a span for every request, continuing the trace of the client if it sent a traceparent header. The
+// spans of the pool and NNTP commands serving the request are its children.
+func (s *server) trace(handler http.Handler) http.Handler {
+	if s.TraceEndpoint == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route(r.URL.Path),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(r.Method),
+				semconv.HTTPTarget(r.URL.Path),
+				semconv.HTTPClientIP(clientIP),
+				attribute.String("usebin.request_id", requestID(r.Context())),
+			))
+		defer span.End()
+		sw := &statusResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPStatusCode(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// startSpan starts a child span of the span in ctx.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// commandAttributes describe an NNTP command sent on conn.
+func (s *server) commandAttributes(conn *nntp.Conn, messageID nntp.MessageID) []attribute.KeyValue {
+	server, _ := s.pool.Server(conn)
+	return []attribute.KeyValue{
+		attribute.String("nntp.server", server.Host),
+		attribute.String("nntp.message_id", string(messageID.Short())),
+	}
+}
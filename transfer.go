@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+)
+
+// transferStats counts the responses sent since the server started.
+type transferStats struct {
+	responses atomic.Uint64
+	bytesSent atomic.Uint64
+	// aborted counts the responses cut short, by the client going away or by the handler
+	aborted atomic.Uint64
+}
+
+// clientGone reports whether err, returned while writing the response, is due to the client going away.
+func clientGone(r *http.Request, err error) bool {
+	return r.Context().Err() != nil || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// account counts the responses, the bytes of their bodies and the aborted ones, which failed to be written, lost
+// their client before being complete, or were aborted by the handler with http.ErrAbortHandler.
+func (s *server) account(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w}
+		defer func() {
+			aborted := recover()
+			s.transfer.responses.Add(1)
+			s.transfer.bytesSent.Add(uint64(sw.written))
+			if aborted != nil || sw.writeErr != nil || r.Context().Err() != nil {
+				s.transfer.aborted.Add(1)
+				logf(r.Context(), "[WARN] %s %s aborted after %d bytes", r.Method, r.URL.Path, sw.written)
+			}
+			if aborted != nil {
+				panic(aborted)
+			}
+		}()
+		handler.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/nntp.v0"
+	"gopkg.in/textproto.v0"
+)
+
+// tusVersion is the version of the tus resumable upload protocol served, see https://tus.io/protocols/resumable-upload.
+const tusVersion = "1.0.0"
+
+const (
+	// defaultTusSegmentSize is the size of the segments the uploads are posted in, the default article size of ngPost.
+	defaultTusSegmentSize = 716800
+	// tusExpiry is how long an upload is kept after its last PATCH, to be resumed or its NZB downloaded.
+	tusExpiry = 24 * time.Hour
+)
+
+// tusUpload is the state of a tus upload, posted as yEnc segments of SegmentSize bytes as the bytes arrive. The bytes
+// received past the segments posted are kept in the tail file of the upload until a segment is complete.
+type tusUpload struct {
+	ID          string
+	Length      int64  // Upload-Length
+	Offset      int64  // the bytes received
+	Posted      int64  // the bytes posted, in Segments
+	SegmentSize int64  // TusSegmentSize when the upload was created
+	Metadata    string // Upload-Metadata, given back as is
+	Name        string
+	Poster      string
+	Newsgroups  string
+	CRC         uint32 // of the bytes posted
+	Segments    []nzbSegment
+	Created     time.Time
+	Updated     time.Time
+}
+
+// complete reports whether all the bytes of the upload are posted.
+func (up *tusUpload) complete() bool {
+	return up.Posted == up.Length
+}
+
+// total returns the number of segments of the upload.
+func (up *tusUpload) total() int {
+	return int((up.Length + up.SegmentSize - 1) / up.SegmentSize)
+}
+
+// nzb returns the NZB document of the segments posted.
+func (up *tusUpload) nzb() *nzbDocument {
+	subject := fmt.Sprintf("\"%s\" yEnc (1/%d)", up.Name, up.total())
+	return &nzbDocument{Files: []nzbFile{{
+		Poster:   up.Poster,
+		Date:     up.Created.Unix(),
+		Subject:  subject,
+		Groups:   strings.Split(up.Newsgroups, ","),
+		Segments: up.Segments,
+	}}}
+}
+
+// tusUploads keeps the uploads in TusDir, a JSON file and a tail file each, so they can be resumed after a restart.
+type tusUploads struct {
+	dir  string
+	mu   sync.Mutex
+	busy map[string]bool // the uploads being appended to
+}
+
+func openTusUploads(dir string) (uploads *tusUploads, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	uploads = &tusUploads{dir: dir, busy: make(map[string]bool)}
+	go uploads.sweep()
+	return
+}
+
+func (t *tusUploads) path(id, ext string) string {
+	return filepath.Join(t.dir, id+ext)
+}
+
+// Get returns the upload, nil if there is none.
+func (t *tusUploads) Get(id string) (up *tusUpload, err error) {
+	data, err := os.ReadFile(t.path(id, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	up = new(tusUpload)
+	if err = json.Unmarshal(data, up); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Put saves the upload, replacing any previous state.
+func (t *tusUploads) Put(up *tusUpload) (err error) {
+	up.Updated = time.Now()
+	data, err := json.Marshal(up)
+	if err != nil {
+		return
+	}
+	file, err := os.CreateTemp(t.dir, "*.tmp")
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), t.path(up.ID, ".json"))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return
+}
+
+// Delete removes the upload and its tail.
+func (t *tusUploads) Delete(id string) (err error) {
+	if err = os.Remove(t.path(id, ".part")); errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if removeErr := os.Remove(t.path(id, ".json")); err == nil {
+		err = removeErr
+	}
+	return
+}
+
+// lock marks the upload as being appended to, reporting false if it already is.
+func (t *tusUploads) lock(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.busy[id] {
+		return false
+	}
+	t.busy[id] = true
+	return true
+}
+
+func (t *tusUploads) unlock(id string) {
+	t.mu.Lock()
+	delete(t.busy, id)
+	t.mu.Unlock()
+}
+
+// sweep removes the uploads not appended to for tusExpiry, every hour.
+func (t *tusUploads) sweep() {
+	for range time.Tick(time.Hour) {
+		entries, err := os.ReadDir(t.dir)
+		if err != nil {
+			logf(context.Background(), "[ERROR] [TUS] sweep error: %s", err.Error())
+			continue
+		}
+		for _, entry := range entries {
+			id := strings.TrimSuffix(entry.Name(), ".json")
+			if !strings.HasSuffix(entry.Name(), ".json") || !t.lock(id) {
+				continue
+			}
+			if up, err := t.Get(id); err == nil && up != nil && time.Since(up.Updated) > tusExpiry {
+				if err = t.Delete(id); err != nil {
+					logf(context.Background(), "[ERROR] [TUS] %s delete error: %s", id, err.Error())
+				}
+			}
+			t.unlock(id)
+		}
+	}
+}
+
+// parseTusMetadata decodes an Upload-Metadata header, comma separated keys each followed by its value in base64.
+func parseTusMetadata(header string) (metadata map[string]string, err error) {
+	metadata = make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		var decoded []byte
+		if decoded, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value of %q: %w", key, err)
+		}
+		metadata[key] = string(decoded)
+	}
+	return
+}
+
+// handleTus serves the tus resumable uploads under /tus/: POST /tus/ creates an upload, HEAD /tus/<id> returns its
+// offset, PATCH /tus/<id> appends to it, posting every SegmentSize bytes received as a yEnc segment, and
+// DELETE /tus/<id> terminates it. GET /tus/<id> returns the NZB of a complete upload.
+func (s *server) handleTus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, "+
+		"Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires")
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if s.tus == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "TusDir is not configured")
+		return
+	}
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); method == http.MethodPost && override != "" {
+		// for the clients behind proxies only allowing GET and POST
+		method = override
+	}
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		if s.TusMaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.TusMaxSize, 10))
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "POST, HEAD, PATCH, DELETE, GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Tus-Resumable, Upload-Length, "+
+			"Upload-Metadata, Upload-Offset, X-HTTP-Method-Override, X-Requested-With")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if method != http.MethodGet && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	_, id := splitRoute(r.URL.Path)
+	if id == "" {
+		if method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleTusCreate(w, r)
+		return
+	}
+	if !validJobID(id) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if method == http.MethodPatch || method == http.MethodDelete {
+		if !s.tus.lock(id) {
+			logf(r.Context(), "[ERROR] TUS %s is busy", id)
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		defer s.tus.unlock(id)
+	}
+	up, err := s.tus.Get(id)
+	if err != nil {
+		logf(r.Context(), "[ERROR] TUS %s error: %s", id, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if up == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Expires", up.Updated.Add(tusExpiry).UTC().Format(http.TimeFormat))
+
+	switch method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+		if up.Metadata != "" {
+			w.Header().Set("Upload-Metadata", up.Metadata)
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		s.handleTusPatch(w, r, up)
+	case http.MethodDelete:
+		// the segments already posted stay on Usenet
+		if err = s.tus.Delete(id); err != nil {
+			logf(r.Context(), "[ERROR] TUS %s delete error: %s", id, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		logf(r.Context(), "[INFO] TUS %s terminated after %d segments", id, len(up.Segments))
+	case http.MethodGet:
+		if !up.complete() {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, "the upload is at %d of %d bytes\n", up.Offset, up.Length)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-nzb")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+strings.ReplaceAll(up.Name, "\"", "")+".nzb\"")
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(up.nzb())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTusCreate serves POST /tus/, the creation extension of tus: the Upload-Length header is required, and the
+// filename, newsgroups and poster keys of Upload-Metadata name the file, and set the Newsgroups and From headers of its
+// segments, defaulting the same way as POST /m/.
+func (s *server) handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		logf(r.Context(), "[ERROR] TUS invalid Upload-Length %q", r.Header.Get("Upload-Length"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if s.TusMaxSize > 0 && length > s.TusMaxSize {
+		logf(r.Context(), "[ERROR] TUS Upload-Length %d exceeds TusMaxSize", length)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		logf(r.Context(), "[ERROR] TUS %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err.Error())
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		logf(r.Context(), "[ERROR] TUS error: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	up := &tusUpload{
+		ID:          hex.EncodeToString(b),
+		Length:      length,
+		SegmentSize: s.TusSegmentSize,
+		Metadata:    r.Header.Get("Upload-Metadata"),
+		Name:        path.Base(strings.ReplaceAll(metadata["filename"], "\\", "/")),
+		Created:     time.Now(),
+	}
+	if up.Name == "" || up.Name == "." || up.Name == "/" {
+		up.Name = up.ID
+	}
+	header := make(textproto.MIMEHeader)
+	err = s.fillPostHeader(header, nntp.MessageID(up.ID), metadata["poster"], metadata["newsgroups"], up.Name)
+	if err != nil {
+		logf(r.Context(), "[ERROR] TUS pwgen error: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	up.Poster, up.Newsgroups = header.Get("From"), header.Get("Newsgroups")
+	if limit, err := s.postPolicy(header); err != nil {
+		logf(r.Context(), "[ERROR] TUS rejected: %s", err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, err.Error())
+		return
+	} else if up.SegmentSize > int64(limit) {
+		logf(r.Context(), "[ERROR] TUS TusSegmentSize exceeds the limit of %s", up.Newsgroups)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err = s.tus.Put(up); err != nil {
+		logf(r.Context(), "[ERROR] TUS %s save error: %s", up.ID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/tus/"+up.ID)
+	w.Header().Set("Upload-Expires", up.Updated.Add(tusExpiry).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+	logf(r.Context(), "[INFO] TUS %s created for %s, %d bytes", up.ID, up.Name, up.Length)
+}
+
+// handleTusPatch serves PATCH /tus/<id>, appending the body at the Upload-Offset of the upload. The body is written to
+// the tail file of the upload, and every SegmentSize bytes of it are posted as the next segment, the last segment once
+// all the Upload-Length bytes are received. The bytes received are kept if the request or a post fails, the offset
+// then telling where to resume.
+func (s *server) handleTusPatch(w http.ResponseWriter, r *http.Request, up *tusUpload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if offset != up.Offset {
+		logf(r.Context(), "[ERROR] TUS %s Upload-Offset %d, expecting %d", up.ID, offset, up.Offset)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	tail, err := os.OpenFile(s.tus.path(up.ID, ".part"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		logf(r.Context(), "[ERROR] TUS %s error: %s", up.ID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer tail.Close()
+
+	// the bytes written past the offset saved, if a previous request was cut short, are written again
+	size := up.Offset - up.Posted
+	if err = tail.Truncate(size); err == nil {
+		_, err = tail.Seek(size, io.SeekStart)
+	}
+	body := io.LimitReader(r.Body, up.Length-up.Offset)
+	var readErr error
+	for err == nil {
+		if size == up.SegmentSize || up.Offset == up.Length && size > 0 {
+			if err = s.postTusSegment(r.Context(), up, tail, size); err != nil {
+				break
+			}
+			size = 0
+			if err = tail.Truncate(0); err == nil {
+				_, err = tail.Seek(0, io.SeekStart)
+			}
+			continue
+		}
+		if up.Offset == up.Length {
+			break
+		}
+		var n int64
+		n, readErr = io.CopyN(tail, body, up.SegmentSize-size)
+		size += n
+		if up.Offset += n; readErr != nil && up.Offset < up.Length {
+			break
+		}
+	}
+	if saveErr := s.tus.Put(up); err == nil {
+		err = saveErr
+	}
+	if err != nil {
+		logf(r.Context(), "[ERROR] TUS %s at %d error: %s", up.ID, up.Offset, err.Error())
+		if !unavailable(w, err) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	} else if readErr != nil && readErr != io.EOF {
+		// the client went away, the bytes received are kept
+		logf(r.Context(), "[WARN] TUS %s cut short at %d: %s", up.ID, up.Offset, readErr.Error())
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Expires", up.Updated.Add(tusExpiry).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+	if up.complete() {
+		logf(r.Context(), "[INFO] TUS %s complete, %d segments", up.ID, len(up.Segments))
+	}
+}
+
+// postTusSegment posts the size bytes of the tail file as the next segment of the upload, under a new message-id.
+func (s *server) postTusSegment(ctx context.Context, up *tusUpload, tail *os.File, size int64) (err error) {
+	data := make([]byte, size)
+	if _, err = tail.ReadAt(data, 0); err != nil {
+		return
+	}
+	id, err := newMessageID(s.MessageIDDomain)
+	if err != nil {
+		return
+	}
+	messageID := nntp.MessageID(id)
+	part, total := len(up.Segments)+1, up.total()
+	crc := crc32.Update(up.CRC, crc32.IEEETable, data)
+	body := encodeYEnc(up.Name, up.Length, part, total, up.Posted+1, data, crc)
+	header := make(textproto.MIMEHeader)
+	subject := fmt.Sprintf("\"%s\" yEnc (%d/%d)", up.Name, part, total)
+	if err = s.fillPostHeader(header, messageID, up.Poster, up.Newsgroups, subject); err != nil {
+		return
+	}
+	article := &nntp.Article{MessageID: messageID, Header: header, Body: bytes.NewReader(body)}
+	if err = s.postArticle(ctx, article, false); err != nil {
+		return fmt.Errorf("segment %d post error: %w", part, err)
+	}
+	up.Segments = append(up.Segments, nzbSegment{Bytes: int64(len(body)), Number: part, MessageID: messageID})
+	up.Posted += size
+	up.CRC = crc
+	logf(ctx, "[DEBUG] TUS %s segment %d/%d posted as %s", up.ID, part, total, messageID)
+	return
+}
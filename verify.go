@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/nntp.v0"
+)
+
+// Verification statuses, see verification.
+const (
+	VerifyPending  = "pending"
+	VerifyVerified = "verified"
+	VerifyMissing  = "missing"
+)
+
+// verifyRetention is how long a finished verification is kept around for status queries.
+const verifyRetention = time.Hour
+
+// verification is the check that a post has reached the NNTP servers read from, with ?verify on the POST.
+type verification struct {
+	ID        string         `json:"id"`
+	MessageID nntp.MessageID `json:"messageId"`
+	Status    string         `json:"status"`
+	Attempts  int            `json:"attempts"`
+	// Server is the host of the NNTP server found to have the article
+	Server    string    `json:"server,omitempty"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// verifications keeps the verifications in memory by their ID, for GET /verify/.
+type verifications struct {
+	mu      sync.Mutex
+	entries map[string]*verification
+}
+
+func newVerifications() *verifications {
+	return &verifications{entries: make(map[string]*verification)}
+}
+
+// Add starts keeping a new verification of the article, forgetting the ones finished for longer than verifyRetention.
+func (vs *verifications) Add(messageID nntp.MessageID) (v verification, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	now := time.Now()
+	v = verification{ID: hex.EncodeToString(b), MessageID: messageID, Status: VerifyPending, Created: now, Updated: now}
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	for id, entry := range vs.entries {
+		if entry.Status != VerifyPending && now.Sub(entry.Updated) > verifyRetention {
+			delete(vs.entries, id)
+		}
+	}
+	entry := v
+	vs.entries[v.ID] = &entry
+	return
+}
+
+// Get returns the verification of the ID, ok reporting whether there is one.
+func (vs *verifications) Get(id string) (v verification, ok bool) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	entry, ok := vs.entries[id]
+	if ok {
+		v = *entry
+	}
+	return
+}
+
+// update saves the progress of a verification.
+func (vs *verifications) update(v verification) {
+	v.Updated = time.Now()
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if entry, ok := vs.entries[v.ID]; ok {
+		*entry = v
+	}
+}
+
+// verifyMode parses the verify query parameter of a post: "" if it is not verified, "1" to verify it before answering,
+// or "async" to answer right away with the verification to query.
+func verifyMode(r *http.Request) (mode string, ok bool) {
+	switch mode = r.URL.Query().Get("verify"); mode {
+	case "", "0", "false":
+		return "", true
+	case "1", "true":
+		return "1", true
+	case "async":
+		return mode, true
+	}
+	return "", false
+}
+
+// verifyPost checks that the article just posted can be read from the NNTP servers, with STAT, up to VerifyAttempts
+// times VerifyDelay milliseconds apart, skipping the store and the memory cache. Every attempt is saved into vs if not
+// nil. The article is verified right away in local-only mode, since the store has it.
+func (s *server) verifyPost(ctx context.Context, v verification, vs *verifications) verification {
+	if s.pool == nil {
+		v.Status, v.Attempts = VerifyVerified, 1
+		return v
+	}
+	delay := time.Duration(s.VerifyDelay) * time.Millisecond
+	for v.Status == VerifyPending {
+		if v.Attempts > 0 {
+			select {
+			case <-ctx.Done():
+				v.Status, v.LastError = VerifyMissing, ctx.Err().Error()
+				continue
+			case <-time.After(delay):
+			}
+		}
+		v.Attempts++
+		conn, _, err := s.fetch(ctx, v.MessageID, "STAT", func(conn *nntp.Conn) (*nntp.Article, error) {
+			return conn.CmdStat(nntp.ArticleMessageID(v.MessageID))
+		})
+		if err == nil {
+			server, _ := s.pool.Server(conn)
+			s.pool.Put(conn)
+			v.Status, v.Server, v.LastError = VerifyVerified, server.Host, ""
+		} else if v.LastError = err.Error(); v.Attempts >= s.VerifyAttempts {
+			v.Status = VerifyMissing
+		}
+		if vs != nil {
+			vs.update(v)
+		}
+	}
+	if v.Status == VerifyVerified {
+		logf(ctx, "[INFO] VERIFY %s found on %s after %d attempts", v.MessageID, v.Server, v.Attempts)
+	} else {
+		logf(ctx, "[WARN] VERIFY %s not found after %d attempts: %s", v.MessageID, v.Attempts, v.LastError)
+	}
+	return v
+}
+
+// answerVerified answers a post once it was verified with the given mode, see verifyMode. Verifying it first, it is
+// answered with 200 OK and X-Usebin-Verified: true, or 202 Accepted with X-Usebin-Verified: false and the verification
+// at the Location header if none of the NNTP servers has it after VerifyAttempts. Verifying it asynchronously, it is answered with
+// 202 Accepted and the verification as JSON, to query at the Location header.
+func (s *server) answerVerified(w http.ResponseWriter, r *http.Request, messageID nntp.MessageID, mode string) {
+	v, err := s.verifications.Add(messageID.Short())
+	if err != nil {
+		logf(r.Context(), "[ERROR] VERIFY %s error: %s", messageID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if mode == "async" {
+		// the verification outlives the request, but not the server
+		go s.verifyPost(context.Background(), v, s.verifications)
+		w.Header().Set("Location", "/verify/"+v.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	if v = s.verifyPost(r.Context(), v, s.verifications); v.Status == VerifyVerified {
+		w.Header().Set("X-Usebin-Verified", "true")
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.Header().Set("X-Usebin-Verified", "false")
+		w.Header().Set("Location", "/verify/"+v.ID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// handleVerify serves GET /verify/<id>, the verification of a post as JSON.
+func (s *server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	// the status changes over time, never cache it
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_, id := splitRoute(r.URL.Path)
+	v, ok := s.verifications.Get(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(v)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+)
+
+// yEncLineLength is the number of encoded characters per line of the yEnc bodies posted.
+const yEncLineLength = 128
+
+var (
+	errYEncHeader = errors.New("missing yEnc header")
+	errYEncCRC    = errors.New("yEnc CRC mismatch")
+)
+
+// yEncPart is a decoded yEnc-encoded article body, see http://www.yenc.org/yenc-draft.1.3.txt.
+type yEncPart struct {
+	Name  string
+	Size  int64 // size of the whole file
+	Part  int   // 0 for single part files
+	Total int
+	Begin int64 // 1-based offset of the part in the file, the spec's =ypart begin
+	End   int64
+	Data  []byte
+}
+
+// yEncParams parses the key=value parameters of a yEnc control line, with name being the last parameter extending to
+// the end of the line since it can contain spaces.
+func yEncParams(line []byte) map[string]string {
+	params := make(map[string]string)
+	if i := bytes.Index(line, []byte(" name=")); i >= 0 {
+		params["name"] = string(bytes.TrimSpace(line[i+len(" name="):]))
+		line = line[:i]
+	}
+	for _, field := range bytes.Fields(line) {
+		if key, value, ok := bytes.Cut(field, []byte("=")); ok {
+			params[string(key)] = string(value)
+		}
+	}
+	return params
+}
+
+// decodeYEnc decodes a dot-decoded yEnc article body, verifying the part or file CRC if the trailer has one.
+func decodeYEnc(body []byte) (part *yEncPart, err error) {
+	var (
+		line    []byte
+		started bool
+		trailer map[string]string
+	)
+	part = &yEncPart{Data: make([]byte, 0, len(body))}
+	for len(body) > 0 && trailer == nil {
+		line, body, _ = bytes.Cut(body, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		switch {
+		case bytes.HasPrefix(line, []byte("=ybegin ")):
+			params := yEncParams(line)
+			part.Name = params["name"]
+			part.Size, _ = strconv.ParseInt(params["size"], 10, 64)
+			part.Part, _ = strconv.Atoi(params["part"])
+			part.Total, _ = strconv.Atoi(params["total"])
+			started = true
+		case !started:
+			// anything before the header is ignored
+		case bytes.HasPrefix(line, []byte("=ypart ")):
+			params := yEncParams(line)
+			part.Begin, _ = strconv.ParseInt(params["begin"], 10, 64)
+			part.End, _ = strconv.ParseInt(params["end"], 10, 64)
+		case bytes.HasPrefix(line, []byte("=yend")):
+			trailer = yEncParams(line)
+		default:
+			for i := 0; i < len(line); i++ {
+				c := line[i]
+				if c == '=' {
+					if i++; i == len(line) {
+						break
+					}
+					c = line[i] - 64
+				}
+				part.Data = append(part.Data, c-42)
+			}
+		}
+	}
+	if !started {
+		err = errYEncHeader
+		return
+	}
+	if part.Part == 0 {
+		part.Begin, part.End = 1, int64(len(part.Data))
+	}
+	crc := trailer["pcrc32"]
+	if part.Part == 0 || crc == "" {
+		crc = trailer["crc32"]
+		if part.Part != 0 && part.Total != 1 {
+			// the file CRC can't be verified from a single part
+			crc = ""
+		}
+	}
+	if crc != "" {
+		if sum, perr := strconv.ParseUint(crc, 16, 32); perr == nil && uint32(sum) != crc32.ChecksumIEEE(part.Data) {
+			err = errYEncCRC
+		}
+	}
+	return
+}
+
+// encodeYEnc encodes the part-th of total parts of a file of size bytes as a yEnc article body, data starting at the
+// 1-based offset begin of the file. A single part file has no =ypart line. The CRC of the whole file, fileCRC, is only
+// given in the trailer of its last part.
+func encodeYEnc(name string, size int64, part, total int, begin int64, data []byte, fileCRC uint32) []byte {
+	var b bytes.Buffer
+	b.Grow(len(data) + len(data)/32 + 256)
+	if total == 1 {
+		fmt.Fprintf(&b, "=ybegin line=%d size=%d name=%s\r\n", yEncLineLength, size, name)
+	} else {
+		fmt.Fprintf(&b, "=ybegin part=%d total=%d line=%d size=%d name=%s\r\n", part, total, yEncLineLength, size, name)
+		fmt.Fprintf(&b, "=ypart begin=%d end=%d\r\n", begin, begin+int64(len(data))-1)
+	}
+	column := 0
+	for i, c := range data {
+		c += 42
+		escape := c == 0 || c == '\n' || c == '\r' || c == '='
+		// leading and trailing whitespace can be stripped in transit, and a leading dot is the NNTP termination
+		last := column == yEncLineLength-1 || i == len(data)-1
+		escape = escape || (c == '\t' || c == ' ') && (column == 0 || last) || c == '.' && column == 0
+		if escape {
+			b.WriteByte('=')
+			c += 64
+			column++
+		}
+		b.WriteByte(c)
+		if column++; column >= yEncLineLength {
+			b.WriteString("\r\n")
+			column = 0
+		}
+	}
+	if column > 0 {
+		b.WriteString("\r\n")
+	}
+	crc := crc32.ChecksumIEEE(data)
+	switch {
+	case total == 1:
+		fmt.Fprintf(&b, "=yend size=%d crc32=%08x\r\n", len(data), crc)
+	case part == total:
+		fmt.Fprintf(&b, "=yend size=%d part=%d pcrc32=%08x crc32=%08x\r\n", len(data), part, crc, fileCRC)
+	default:
+		fmt.Fprintf(&b, "=yend size=%d part=%d pcrc32=%08x\r\n", len(data), part, crc)
+	}
+	return b.Bytes()
+}